@@ -0,0 +1,273 @@
+package postlite
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Session states reported in pg_stat_activity.
+const (
+	StateActive            = "active"
+	StateIdle              = "idle"
+	StateIdleInTransaction = "idle in transaction"
+)
+
+// Activity describes the current state of a client session.
+type Activity struct {
+	PID             int32
+	Database        string
+	User            string
+	ApplicationName string
+	ClientAddr      string // blank for Unix domain socket connections
+	ClientPort      int    // -1 for Unix domain socket connections
+	BackendStart    time.Time
+	XactStart       time.Time // zero if no transaction is open
+	QueryStart      time.Time
+	StateChange     time.Time
+	State           string
+	Query           string // most recent query
+}
+
+// Activity returns the activity of all sessions that have completed startup,
+// ordered by pid.
+func (s *Server) Activity() []Activity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := make([]Activity, 0, len(s.conns))
+	for c := range s.conns {
+		if act, ok := c.activity(); ok {
+			a = append(a, act)
+		}
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].PID < a[j].PID })
+	return a
+}
+
+// CancelBackend cancels the query running in the session with the given pid.
+// Returns false if no session has the pid.
+func (s *Server) CancelBackend(pid int32) bool {
+	if c := s.connByPID(pid); c != nil {
+		c.cancel()
+		return true
+	}
+	return false
+}
+
+// TerminateBackend cancels the query running in the session with the given
+// pid & closes the session with an admin_shutdown error. Its transaction is
+// rolled back. Returns false if no session has the pid.
+func (s *Server) TerminateBackend(pid int32) bool {
+	if c := s.connByPID(pid); c != nil {
+		c.terminate()
+		return true
+	}
+	return false
+}
+
+// connByPID returns the connection with the given backend pid, if any.
+func (s *Server) connByPID(pid int32) *Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		if c.pid == pid {
+			return c
+		}
+	}
+	return nil
+}
+
+// pgStatActivity returns the rows of pg_stat_activity as seen by the session
+// that has pinned conn. Queries of other users' sessions are hidden unless
+// the session is a superuser.
+func (s *Server) pgStatActivity(conn *sqlite3.SQLiteConn) ([]Activity, error) {
+	var user string
+	var superuser bool
+	if c := s.session(conn); c != nil {
+		role, err := s.sessionRole(c)
+		if err != nil {
+			return nil, err
+		}
+		user, superuser = c.sessionUser(), role.Superuser
+	}
+
+	a := s.Activity()
+	for i := range a {
+		if a[i].User != user && !superuser {
+			a[i].Query = "<insufficient privilege>"
+		}
+	}
+	return a, nil
+}
+
+// bindSession associates the SQLite connection pinned by a session with the
+// session so SQL functions can find the session that called them.
+func (s *Server) bindSession(c *Conn) error {
+	return c.conn.Raw(func(driverConn interface{}) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c.sqliteConn = driverConn.(*sqlite3.SQLiteConn)
+		s.sessions[c.sqliteConn] = c // removed by CloseClientConnection()
+		return nil
+	})
+}
+
+// session returns the session that has pinned conn. Returns nil if conn is
+// not pinned, such as when it is idle in the pool.
+func (s *Server) session(conn *sqlite3.SQLiteConn) *Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[conn]
+}
+
+// signalBackend returns a SQL function that applies fn to the session with
+// the given pid. Sessions may signal sessions of the same user & superusers
+// may signal any session.
+func (s *Server) signalBackend(conn *sqlite3.SQLiteConn, fn func(pid int32) bool) func(pid int64) (bool, error) {
+	return func(pid int64) (bool, error) {
+		caller, target := s.session(conn), s.connByPID(int32(pid))
+		if target == nil {
+			return false, nil
+		} else if caller == nil {
+			return false, errSignalBackend
+		} else if caller.sessionUser() != target.sessionUser() {
+			if role, err := s.sessionRole(caller); err != nil {
+				return false, err
+			} else if !role.Superuser {
+				return false, errSignalBackend
+			}
+		}
+		return fn(int32(pid)), nil
+	}
+}
+
+// errSignalBackend is returned when a session signals a session it may not.
+var errSignalBackend = errors.New("must be a member of the role whose process is being signaled")
+
+// handleCancelRequest cancels the query of the session identified by the
+// request. Requests with an invalid key are ignored, as in Postgres.
+func (s *Server) handleCancelRequest(msg *pgproto3.CancelRequest) error {
+	if c := s.connByPID(int32(msg.ProcessID)); c != nil && c.secretKey == msg.SecretKey {
+		c.cancel()
+	}
+	return errCancelRequest
+}
+
+// errCancelRequest is returned once a cancel request has been handled so the
+// connection that sent it is closed.
+var errCancelRequest = errors.New("cancel request")
+
+// newSecretKey returns a random key that clients must send to cancel queries.
+func newSecretKey() (uint32, error) {
+	var buf [4]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(buf[:]), nil
+}
+
+// sessionUser returns the user the session authenticated as.
+func (c *Conn) sessionUser() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.user
+}
+
+// setState updates the session state. If query is blank, the most recent
+// query is kept.
+func (c *Conn) setState(state, query string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if state == StateActive {
+		c.queryStart = now
+		if c.xactStart.IsZero() {
+			c.xactStart = now
+		}
+	} else if state == StateIdle {
+		c.xactStart = time.Time{}
+	}
+	if query != "" {
+		c.query = query
+	}
+	if state != c.state {
+		c.state, c.stateChange = state, now
+	}
+}
+
+// activity returns a snapshot of the session's activity. Returns false if the
+// session has not completed startup.
+func (c *Conn) activity() (Activity, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == "" {
+		return Activity{}, false
+	}
+	return Activity{
+		PID:             c.pid,
+		Database:        c.database,
+		User:            c.user,
+		ApplicationName: c.applicationName,
+		ClientAddr:      c.clientAddr(),
+		ClientPort:      c.clientPort(),
+		BackendStart:    c.backendStart,
+		XactStart:       c.xactStart,
+		QueryStart:      c.queryStart,
+		StateChange:     c.stateChange,
+		State:           c.state,
+		Query:           c.query,
+	}, true
+}
+
+// clientAddr returns the IP address of the client. Returns blank for Unix
+// domain socket connections.
+func (c *Conn) clientAddr() string {
+	if ip := addrIP(c.remoteAddr); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// clientPort returns the TCP port of the client. Returns -1 for Unix domain
+// socket connections.
+func (c *Conn) clientPort() int {
+	if addr, ok := c.remoteAddr.(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return -1
+}
+
+// cancel cancels the query the session is running, if any.
+func (c *Conn) cancel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancelQuery != nil {
+		c.cancelQuery()
+	}
+}
+
+// terminate cancels the running query & marks the session to be closed.
+// Sessions waiting for a message are interrupted.
+func (c *Conn) terminate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.terminated = true
+	if c.cancelQuery != nil {
+		c.cancelQuery()
+	}
+	c.SetReadDeadline(time.Now())
+}
+
+// userCanceled returns true if ctx was canceled by a cancel request.
+func userCanceled(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled)
+}
@@ -0,0 +1,96 @@
+package postlite_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure sessions can only see the queries of & signal sessions of their own
+// user, while superusers may do so for any session.
+func TestServer_SignalBackend(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) { s.MetaStore = MustOpenMetaStore(t) })
+
+	admin := MustConnect(t, s, "db", "sqlite3", "")
+	admin.MustQuery(`CREATE ROLE alice LOGIN`)
+	admin.MustQuery(`CREATE ROLE bob LOGIN`)
+
+	alice := MustConnect(t, s, "db", "alice", "")
+	alice2 := MustConnect(t, s, "db", "alice", "")
+	bob := MustConnect(t, s, "db", "bob", "")
+	bob.MustQuery(`SELECT 'bob-secret'`)
+	alice2.MustQuery(`SELECT 'alice-query'`)
+
+	pid := func(user string) string {
+		t.Helper()
+		rows := admin.MustQuery(`SELECT pid FROM pg_stat_activity WHERE usename = '` + user + `' ORDER BY pid DESC LIMIT 1`)
+		if len(rows) != 1 {
+			t.Fatalf("no session for %s", user)
+		}
+		return rows[0][0]
+	}
+	bobPID, alicePID := pid("bob"), pid("alice") // alice2
+
+	// Queries of other users' sessions are hidden, except from superusers.
+	if rows := alice.MustQuery(`SELECT query FROM pg_stat_activity WHERE pid = ` + bobPID); rows[0][0] != "<insufficient privilege>" {
+		t.Fatalf("query=%q, want hidden", rows[0][0])
+	} else if rows := alice.MustQuery(`SELECT query FROM pg_stat_activity WHERE pid = ` + alicePID); rows[0][0] != `SELECT 'alice-query'` {
+		t.Fatalf("query=%q, want own query", rows[0][0])
+	} else if rows := admin.MustQuery(`SELECT query FROM pg_stat_activity WHERE pid = ` + bobPID); rows[0][0] != `SELECT 'bob-secret'` {
+		t.Fatalf("query=%q, want query visible to superuser", rows[0][0])
+	}
+
+	// Sessions may only signal sessions of the same user.
+	for _, fn := range []string{"pg_cancel_backend", "pg_terminate_backend"} {
+		if _, err := alice.Query(`SELECT ` + fn + `(` + bobPID + `)`); err == nil || !strings.Contains(err.Error(), "must be a member of the role") {
+			t.Fatalf("%s: unexpected error: %v", fn, err)
+		}
+	}
+	if rows := alice.MustQuery(`SELECT pg_cancel_backend(` + alicePID + `)`); rows[0][0] != "1" {
+		t.Fatalf("pg_cancel_backend()=%s, want 1", rows[0][0])
+	} else if rows := alice.MustQuery(`SELECT pg_cancel_backend(999999)`); rows[0][0] != "0" {
+		t.Fatalf("pg_cancel_backend()=%s, want 0", rows[0][0])
+	}
+	bob.MustQuery(`SELECT 1`)
+
+	// Superusers may cancel the running query of another user's session.
+	errc := make(chan error, 1)
+	go func() {
+		_, err := bob.Query(`WITH RECURSIVE r(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM r) SELECT count(*) FROM r`)
+		errc <- err
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if rows := admin.MustQuery(`SELECT state FROM pg_stat_activity WHERE pid = ` + bobPID); rows[0][0] == postlite.StateActive {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for query to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rows := admin.MustQuery(`SELECT pg_cancel_backend(` + bobPID + `)`); rows[0][0] != "1" {
+		t.Fatalf("pg_cancel_backend()=%s, want 1", rows[0][0])
+	}
+	select {
+	case err := <-errc:
+		if e := (*Error)(nil); !errors.As(err, &e) || e.Code != "57014" {
+			t.Fatalf("expected query to be canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for cancellation")
+	}
+
+	// Superusers may terminate another user's session.
+	if rows := admin.MustQuery(`SELECT pg_terminate_backend(` + bobPID + `)`); rows[0][0] != "1" {
+		t.Fatalf("pg_terminate_backend()=%s, want 1", rows[0][0])
+	}
+	bob.MustReceiveFatal("57P01")
+
+	// Sessions may terminate other sessions of their own user.
+	if rows := alice.MustQuery(`SELECT pg_terminate_backend(` + alicePID + `)`); rows[0][0] != "1" {
+		t.Fatalf("pg_terminate_backend()=%s, want 1", rows[0][0])
+	}
+	alice2.MustReceiveFatal("57P01")
+}
@@ -0,0 +1,409 @@
+package postlite
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminHandler serves a JSON API to manage a server over HTTP:
+//
+//	GET    /admin/connections               list sessions & their current query
+//	DELETE /admin/connections/PID           terminate a session
+//	POST   /admin/connections/PID/cancel    cancel the query of a session
+//	GET    /admin/databases                 list databases in the data directory
+//	POST   /admin/databases/NAME/checkpoint checkpoint & truncate the WAL
+//	POST   /admin/databases/NAME/vacuum     rebuild the database file
+//	POST   /admin/databases/NAME/backup     download a consistent copy
+//	POST   /admin/reload                    reload configuration
+type AdminHandler struct {
+	Server *Server
+
+	// Requests must send the token as "Authorization: Bearer TOKEN". If
+	// blank, every request is rejected.
+	Token string
+
+	// Called to reload configuration. If nil, reloading is not supported.
+	Reload func() error
+}
+
+// NewAdminHandler returns a new instance of AdminHandler for s.
+func NewAdminHandler(s *Server) *AdminHandler {
+	return &AdminHandler{Server: s}
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSONError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/admin")
+	switch {
+	case path == "/connections":
+		h.handleConnections(w, r)
+	case strings.HasPrefix(path, "/connections/"):
+		h.handleConnection(w, r, strings.TrimPrefix(path, "/connections/"))
+	case path == "/databases":
+		h.handleDatabases(w, r)
+	case strings.HasPrefix(path, "/databases/"):
+		h.handleDatabase(w, r, strings.TrimPrefix(path, "/databases/"))
+	case path == "/reload":
+		h.handleReload(w, r)
+	default:
+		writeJSONError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (h *AdminHandler) authorized(r *http.Request) bool {
+	if h.Token == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.Token)) == 1
+}
+
+func (h *AdminHandler) handleConnections(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	conns := make([]adminConnection, 0)
+	for _, a := range h.Server.Activity() {
+		conns = append(conns, adminConnection{
+			PID:             a.PID,
+			Database:        a.Database,
+			User:            a.User,
+			ApplicationName: a.ApplicationName,
+			ClientAddr:      a.ClientAddr,
+			BackendStart:    a.BackendStart,
+			XactStart:       timePtr(a.XactStart),
+			QueryStart:      timePtr(a.QueryStart),
+			State:           a.State,
+			Query:           a.Query,
+		})
+	}
+	writeJSON(w, http.StatusOK, conns)
+}
+
+// handleConnection terminates a session or, for ".../cancel", cancels its query.
+func (h *AdminHandler) handleConnection(w http.ResponseWriter, r *http.Request, path string) {
+	pidStr, action, _ := strings.Cut(path, "/")
+	pid, err := strconv.ParseInt(pidStr, 10, 32)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid pid: %q", pidStr))
+		return
+	}
+
+	var ok bool
+	switch action {
+	case "":
+		if !allowMethod(w, r, http.MethodDelete) {
+			return
+		}
+		ok = h.Server.TerminateBackend(int32(pid))
+	case "cancel":
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		ok = h.Server.CancelBackend(int32(pid))
+	default:
+		writeJSONError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("connection not found: %d", pid))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"pid": pid})
+}
+
+func (h *AdminHandler) handleDatabases(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	dbs, err := h.databases()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, dbs)
+}
+
+// databases returns the SQLite databases in the data directory.
+func (h *AdminHandler) databases() ([]adminDatabase, error) {
+	connN := make(map[string]int)
+	for _, a := range h.Server.Activity() {
+		connN[a.Database]++
+	}
+	open := make(map[string]bool)
+	for _, db := range h.Server.DBs.DBs() {
+		open[db.Name()] = true
+	}
+
+	dbs := make([]adminDatabase, 0)
+	err := filepath.WalkDir(h.Server.DataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() {
+			return nil
+		} else if ok, err := isSQLiteFile(path); err != nil || !ok {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(h.Server.DataDir, path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+
+		db := adminDatabase{Name: name, Size: fi.Size(), Open: open[name], Connections: connN[name]}
+		if fi, err := os.Stat(path + "-wal"); err == nil {
+			db.WALSize = fi.Size()
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		dbs = append(dbs, db)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Name < dbs[j].Name })
+	return dbs, nil
+}
+
+// handleDatabase performs a maintenance action on a database. The action is
+// the last element of the path so database names may contain slashes.
+func (h *AdminHandler) handleDatabase(w http.ResponseWriter, r *http.Request, path string) {
+	i := strings.LastIndex(path, "/")
+	if i == -1 {
+		writeJSONError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	name, action := path[:i], path[i+1:]
+
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	switch action {
+	case "checkpoint":
+		h.handleCheckpoint(w, r, name)
+	case "vacuum":
+		h.handleVacuum(w, r, name)
+	case "backup":
+		h.handleBackup(w, r, name)
+	default:
+		writeJSONError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (h *AdminHandler) handleCheckpoint(w http.ResponseWriter, r *http.Request, name string) {
+	db, err := h.Server.acquireExistingDB(name)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	defer h.Server.DBs.Release(db)
+
+	var result struct {
+		Busy         bool `json:"busy"`
+		Log          int  `json:"log"`
+		Checkpointed int  `json:"checkpointed"`
+	}
+	if err := db.QueryRowContext(r.Context(), `PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&result.Busy, &result.Log, &result.Checkpointed); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("checkpoint: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *AdminHandler) handleVacuum(w http.ResponseWriter, r *http.Request, name string) {
+	db, err := h.Server.acquireExistingDB(name)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	defer h.Server.DBs.Release(db)
+
+	t := time.Now()
+	if _, err := db.ExecContext(r.Context(), `VACUUM`); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("vacuum: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"duration": time.Since(t).String()})
+}
+
+// handleBackup writes a consistent copy of the database to the response. The
+// copy is made with VACUUM INTO so it does not block writers.
+func (h *AdminHandler) handleBackup(w http.ResponseWriter, r *http.Request, name string) {
+	db, err := h.Server.acquireExistingDB(name)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	defer h.Server.DBs.Release(db)
+
+	dir, err := os.MkdirTemp("", "postlite-backup-")
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "backup.db")
+	if _, err := db.ExecContext(r.Context(), `VACUUM INTO ?`, filename); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("backup: %w", err))
+		return
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(name)))
+	if _, err := io.Copy(w, f); err != nil {
+		h.Server.Logger.Warn("backup: write response", "database", name, "err", err)
+	}
+}
+
+func (h *AdminHandler) handleReload(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	} else if h.Reload == nil {
+		writeJSONError(w, http.StatusNotImplemented, errors.New("reload not supported"))
+		return
+	}
+
+	if err := h.Reload(); err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"reloaded": true})
+}
+
+// acquireExistingDB returns a handle to a database in the data directory.
+// Unlike client connections, it does not create the database if it does
+// not exist. The caller must release the handle.
+func (s *Server) acquireExistingDB(name string) (*DB, error) {
+	if name == "" || strings.Contains(name, "..") {
+		return nil, errInvalidDatabase
+	}
+
+	path := filepath.Join(s.DataDir, name)
+	if ok, err := isSQLiteFile(path); os.IsNotExist(err) || (err == nil && !ok) {
+		return nil, errDatabaseNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return s.DBs.Acquire(name, path, s.ReadOnly)
+}
+
+var (
+	errInvalidDatabase  = errors.New("invalid database name")
+	errDatabaseNotFound = errors.New("database not found")
+)
+
+// isSQLiteFile returns true if the file at path begins with the SQLite header.
+func isSQLiteFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header := make([]byte, len(sqliteHeader))
+	if _, err := io.ReadFull(f, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return bytes.Equal(header, sqliteHeader), nil
+}
+
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+type adminConnection struct {
+	PID             int32      `json:"pid"`
+	Database        string     `json:"database"`
+	User            string     `json:"user"`
+	ApplicationName string     `json:"application_name,omitempty"`
+	ClientAddr      string     `json:"client_addr,omitempty"`
+	BackendStart    time.Time  `json:"backend_start"`
+	XactStart       *time.Time `json:"xact_start,omitempty"`
+	QueryStart      *time.Time `json:"query_start,omitempty"`
+	State           string     `json:"state"`
+	Query           string     `json:"query"`
+}
+
+type adminDatabase struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	WALSize     int64  `json:"wal_size"`
+	Open        bool   `json:"open"`
+	Connections int    `json:"connections"`
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// allowMethod writes an error & returns false if the request method is not method.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return false
+	}
+	return true
+}
+
+func writeDBError(w http.ResponseWriter, err error) {
+	switch err {
+	case errInvalidDatabase:
+		writeJSONError(w, http.StatusBadRequest, err)
+	case errDatabaseNotFound:
+		writeJSONError(w, http.StatusNotFound, err)
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,37 @@
+package postlite_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure the admin API rejects every request unless a token is set & sent.
+func TestAdminHandler_Token(t *testing.T) {
+	s := MustOpenServer(t)
+	h := postlite.NewAdminHandler(s)
+
+	for _, tt := range []struct {
+		token, auth string
+		code        int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"", "Bearer ", http.StatusUnauthorized},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusOK},
+	} {
+		h.Token = tt.token
+		r := httptest.NewRequest("GET", "/admin/connections", nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("token=%q auth=%q: code=%d, want %d", tt.token, tt.auth, w.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package postlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// Audit event types.
+const (
+	AuditSessionStart = "session_start"
+	AuditSessionEnd   = "session_end"
+	AuditStatement    = "statement"
+)
+
+// StatementClass categorizes statements for auditing.
+type StatementClass string
+
+// Statement classes.
+const (
+	ClassRead  StatementClass = "read"
+	ClassWrite StatementClass = "write"
+	ClassDDL   StatementClass = "ddl"
+	ClassOther StatementClass = "other" // transaction control, pragmas & settings
+)
+
+// ParseStatementClass returns the statement class with the given name.
+func ParseStatementClass(s string) (StatementClass, error) {
+	switch class := StatementClass(strings.ToLower(strings.TrimSpace(s))); class {
+	case ClassRead, ClassWrite, ClassDDL, ClassOther:
+		return class, nil
+	default:
+		return "", fmt.Errorf("invalid statement class: %q", s)
+	}
+}
+
+// ClassifyStatement returns the class of a SQL statement based on its
+// leading keyword. Common table expressions are classified as writes if they
+// contain a data-modifying statement.
+func ClassifyStatement(query string) StatementClass {
+	switch tag := strings.ToUpper(firstKeyword(query)); tag {
+	case "SELECT", "VALUES", "EXPLAIN", "SHOW", "TABLE":
+		return ClassRead
+	case "WITH":
+		if writeKeywordRegex.MatchString(query) {
+			return ClassWrite
+		}
+		return ClassRead
+	case "INSERT", "UPDATE", "DELETE", "REPLACE", "UPSERT":
+		return ClassWrite
+	case "CREATE", "ALTER", "DROP", "REINDEX", "ATTACH", "DETACH":
+		return ClassDDL
+	default:
+		return ClassOther
+	}
+}
+
+var writeKeywordRegex = regexp.MustCompile(`(?i)\b(INSERT|UPDATE|DELETE|REPLACE)\b`)
+
+// firstKeyword returns the first word of query, skipping leading comments.
+func firstKeyword(query string) string {
+	for {
+		query = strings.TrimSpace(query)
+		if strings.HasPrefix(query, "--") {
+			if i := strings.IndexByte(query, '\n'); i != -1 {
+				query = query[i+1:]
+				continue
+			}
+			return ""
+		} else if strings.HasPrefix(query, "/*") {
+			if i := strings.Index(query, "*/"); i != -1 {
+				query = query[i+2:]
+				continue
+			}
+			return ""
+		}
+		break
+	}
+
+	i := strings.IndexFunc(query, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_')
+	})
+	if i == -1 {
+		return query
+	}
+	return query[:i]
+}
+
+// AuditEvent records a session starting or ending, or a statement executed
+// by a session.
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	PID        int32     `json:"pid"`
+	User       string    `json:"user"`
+	Database   string    `json:"database"`
+	ClientAddr string    `json:"client_addr,omitempty"`
+
+	// Statement fields, only set for statement events.
+	Statement    string         `json:"statement,omitempty"`
+	Class        StatementClass `json:"class,omitempty"`
+	Duration     time.Duration  `json:"-"`
+	RowsAffected int64          `json:"rows_affected,omitempty"` // rows returned by reads
+	Code         string         `json:"code,omitempty"`          // SQLSTATE, if the statement failed
+	Error        string         `json:"error,omitempty"`
+}
+
+// MarshalJSON encodes the event with its duration in milliseconds.
+func (e *AuditEvent) MarshalJSON() ([]byte, error) {
+	type event AuditEvent
+	var durationMS *float64
+	if e.Type == AuditStatement {
+		ms := float64(e.Duration) / float64(time.Millisecond)
+		durationMS = &ms
+	}
+	return json.Marshal(struct {
+		*event
+		DurationMS *float64 `json:"duration_ms,omitempty"`
+	}{(*event)(e), durationMS})
+}
+
+// AuditSink records audit events. Implementations must be safe for
+// concurrent use.
+type AuditSink interface {
+	Record(e *AuditEvent) error
+	Close() error
+}
+
+// MultiAuditSink records events to every sink in the list.
+type MultiAuditSink []AuditSink
+
+func (a MultiAuditSink) Record(e *AuditEvent) (err error) {
+	for _, sink := range a {
+		if e := sink.Record(e); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (a MultiAuditSink) Close() (err error) {
+	for _, sink := range a {
+		if e := sink.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// auditSession records a session start or end event. Connections that did
+// not complete startup are not recorded.
+func (s *Server) auditSession(c *Conn, typ string) {
+	if s.AuditSink == nil {
+		return
+	}
+	act, ok := c.activity()
+	if !ok {
+		return
+	}
+	s.recordAudit(&AuditEvent{
+		Time:       time.Now(),
+		Type:       typ,
+		PID:        act.PID,
+		User:       act.User,
+		Database:   act.Database,
+		ClientAddr: act.ClientAddr,
+	})
+}
+
+// auditStatement records a statement executed by a session. For writes, the
+// number of rows changed is read from the session's connection; for other
+// statements n is the number of rows returned.
+func (s *Server) auditStatement(c *Conn, query string, start time.Time, n int64, resp *pgproto3.ErrorResponse) {
+	if s.AuditSink == nil {
+		return
+	}
+	class := ClassifyStatement(query)
+	if !s.auditsClass(class) {
+		return
+	}
+
+	e := &AuditEvent{
+		Time:       start,
+		Type:       AuditStatement,
+		PID:        c.pid,
+		User:       c.sessionUser(),
+		Database:   c.database,
+		ClientAddr: c.clientAddr(),
+		Statement:  query,
+		Class:      class,
+		Duration:   time.Since(start),
+	}
+
+	if resp != nil {
+		e.Code, e.Error = resp.Code, resp.Message
+	} else if class == ClassWrite {
+		if err := c.conn.QueryRowContext(context.Background(), `SELECT changes()`).Scan(&e.RowsAffected); err != nil {
+			s.Logger.Warn("audit: read rows affected", "pid", c.pid, "err", err)
+		}
+	} else if class != ClassDDL {
+		e.RowsAffected = n
+	}
+	s.recordAudit(e)
+}
+
+// auditsClass returns true if statements of the class should be audited.
+func (s *Server) auditsClass(class StatementClass) bool {
+	if len(s.AuditClasses) == 0 {
+		return true
+	}
+	for _, c := range s.AuditClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) recordAudit(e *AuditEvent) {
+	if err := s.AuditSink.Record(e); err != nil {
+		s.Logger.Warn("audit: record event", "type", e.Type, "pid", e.PID, "err", err)
+	}
+}
+
+// FileAuditSink writes audit events to a file as JSON lines. The file is
+// rotated once it exceeds MaxSize.
+type FileAuditSink struct {
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+
+	// Path of the current log file. Rotated files have a timestamp appended.
+	Path string
+
+	// Size in bytes at which the file is rotated. If zero, it is never rotated.
+	MaxSize int64
+
+	// Number of rotated files to keep. If zero, all are kept.
+	MaxBackups int
+}
+
+// NewFileAuditSink returns a new instance of FileAuditSink writing to path.
+func NewFileAuditSink(path string) *FileAuditSink {
+	return &FileAuditSink{Path: path}
+}
+
+// Open opens the log file for appending, creating it if needed.
+func (s *FileAuditSink) Open() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.open()
+}
+
+func (s *FileAuditSink) open() error {
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, fi.Size()
+	return nil
+}
+
+func (s *FileAuditSink) Record(e *AuditEvent) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return errors.New("audit file not open")
+	}
+	if s.MaxSize > 0 && s.size > 0 && s.size+int64(len(buf)) > s.MaxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("rotate: %w", err)
+		}
+	}
+
+	n, err := s.f.Write(buf)
+	s.size += int64(n)
+	return err
+}
+
+// rotate renames the current file & opens a new one. Old files beyond
+// MaxBackups are removed.
+func (s *FileAuditSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	s.f = nil
+
+	if err := os.Rename(s.Path, s.Path+"."+time.Now().UTC().Format("20060102T150405.000000000")); err != nil {
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	if s.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(s.Path + ".*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > s.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// SQLiteAuditSink writes audit events to the "audit_events" table of a SQLite
+// database. The database should not be in the data directory, where clients
+// could connect to it.
+type SQLiteAuditSink struct {
+	db *sql.DB
+
+	// Path of the audit database.
+	Path string
+}
+
+// NewSQLiteAuditSink returns a new instance of SQLiteAuditSink writing to path.
+func NewSQLiteAuditSink(path string) *SQLiteAuditSink {
+	return &SQLiteAuditSink{Path: path}
+}
+
+// Open opens the audit database & creates the events table if needed.
+func (s *SQLiteAuditSink) Open() (err error) {
+	if s.db, err = sql.Open("sqlite3", "file:"+s.Path+"?_journal_mode=wal&_busy_timeout=5000&_synchronous=normal"); err != nil {
+		return err
+	}
+	s.db.SetMaxOpenConns(1)
+
+	if _, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_events (
+			id            INTEGER PRIMARY KEY,
+			time          TEXT NOT NULL,
+			type          TEXT NOT NULL,
+			pid           INTEGER NOT NULL,
+			user          TEXT NOT NULL,
+			database      TEXT NOT NULL,
+			client_addr   TEXT,
+			statement     TEXT,
+			class         TEXT,
+			duration_ms   REAL,
+			rows_affected INTEGER,
+			code          TEXT,
+			error         TEXT
+		)
+	`); err != nil {
+		s.db.Close()
+		return fmt.Errorf("create audit_events: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteAuditSink) Record(e *AuditEvent) error {
+	var durationMS, rowsAffected interface{}
+	if e.Type == AuditStatement {
+		durationMS = float64(e.Duration) / float64(time.Millisecond)
+		rowsAffected = e.RowsAffected
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO audit_events (time, type, pid, user, database, client_addr, statement, class, duration_ms, rows_affected, code, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		e.Time.UTC().Format(time.RFC3339Nano), e.Type, e.PID, e.User, e.Database,
+		nullString(e.ClientAddr), nullString(e.Statement), nullString(string(e.Class)),
+		durationMS, rowsAffected, nullString(e.Code), nullString(e.Error),
+	)
+	return err
+}
+
+func (s *SQLiteAuditSink) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,235 @@
+package postlite_test
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure sessions & their statements are recorded with their class, rows
+// affected & error.
+func TestServer_Audit(t *testing.T) {
+	var sink memoryAuditSink
+	s := MustOpenServer(t, func(s *postlite.Server) { s.AuditSink = &sink })
+
+	c := MustConnect(t, s, "db", "alice", "")
+	c.MustQuery(`CREATE TABLE t (x INTEGER)`)
+	c.MustQuery(`INSERT INTO t VALUES (1), (2), (3)`)
+	c.MustQuery(`SELECT x FROM t WHERE x > 1`)
+	c.MustQueryExtended(`UPDATE t SET x = x + 1 WHERE x > $1`, "2")
+	c.MustFailQuery(`SELECT * FROM missing`, "42000")
+	c.MustQuery(`BEGIN`)
+	c.MustQuery(`COMMIT`)
+	c.Close()
+	events := sink.Wait(t, postlite.AuditSessionEnd)
+
+	type event struct {
+		Type, User, Database, Statement string
+		Class                           postlite.StatementClass
+		RowsAffected                    int64
+		Code                            string
+	}
+	var got []event
+	for _, e := range events {
+		if e.PID == 0 || e.Time.IsZero() || e.ClientAddr != "127.0.0.1" {
+			t.Fatalf("unexpected event: %+v", e)
+		}
+		got = append(got, event{e.Type, e.User, e.Database, e.Statement, e.Class, e.RowsAffected, e.Code})
+	}
+	if want := []event{
+		{Type: postlite.AuditSessionStart, User: "alice", Database: "db"},
+		{postlite.AuditStatement, "alice", "db", `CREATE TABLE t (x INTEGER)`, postlite.ClassDDL, 0, ""},
+		{postlite.AuditStatement, "alice", "db", `INSERT INTO t VALUES (1), (2), (3)`, postlite.ClassWrite, 3, ""},
+		{postlite.AuditStatement, "alice", "db", `SELECT x FROM t WHERE x > 1`, postlite.ClassRead, 2, ""},
+		{postlite.AuditStatement, "alice", "db", `UPDATE t SET x = x + 1 WHERE x > $1`, postlite.ClassWrite, 1, ""},
+		{postlite.AuditStatement, "alice", "db", `SELECT * FROM missing`, postlite.ClassRead, 0, "42000"},
+		{postlite.AuditStatement, "alice", "db", `BEGIN`, postlite.ClassOther, 0, ""},
+		{postlite.AuditStatement, "alice", "db", `COMMIT`, postlite.ClassOther, 0, ""},
+		{Type: postlite.AuditSessionEnd, User: "alice", Database: "db"},
+	}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("events:\n%+v\nwant:\n%+v", got, want)
+	}
+}
+
+// Ensure only statements of the audited classes are recorded while session
+// events are always recorded.
+func TestServer_Audit_Classes(t *testing.T) {
+	var sink memoryAuditSink
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.AuditSink = &sink
+		s.AuditClasses = []postlite.StatementClass{postlite.ClassDDL}
+	})
+
+	c := MustConnect(t, s, "db", "alice", "")
+	c.MustQuery(`CREATE TABLE t (x)`)
+	c.MustQuery(`INSERT INTO t VALUES (1)`)
+	c.MustQuery(`SELECT * FROM t`)
+	c.MustQuery(`DROP TABLE t`)
+	c.Close()
+
+	var got []string
+	for _, e := range sink.Wait(t, postlite.AuditSessionEnd) {
+		got = append(got, e.Type+":"+e.Statement)
+	}
+	if want := []string{"session_start:", "statement:CREATE TABLE t (x)", "statement:DROP TABLE t", "session_end:"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("events=%q, want %q", got, want)
+	}
+}
+
+func TestClassifyStatement(t *testing.T) {
+	for query, want := range map[string]postlite.StatementClass{
+		`SELECT 1`:                                           postlite.ClassRead,
+		`  -- comment` + "\n" + `/* c */ show x`:             postlite.ClassRead,
+		`WITH a AS (SELECT 1) SELECT * FROM a`:               postlite.ClassRead,
+		`WITH a AS (SELECT 1) INSERT INTO t SELECT * FROM a`: postlite.ClassWrite,
+		`insert into t values (1)`:                           postlite.ClassWrite,
+		`REPLACE INTO t VALUES (1)`:                          postlite.ClassWrite,
+		`CREATE INDEX i ON t (x)`:                            postlite.ClassDDL,
+		`ATTACH 'x.db' AS x`:                                 postlite.ClassDDL,
+		`BEGIN`:                                              postlite.ClassOther,
+		`PRAGMA foreign_keys = 1`:                            postlite.ClassOther,
+		``:                                                   postlite.ClassOther,
+	} {
+		if got := postlite.ClassifyStatement(query); got != want {
+			t.Errorf("ClassifyStatement(%q)=%s, want %s", query, got, want)
+		}
+	}
+}
+
+// Ensure the file sink writes JSON lines & rotates the file, keeping at most
+// MaxBackups rotated files.
+func TestFileAuditSink(t *testing.T) {
+	dir := t.TempDir()
+	sink := postlite.NewFileAuditSink(filepath.Join(dir, "audit.log"))
+	sink.MaxSize, sink.MaxBackups = 400, 2
+	if err := sink.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := sink.Record(&postlite.AuditEvent{
+			Time: time.Now(), Type: postlite.AuditStatement, PID: 1, User: "alice", Database: "db",
+			Statement: "SELECT 1", Class: postlite.ClassRead, Duration: 1500 * time.Microsecond, RowsAffected: 1,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond) // rotated files are named by time
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "audit.log*"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(matches) != 3 {
+		t.Fatalf("files=%v, want current file & 2 backups", matches)
+	}
+
+	for _, path := range matches {
+		if fi, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		} else if fi.Size() > sink.MaxSize {
+			t.Fatalf("%s: size=%d, want at most %d", path, fi.Size(), sink.MaxSize)
+		}
+	}
+
+	f, err := os.Open(sink.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("expected event in current file")
+	}
+	var e map[string]interface{}
+	if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	} else if e["type"] != "statement" || e["statement"] != "SELECT 1" || e["class"] != "read" || e["duration_ms"] != 1.5 || e["rows_affected"] != 1.0 {
+		t.Fatalf("unexpected event: %v", e)
+	} else if _, ok := e["code"]; ok {
+		t.Fatalf("unexpected code in event: %v", e)
+	}
+}
+
+// Ensure the SQLite sink records events into the audit_events table.
+func TestSQLiteAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.db")
+	sink := postlite.NewSQLiteAuditSink(path)
+	if err := sink.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	now := time.Now()
+	for _, e := range []*postlite.AuditEvent{
+		{Time: now, Type: postlite.AuditSessionStart, PID: 7, User: "alice", Database: "db", ClientAddr: "10.0.0.1"},
+		{Time: now, Type: postlite.AuditStatement, PID: 7, User: "alice", Database: "db", Statement: "DELETE FROM t", Class: postlite.ClassWrite, Code: "42P01", Error: "no such table: t"},
+	} {
+		if err := sink.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var typ, clientAddr string
+	var statement, code sql.NullString
+	var rowsAffected sql.NullInt64
+	if err := db.QueryRow(`SELECT type, client_addr, statement FROM audit_events WHERE type = 'session_start'`).Scan(&typ, &clientAddr, &statement); err != nil {
+		t.Fatal(err)
+	} else if clientAddr != "10.0.0.1" || statement.Valid {
+		t.Fatalf("unexpected session event: %s %s %v", typ, clientAddr, statement)
+	}
+	if err := db.QueryRow(`SELECT statement, code, rows_affected FROM audit_events WHERE type = 'statement'`).Scan(&statement, &code, &rowsAffected); err != nil {
+		t.Fatal(err)
+	} else if statement.String != "DELETE FROM t" || code.String != "42P01" || !rowsAffected.Valid || rowsAffected.Int64 != 0 {
+		t.Fatalf("unexpected statement event: %v %v %v", statement, code, rowsAffected)
+	}
+}
+
+// memoryAuditSink records audit events in memory.
+type memoryAuditSink struct {
+	mu     sync.Mutex
+	events []*postlite.AuditEvent
+}
+
+func (s *memoryAuditSink) Record(e *postlite.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *memoryAuditSink) Close() error { return nil }
+
+// Wait returns the recorded events once an event of the given type is recorded.
+func (s *memoryAuditSink) Wait(tb testing.TB, typ string) []*postlite.AuditEvent {
+	tb.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		s.mu.Lock()
+		events := append([]*postlite.AuditEvent(nil), s.events...)
+		s.mu.Unlock()
+		for _, e := range events {
+			if e.Type == typ {
+				return events
+			}
+		}
+	}
+	tb.Fatalf("timeout waiting for %s event", typ)
+	return nil
+}
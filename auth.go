@@ -0,0 +1,92 @@
+package postlite
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Auth holds the authentication & access control settings evaluated when a
+// client connects. Connections keep the settings in effect at startup for
+// their lifetime so an Auth must not be modified once it is in use.
+type Auth struct {
+	// Users that may connect with a password. If empty, clients are not
+	// asked for a password.
+	Users []*User
+
+	// Rules that determine which users & clients can access each database.
+	// The first matching rule is used. If no rules are set, all connections
+	// are allowed read-write access. Otherwise, unmatched connections are denied.
+	AccessRules []*AccessRule
+
+	// Mappings from operating system users to database users, referenced
+	// by name from access rules that use peer authentication.
+	IdentMappings []*IdentMapping
+}
+
+// User returns the user with the given name. Returns nil if not found.
+func (a *Auth) User(name string) *User {
+	for _, u := range a.Users {
+		if u.Name == name {
+			return u
+		}
+	}
+	return nil
+}
+
+// User represents a user that authenticates with a password.
+type User struct {
+	Name string
+
+	// Password in plaintext or as a Postgres-style MD5 hash, which is "md5"
+	// followed by the hex-encoded MD5 of the password & user name.
+	Password string
+}
+
+// Validate returns an error if the user is missing required fields.
+func (u *User) Validate() error {
+	if u.Name == "" {
+		return fmt.Errorf("user name required")
+	} else if u.Password == "" {
+		return fmt.Errorf("password required for user %q", u.Name)
+	}
+	return nil
+}
+
+// md5Hash returns the Postgres-style MD5 hash of the user's password.
+func (u *User) md5Hash() string {
+	if isMD5Hash(u.Password) {
+		return u.Password
+	}
+	return "md5" + md5Hex(u.Password+u.Name)
+}
+
+// CheckMD5Password returns true if response matches the MD5 password
+// response expected for the given salt.
+func (u *User) CheckMD5Password(response string, salt [4]byte) bool {
+	expected := "md5" + md5Hex(strings.TrimPrefix(u.md5Hash(), "md5")+string(salt[:]))
+	return subtle.ConstantTimeCompare([]byte(response), []byte(expected)) == 1
+}
+
+// isMD5Hash returns true if s is formatted as a Postgres MD5 password hash.
+func isMD5Hash(s string) bool {
+	if len(s) != 35 || !strings.HasPrefix(s, "md5") {
+		return false
+	}
+	_, err := hex.DecodeString(s[3:])
+	return err == nil
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSalt returns a random salt for MD5 password authentication.
+func newSalt() (salt [4]byte, err error) {
+	_, err = rand.Read(salt[:])
+	return salt, err
+}
@@ -0,0 +1,26 @@
+package postlite_test
+
+import (
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure configured users must send their MD5 password & unknown users are
+// rejected the same way as a wrong password.
+func TestServer_PasswordAuth(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.Auth = &postlite.Auth{Users: []*postlite.User{
+			{Name: "alice", Password: "secret"},
+			{Name: "bob", Password: "md5" + md5Hex("hunter2bob")},
+		}}
+	})
+
+	MustConnect(t, s, "db", "alice", "secret").MustQuery(`SELECT 1`)
+	MustConnect(t, s, "db", "bob", "hunter2").MustQuery(`SELECT 1`)
+
+	MustFailConnect(t, s, "db", "alice", "wrong", "28P01")
+	MustFailConnect(t, s, "db", "alice", "", "28P01")
+	MustFailConnect(t, s, "db", "bob", "md5"+md5Hex("hunter2bob"), "28P01")
+	MustFailConnect(t, s, "db", "mallory", "secret", "28P01")
+}
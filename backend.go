@@ -0,0 +1,65 @@
+package postlite
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+)
+
+// ErrDatabaseNotFound is returned by a Backend when no database has the
+// requested name. Clients receive a 3D000 invalid_catalog_name error.
+var ErrDatabaseNotFound = errors.New("database does not exist")
+
+// Backend opens the databases that clients connect to by name. Each session
+// acquires its database once startup succeeds & releases it when it ends.
+type Backend interface {
+	// Acquire returns a handle to the named database. If readOnly is true,
+	// the session may not write. Handles that are not opened read-only are
+	// restricted with the query_only pragma for the session.
+	Acquire(ctx context.Context, name string, readOnly bool) (*DB, error)
+
+	// Release is called once a session no longer uses a handle.
+	Release(db *DB) error
+}
+
+// backend returns the backend used to open databases for sessions.
+func (s *Server) backend() Backend {
+	if s.Backend != nil {
+		return s.Backend
+	}
+	return &fileBackend{s: s}
+}
+
+// fileBackend opens SQLite files in the server's data directory with the
+// server's DBManager.
+type fileBackend struct {
+	s *Server
+}
+
+func (b *fileBackend) Acquire(ctx context.Context, name string, readOnly bool) (*DB, error) {
+	return b.s.DBs.Acquire(name, filepath.Join(b.s.DataDir, name), readOnly)
+}
+
+func (b *fileBackend) Release(db *DB) error {
+	return b.s.DBs.Release(db)
+}
+
+// StaticBackend serves a fixed set of database handles by name, such as
+// handles opened by an embedding application. Handles are not closed when
+// released.
+//
+// Handles acquired from Server.DBs, which accepts any SQLite DSN such as a
+// shared in-memory database, have the pg_catalog tables & functions
+// registered. Handles wrapping an application's own *sql.DB with NewDB do not,
+// so client introspection queries may fail.
+type StaticBackend map[string]*DB
+
+func (b StaticBackend) Acquire(ctx context.Context, name string, readOnly bool) (*DB, error) {
+	db := b[name]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+	return db, nil
+}
+
+func (b StaticBackend) Release(db *DB) error { return nil }
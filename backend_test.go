@@ -0,0 +1,53 @@
+package postlite_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure a static backend serves its handles by name & rejects other names.
+func TestServer_StaticBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sqlite")
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.DataDir = ""
+		db, err := s.DBs.Acquire("app", path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Backend = postlite.StaticBackend{"app": db}
+	})
+
+	c := MustConnect(t, s, "app", "alice", "")
+	c.MustQuery(`CREATE TABLE t (x)`)
+	c.MustQuery(`INSERT INTO t VALUES ('hello')`)
+	if rows := c.MustQuery(`SELECT relname FROM pg_class WHERE relname = 't'`); len(rows) != 1 {
+		t.Fatalf("expected pg_catalog tables, got %v", rows)
+	}
+	c.Close()
+
+	// Sessions share the handle, which stays open once they end.
+	c = MustConnect(t, s, "app", "bob", "")
+	if rows := c.MustQuery(`SELECT x FROM t`); !reflect.DeepEqual(rows, [][]string{{"hello"}}) {
+		t.Fatalf("rows=%v", rows)
+	}
+
+	// Writes reach the file the name is mapped to.
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var x string
+	if err := db.QueryRow(`SELECT x FROM t`).Scan(&x); err != nil {
+		t.Fatal(err)
+	} else if x != "hello" {
+		t.Fatalf("x=%q, want hello", x)
+	}
+
+	MustFailConnect(t, s, "other", "alice", "", "3D000")
+	MustFailConnect(t, s, "app.sqlite", "alice", "", "3D000")
+}
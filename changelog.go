@@ -0,0 +1,436 @@
+package postlite
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Errors returned by ChangeLog.
+var (
+	ErrSlotExists   = errors.New("replication slot already exists")
+	ErrSlotNotFound = errors.New("replication slot does not exist")
+)
+
+// ChangeLog is a sidecar SQLite database that holds the row changes of the
+// databases served, for logical replication. Changes are only logged for
+// databases that have a replication slot & are kept until every slot of the
+// database has confirmed them, so consumers can resume from the last position
+// they confirmed. Like the metastore, it should not be in the data directory.
+type ChangeLog struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	slotDBs map[string]int // number of slots by database
+	changed chan struct{}  // closed when a transaction is appended
+
+	// Path of the change log database.
+	Path string
+}
+
+// NewChangeLog returns a new instance of ChangeLog stored at path.
+func NewChangeLog(path string) *ChangeLog {
+	return &ChangeLog{Path: path, changed: make(chan struct{})}
+}
+
+// Open opens the change log database & creates its tables if needed.
+// Temporary slots left by a previous process are dropped.
+func (l *ChangeLog) Open() (err error) {
+	if l.db, err = sql.Open("sqlite3", "file:"+l.Path+"?_journal_mode=wal&_busy_timeout=5000"); err != nil {
+		return err
+	}
+	l.db.SetMaxOpenConns(1)
+
+	for _, stmt := range changeLogSchema {
+		if _, err := l.db.Exec(stmt); err != nil {
+			l.db.Close()
+			return fmt.Errorf("create change log schema: %w", err)
+		}
+	}
+
+	slots, err := l.Slots()
+	if err != nil {
+		l.db.Close()
+		return err
+	}
+	l.slotDBs = make(map[string]int)
+	for _, slot := range slots {
+		l.slotDBs[slot.Database]++
+	}
+	for _, slot := range slots {
+		if !slot.Temporary {
+			continue
+		} else if err := l.DropSlot(slot.Name); err != nil {
+			l.db.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the change log database.
+func (l *ChangeLog) Close() error {
+	if l.db == nil {
+		return nil
+	}
+	return l.db.Close()
+}
+
+// changeLogSchema creates the tables of the change log database.
+var changeLogSchema = []string{
+	`CREATE TABLE IF NOT EXISTS slots (
+		name          TEXT PRIMARY KEY,
+		database      TEXT NOT NULL,
+		plugin        TEXT NOT NULL,
+		temporary     INTEGER NOT NULL DEFAULT 0,
+		confirmed_lsn INTEGER NOT NULL,
+		created_at    TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS transactions (
+		lsn          INTEGER PRIMARY KEY AUTOINCREMENT,
+		database     TEXT NOT NULL,
+		committed_at TEXT NOT NULL,
+		changes      TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS transactions_database ON transactions (database, lsn)`,
+	`CREATE TABLE IF NOT EXISTS staged (
+		database TEXT PRIMARY KEY,
+		seq      INTEGER NOT NULL
+	)`,
+}
+
+// ReplicationSlot is a logical replication slot. It records the position up
+// to which its consumer has confirmed the changes of its database.
+type ReplicationSlot struct {
+	Name         string
+	Database     string
+	Plugin       string
+	Temporary    bool // dropped when the session that created it ends
+	ConfirmedLSN uint64
+	CreatedAt    time.Time
+}
+
+// ChangeTx is a committed transaction of a database & its row changes. Its
+// LSN orders it in the change log.
+type ChangeTx struct {
+	LSN         uint64         `json:"-"`
+	Seq         uint64         `json:"-"` // position staged in its database, zero if not staged
+	Database    string         `json:"-"`
+	CommittedAt time.Time      `json:"-"`
+	Relations   []*RelationDef `json:"relations"`
+	Changes     []*RowChange   `json:"changes"`
+}
+
+// RelationDef describes the columns of a table at the time of a transaction.
+type RelationDef struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Types   []string `json:"types"` // declared SQLite types
+	Keys    []bool   `json:"keys"`  // columns of the primary key
+}
+
+// RowChange is an insert, update or delete of a row. Values are nil for NULL,
+// int64, float64, or []byte for text & blobs.
+type RowChange struct {
+	Op       string        `json:"op"` // "insert", "update" or "delete"
+	Relation int           `json:"relation"`
+	Old      []interface{} `json:"old,omitempty"`
+	New      []interface{} `json:"new,omitempty"`
+}
+
+// HasSlots returns true if a database has a replication slot, and its changes
+// should be logged.
+func (l *ChangeLog) HasSlots(database string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.slotDBs[database] > 0
+}
+
+// Slots returns all replication slots sorted by name.
+func (l *ChangeLog) Slots() ([]*ReplicationSlot, error) {
+	rows, err := l.db.Query(`SELECT name, database, plugin, temporary, confirmed_lsn, created_at FROM slots ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var a []*ReplicationSlot
+	for rows.Next() {
+		var slot ReplicationSlot
+		var createdAt string
+		if err := rows.Scan(&slot.Name, &slot.Database, &slot.Plugin, &slot.Temporary, &slot.ConfirmedLSN, &createdAt); err != nil {
+			return nil, err
+		}
+		slot.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+		a = append(a, &slot)
+	}
+	return a, rows.Err()
+}
+
+// Slot returns a replication slot by name. Returns ErrSlotNotFound if it does
+// not exist.
+func (l *ChangeLog) Slot(name string) (*ReplicationSlot, error) {
+	slots, err := l.Slots()
+	if err != nil {
+		return nil, err
+	}
+	for _, slot := range slots {
+		if slot.Name == name {
+			return slot, nil
+		}
+	}
+	return nil, ErrSlotNotFound
+}
+
+// CreateSlot adds a replication slot. Its consumer starts with the changes
+// logged after it is created. Returns ErrSlotExists if a slot with the same
+// name exists.
+func (l *ChangeLog) CreateSlot(slot *ReplicationSlot) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lsn, err := l.currentLSN()
+	if err != nil {
+		return err
+	}
+	slot.ConfirmedLSN, slot.CreatedAt = lsn, time.Now().UTC()
+
+	_, err = l.db.Exec(`INSERT INTO slots (name, database, plugin, temporary, confirmed_lsn, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		slot.Name, slot.Database, slot.Plugin, slot.Temporary, slot.ConfirmedLSN, slot.CreatedAt.Format(time.RFC3339Nano))
+	var serr sqlite3.Error
+	if errors.As(err, &serr) && serr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+		return ErrSlotExists
+	} else if err != nil {
+		return err
+	}
+	l.slotDBs[slot.Database]++
+	return nil
+}
+
+// DropSlot removes a replication slot & the changes no other slot needs.
+// Returns ErrSlotNotFound if it does not exist.
+func (l *ChangeLog) DropSlot(name string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var database string
+	if err := l.db.QueryRow(`DELETE FROM slots WHERE name = ? RETURNING database`, name).Scan(&database); err == sql.ErrNoRows {
+		return ErrSlotNotFound
+	} else if err != nil {
+		return err
+	}
+	if l.slotDBs[database]--; l.slotDBs[database] <= 0 {
+		delete(l.slotDBs, database)
+	}
+	return l.prune(database)
+}
+
+// ConfirmSlot records that the consumer of a slot has received the changes up
+// to lsn, which are removed once no other slot needs them.
+func (l *ChangeLog) ConfirmSlot(name string, lsn uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var database string
+	if err := l.db.QueryRow(`UPDATE slots SET confirmed_lsn = max(confirmed_lsn, ?) WHERE name = ? RETURNING database`, lsn, name).Scan(&database); err == sql.ErrNoRows {
+		return ErrSlotNotFound
+	} else if err != nil {
+		return err
+	}
+	return l.prune(database)
+}
+
+// prune removes the changes of a database confirmed by all of its slots. Must
+// be called with mu held.
+func (l *ChangeLog) prune(database string) error {
+	_, err := l.db.Exec(`
+		DELETE FROM transactions
+		WHERE database = ?1 AND lsn <= coalesce((SELECT min(confirmed_lsn) FROM slots WHERE database = ?1), lsn)
+	`, database)
+	return err
+}
+
+// currentLSN returns the LSN of the last transaction logged. Must be called
+// with mu held.
+func (l *ChangeLog) currentLSN() (lsn uint64, err error) {
+	err = l.db.QueryRow(`SELECT coalesce((SELECT seq FROM sqlite_sequence WHERE name = 'transactions'), 0)`).Scan(&lsn)
+	return lsn, err
+}
+
+// CurrentLSN returns the LSN of the last transaction logged.
+func (l *ChangeLog) CurrentLSN() (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.currentLSN()
+}
+
+// LastSeq returns the position of the last staged transaction of a database
+// that was appended.
+func (l *ChangeLog) LastSeq(database string) (seq uint64, err error) {
+	err = l.db.QueryRow(`SELECT coalesce((SELECT seq FROM staged WHERE database = ?), 0)`, database).Scan(&seq)
+	return seq, err
+}
+
+// Append logs a committed transaction & sets its LSN. Transactions of
+// databases without a replication slot are not logged. Staged transactions
+// are logged once, in the order they were staged: transactions at or before
+// the last position appended for their database are skipped.
+func (l *ChangeLog) Append(tx *ChangeTx) error {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	dbtx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer dbtx.Rollback()
+
+	if tx.Seq != 0 {
+		var last uint64
+		if err := dbtx.QueryRow(`SELECT coalesce((SELECT seq FROM staged WHERE database = ?), 0)`, tx.Database).Scan(&last); err != nil {
+			return err
+		} else if tx.Seq <= last {
+			return nil
+		}
+		if _, err := dbtx.Exec(`INSERT INTO staged (database, seq) VALUES (?, ?) ON CONFLICT (database) DO UPDATE SET seq = excluded.seq`, tx.Database, tx.Seq); err != nil {
+			return err
+		}
+	}
+
+	var lsn int64
+	if l.slotDBs[tx.Database] > 0 {
+		result, err := dbtx.Exec(`INSERT INTO transactions (database, committed_at, changes) VALUES (?, ?, ?)`,
+			tx.Database, tx.CommittedAt.UTC().Format(time.RFC3339Nano), data)
+		if err != nil {
+			return err
+		}
+		if lsn, err = result.LastInsertId(); err != nil {
+			return err
+		}
+	}
+	if err := dbtx.Commit(); err != nil {
+		return err
+	} else if lsn == 0 {
+		return nil
+	}
+	tx.LSN = uint64(lsn)
+
+	// Wake consumers waiting for changes.
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return nil
+}
+
+// Changed returns a channel that is closed once the next transaction is
+// appended.
+func (l *ChangeLog) Changed() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.changed
+}
+
+// Transactions returns up to limit transactions of a database logged after
+// lsn, in order.
+func (l *ChangeLog) Transactions(database string, lsn uint64, limit int) ([]*ChangeTx, error) {
+	rows, err := l.db.Query(`SELECT lsn, database, committed_at, changes FROM transactions WHERE database = ? AND lsn > ? ORDER BY lsn LIMIT ?`, database, lsn, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var a []*ChangeTx
+	for rows.Next() {
+		var tx ChangeTx
+		var committedAt string
+		var data []byte
+		if err := rows.Scan(&tx.LSN, &tx.Database, &committedAt, &data); err != nil {
+			return nil, err
+		} else if err := json.Unmarshal(data, &tx); err != nil {
+			return nil, fmt.Errorf("decode transaction %d: %w", tx.LSN, err)
+		}
+		tx.CommittedAt, _ = time.Parse(time.RFC3339Nano, committedAt)
+		a = append(a, &tx)
+	}
+	return a, rows.Err()
+}
+
+// MarshalJSON encodes a row change with the types of its values: integers as
+// numbers, floats as {"f": number} & text or blobs as base64 strings.
+func (c *RowChange) MarshalJSON() ([]byte, error) {
+	type rowChange RowChange
+	v := rowChange(*c)
+	v.Old, v.New = encodeChangeValues(c.Old), encodeChangeValues(c.New)
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes a row change encoded by MarshalJSON.
+func (c *RowChange) UnmarshalJSON(data []byte) error {
+	type rowChange RowChange
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v rowChange
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	*c = RowChange(v)
+
+	var err error
+	if c.Old, err = decodeChangeValues(v.Old); err != nil {
+		return err
+	}
+	c.New, err = decodeChangeValues(v.New)
+	return err
+}
+
+func encodeChangeValues(values []interface{}) []interface{} {
+	if values == nil {
+		return nil
+	}
+	a := make([]interface{}, len(values))
+	for i, v := range values {
+		if f, ok := v.(float64); ok {
+			a[i] = map[string]float64{"f": f}
+		} else {
+			a[i] = v
+		}
+	}
+	return a
+}
+
+func decodeChangeValues(values []interface{}) ([]interface{}, error) {
+	for i, v := range values {
+		switch v := v.(type) {
+		case json.Number:
+			n, err := v.Int64()
+			if err != nil {
+				return nil, err
+			}
+			values[i] = n
+		case map[string]interface{}:
+			f, err := v["f"].(json.Number).Float64()
+			if err != nil {
+				return nil, err
+			}
+			values[i] = f
+		case string:
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = b
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/benbjohnson/postlite"
+	"gopkg.in/yaml.v2"
+)
+
+// Config represents a configuration file for the postlite server.
+type Config struct {
+	// Directory that holds SQLite databases.
+	DataDir string `yaml:"data-dir"`
+
+	// Bind address to listen to Postgres wire protocol. Set to blank to
+	// only listen on Unix domain sockets.
+	Addr string `yaml:"addr"`
+
+	// Bind address for the HTTP endpoint that serves metrics. If blank, the
+	// endpoint is disabled.
+	HTTPAddr string `yaml:"http-addr"`
+
+	// HTTP admin API. If no address is set, the API is disabled.
+	Admin AdminConfig `yaml:"admin"`
+
+	// Directories to create Unix domain sockets in & their permissions.
+	SocketDirs        []string `yaml:"socket-dirs"`
+	SocketPermissions string   `yaml:"socket-permissions"`
+
+	// TLS certificate used when clients request SSL.
+	TLS *TLSConfig `yaml:"tls"`
+
+	// Password authentication. If no users are defined, no password is required.
+	Users []*UserConfig `yaml:"users"`
+
+	// SQLite database that stores roles created with CREATE ROLE. If blank,
+	// only the users above are roles.
+	MetaStore string `yaml:"metastore"`
+
+	// SQLite database that logs the row changes of databases with logical
+	// replication slots. If blank, logical replication is disabled.
+	ChangeLog string `yaml:"change-log"`
+
+	// Access rules, either inline or in a pg_hba.conf-style file.
+	Access   []*AccessRuleConfig `yaml:"access"`
+	HBAFile  string              `yaml:"hba-file"`
+	ReadOnly bool                `yaml:"read-only"`
+
+	// Mappings from operating system users to database users for peer
+	// authentication, in a pg_ident.conf-style file.
+	IdentFile string `yaml:"ident-file"`
+
+	// SQLite pragmas for all databases & for databases matching a glob.
+	Pragmas   map[string]string `yaml:"pragmas"`
+	Databases []*DatabaseConfig `yaml:"databases"`
+
+	// Loadable SQLite extensions that sessions may create with CREATE
+	// EXTENSION. Databases list extensions to load on every connection.
+	Extensions []*ExtensionConfig `yaml:"extensions"`
+
+	Limits LimitsConfig `yaml:"limits"`
+	Log    LogConfig    `yaml:"log"`
+
+	// Audit log of sessions & statements. If no file or database is set,
+	// auditing is disabled.
+	Audit AuditConfig `yaml:"audit"`
+
+	// Time to wait for open transactions to finish on shutdown.
+	ShutdownTimeout time.Duration `yaml:"shutdown-timeout"`
+}
+
+// DefaultConfig returns a new instance of Config with defaults set.
+func DefaultConfig() Config {
+	return Config{
+		Addr:            DefaultAddr,
+		ShutdownTimeout: DefaultShutdownTimeout,
+		Limits: LimitsConfig{
+			MaxIdleConns:  2,
+			DBIdleTimeout: postlite.DefaultIdleTimeout,
+		},
+	}
+}
+
+// ReadConfigFile unmarshals config from filename. Environment variables in
+// the file are expanded before it is parsed.
+func ReadConfigFile(filename string) (_ Config, err error) {
+	config := DefaultConfig()
+
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return config, err
+	}
+	buf = []byte(os.ExpandEnv(string(buf)))
+
+	if err := yaml.UnmarshalStrict(buf, &config); err != nil {
+		return config, fmt.Errorf("%s: %w", filename, err)
+	}
+	return config, nil
+}
+
+// Validate returns an error if the configuration is invalid. It builds every
+// setting that is applied to the server so any error is caught before use.
+func (c *Config) Validate() error {
+	if c.DataDir == "" {
+		return fmt.Errorf("data-dir required")
+	} else if c.Addr == "" && len(c.SocketDirs) == 0 {
+		return fmt.Errorf("addr or socket-dirs required")
+	}
+	if c.Admin.Addr != "" && c.Admin.Token == "" {
+		return fmt.Errorf("admin token required when admin addr is set")
+	}
+	if _, err := c.SocketPerm(); err != nil {
+		return err
+	}
+	if _, err := c.TLSConfig(); err != nil {
+		return err
+	}
+	if _, err := c.Auth(); err != nil {
+		return err
+	}
+	if _, err := c.Log.Logger(io.Discard, new(slog.LevelVar)); err != nil {
+		return err
+	}
+	if _, err := c.DatabaseLimits(); err != nil {
+		return err
+	}
+	if _, err := c.Audit.StatementClasses(); err != nil {
+		return err
+	} else if c.Audit.MaxSizeMB < 0 || c.Audit.MaxBackups < 0 {
+		return fmt.Errorf("audit max-size-mb & max-backups must not be negative")
+	}
+	if c.Limits.MaxConnections < 0 {
+		return fmt.Errorf("invalid max-connections: %d", c.Limits.MaxConnections)
+	} else if c.Limits.StatementTimeout < 0 || c.Limits.IdleInTransactionSessionTimeout < 0 || c.Limits.IdleSessionTimeout < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+
+	pragmas, databasePragmas, err := c.DBPragmas()
+	if err != nil {
+		return err
+	}
+	dbs := postlite.NewDBManager()
+	dbs.Pragmas, dbs.DatabasePragmas = pragmas, databasePragmas
+	dbs.Extensions, dbs.DatabaseExtensions = c.DBExtensions()
+	return dbs.Validate()
+}
+
+// SocketPerm returns the file mode for Unix domain sockets.
+func (c *Config) SocketPerm() (os.FileMode, error) {
+	if c.SocketPermissions == "" {
+		return postlite.DefaultSocketPerm, nil
+	}
+	perm, err := strconv.ParseUint(c.SocketPermissions, 8, 32)
+	if err != nil || perm > 0777 {
+		return 0, fmt.Errorf("invalid socket-permissions: %q", c.SocketPermissions)
+	}
+	return os.FileMode(perm), nil
+}
+
+// TLSConfig returns the TLS configuration for the server, if any.
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	if c.TLS == nil {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// Auth returns the users & access rules for the server.
+func (c *Config) Auth() (*postlite.Auth, error) {
+	var auth postlite.Auth
+	for _, uc := range c.Users {
+		u := &postlite.User{Name: uc.Name, Password: uc.Password}
+		if err := u.Validate(); err != nil {
+			return nil, fmt.Errorf("users: %w", err)
+		} else if auth.User(u.Name) != nil {
+			return nil, fmt.Errorf("users: duplicate user %q", u.Name)
+		}
+		auth.Users = append(auth.Users, u)
+	}
+
+	for i, rc := range c.Access {
+		rule, err := rc.AccessRule()
+		if err != nil {
+			return nil, fmt.Errorf("access rule %d: %w", i+1, err)
+		}
+		auth.AccessRules = append(auth.AccessRules, rule)
+	}
+
+	// Rules from the hba file are evaluated after inline rules.
+	if c.HBAFile != "" {
+		rules, err := postlite.ReadAccessRulesFile(c.HBAFile)
+		if err != nil {
+			return nil, err
+		}
+		auth.AccessRules = append(auth.AccessRules, rules...)
+	}
+
+	if c.IdentFile != "" {
+		mappings, err := postlite.ReadIdentMapFile(c.IdentFile)
+		if err != nil {
+			return nil, err
+		}
+		auth.IdentMappings = mappings
+	}
+	return &auth, nil
+}
+
+// DatabaseLimits returns the connection limits for databases that set one.
+func (c *Config) DatabaseLimits() ([]*postlite.DatabaseLimit, error) {
+	var limits []*postlite.DatabaseLimit
+	for _, dc := range c.Databases {
+		if dc.MaxConnections == nil {
+			continue
+		} else if *dc.MaxConnections < 0 {
+			return nil, fmt.Errorf("%s: invalid max-connections: %d", dc.Pattern, *dc.MaxConnections)
+		}
+		limits = append(limits, &postlite.DatabaseLimit{Database: dc.Pattern, MaxConnections: *dc.MaxConnections})
+	}
+	return limits, nil
+}
+
+// DBPragmas returns the global & per-database pragmas.
+func (c *Config) DBPragmas() (_ postlite.Pragmas, _ []*postlite.DatabasePragmas, err error) {
+	pragmas := postlite.DefaultPragmas()
+	if err := setPragmas(&pragmas, c.Pragmas); err != nil {
+		return pragmas, nil, err
+	}
+
+	var databasePragmas []*postlite.DatabasePragmas
+	for _, dc := range c.Databases {
+		p := &postlite.DatabasePragmas{Database: dc.Pattern}
+		if err := setPragmas(&p.Pragmas, dc.Pragmas); err != nil {
+			return pragmas, nil, fmt.Errorf("%s: %w", dc.Pattern, err)
+		}
+		databasePragmas = append(databasePragmas, p)
+	}
+	return pragmas, databasePragmas, nil
+}
+
+// DBExtensions returns the allowlisted extensions & the extensions loaded
+// into databases matching a glob.
+func (c *Config) DBExtensions() ([]*postlite.Extension, []*postlite.DatabaseExtensions) {
+	var extensions []*postlite.Extension
+	for _, ec := range c.Extensions {
+		extensions = append(extensions, &postlite.Extension{
+			Name:       ec.Name,
+			Path:       ec.Path,
+			EntryPoint: ec.EntryPoint,
+			Version:    ec.Version,
+		})
+	}
+
+	var databaseExtensions []*postlite.DatabaseExtensions
+	for _, dc := range c.Databases {
+		if len(dc.Extensions) > 0 {
+			databaseExtensions = append(databaseExtensions, &postlite.DatabaseExtensions{Database: dc.Pattern, Extensions: dc.Extensions})
+		}
+	}
+	return extensions, databaseExtensions
+}
+
+func setPragmas(p *postlite.Pragmas, m map[string]string) error {
+	for name, value := range m {
+		if err := p.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TLSConfig represents the paths to a TLS certificate & key.
+type TLSConfig struct {
+	CertFile string `yaml:"cert-file"`
+	KeyFile  string `yaml:"key-file"`
+}
+
+// UserConfig represents a user that can authenticate with a password.
+type UserConfig struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+}
+
+// AccessRuleConfig represents a single access rule.
+type AccessRuleConfig struct {
+	Type     string `yaml:"type"`
+	Database string `yaml:"database"`
+	User     string `yaml:"user"`
+	Address  string `yaml:"address"`
+	Access   string `yaml:"access"`
+	Auth     string `yaml:"auth"`
+	Map      string `yaml:"map"`
+}
+
+// AccessRule converts the configuration into an access rule. Blank fields
+// default to matching all connections.
+func (c *AccessRuleConfig) AccessRule() (*postlite.AccessRule, error) {
+	rule, err := postlite.NewAccessRule(
+		stringOr(c.Type, "host"),
+		stringOr(c.Database, "all"),
+		stringOr(c.User, "all"),
+		c.address(),
+		c.Access,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if rule.Auth, err = postlite.ParseAuthMethod(stringOr(c.Auth, "password")); err != nil {
+		return nil, err
+	}
+	rule.IdentMap = c.Map
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// address returns the rule address. Local rules have no address.
+func (c *AccessRuleConfig) address() string {
+	if c.Type == "local" {
+		return c.Address
+	}
+	return stringOr(c.Address, "all")
+}
+
+func stringOr(s, defaultValue string) string {
+	if s == "" {
+		return defaultValue
+	}
+	return s
+}
+
+// DatabaseConfig represents settings for databases matching a glob.
+type DatabaseConfig struct {
+	Pattern        string            `yaml:"pattern"`
+	Pragmas        map[string]string `yaml:"pragmas"`
+	MaxConnections *int              `yaml:"max-connections"`
+	Extensions     []string          `yaml:"extensions"`
+}
+
+// ExtensionConfig represents a loadable SQLite extension.
+type ExtensionConfig struct {
+	Name       string `yaml:"name"`
+	Path       string `yaml:"path"`
+	EntryPoint string `yaml:"entry-point"`
+	Version    string `yaml:"version"`
+}
+
+// LimitsConfig represents client connection limits, session timeouts &
+// SQLite connection pool limits.
+type LimitsConfig struct {
+	MaxConnections int `yaml:"max-connections"`
+
+	StatementTimeout                time.Duration `yaml:"statement-timeout"`
+	IdleInTransactionSessionTimeout time.Duration `yaml:"idle-in-transaction-session-timeout"`
+	IdleSessionTimeout              time.Duration `yaml:"idle-session-timeout"`
+
+	MaxOpenConns  int           `yaml:"max-open-conns"`
+	MaxIdleConns  int           `yaml:"max-idle-conns"`
+	DBIdleTimeout time.Duration `yaml:"db-idle-timeout"`
+}
+
+// AdminConfig represents the admin API settings.
+type AdminConfig struct {
+	Addr  string `yaml:"addr"`
+	Token string `yaml:"token"`
+}
+
+// AuditConfig represents the audit log settings. Events are written to a
+// JSON lines file, a SQLite database, or both.
+type AuditConfig struct {
+	File       string   `yaml:"file"`
+	MaxSizeMB  int64    `yaml:"max-size-mb"` // rotate the file at this size, 0 to disable
+	MaxBackups int      `yaml:"max-backups"` // rotated files to keep, 0 to keep all
+	Database   string   `yaml:"database"`
+	Classes    []string `yaml:"classes"` // read, write, ddl or other; all if empty
+}
+
+// StatementClasses returns the statement classes to audit.
+func (c *AuditConfig) StatementClasses() ([]postlite.StatementClass, error) {
+	a := make([]postlite.StatementClass, 0, len(c.Classes))
+	for _, name := range c.Classes {
+		class, err := postlite.ParseStatementClass(name)
+		if err != nil {
+			return nil, fmt.Errorf("audit: %w", err)
+		}
+		a = append(a, class)
+	}
+	return a, nil
+}
+
+// OpenSink opens the configured audit sinks. Returns nil if auditing is
+// disabled.
+func (c *AuditConfig) OpenSink() (postlite.AuditSink, error) {
+	var sinks postlite.MultiAuditSink
+	if c.File != "" {
+		sink := postlite.NewFileAuditSink(c.File)
+		sink.MaxSize = c.MaxSizeMB << 20
+		sink.MaxBackups = c.MaxBackups
+		if err := sink.Open(); err != nil {
+			return nil, fmt.Errorf("open audit file: %w", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if c.Database != "" {
+		sink := postlite.NewSQLiteAuditSink(c.Database)
+		if err := sink.Open(); err != nil {
+			sinks.Close()
+			return nil, fmt.Errorf("open audit database: %w", err)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	switch len(sinks) {
+	case 0:
+		return nil, nil
+	case 1:
+		return sinks[0], nil
+	default:
+		return sinks, nil
+	}
+}
+
+// LogConfig represents logging settings.
+type LogConfig struct {
+	Level            string `yaml:"level"`  // debug, info, warn or error
+	Format           string `yaml:"format"` // text or json
+	Timestamps       bool   `yaml:"timestamps"`
+	RedactParameters bool   `yaml:"redact-parameters"`
+}
+
+// Logger returns a logger that writes to w with the configured format. The
+// configured level is stored in level so it can be changed on reload.
+func (c *LogConfig) Logger(w io.Writer, level *slog.LevelVar) (*slog.Logger, error) {
+	if err := c.SetLevel(level); err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	if !c.Timestamps {
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		}
+	}
+
+	switch c.Format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format: %q", c.Format)
+	}
+}
+
+// SetLevel sets level to the configured log level.
+func (c *LogConfig) SetLevel(level *slog.LevelVar) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(stringOr(c.Level, "info"))); err != nil {
+		return fmt.Errorf("invalid log level: %q", c.Level)
+	}
+	level.Set(l)
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/postlite"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Setenv("POSTLITE_TEST_PASSWORD", "secret")
+		path := writeFile(t, "postlite.yml", `
+data-dir: /var/lib/postlite
+socket-dirs: [/run/postlite]
+users:
+  - name: alice
+    password: $POSTLITE_TEST_PASSWORD
+pragmas:
+  synchronous: normal
+databases:
+  - pattern: "logs*"
+    max-connections: 5
+    pragmas:
+      journal_mode: delete
+limits:
+  statement-timeout: 30s
+log:
+  level: debug
+audit:
+  classes: [ddl, write]
+`)
+
+		config, err := ReadConfigFile(path)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := config.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := config.Users[0].Password, "secret"; got != want {
+			t.Fatalf("password=%q, want %q", got, want)
+		} else if got, want := config.Addr, DefaultAddr; got != want {
+			t.Fatalf("addr=%q, want %q", got, want)
+		} else if got, want := config.Limits.StatementTimeout, 30*time.Second; got != want {
+			t.Fatalf("statement-timeout=%s, want %s", got, want)
+		} else if got, want := config.Limits.DBIdleTimeout, postlite.DefaultIdleTimeout; got != want {
+			t.Fatalf("db-idle-timeout=%s, want %s", got, want)
+		}
+
+		pragmas, databasePragmas, err := config.DBPragmas()
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := pragmas.Synchronous, "normal"; got != want {
+			t.Fatalf("synchronous=%q, want %q", got, want)
+		} else if got, want := pragmas.JournalMode, postlite.DefaultJournalMode; got != want {
+			t.Fatalf("journal_mode=%q, want %q", got, want)
+		} else if len(databasePragmas) != 1 || databasePragmas[0].Database != "logs*" || databasePragmas[0].Pragmas.JournalMode != "delete" {
+			t.Fatalf("unexpected database pragmas: %+v", databasePragmas)
+		}
+
+		if limits, err := config.DatabaseLimits(); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(limits, []*postlite.DatabaseLimit{{Database: "logs*", MaxConnections: 5}}) {
+			t.Fatalf("unexpected limits: %+v", limits)
+		}
+		if classes, err := config.Audit.StatementClasses(); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(classes, []postlite.StatementClass{postlite.ClassDDL, postlite.ClassWrite}) {
+			t.Fatalf("unexpected classes: %v", classes)
+		}
+	})
+
+	t.Run("ErrUnknownField", func(t *testing.T) {
+		path := writeFile(t, "postlite.yml", "data-dir: /tmp\ndatadir: /tmp\n")
+		if _, err := ReadConfigFile(path); err == nil || !strings.Contains(err.Error(), "field datadir not found") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNotExist", func(t *testing.T) {
+		if _, err := ReadConfigFile(filepath.Join(t.TempDir(), "missing.yml")); !os.IsNotExist(err) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestConfig_Validate(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		fn   func(c *Config)
+		err  string
+	}{
+		{"DataDirRequired", func(c *Config) { c.DataDir = "" }, "data-dir required"},
+		{"AddrRequired", func(c *Config) { c.Addr = "" }, "addr or socket-dirs required"},
+		{"SocketDirsOnly", func(c *Config) { c.Addr, c.SocketDirs = "", []string{"/run/postlite"} }, ""},
+		{"AdminToken", func(c *Config) { c.Admin.Addr = ":8080" }, "admin token required when admin addr is set"},
+		{"SocketPermissions", func(c *Config) { c.SocketPermissions = "0778" }, `invalid socket-permissions: "0778"`},
+		{"TLS", func(c *Config) { c.TLS = &TLSConfig{CertFile: "missing.crt", KeyFile: "missing.key"} }, "tls: open missing.crt"},
+		{"DuplicateUser", func(c *Config) {
+			c.Users = []*UserConfig{{Name: "alice", Password: "a"}, {Name: "alice", Password: "b"}}
+		}, `users: duplicate user "alice"`},
+		{"AccessRule", func(c *Config) { c.Access = []*AccessRuleConfig{{Access: "sometimes"}} }, "access rule 1: "},
+		{"LogFormat", func(c *Config) { c.Log.Format = "xml" }, `invalid log format: "xml"`},
+		{"LogLevel", func(c *Config) { c.Log.Level = "loud" }, `invalid log level: "loud"`},
+		{"DatabaseMaxConnections", func(c *Config) {
+			n := -1
+			c.Databases = []*DatabaseConfig{{Pattern: "logs*", MaxConnections: &n}}
+		}, "logs*: invalid max-connections: -1"},
+		{"AuditClass", func(c *Config) { c.Audit.Classes = []string{"select"} }, "audit: "},
+		{"AuditMaxSize", func(c *Config) { c.Audit.MaxSizeMB = -1 }, "audit max-size-mb & max-backups must not be negative"},
+		{"MaxConnections", func(c *Config) { c.Limits.MaxConnections = -1 }, "invalid max-connections: -1"},
+		{"Timeout", func(c *Config) { c.Limits.IdleSessionTimeout = -time.Second }, "timeouts must not be negative"},
+		{"Pragma", func(c *Config) { c.Pragmas = map[string]string{"page_size": "4096"} }, `unsupported pragma: "page_size"`},
+		{"PragmaValue", func(c *Config) { c.Pragmas = map[string]string{"synchronous": "sometimes"} }, `invalid synchronous: "sometimes"`},
+		{"DatabasePattern", func(c *Config) {
+			c.Databases = []*DatabaseConfig{{Pattern: "logs["}}
+		}, `invalid database pattern "logs["`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.DataDir = t.TempDir()
+			tt.fn(&config)
+
+			if err := config.Validate(); tt.err == "" && err != nil {
+				t.Fatal(err)
+			} else if tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)) {
+				t.Fatalf("err=%v, want prefix %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRestartSettings(t *testing.T) {
+	prev := DefaultConfig()
+	prev.DataDir = "/var/lib/postlite"
+
+	// Settings applied on reload do not require a restart.
+	next := prev
+	next.Users = []*UserConfig{{Name: "alice", Password: "secret"}}
+	next.Pragmas = map[string]string{"synchronous": "normal"}
+	next.Limits.MaxConnections = 10
+	next.Limits.StatementTimeout = time.Minute
+	next.Log.Level = "debug"
+	if a := restartSettings(prev, next); len(a) != 0 {
+		t.Fatalf("unexpected restart settings: %v", a)
+	}
+
+	next.Addr = ":5433"
+	next.TLS = &TLSConfig{CertFile: "server.crt", KeyFile: "server.key"}
+	next.Limits.MaxOpenConns = 4
+	next.Log.Format = "json"
+	next.Audit.Classes = []string{"ddl"}
+	next.Databases = []*DatabaseConfig{{Pattern: "geo", Extensions: []string{"spatialite"}}}
+	if got, want := restartSettings(prev, next), []string{"addr", "tls", "databases.extensions", "limits.max-open-conns", "log.format", "audit"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("restartSettings()=%v, want %v", got, want)
+	}
+}
+
+// writeFile writes data to a file in a temporary directory & returns its path.
+func writeFile(tb testing.TB, name, data string) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// metricsHandler returns the handler for the operational HTTP endpoint,
+// which serves metrics & health checks.
+func metricsHandler(s *postlite.Server) http.Handler {
+	health := postlite.HealthHandler(s)
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", health)
+	mux.Handle("/readyz", health)
+	if s.Metrics != nil {
+		mux.Handle("/metrics", s.Metrics.Handler())
+	}
+	return mux
+}
+
+// adminHandler returns the handler for the admin API. Reloads apply the
+// same settings as SIGHUP.
+func adminHandler(r *reloader, token string, logger *slog.Logger) http.Handler {
+	h := postlite.NewAdminHandler(r.s)
+	h.Token = token
+	h.Reload = func() error {
+		if err := r.reload(); err != nil {
+			return err
+		}
+		logger.Info("configuration reloaded by admin api")
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", h)
+	return mux
+}
+
+// openHTTP starts an HTTP server on addr.
+func openHTTP(addr string, handler http.Handler, logger *slog.Logger) (*http.Server, net.Addr, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hs := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := hs.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("http server", "err", err)
+		}
+	}()
+	return hs, ln.Addr(), nil
+}
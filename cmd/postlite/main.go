@@ -4,53 +4,468 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/benbjohnson/postlite"
 )
 
+// DefaultAddr is the default bind address for the Postgres wire protocol.
+const DefaultAddr = ":5432"
+
+// DefaultShutdownTimeout is the default time to wait for sessions to finish
+// their transactions before they are closed on shutdown.
+const DefaultShutdownTimeout = 30 * time.Second
+
 func main() {
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	if err := run(ctx); err != nil {
+	if err := run(ctx, os.Args[1:]); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
 
-func run(ctx context.Context) error {
-	addr := flag.String("addr", ":5432", "postgres protocol bind address")
-	dataDir := flag.String("data-dir", "", "data directory")
-	flag.Parse()
+func run(ctx context.Context, args []string) error {
+	if len(args) > 0 && args[0] == "config" {
+		return runConfig(ctx, args[1:])
+	} else if len(args) > 0 && args[0] == "ping" {
+		return runPing(ctx, args[1:])
+	}
+	return runServe(ctx, args)
+}
+
+func runServe(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("postlite", flag.ContinueOnError)
+	cf := registerConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
 
-	if *dataDir == "" {
-		return fmt.Errorf("required: -data-dir PATH")
+	config, err := cf.load()
+	if err != nil {
+		return err
+	} else if err := config.Validate(); err != nil {
+		return err
 	}
 
-	log.SetFlags(0)
+	level := new(slog.LevelVar)
+	logger, err := config.Log.Logger(os.Stderr, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	s, err := newServer(config)
+	if err != nil {
+		return err
+	}
+	s.Logger = logger
+
+	// Audit sinks are closed after the server so session ends are recorded.
+	if sink, err := config.Audit.OpenSink(); err != nil {
+		return err
+	} else if sink != nil {
+		defer sink.Close()
+		s.AuditSink = sink
+	}
+
+	if config.MetaStore != "" {
+		store := postlite.NewMetaStore(config.MetaStore)
+		if err := store.Open(); err != nil {
+			return fmt.Errorf("open metastore: %w", err)
+		}
+		defer store.Close()
+		s.MetaStore = store
+	}
+
+	if config.ChangeLog != "" {
+		changeLog := postlite.NewChangeLog(config.ChangeLog)
+		if err := changeLog.Open(); err != nil {
+			return fmt.Errorf("open change log: %w", err)
+		}
+		defer changeLog.Close()
+		s.ChangeLog = changeLog
+	}
 
-	s := postlite.NewServer()
-	s.Addr = *addr
-	s.DataDir = *dataDir
 	if err := s.Open(); err != nil {
 		return err
 	}
 	defer s.Close()
 
-	log.Printf("listening on %s", s.Addr)
+	for _, addr := range s.Listeners() {
+		logger.Info("listening", "addr", addr.String())
+	}
+
+	r := &reloader{s: s, cf: cf, config: config, level: level, logger: logger}
+
+	// Serve metrics & the admin API over HTTP, if enabled.
+	if config.HTTPAddr != "" {
+		hs, addr, err := openHTTP(config.HTTPAddr, metricsHandler(s), logger)
+		if err != nil {
+			return fmt.Errorf("http: %w", err)
+		}
+		defer hs.Close()
+		logger.Info("http listening", "addr", addr.String())
+	}
+	if config.Admin.Addr != "" {
+		hs, addr, err := openHTTP(config.Admin.Addr, adminHandler(r, config.Admin.Token, logger), logger)
+		if err != nil {
+			return fmt.Errorf("admin: %w", err)
+		}
+		defer hs.Close()
+		logger.Info("admin api listening", "addr", addr.String())
+	}
+
+	// Reload the configuration on SIGHUP.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
 
 	// Wait on signal before shutting down.
-	<-ctx.Done()
-	log.Printf("SIGINT received, shutting down")
+	for ctx.Err() == nil {
+		select {
+		case <-hup:
+			if err := r.reload(); err != nil {
+				logger.Warn("reload failed, keeping current configuration", "err", err)
+			} else {
+				logger.Info("configuration reloaded")
+			}
+		case <-ctx.Done():
+		}
+	}
+	logger.Info("signal received, shutting down")
+
+	// Wait for sessions to finish their transactions before closing them.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	logger.Info("postlite shutdown complete")
+
+	return nil
+}
+
+// newServer returns a server configured from config.
+func newServer(config Config) (*postlite.Server, error) {
+	s := postlite.NewServer()
+	s.Addr = config.Addr
+	s.SocketDirs = config.SocketDirs
+	s.DataDir = config.DataDir
+	s.ReadOnly = config.ReadOnly
+	s.RedactParameters = config.Log.RedactParameters
+
+	var err error
+	if s.SocketPerm, err = config.SocketPerm(); err != nil {
+		return nil, err
+	}
+	if s.TLSConfig, err = config.TLSConfig(); err != nil {
+		return nil, err
+	}
+	if s.Auth, err = config.Auth(); err != nil {
+		return nil, err
+	}
+	if s.DBs.Pragmas, s.DBs.DatabasePragmas, err = config.DBPragmas(); err != nil {
+		return nil, err
+	}
+	s.DBs.Extensions, s.DBs.DatabaseExtensions = config.DBExtensions()
+	if s.DatabaseLimits, err = config.DatabaseLimits(); err != nil {
+		return nil, err
+	}
+	if s.AuditClasses, err = config.Audit.StatementClasses(); err != nil {
+		return nil, err
+	}
+
+	s.MaxConnections = config.Limits.MaxConnections
+	s.StatementTimeout = config.Limits.StatementTimeout
+	s.IdleInTransactionSessionTimeout = config.Limits.IdleInTransactionSessionTimeout
+	s.IdleSessionTimeout = config.Limits.IdleSessionTimeout
+
+	s.DBs.MaxOpenConns = config.Limits.MaxOpenConns
+	s.DBs.MaxIdleConns = config.Limits.MaxIdleConns
+	s.DBs.IdleTimeout = config.Limits.DBIdleTimeout
+	return s, nil
+}
+
+// reloader rereads the configuration & applies the settings that can change
+// while the server is running.
+type reloader struct {
+	s      *postlite.Server
+	cf     *configFlags
+	level  *slog.LevelVar
+	logger *slog.Logger
 
-	// Perform clean shutdown.
-	if err := s.Close(); err != nil {
+	mu     sync.Mutex
+	config Config // last applied configuration
+}
+
+// reload applies authentication, access rules, pragmas, connection limits,
+// session timeouts & the log level. Only new connections & newly opened
+// databases use the new settings. Changes to any other setting are logged
+// as requiring a restart.
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Validate builds every setting, including the TLS key pair, socket
+	// permissions & audit classes that are only used on restart, so a file
+	// the server could not start with is rejected.
+	config, err := r.cf.load()
+	if err != nil {
+		return err
+	} else if err := config.Validate(); err != nil {
+		return err
+	}
+
+	auth, err := config.Auth()
+	if err != nil {
+		return err
+	}
+	pragmas, databasePragmas, err := config.DBPragmas()
+	if err != nil {
+		return err
+	}
+	databaseLimits, err := config.DatabaseLimits()
+	if err != nil {
+		return err
+	}
+
+	r.s.SetAuth(auth)
+	r.s.DBs.SetPragmas(pragmas, databasePragmas)
+	r.s.SetConnLimits(config.Limits.MaxConnections, databaseLimits)
+	r.s.SetSessionTimeouts(config.Limits.StatementTimeout, config.Limits.IdleInTransactionSessionTimeout, config.Limits.IdleSessionTimeout)
+	if err := config.Log.SetLevel(r.level); err != nil {
+		return err
+	}
+
+	for _, name := range restartSettings(r.config, config) {
+		r.logger.Warn("setting changed, restart required", "setting", name)
+	}
+	r.config = config
+	return nil
+}
+
+// restartSettings returns the names of settings that differ between prev &
+// next but are only applied when the server starts.
+func restartSettings(prev, next Config) []string {
+	prevExtensions, prevDatabaseExtensions := prev.DBExtensions()
+	nextExtensions, nextDatabaseExtensions := next.DBExtensions()
+
+	var a []string
+	for _, setting := range []struct {
+		name       string
+		prev, next any
+	}{
+		{"data-dir", prev.DataDir, next.DataDir},
+		{"addr", prev.Addr, next.Addr},
+		{"http-addr", prev.HTTPAddr, next.HTTPAddr},
+		{"admin", prev.Admin, next.Admin},
+		{"socket-dirs", prev.SocketDirs, next.SocketDirs},
+		{"socket-permissions", prev.SocketPermissions, next.SocketPermissions},
+		{"tls", prev.TLS, next.TLS},
+		{"metastore", prev.MetaStore, next.MetaStore},
+		{"change-log", prev.ChangeLog, next.ChangeLog},
+		{"read-only", prev.ReadOnly, next.ReadOnly},
+		{"extensions", prevExtensions, nextExtensions},
+		{"databases.extensions", prevDatabaseExtensions, nextDatabaseExtensions},
+		{"limits.max-open-conns", prev.Limits.MaxOpenConns, next.Limits.MaxOpenConns},
+		{"limits.max-idle-conns", prev.Limits.MaxIdleConns, next.Limits.MaxIdleConns},
+		{"limits.db-idle-timeout", prev.Limits.DBIdleTimeout, next.Limits.DBIdleTimeout},
+		{"log.format", prev.Log.Format, next.Log.Format},
+		{"log.timestamps", prev.Log.Timestamps, next.Log.Timestamps},
+		{"log.redact-parameters", prev.Log.RedactParameters, next.Log.RedactParameters},
+		{"audit", prev.Audit, next.Audit},
+		{"shutdown-timeout", prev.ShutdownTimeout, next.ShutdownTimeout},
+	} {
+		if !reflect.DeepEqual(setting.prev, setting.next) {
+			a = append(a, setting.name)
+		}
+	}
+	return a
+}
+
+func runConfig(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("usage: postlite config check -config PATH")
+	}
+
+	fs := flag.NewFlagSet("postlite-config-check", flag.ContinueOnError)
+	configPath := fs.String("config", "", "config file path")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	} else if *configPath == "" {
+		return fmt.Errorf("required: -config PATH")
+	}
+
+	config, err := ReadConfigFile(*configPath)
+	if err != nil {
 		return err
+	} else if err := config.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", *configPath, err)
+	}
+
+	fmt.Printf("%s: configuration ok\n", *configPath)
+	return nil
+}
+
+// configFlags holds command line flags which override the config file.
+type configFlags struct {
+	fs           *flag.FlagSet
+	configPath   string
+	addr         string
+	socketDir    string
+	dataDir      string
+	hbaFile      string
+	identFile    string
+	metastore    string
+	changeLog    string
+	readOnly     bool
+	maxOpenConns int
+	maxIdleConns int
+	idleTimeout  time.Duration
+	pragmas      pragmaFlag
+	httpAddr     string
+	adminAddr    string
+	adminToken   string
+	shutdown     time.Duration
+	logLevel     string
+	logFormat    string
+	redact       bool
+	auditFile    string
+	auditDB      string
+	auditClasses string
+
+	maxConnections                  int
+	statementTimeout                time.Duration
+	idleInTransactionSessionTimeout time.Duration
+	idleSessionTimeout              time.Duration
+}
+
+func registerConfigFlags(fs *flag.FlagSet) *configFlags {
+	cf := &configFlags{fs: fs, pragmas: make(pragmaFlag)}
+	fs.StringVar(&cf.configPath, "config", "", "config file path")
+	fs.StringVar(&cf.addr, "addr", DefaultAddr, "postgres protocol bind address")
+	fs.StringVar(&cf.httpAddr, "http-addr", "", "bind address for the HTTP metrics & health check endpoint, disabled if blank")
+	fs.StringVar(&cf.adminAddr, "admin-addr", "", "bind address for the HTTP admin API, disabled if blank")
+	fs.StringVar(&cf.adminToken, "admin-token", "", "bearer token required by the admin API")
+	fs.StringVar(&cf.socketDir, "socket-dir", "", "comma-separated directories for Unix domain sockets")
+	fs.StringVar(&cf.dataDir, "data-dir", "", "data directory")
+	fs.StringVar(&cf.hbaFile, "hba-file", "", "access rules file")
+	fs.StringVar(&cf.identFile, "ident-file", "", "ident map file for peer authentication")
+	fs.StringVar(&cf.metastore, "metastore", "", "SQLite database that stores roles")
+	fs.StringVar(&cf.changeLog, "change-log", "", "SQLite database that logs row changes for logical replication")
+	fs.BoolVar(&cf.readOnly, "read-only", false, "open all databases read-only")
+	fs.IntVar(&cf.maxOpenConns, "max-open-conns", 0, "max open SQLite connections per database, 0 for unlimited")
+	fs.IntVar(&cf.maxIdleConns, "max-idle-conns", 2, "max idle SQLite connections per database")
+	fs.DurationVar(&cf.idleTimeout, "db-idle-timeout", postlite.DefaultIdleTimeout, "time before unused databases are closed")
+	fs.IntVar(&cf.maxConnections, "max-connections", 0, "max client connections, 0 for unlimited")
+	fs.DurationVar(&cf.statementTimeout, "statement-timeout", 0, "default statement timeout, 0 to disable")
+	fs.DurationVar(&cf.idleInTransactionSessionTimeout, "idle-in-transaction-session-timeout", 0, "time before sessions idle in a transaction are closed, 0 to disable")
+	fs.DurationVar(&cf.idleSessionTimeout, "idle-session-timeout", 0, "time before idle sessions are closed, 0 to disable")
+	fs.DurationVar(&cf.shutdown, "shutdown-timeout", DefaultShutdownTimeout, "time to wait for open transactions on shutdown")
+	fs.StringVar(&cf.logLevel, "log-level", "info", "log level: debug, info, warn or error")
+	fs.StringVar(&cf.logFormat, "log-format", "text", "log format: text or json")
+	fs.BoolVar(&cf.redact, "redact-parameters", false, "omit bound query parameters from logs")
+	fs.StringVar(&cf.auditFile, "audit-file", "", "JSON lines file for the audit log")
+	fs.StringVar(&cf.auditDB, "audit-database", "", "SQLite database for the audit log")
+	fs.StringVar(&cf.auditClasses, "audit-classes", "", "comma-separated statement classes to audit: read, write, ddl or other")
+	fs.Var(cf.pragmas, "pragma", "SQLite pragma applied to all databases, as NAME=VALUE; may be repeated")
+	return cf
+}
+
+// load reads the config file, if specified, and applies flags set on the
+// command line on top of it.
+func (cf *configFlags) load() (Config, error) {
+	config := DefaultConfig()
+	if cf.configPath != "" {
+		var err error
+		if config, err = ReadConfigFile(cf.configPath); err != nil {
+			return config, err
+		}
+	}
+
+	cf.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			config.Addr = cf.addr
+		case "http-addr":
+			config.HTTPAddr = cf.httpAddr
+		case "admin-addr":
+			config.Admin.Addr = cf.adminAddr
+		case "admin-token":
+			config.Admin.Token = cf.adminToken
+		case "socket-dir":
+			config.SocketDirs = strings.Split(cf.socketDir, ",")
+		case "data-dir":
+			config.DataDir = cf.dataDir
+		case "hba-file":
+			config.HBAFile = cf.hbaFile
+		case "ident-file":
+			config.IdentFile = cf.identFile
+		case "metastore":
+			config.MetaStore = cf.metastore
+		case "change-log":
+			config.ChangeLog = cf.changeLog
+		case "read-only":
+			config.ReadOnly = cf.readOnly
+		case "max-open-conns":
+			config.Limits.MaxOpenConns = cf.maxOpenConns
+		case "max-idle-conns":
+			config.Limits.MaxIdleConns = cf.maxIdleConns
+		case "db-idle-timeout":
+			config.Limits.DBIdleTimeout = cf.idleTimeout
+		case "log-level":
+			config.Log.Level = cf.logLevel
+		case "log-format":
+			config.Log.Format = cf.logFormat
+		case "redact-parameters":
+			config.Log.RedactParameters = cf.redact
+		case "shutdown-timeout":
+			config.ShutdownTimeout = cf.shutdown
+		case "max-connections":
+			config.Limits.MaxConnections = cf.maxConnections
+		case "statement-timeout":
+			config.Limits.StatementTimeout = cf.statementTimeout
+		case "idle-in-transaction-session-timeout":
+			config.Limits.IdleInTransactionSessionTimeout = cf.idleInTransactionSessionTimeout
+		case "idle-session-timeout":
+			config.Limits.IdleSessionTimeout = cf.idleSessionTimeout
+		case "audit-file":
+			config.Audit.File = cf.auditFile
+		case "audit-database":
+			config.Audit.Database = cf.auditDB
+		case "audit-classes":
+			config.Audit.Classes = strings.Split(cf.auditClasses, ",")
+		}
+	})
+
+	if len(cf.pragmas) > 0 && config.Pragmas == nil {
+		config.Pragmas = make(map[string]string)
+	}
+	for name, value := range cf.pragmas {
+		config.Pragmas[name] = value
 	}
-	log.Printf("postlite shutdown complete")
+	return config, nil
+}
 
+// pragmaFlag implements flag.Value to set SQLite pragmas as NAME=VALUE pairs.
+type pragmaFlag map[string]string
+
+func (f pragmaFlag) String() string { return "" }
+
+func (f pragmaFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("expected NAME=VALUE: %q", s)
+	}
+	f[strings.TrimSpace(name)] = strings.TrimSpace(value)
 	return nil
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Ensure reload applies runtime settings, warns about settings that require
+// a restart & keeps the current configuration if the new one is invalid.
+func TestReloader_Reload(t *testing.T) {
+	dataDir := t.TempDir()
+	path := writeFile(t, "postlite.yml", fmt.Sprintf("data-dir: %s\naddr: 127.0.0.1:0\n", dataDir))
+
+	fs := flag.NewFlagSet("postlite", flag.ContinueOnError)
+	cf := registerConfigFlags(fs)
+	if err := fs.Parse([]string{"-config", path}); err != nil {
+		t.Fatal(err)
+	}
+	config, err := cf.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newServer(config)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var buf bytes.Buffer
+	level := new(slog.LevelVar)
+	r := &reloader{s: s, cf: cf, config: config, level: level, logger: slog.New(slog.NewTextHandler(&buf, nil))}
+
+	writeConfig := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("data-dir: %s\n%s", dataDir, data)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig(`
+addr: 127.0.0.1:5433
+users:
+  - name: alice
+    password: secret
+pragmas:
+  synchronous: "off"
+limits:
+  max-connections: 10
+  statement-timeout: 1m
+log:
+  level: debug
+`)
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := level.Level(), slog.LevelDebug; got != want {
+		t.Fatalf("level=%s, want %s", got, want)
+	} else if got, want := s.MaxConnections, 10; got != want {
+		t.Fatalf("max-connections=%d, want %d", got, want)
+	} else if got, want := s.StatementTimeout, time.Minute; got != want {
+		t.Fatalf("statement-timeout=%s, want %s", got, want)
+	} else if s.Auth.User("alice") == nil {
+		t.Fatal("expected user to be added")
+	} else if got, want := s.DBs.Pragmas.Synchronous, "off"; got != want {
+		t.Fatalf("synchronous=%q, want %q", got, want)
+	} else if !strings.Contains(buf.String(), "setting=addr") {
+		t.Fatalf("expected restart warning, got %q", buf.String())
+	}
+
+	// Invalid settings are rejected, including those only used on restart.
+	for _, data := range []string{
+		"addr: 127.0.0.1:5433\nlimits:\n  max-connections: -1\n",
+		"addr: 127.0.0.1:5433\nlog:\n  level: loud\n",
+		"addr: 127.0.0.1:5433\ntls:\n  cert-file: missing.crt\n  key-file: missing.key\n",
+		"addr: 127.0.0.1:5433\naudit:\n  classes: [select]\n",
+		"addr: 127.0.0.1:5433\nsocket-permissions: \"999\"\n",
+		"addr: 127.0.0.1:5433\nunknown: true\n",
+	} {
+		writeConfig(data)
+		if err := r.reload(); err == nil {
+			t.Fatalf("expected error reloading:\n%s", data)
+		}
+	}
+	if got, want := level.Level(), slog.LevelDebug; got != want {
+		t.Fatalf("level=%s, want %s", got, want)
+	} else if got, want := s.MaxConnections, 10; got != want {
+		t.Fatalf("max-connections=%d, want %d", got, want)
+	} else if got, want := r.config.Limits.MaxConnections, 10; got != want {
+		t.Fatalf("config max-connections=%d, want %d", got, want)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/benbjohnson/postlite"
+	"github.com/jackc/pgproto3/v2"
+)
+
+// runPing connects to a running server, authenticates & runs "SELECT 1".
+// It exits with an error if any step fails so it can be used as a container
+// health check.
+func runPing(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("postlite-ping", flag.ContinueOnError)
+	host := fs.String("host", "localhost", "server host, or a Unix domain socket directory")
+	port := fs.String("port", postlite.DefaultPort, "server port")
+	user := fs.String("user", "postlite", "user name")
+	database := fs.String("database", "", "database name")
+	timeout := fs.Duration("timeout", 5*time.Second, "time to wait for the server")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *database == "" {
+		return fmt.Errorf("required: -database NAME")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	// Connect over a Unix domain socket if the host is a directory, like libpq.
+	network, addr := "tcp", net.JoinHostPort(*host, *port)
+	if strings.HasPrefix(*host, "/") {
+		network, addr = "unix", filepath.Join(*host, ".s.PGSQL."+*port)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	t := time.Now()
+	if err := ping(conn, *user, *database, os.Getenv("PGPASSWORD")); err != nil {
+		return fmt.Errorf("ping %s: %w", addr, err)
+	}
+	fmt.Printf("%s: ok (%s)\n", addr, time.Since(t).Round(time.Microsecond))
+	return nil
+}
+
+// ping performs a startup handshake & runs a query on conn.
+func ping(conn net.Conn, user, database, password string) error {
+	frontend := pgproto3.NewFrontend(pgproto3.NewChunkReader(conn), conn)
+
+	if err := frontend.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": user, "database": database, "application_name": "postlite ping"},
+	}); err != nil {
+		return err
+	}
+
+	// Authenticate & wait for the server to be ready for queries.
+	if err := receiveUntilReady(frontend, func(msg pgproto3.BackendMessage) error {
+		switch msg := msg.(type) {
+		case *pgproto3.AuthenticationMD5Password:
+			if password == "" {
+				return fmt.Errorf("password required, set PGPASSWORD")
+			}
+			return frontend.Send(&pgproto3.PasswordMessage{Password: md5Password(user, password, msg.Salt)})
+		case *pgproto3.AuthenticationOk, *pgproto3.BackendKeyData, *pgproto3.ParameterStatus:
+			return nil
+		default:
+			return fmt.Errorf("unexpected message during startup: %T", msg)
+		}
+	}); err != nil {
+		return err
+	}
+
+	if err := frontend.Send(&pgproto3.Query{String: "SELECT 1"}); err != nil {
+		return err
+	}
+	if err := receiveUntilReady(frontend, func(msg pgproto3.BackendMessage) error {
+		switch msg.(type) {
+		case *pgproto3.RowDescription, *pgproto3.DataRow, *pgproto3.CommandComplete:
+			return nil
+		default:
+			return fmt.Errorf("unexpected message during query: %T", msg)
+		}
+	}); err != nil {
+		return err
+	}
+
+	return frontend.Send(&pgproto3.Terminate{})
+}
+
+// receiveUntilReady passes messages to fn until the server sends ReadyForQuery.
+// Error responses are returned as errors.
+func receiveUntilReady(frontend *pgproto3.Frontend, fn func(pgproto3.BackendMessage) error) error {
+	for {
+		msg, err := frontend.Receive()
+		if err != nil {
+			return err
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.ReadyForQuery:
+			return nil
+		case *pgproto3.ErrorResponse:
+			return fmt.Errorf("%s: %s (SQLSTATE %s)", msg.Severity, msg.Message, msg.Code)
+		case *pgproto3.NoticeResponse:
+			continue
+		default:
+			if err := fn(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// md5Password returns the response to an MD5 password challenge.
+func md5Password(user, password string, salt [4]byte) string {
+	return "md5" + md5Hex(md5Hex(password+user)+string(salt[:]))
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,464 @@
+package postlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Default database manager settings.
+const (
+	DefaultJournalMode = "wal"
+	DefaultBusyTimeout = 5 * time.Second
+	DefaultIdleTimeout = 1 * time.Minute
+	DefaultConnTimeout = 5 * time.Second
+)
+
+// DBManager shares a single *sql.DB between all client connections to the
+// same SQLite file. Handles are reference counted and closed once they have
+// been unused for IdleTimeout.
+type DBManager struct {
+	mu  sync.Mutex
+	dbs map[dbKey]*DB
+
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel func()
+
+	// Maximum number of open & idle connections in each database's pool.
+	// Each client session pins one connection so MaxOpenConns also limits the
+	// number of concurrent sessions per database. Zero means unlimited.
+	MaxOpenConns int
+	MaxIdleConns int
+
+	// Time a new session waits for a connection once MaxOpenConns are in
+	// use. If zero, sessions wait until a connection is released.
+	ConnTimeout time.Duration
+
+	// PRAGMAs applied to every database. Databases matching a glob in
+	// DatabasePragmas have those settings applied on top, in order. Use
+	// SetPragmas() to replace them while the manager is open.
+	Pragmas         Pragmas
+	DatabasePragmas []*DatabasePragmas
+
+	// Loadable extensions that sessions may create with CREATE EXTENSION.
+	// Databases matching a glob in DatabaseExtensions load those extensions
+	// on every connection.
+	Extensions         []*Extension
+	DatabaseExtensions []*DatabaseExtensions
+
+	// Time an unreferenced database stays open before it is closed.
+	// If zero, databases are closed as soon as their last session ends.
+	IdleTimeout time.Duration
+
+	// Called for every new SQLite connection after pragmas are applied. Used
+	// by the server to register functions & create the pg_catalog tables.
+	ConnectHook func(conn *sqlite3.SQLiteConn, db *DB) error
+}
+
+// NewDBManager returns a new instance of DBManager with default settings.
+func NewDBManager() *DBManager {
+	m := &DBManager{
+		dbs:          make(map[dbKey]*DB),
+		MaxIdleConns: 2,
+		Pragmas:      DefaultPragmas(),
+		IdleTimeout:  DefaultIdleTimeout,
+		ConnTimeout:  DefaultConnTimeout,
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	return m
+}
+
+// Open validates the settings & starts the background monitor that closes
+// idle databases.
+func (m *DBManager) Open() error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	if m.IdleTimeout > 0 {
+		m.wg.Add(1)
+		go func() { defer m.wg.Done(); m.monitor() }()
+	}
+	return nil
+}
+
+// SetPragmas replaces the pragmas applied to databases. Databases that are
+// already open keep their settings until they are closed.
+func (m *DBManager) SetPragmas(pragmas Pragmas, databasePragmas []*DatabasePragmas) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Pragmas, m.DatabasePragmas = pragmas, databasePragmas
+}
+
+// Validate returns an error if any pragma settings are invalid.
+func (m *DBManager) Validate() error {
+	if err := m.Pragmas.Validate(); err != nil {
+		return err
+	}
+	for _, p := range m.DatabasePragmas {
+		if _, err := path.Match(p.Database, ""); err != nil {
+			return fmt.Errorf("invalid database pattern %q", p.Database)
+		} else if err := p.Pragmas.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", p.Database, err)
+		}
+	}
+	return m.validateExtensions()
+}
+
+// Close closes all databases regardless of their reference count.
+func (m *DBManager) Close() (err error) {
+	m.cancel()
+	m.wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, db := range m.dbs {
+		if e := db.DB.Close(); err == nil {
+			err = e
+		}
+		delete(m.dbs, key)
+	}
+	return err
+}
+
+// Acquire returns a shared handle to the database file at path and increments
+// its reference count. The name is the database name used by clients and is
+// matched against DatabasePragmas. The caller must call Release when finished.
+func (m *DBManager) Acquire(name, path string, readOnly bool) (*DB, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := dbKey{path: path, readOnly: readOnly}
+	if db := m.dbs[key]; db != nil {
+		db.refN++
+		return db, nil
+	}
+
+	pragmas := m.Pragmas
+	for _, p := range m.DatabasePragmas {
+		if p.Match(name) {
+			pragmas = pragmas.Merge(p.Pragmas)
+		}
+	}
+
+	db := &DB{name: name, path: path, readOnly: readOnly, refN: 1, extensions: m.databaseExtensions(name)}
+	db.DB = sql.OpenDB(&sqliteConnector{
+		driver: &sqlite3.SQLiteDriver{ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return m.initConn(conn, db, pragmas)
+		}},
+		dsn: db.dsn(),
+	})
+	db.DB.SetMaxOpenConns(m.MaxOpenConns)
+	db.DB.SetMaxIdleConns(m.MaxIdleConns)
+
+	m.dbs[key] = db
+	return db, nil
+}
+
+// Release decrements the reference count of db. The database is closed once
+// it has been unreferenced for IdleTimeout.
+func (m *DBManager) Release(db *DB) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if db.refN--; db.refN > 0 {
+		return nil
+	}
+	db.idleAt = time.Now()
+
+	if m.IdleTimeout > 0 {
+		return nil
+	}
+	delete(m.dbs, dbKey{path: db.path, readOnly: db.readOnly})
+	return db.DB.Close()
+}
+
+// DBs returns a list of all open databases.
+func (m *DBManager) DBs() []*DB {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a := make([]*DB, 0, len(m.dbs))
+	for _, db := range m.dbs {
+		a = append(a, db)
+	}
+	return a
+}
+
+// monitor periodically closes databases that have exceeded the idle timeout.
+func (m *DBManager) monitor() {
+	ticker := time.NewTicker(m.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.closeIdle()
+		}
+	}
+}
+
+func (m *DBManager) closeIdle() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, db := range m.dbs {
+		if db.refN > 0 || time.Since(db.idleAt) < m.IdleTimeout {
+			continue
+		}
+		db.DB.Close()
+		delete(m.dbs, key)
+	}
+}
+
+// initConn is called for every new SQLite connection. It applies database
+// settings & calls the connect hook.
+func (m *DBManager) initConn(conn *sqlite3.SQLiteConn, db *DB, pragmas Pragmas) error {
+	for _, stmt := range pragmas.statements(db.readOnly) {
+		if _, err := conn.Exec(stmt, nil); err != nil {
+			return fmt.Errorf("%s: %w", strings.ToLower(stmt), err)
+		}
+	}
+
+	if m.ConnectHook != nil {
+		if err := m.ConnectHook(conn, db); err != nil {
+			return err
+		}
+	}
+
+	// Disallow writes to any attached database, including pg_catalog, once
+	// the connect hook has created the catalog tables.
+	if db.readOnly {
+		if _, err := conn.Exec(`PRAGMA query_only = 1`, nil); err != nil {
+			return fmt.Errorf("set query_only: %w", err)
+		}
+	}
+
+	// Record the state of a new connection, which is the same for every
+	// connection to the database, so it can be restored on release.
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.initial == nil {
+		state, err := readConnState(conn)
+		if err != nil {
+			return err
+		}
+		db.initial = state
+	}
+	return nil
+}
+
+// connPragmas lists the connection settings a session may change, which are
+// restored before its connection is reused. query_only is restored last as
+// it prevents the others from being changed.
+var connPragmas = []string{
+	"busy_timeout", "synchronous", "foreign_keys", "cache_size", "cache_spill", "mmap_size",
+	"temp_store", "secure_delete", "automatic_index", "cell_size_check", "defer_foreign_keys",
+	"ignore_check_constraints", "legacy_alter_table", "recursive_triggers",
+	"reverse_unordered_selects", "trusted_schema", "writable_schema", "query_only",
+}
+
+// connState is the state of a new connection to a database.
+type connState struct {
+	databases map[string]string // files of attached databases, by name
+	pragmas   []string          // PRAGMA statements restoring connPragmas
+}
+
+// readConnState returns the attached databases & settings of conn.
+func readConnState(conn *sqlite3.SQLiteConn) (*connState, error) {
+	state := &connState{databases: make(map[string]string)}
+	rows, err := conn.Query(`SELECT name, file FROM pragma_database_list WHERE name NOT IN ('main', 'temp')`, nil)
+	if err != nil {
+		return nil, fmt.Errorf("database list: %w", err)
+	}
+	defer rows.Close()
+
+	dest := make([]driver.Value, 2)
+	for {
+		if err := rows.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("database list: %w", err)
+		}
+		state.databases[dest[0].(string)] = dest[1].(string)
+	}
+
+	for _, name := range connPragmas {
+		rows, err := conn.Query(`PRAGMA `+name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", name, err)
+		}
+		dest := make([]driver.Value, 1)
+		err = rows.Next(dest)
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", name, err)
+		}
+		state.pragmas = append(state.pragmas, fmt.Sprintf("PRAGMA %s = %v", name, dest[0]))
+	}
+	return state, nil
+}
+
+// DB represents a shared handle to a SQLite database file.
+type DB struct {
+	*sql.DB
+
+	name     string
+	path     string
+	readOnly bool
+	refN     int       // number of sessions using the database
+	idleAt   time.Time // time the reference count dropped to zero
+
+	mu         sync.Mutex
+	extensions []string   // loaded into every connection
+	initial    *connState // state of a new connection, restored on release
+}
+
+// NewDB returns a handle to a database opened by the caller, for use with a
+// Backend. The handle is not managed by a DBManager.
+func NewDB(name string, db *sql.DB) *DB {
+	return &DB{DB: db, name: name}
+}
+
+// Name returns the database name used by clients.
+func (db *DB) Name() string { return db.name }
+
+// Path returns the path to the database file.
+func (db *DB) Path() string { return db.path }
+
+// ReadOnly returns true if the database was opened read-only.
+func (db *DB) ReadOnly() bool { return db.readOnly }
+
+// Extensions returns the names of the extensions configured to load into
+// every connection to the database. Extensions created by sessions are
+// recorded in the database itself.
+func (db *DB) Extensions() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]string(nil), db.extensions...)
+}
+
+// resetConn restores a connection released by a session to the state of a
+// new connection so nothing the session changed is seen by the next session
+// using it: databases it attached are detached, its temporary objects are
+// dropped & the settings it changed are restored. Returns an error if the
+// connection cannot be reset, in which case it must not be reused.
+//
+// Handles not opened by a DBManager have no recorded state, so only the
+// temporary objects of their connections are dropped.
+func (db *DB) resetConn(ctx context.Context, conn *sql.Conn) error {
+	db.mu.Lock()
+	initial := db.initial
+	db.mu.Unlock()
+
+	// Temporary objects cannot be dropped by read-only sessions.
+	if _, err := conn.ExecContext(ctx, `PRAGMA query_only = OFF`); err != nil {
+		return fmt.Errorf("reset query_only: %w", err)
+	}
+
+	if initial != nil {
+		rows, err := conn.QueryContext(ctx, `SELECT name, file FROM pragma_database_list WHERE name NOT IN ('main', 'temp')`)
+		if err != nil {
+			return fmt.Errorf("database list: %w", err)
+		}
+		var detach []string
+		found := 0
+		for rows.Next() {
+			var name, file string
+			if err := rows.Scan(&name, &file); err != nil {
+				rows.Close()
+				return fmt.Errorf("database list: %w", err)
+			}
+			if f, ok := initial.databases[name]; ok && f == file {
+				found++
+			} else {
+				detach = append(detach, name)
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return fmt.Errorf("database list: %w", err)
+		} else if found != len(initial.databases) {
+			return fmt.Errorf("attached databases were detached by the session")
+		}
+
+		for _, name := range detach {
+			if _, err := conn.ExecContext(ctx, fmt.Sprintf(`DETACH DATABASE %q`, name)); err != nil {
+				return fmt.Errorf("detach %s: %w", name, err)
+			}
+		}
+	}
+
+	// Triggers & views are dropped first as they may depend on tables.
+	// Indexes are dropped with their tables.
+	rows, err := conn.QueryContext(ctx, `
+		SELECT type, name FROM temp.sqlite_schema
+		WHERE type IN ('table', 'view', 'trigger') AND name NOT LIKE 'sqlite\_%' ESCAPE '\'
+		ORDER BY CASE type WHEN 'trigger' THEN 0 WHEN 'view' THEN 1 ELSE 2 END
+	`)
+	if err != nil {
+		return fmt.Errorf("temporary objects: %w", err)
+	}
+	var drops []string
+	for rows.Next() {
+		var typ, name string
+		if err := rows.Scan(&typ, &name); err != nil {
+			rows.Close()
+			return fmt.Errorf("temporary objects: %w", err)
+		}
+		drops = append(drops, fmt.Sprintf(`DROP %s IF EXISTS temp.%q`, strings.ToUpper(typ), name))
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("temporary objects: %w", err)
+	}
+	for _, stmt := range drops {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("drop temporary objects: %w", err)
+		}
+	}
+
+	if initial != nil {
+		for _, stmt := range initial.pragmas {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("%s: %w", strings.ToLower(stmt), err)
+			}
+		}
+	}
+	return nil
+}
+
+// dsn returns the data source name used to open the database. Read-only
+// databases are opened with mode=ro so writes fail at the file level.
+func (db *DB) dsn() string {
+	if db.readOnly {
+		return "file:" + db.path + "?mode=ro"
+	}
+	return db.path
+}
+
+type dbKey struct {
+	path     string
+	readOnly bool
+}
+
+// sqliteConnector opens connections to a single database with a dedicated driver.
+type sqliteConnector struct {
+	driver *sqlite3.SQLiteDriver
+	dsn    string
+}
+
+func (c *sqliteConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *sqliteConnector) Driver() driver.Driver { return c.driver }
@@ -0,0 +1,448 @@
+package postlite
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Extension is a SQLite extension that databases may load. Extensions are
+// loaded for databases matching DBManager.DatabaseExtensions or once created
+// in a database by a superuser with CREATE EXTENSION.
+type Extension struct {
+	Name string
+
+	// Path to the shared library. Blank for extensions compiled into SQLite,
+	// such as fts5 which requires the sqlite_fts5 build tag.
+	Path string
+
+	// Name of the library's init function. If blank, it is derived from the
+	// file name as SQLite does, e.g. "sqlite3_vec_init" for "vec0.so".
+	EntryPoint string
+
+	// Version reported in pg_extension.
+	Version string
+}
+
+// entryPoint returns the init function of the extension library.
+func (e *Extension) entryPoint() string {
+	if e.EntryPoint != "" {
+		return e.EntryPoint
+	}
+
+	name := strings.TrimPrefix(filepath.Base(e.Path), "lib")
+	if i := strings.IndexByte(name, '.'); i != -1 {
+		name = name[:i]
+	}
+	var buf strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' {
+			buf.WriteRune(r)
+		}
+	}
+	return "sqlite3_" + buf.String() + "_init"
+}
+
+// builtinExtensions lists extensions that may be compiled into SQLite and
+// the compile option that enables each.
+var builtinExtensions = []struct {
+	name   string
+	option string
+}{
+	{"fts4", "ENABLE_FTS3"},
+	{"fts5", "ENABLE_FTS5"},
+	{"rtree", "ENABLE_RTREE"},
+}
+
+// DatabaseExtensions loads extensions into every connection to databases
+// matching a glob.
+type DatabaseExtensions struct {
+	Database   string   // database glob, relative to the data directory
+	Extensions []string // extension names
+}
+
+// Match returns true if name matches the database glob.
+func (e *DatabaseExtensions) Match(name string) bool {
+	ok, _ := path.Match(e.Database, name)
+	return ok
+}
+
+// extension returns the allowlisted extension with the given name. Built-in
+// extensions are available if they are compiled into SQLite. Returns nil if
+// the extension is not available.
+func (m *DBManager) extension(conn *sqlite3.SQLiteConn, name string) (*Extension, error) {
+	for _, ext := range m.Extensions {
+		if ext.Name == name {
+			return ext, nil
+		}
+	}
+
+	for _, ext := range builtinExtensions {
+		if ext.name != name {
+			continue
+		}
+		if ok, err := compileOptionUsed(conn, ext.option); err != nil || !ok {
+			return nil, err
+		}
+		return &Extension{Name: name}, nil
+	}
+	return nil, nil
+}
+
+// validateExtensions returns an error if extensions are misconfigured.
+func (m *DBManager) validateExtensions() error {
+	names := make(map[string]bool)
+	for _, ext := range m.Extensions {
+		if ext.Name == "" {
+			return fmt.Errorf("extension name required")
+		} else if names[ext.Name] {
+			return fmt.Errorf("duplicate extension: %q", ext.Name)
+		}
+		names[ext.Name] = true
+	}
+
+	for _, e := range m.DatabaseExtensions {
+		if _, err := path.Match(e.Database, ""); err != nil {
+			return fmt.Errorf("invalid database pattern %q", e.Database)
+		}
+		for _, name := range e.Extensions {
+			if !names[name] && !isBuiltinExtension(name) {
+				return fmt.Errorf("%s: unknown extension: %q", e.Database, name)
+			}
+		}
+	}
+	return nil
+}
+
+func isBuiltinExtension(name string) bool {
+	for _, ext := range builtinExtensions {
+		if ext.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// databaseExtensions returns the extensions configured for a database.
+func (m *DBManager) databaseExtensions(name string) []string {
+	var a []string
+	for _, e := range m.DatabaseExtensions {
+		if e.Match(name) {
+			a = append(a, e.Extensions...)
+		}
+	}
+	return a
+}
+
+// extensionTable is the table of the main database recording the extensions
+// created in the database. Only the server may read or write it.
+const extensionTable = "postlite_extensions"
+
+// dbExtensions returns the extensions configured for db & the extensions
+// created in it, read with conn.
+func dbExtensions(conn *sqlite3.SQLiteConn, db *DB) ([]string, error) {
+	names := db.Extensions()
+
+	rows, err := conn.Query(`SELECT name FROM main.sqlite_schema WHERE type = 'table' AND name = ?`, []driver.Value{extensionTable})
+	if err != nil {
+		return nil, fmt.Errorf("created extensions: %w", err)
+	}
+	dest := make([]driver.Value, 1)
+	exists := rows.Next(dest) == nil
+	rows.Close()
+	if !exists {
+		return names, nil
+	}
+
+	if rows, err = conn.Query(`SELECT name FROM main.`+extensionTable+` ORDER BY rowid`, nil); err != nil {
+		return nil, fmt.Errorf("created extensions: %w", err)
+	}
+	defer rows.Close()
+	for {
+		if err := rows.Next(dest); err == io.EOF {
+			return names, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("created extensions: %w", err)
+		}
+		if name, _ := dest[0].(string); !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+}
+
+func containsString(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// connExtensions tracks the extensions loaded into a SQLite connection.
+type connExtensions struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (e *connExtensions) loaded(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, n := range e.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *connExtensions) add(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.names = append(e.names, name)
+}
+
+// list returns the names of the loaded extensions.
+func (e *connExtensions) list() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.names...)
+}
+
+// loadExtensions loads the extensions of db that are not yet loaded into
+// conn. It is called for new connections & whenever a session pins a
+// connection, as other sessions may have created extensions since. Created
+// extensions that are no longer allowlisted are skipped.
+func (s *Server) loadExtensions(conn *sqlite3.SQLiteConn, db *DB) error {
+	loaded := s.connExtensions(conn)
+	if loaded == nil {
+		return nil
+	}
+
+	names, err := dbExtensions(conn, db)
+	if err != nil {
+		return err
+	}
+	configured := db.Extensions()
+	for _, name := range names {
+		if loaded.loaded(name) {
+			continue
+		}
+
+		ext, err := s.DBs.extension(conn, name)
+		if err != nil {
+			return err
+		} else if ext == nil && !containsString(configured, name) {
+			s.Logger.Warn("created extension is not available, skipping", "db", db.Name(), "extension", name)
+			continue
+		} else if ext == nil {
+			return fmt.Errorf("extension %q is not available", name)
+		}
+		if err := loadExtension(conn, loaded, ext); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadExtension loads an extension library into conn.
+func loadExtension(conn *sqlite3.SQLiteConn, loaded *connExtensions, ext *Extension) error {
+	if ext.Path != "" {
+		if err := conn.LoadExtension(ext.Path, ext.entryPoint()); err != nil {
+			return fmt.Errorf("load extension %s: %w", ext.Name, err)
+		}
+	}
+	loaded.add(ext.Name)
+	return nil
+}
+
+// connExtensions returns the extensions loaded into conn. Returns nil if the
+// connection was not initialized by the server.
+func (s *Server) connExtensions(conn *sqlite3.SQLiteConn) *connExtensions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.extensions[conn]
+}
+
+// trackExtensions starts tracking the extensions loaded into conn. The
+// returned function stops tracking once the connection is closed.
+func (s *Server) trackExtensions(conn *sqlite3.SQLiteConn) (*connExtensions, func()) {
+	loaded := &connExtensions{}
+	s.mu.Lock()
+	s.extensions[conn] = loaded
+	s.mu.Unlock()
+
+	return loaded, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.extensions[conn] == loaded {
+			delete(s.extensions, conn)
+		}
+	}
+}
+
+// pgExtensions returns the pg_extension rows for the extensions loaded into
+// a connection.
+func (s *Server) pgExtensions(loaded *connExtensions) []pgExtension {
+	names := loaded.list()
+	sort.Strings(names)
+
+	rows := make([]pgExtension, 0, len(names))
+	for _, name := range names {
+		rows = append(rows, pgExtension{
+			oid:          stableOID("extension:" + name),
+			extname:      name,
+			extowner:     10,
+			extnamespace: 2200, // public
+			extversion:   s.DBs.extensionVersion(name),
+		})
+	}
+	return rows
+}
+
+// pgAvailableExtensions returns the pg_available_extensions rows for a
+// connection: allowlisted extensions & built-in extensions compiled into SQLite.
+func (s *Server) pgAvailableExtensions(conn *sqlite3.SQLiteConn, loaded *connExtensions) ([]pgAvailableExtension, error) {
+	var rows []pgAvailableExtension
+	add := func(name, comment string) {
+		row := pgAvailableExtension{name: name, defaultVersion: s.DBs.extensionVersion(name), comment: comment}
+		if loaded.loaded(name) {
+			row.installedVersion = row.defaultVersion
+		}
+		rows = append(rows, row)
+	}
+
+	for _, ext := range s.DBs.Extensions {
+		add(ext.Name, ext.Path)
+	}
+	for _, ext := range builtinExtensions {
+		if ok, err := compileOptionUsed(conn, ext.option); err != nil {
+			return nil, err
+		} else if ok && !s.DBs.hasExtension(ext.name) {
+			add(ext.name, "compiled into SQLite")
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].name < rows[j].name })
+	return rows, nil
+}
+
+// extensionVersion returns the version of an extension reported in the
+// catalog. Defaults to "1.0".
+func (m *DBManager) extensionVersion(name string) string {
+	for _, ext := range m.Extensions {
+		if ext.Name == name && ext.Version != "" {
+			return ext.Version
+		}
+	}
+	return "1.0"
+}
+
+// hasExtension returns true if the extension is allowlisted.
+func (m *DBManager) hasExtension(name string) bool {
+	for _, ext := range m.Extensions {
+		if ext.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// stableOID returns an object ID derived from a key so objects keep the same
+// ID across connections.
+func stableOID(key string) int {
+	h := 0
+	for _, b := range []byte(key) {
+		h = (h*31 + int(b)) % 1000000
+	}
+	return 100000 + h
+}
+
+// handleCreateExtension handles CREATE EXTENSION statements by loading an
+// allowlisted extension. Returns false if the query is not CREATE EXTENSION.
+func (s *Server) handleCreateExtension(ctx context.Context, c *Conn, w *resultBuffer, query string) (bool, error) {
+	m := createExtensionRegex.FindStringSubmatch(query)
+	if m == nil {
+		return false, nil
+	}
+	ifNotExists, name := m[1] != "", strings.Trim(m[2], `"`)
+
+	if exists, err := s.createExtension(ctx, c, name); err != nil {
+		return true, err
+	} else if exists && !ifNotExists {
+		return true, Errorf("42710", "extension %q already exists", name) // duplicate_object
+	} else if exists {
+		w.notices = append(w.notices, &pgproto3.NoticeResponse{Severity: "NOTICE", Code: "42710", Message: fmt.Sprintf("extension %q already exists, skipping", name)})
+	}
+	w.SetCommandTag("CREATE EXTENSION")
+	return true, nil
+}
+
+// createExtension loads an extension into the session's connection & records
+// it in the session's database, so every connection to the database loads it.
+// Only superusers may create extensions. Returns true if the database already
+// has the extension.
+func (s *Server) createExtension(ctx context.Context, c *Conn, name string) (exists bool, _ error) {
+	if names, err := dbExtensions(c.sqliteConn, c.db); err != nil {
+		return false, err
+	} else if containsString(names, name) {
+		return true, nil
+	}
+
+	if c.readOnly {
+		return false, Errorf("25006", "cannot execute CREATE EXTENSION in a read-only transaction") // read_only_sql_transaction
+	}
+	if role, err := s.sessionRole(c); err != nil {
+		return false, err
+	} else if !role.Superuser {
+		return false, Errorf("42501", "permission denied to create extension %q", name) // insufficient_privilege
+	}
+
+	ext, err := s.DBs.extension(c.sqliteConn, name)
+	if err != nil {
+		return false, err
+	} else if ext == nil {
+		return false, Errorf("0A000", "extension %q is not available", name) // feature_not_supported
+	}
+
+	if loaded := s.connExtensions(c.sqliteConn); loaded != nil && !loaded.loaded(name) {
+		if err := loadExtension(c.sqliteConn, loaded, ext); err != nil {
+			return false, Errorf("58P01", "%s", err) // undefined_file
+		}
+	}
+
+	defer c.withoutPrivileges()()
+	if _, err := c.conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS main.`+extensionTable+` (name TEXT PRIMARY KEY)`); err != nil {
+		return false, fmt.Errorf("create extension table: %w", err)
+	} else if _, err := c.conn.ExecContext(ctx, `INSERT INTO main.`+extensionTable+` (name) VALUES (?)`, name); err != nil {
+		return false, fmt.Errorf("record extension: %w", err)
+	}
+	c.logger.Info("extension created", "extension", name)
+	return false, nil
+}
+
+var createExtensionRegex = regexp.MustCompile(`(?i)^\s*CREATE\s+EXTENSION\s+(IF\s+NOT\s+EXISTS\s+)?("[^"]+"|[\w-]+)(?:\s+(?:WITH\s+)?(?:SCHEMA\s+\S+|VERSION\s+\S+|CASCADE)\s*)*;?\s*$`)
+
+// compileOptionUsed returns true if SQLite was compiled with the option.
+func compileOptionUsed(conn *sqlite3.SQLiteConn, option string) (bool, error) {
+	rows, err := conn.Query(`SELECT sqlite_compileoption_used(?)`, []driver.Value{option})
+	if err != nil {
+		return false, fmt.Errorf("compile option %s: %w", option, err)
+	}
+	defer rows.Close()
+
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		return false, fmt.Errorf("compile option %s: %w", option, err)
+	}
+	return dest[0] == int64(1), nil
+}
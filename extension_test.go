@@ -0,0 +1,38 @@
+package postlite_test
+
+import (
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure extensions are created by superusers only & are recorded in the
+// database, so they are loaded again once the database is reopened.
+func TestServer_CreateExtension(t *testing.T) {
+	dir, store := t.TempDir(), MustOpenMetaStore(t)
+	s := MustOpenServer(t, func(s *postlite.Server) { s.DataDir, s.MetaStore = dir, store })
+
+	admin := MustConnect(t, s, "db", "sqlite3", "")
+	admin.MustQuery(`CREATE ROLE low LOGIN`)
+	admin.MustQuery(`CREATE TABLE t (x)`)
+	low := MustConnect(t, s, "db", "low", "")
+	low.MustFailQuery(`CREATE EXTENSION rtree`, "42501")
+	low.MustFailQueryExtended(`CREATE EXTENSION rtree`, "42501")
+
+	admin.MustQueryExtended(`CREATE EXTENSION rtree`)
+	admin.MustQuery(`GRANT ALL ON ALL TABLES IN SCHEMA public TO low`)
+	low.MustFailQuery(`INSERT INTO postlite_extensions VALUES ('fts4')`, "42501")
+	admin.Close()
+	low.Close()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = MustOpenServer(t, func(s *postlite.Server) { s.DataDir, s.MetaStore = dir, store })
+	c := MustConnect(t, s, "db", "sqlite3", "")
+	if rows := c.MustQuery(`SELECT extname FROM pg_extension`); len(rows) != 1 || rows[0][0] != "rtree" {
+		t.Fatalf("extensions=%v, want rtree", rows)
+	}
+	c.MustFailQuery(`CREATE EXTENSION rtree`, "42710")
+	c.MustQuery(`CREATE EXTENSION IF NOT EXISTS rtree`)
+}
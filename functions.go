@@ -0,0 +1,146 @@
+package postlite
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Function is a scalar SQL function registered on every SQLite connection.
+type Function struct {
+	Name string
+
+	// Go function implementing the SQL function. See
+	// sqlite3.SQLiteConn.RegisterFunc for the supported signatures.
+	Impl interface{}
+
+	// If true, the function is deterministic: it always returns the same
+	// result for the same arguments, which lets SQLite optimize calls & use
+	// it in indexes. Passed as the pure argument of RegisterFunc.
+	Pure bool
+}
+
+// Aggregate is an aggregate SQL function registered on every SQLite
+// connection.
+type Aggregate struct {
+	Name string
+
+	// Constructor of the aggregator type. See
+	// sqlite3.SQLiteConn.RegisterAggregator for its required methods.
+	Impl interface{}
+
+	// If true, the aggregate is deterministic: it always returns the same
+	// result for the same rows. Passed as the pure argument of
+	// RegisterAggregator.
+	Pure bool
+}
+
+// Collation is a text collating sequence registered on every SQLite
+// connection.
+type Collation struct {
+	Name    string
+	Compare func(a, b string) int
+}
+
+// Module is a virtual table module registered on every SQLite connection.
+// Tables are created with CREATE VIRTUAL TABLE ... USING Name.
+type Module struct {
+	Name   string
+	Module sqlite3.Module
+}
+
+// registerCustom registers the server's custom functions, aggregates,
+// collations & modules. They are registered after the built-in functions so
+// functions with the same name & number of arguments replace them.
+func (s *Server) registerCustom(conn *sqlite3.SQLiteConn) error {
+	for _, fn := range s.Functions {
+		if err := conn.RegisterFunc(fn.Name, fn.Impl, fn.Pure); err != nil {
+			return fmt.Errorf("cannot register %s() function: %w", fn.Name, err)
+		}
+	}
+	for _, agg := range s.Aggregates {
+		if err := conn.RegisterAggregator(agg.Name, agg.Impl, agg.Pure); err != nil {
+			return fmt.Errorf("cannot register %s() aggregate: %w", agg.Name, err)
+		}
+	}
+	for _, coll := range s.Collations {
+		if err := conn.RegisterCollation(coll.Name, coll.Compare); err != nil {
+			return fmt.Errorf("cannot register %s collation: %w", coll.Name, err)
+		}
+	}
+	for _, mod := range s.Modules {
+		if err := conn.CreateModule(mod.Name, mod.Module); err != nil {
+			return fmt.Errorf("cannot register %s module: %w", mod.Name, err)
+		}
+	}
+	return nil
+}
+
+// pgProcs returns the pg_proc rows for the functions registered on conn:
+// SQLite's built-in functions, the Postgres compatibility functions & the
+// server's custom functions, which are reported in the public schema.
+func (s *Server) pgProcs(conn *sqlite3.SQLiteConn) ([]pgProc, error) {
+	custom := make(map[string]bool)
+	for _, fn := range s.Functions {
+		custom[strings.ToLower(fn.Name)] = true
+	}
+	for _, agg := range s.Aggregates {
+		custom[strings.ToLower(agg.Name)] = true
+	}
+
+	rows, err := conn.Query(`SELECT name, type, narg, flags FROM pragma_function_list WHERE enc = 'utf8' ORDER BY name, narg`, nil)
+	if err != nil {
+		return nil, fmt.Errorf("function list: %w", err)
+	}
+	defer rows.Close()
+
+	var procs []pgProc
+	dest := make([]driver.Value, 4)
+	for {
+		if err := rows.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("function list: %w", err)
+		}
+		name, typ, narg, flags := dest[0].(string), dest[1].(string), dest[2].(int64), dest[3].(int64)
+
+		proc := pgProc{
+			oid:          stableOID(fmt.Sprintf("proc:%s/%d", name, narg)),
+			proname:      name,
+			pronamespace: 11, // pg_catalog
+			prokind:      "f",
+			provolatile:  "v",
+			pronargs:     int(narg),
+			prorettype:   25, // text
+		}
+		if custom[name] {
+			proc.pronamespace = 2200 // public
+		}
+		switch {
+		case typ == "w" && windowFuncs[name]:
+			proc.prokind = "w"
+		case typ == "a" || typ == "w": // aggregates usable as window functions
+			proc.prokind = "a"
+		}
+		if flags&0x800 != 0 { // SQLITE_DETERMINISTIC
+			proc.provolatile = "i"
+		}
+		if narg < 0 {
+			proc.pronargs, proc.provariadic = 1, 25
+		}
+		proc.proargtypes = strings.TrimSpace(strings.Repeat("25 ", proc.pronargs))
+		procs = append(procs, proc)
+	}
+	return procs, nil
+}
+
+// windowFuncs lists SQLite's built-in functions that can only be used as
+// window functions.
+var windowFuncs = map[string]bool{
+	"row_number": true, "rank": true, "dense_rank": true, "percent_rank": true,
+	"cume_dist": true, "ntile": true, "lag": true, "lead": true,
+	"first_value": true, "last_value": true, "nth_value": true,
+}
@@ -0,0 +1,137 @@
+package postlite_test
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Ensure custom functions, aggregates, collations & modules are registered
+// on every session's connection.
+func TestServer_Custom(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.Functions = []*postlite.Function{
+			{Name: "shout", Impl: func(s string) string { return strings.ToUpper(s) + "!" }, Pure: true},
+			{Name: "version", Impl: func() string { return "PostgreSQL 13.0 (test)" }, Pure: true},
+		}
+		s.Aggregates = []*postlite.Aggregate{
+			{Name: "longest", Impl: func() *longest { return &longest{} }, Pure: true},
+		}
+		s.Collations = []*postlite.Collation{
+			{Name: "reverse", Compare: func(a, b string) int { return strings.Compare(b, a) }},
+		}
+		s.Modules = []*postlite.Module{
+			{Name: "series", Module: seriesModule{}},
+		}
+	})
+
+	c := MustConnect(t, s, "db", "alice", "")
+	c.MustQuery(`CREATE TABLE t (s TEXT)`)
+	c.MustQuery(`INSERT INTO t VALUES ('a'), ('ccc'), ('bb')`)
+
+	if rows := c.MustQuery(`SELECT shout(s) FROM t ORDER BY s`); !reflect.DeepEqual(rows, [][]string{{"A!"}, {"BB!"}, {"CCC!"}}) {
+		t.Fatalf("shout: rows=%v", rows)
+	}
+	if rows := c.MustQueryExtended(`SELECT shout($1)`, "hi"); !reflect.DeepEqual(rows, [][]string{{"HI!"}}) {
+		t.Fatalf("shout: rows=%v", rows)
+	}
+
+	// Deterministic functions can be used in indexes.
+	c.MustQuery(`CREATE INDEX t_shout ON t (shout(s))`)
+
+	// Custom functions replace built-in functions of the same name.
+	if rows := c.MustQuery(`SELECT version()`); !reflect.DeepEqual(rows, [][]string{{"PostgreSQL 13.0 (test)"}}) {
+		t.Fatalf("version: rows=%v", rows)
+	}
+
+	if rows := c.MustQuery(`SELECT longest(s) FROM t`); !reflect.DeepEqual(rows, [][]string{{"ccc"}}) {
+		t.Fatalf("longest: rows=%v", rows)
+	}
+
+	if rows := c.MustQuery(`SELECT s FROM t ORDER BY s COLLATE reverse`); !reflect.DeepEqual(rows, [][]string{{"ccc"}, {"bb"}, {"a"}}) {
+		t.Fatalf("reverse: rows=%v", rows)
+	}
+
+	c.MustQuery(`CREATE VIRTUAL TABLE three USING series(3)`)
+	if rows := c.MustQuery(`SELECT value FROM three`); !reflect.DeepEqual(rows, [][]string{{"1"}, {"2"}, {"3"}}) {
+		t.Fatalf("series: rows=%v", rows)
+	}
+
+	// Other sessions have their own connections with the same registrations.
+	other := MustConnect(t, s, "db", "bob", "")
+	if rows := other.MustQuery(`SELECT shout(s), value FROM t, three WHERE value = length(s) ORDER BY value`); !reflect.DeepEqual(rows, [][]string{{"A!", "1"}, {"BB!", "2"}, {"CCC!", "3"}}) {
+		t.Fatalf("rows=%v", rows)
+	}
+}
+
+// longest is an aggregator returning its longest string.
+type longest struct{ s string }
+
+func (a *longest) Step(s string) {
+	if len(s) > len(a.s) {
+		a.s = s
+	}
+}
+
+func (a *longest) Done() string { return a.s }
+
+// seriesModule is a virtual table module whose tables hold the integers from
+// 1 to the module argument.
+type seriesModule struct{}
+
+func (m seriesModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Connect(c, args)
+}
+
+func (seriesModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	if len(args) != 4 {
+		return nil, fmt.Errorf("series: expected one argument")
+	}
+	n, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("series: %w", err)
+	}
+	if err := c.DeclareVTab(`CREATE TABLE x (value INTEGER)`); err != nil {
+		return nil, err
+	}
+	return &seriesTable{n: n}, nil
+}
+
+func (seriesModule) DestroyModule() {}
+
+type seriesTable struct{ n int64 }
+
+func (t *seriesTable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	return &sqlite3.IndexResult{Used: make([]bool, len(cst))}, nil
+}
+
+func (t *seriesTable) Disconnect() error { return nil }
+func (t *seriesTable) Destroy() error    { return nil }
+
+func (t *seriesTable) Open() (sqlite3.VTabCursor, error) {
+	return &seriesCursor{n: t.n}, nil
+}
+
+type seriesCursor struct{ i, n int64 }
+
+func (c *seriesCursor) Close() error { return nil }
+
+func (c *seriesCursor) Filter(idxNum int, idxStr string, vals []interface{}) error {
+	c.i = 1
+	return nil
+}
+
+func (c *seriesCursor) Next() error { c.i++; return nil }
+func (c *seriesCursor) EOF() bool   { return c.i > c.n }
+
+func (c *seriesCursor) Column(ctx *sqlite3.SQLiteContext, col int) error {
+	ctx.ResultInt64(c.i)
+	return nil
+}
+
+func (c *seriesCursor) Rowid() (int64, error) { return c.i, nil }
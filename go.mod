@@ -1,13 +1,24 @@
 module github.com/benbjohnson/postlite
 
-go 1.18
+go 1.21
 
-require github.com/jackc/pgproto3/v2 v2.2.0
+require (
+	github.com/jackc/pgproto3/v2 v2.2.0
+	github.com/jackc/pgtype v1.10.0
+	github.com/mattn/go-sqlite3 v1.14.12
+	github.com/prometheus/client_golang v1.19.1
+	github.com/prometheus/client_model v0.5.0
+	golang.org/x/sync v0.3.0
+	gopkg.in/yaml.v2 v2.4.0
+)
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/jackc/chunkreader/v2 v2.0.1 // indirect
 	github.com/jackc/pgio v1.0.0 // indirect
-	github.com/jackc/pgtype v1.10.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.12 // indirect
-	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
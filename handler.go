@@ -0,0 +1,185 @@
+package postlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgtype"
+)
+
+// Query is a statement received from a client, passed to the server's query
+// handler.
+type Query struct {
+	SQL  string        // statement text, as sent by the client
+	Args []interface{} // bound parameters of extended protocol queries
+
+	// Session that sent the query.
+	PID      int32
+	User     string
+	Database string
+	ReadOnly bool
+
+	// SQLite connection pinned by the session. Handlers that answer a query
+	// themselves may still use it, e.g. to run a different statement.
+	Conn *sql.Conn
+}
+
+// ResultWriter is used by a query handler to send the results of a query.
+type ResultWriter interface {
+	// WriteColumns sets the names of the result columns. It must be called
+	// before any rows are written.
+	WriteColumns(names ...string) error
+
+	// WriteRow adds a row to the result. Values are sent as text.
+	WriteRow(values ...interface{}) error
+
+	// SetCommandTag sets the tag sent once the query completes. Defaults
+	// to "SELECT 1".
+	SetCommandTag(tag string)
+}
+
+// QueryHandler executes queries for client sessions. Returning an error sends
+// an error response to the client; return an *Error to set its SQLSTATE.
+type QueryHandler interface {
+	ServeQuery(ctx context.Context, w ResultWriter, q *Query) error
+}
+
+// QueryHandlerFunc is an adapter to allow ordinary functions to be used as
+// query handlers.
+type QueryHandlerFunc func(ctx context.Context, w ResultWriter, q *Query) error
+
+func (fn QueryHandlerFunc) ServeQuery(ctx context.Context, w ResultWriter, q *Query) error {
+	return fn(ctx, w, q)
+}
+
+// Middleware wraps a query handler. Middleware can observe or rewrite a query
+// before passing it to next, reject it by returning an error, or answer it
+// without calling next.
+type Middleware func(next QueryHandler) QueryHandler
+
+// Error is an error reported to the client with a SQLSTATE code.
+type Error struct {
+	Code    string
+	Message string
+}
+
+// Errorf returns an error with the given SQLSTATE code & formatted message.
+func Errorf(code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// queryHandler returns the server's handler wrapped by its middleware. The
+// first middleware is outermost.
+func (s *Server) queryHandler() QueryHandler {
+	h := s.Handler
+	if h == nil {
+		h = QueryHandlerFunc(execQuery)
+	}
+	for i := len(s.Middleware) - 1; i >= 0; i-- {
+		h = s.Middleware[i](h)
+	}
+	return h
+}
+
+// execQuery is the default query handler. It executes queries against the
+// session's SQLite connection.
+func execQuery(ctx context.Context, w ResultWriter, q *Query) error {
+	// Rewrite system-information queries so they're tolerable by SQLite.
+	rows, err := q.Conn.QueryContext(ctx, rewriteQuery(q.SQL), q.Args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("columns: %w", err)
+	}
+	if err := w.WriteColumns(cols...); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(cols))
+	refs := make([]interface{}, len(cols))
+	for i := range refs {
+		refs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(refs...); err != nil {
+			return fmt.Errorf("scan: %w", err)
+		}
+		if err := w.WriteRow(values...); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// resultBuffer collects the results written by a query handler so they can be
+// sent to the client once the handler returns.
+type resultBuffer struct {
+	cols    []string
+	rows    []*pgproto3.DataRow
+	tag     string
+	notices []*pgproto3.NoticeResponse // sent before the rows
+}
+
+func (b *resultBuffer) WriteColumns(names ...string) error {
+	if len(b.rows) > 0 {
+		return fmt.Errorf("columns must be written before rows")
+	}
+	b.cols = names
+	return nil
+}
+
+func (b *resultBuffer) WriteRow(values ...interface{}) error {
+	if len(values) != len(b.cols) {
+		return fmt.Errorf("row has %d values, expected %d", len(values), len(b.cols))
+	}
+
+	// Convert to TEXT values to return over Postgres wire protocol.
+	row := &pgproto3.DataRow{Values: make([][]byte, len(values))}
+	for i := range values {
+		row.Values[i] = []byte(fmt.Sprint(values[i]))
+	}
+	b.rows = append(b.rows, row)
+	return nil
+}
+
+func (b *resultBuffer) SetCommandTag(tag string) { b.tag = tag }
+
+// rowDescription returns the description of the result columns.
+func (b *resultBuffer) rowDescription() *pgproto3.RowDescription {
+	var desc pgproto3.RowDescription
+	for _, name := range b.cols {
+		desc.Fields = append(desc.Fields, pgproto3.FieldDescription{
+			Name:                 []byte(name),
+			TableOID:             0,
+			TableAttributeNumber: 0,
+			DataTypeOID:          pgtype.TextOID,
+			DataTypeSize:         -1,
+			TypeModifier:         -1,
+			Format:               0,
+		})
+	}
+	return &desc
+}
+
+// encodeRows encodes the notices, the rows & the command completion.
+func (b *resultBuffer) encodeRows(buf []byte) []byte {
+	for _, notice := range b.notices {
+		buf = notice.Encode(buf)
+	}
+	for _, row := range b.rows {
+		buf = row.Encode(buf)
+	}
+	tag := b.tag
+	if tag == "" {
+		tag = "SELECT 1"
+	}
+	return (&pgproto3.CommandComplete{CommandTag: []byte(tag)}).Encode(buf)
+}
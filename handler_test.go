@@ -0,0 +1,115 @@
+package postlite_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure middleware can rewrite queries, answer them & reject them with a
+// SQLSTATE over both query protocols.
+func TestServer_Middleware(t *testing.T) {
+	var queries []postlite.Query
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.Middleware = append(s.Middleware,
+			func(next postlite.QueryHandler) postlite.QueryHandler {
+				return postlite.QueryHandlerFunc(func(ctx context.Context, w postlite.ResultWriter, q *postlite.Query) error {
+					queries = append(queries, *q)
+					return next.ServeQuery(ctx, w, q)
+				})
+			},
+			func(next postlite.QueryHandler) postlite.QueryHandler {
+				return postlite.QueryHandlerFunc(func(ctx context.Context, w postlite.ResultWriter, q *postlite.Query) error {
+					if strings.HasPrefix(strings.ToUpper(q.SQL), "DROP") {
+						return postlite.Errorf("42501", "drops are not allowed")
+					}
+					q.SQL = strings.ReplaceAll(q.SQL, "legacy_t", "t")
+					return next.ServeQuery(ctx, w, q)
+				})
+			},
+		)
+	})
+
+	c := MustConnect(t, s, "db", "alice", "")
+	c.MustQuery(`CREATE TABLE t (x INTEGER)`)
+	c.MustQuery(`INSERT INTO legacy_t VALUES (1)`)
+	if rows := c.MustQueryExtended(`SELECT x FROM legacy_t WHERE x = $1`, "1"); !reflect.DeepEqual(rows, [][]string{{"1"}}) {
+		t.Fatalf("rows=%v", rows)
+	}
+
+	c.MustFailQuery(`DROP TABLE t`, "42501")
+	c.MustFailQueryExtended(`DROP TABLE t`, "42501")
+	if _, err := c.Query(`DROP TABLE t`); err == nil || !strings.Contains(err.Error(), "drops are not allowed") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.MustQuery(`SELECT * FROM t`)
+
+	// The outermost middleware sees each query as sent by the client.
+	q := queries[1]
+	if q.SQL != `INSERT INTO legacy_t VALUES (1)` || q.User != "alice" || q.Database != "db" || q.PID == 0 || q.Conn == nil {
+		t.Fatalf("unexpected query: %+v", q)
+	} else if q := queries[2]; !reflect.DeepEqual(q.Args, []interface{}{"1"}) {
+		t.Fatalf("args=%v", q.Args)
+	}
+}
+
+// Ensure a custom handler can answer queries without SQLite & that the
+// results it writes, including the command tag, reach the client.
+func TestServer_Handler(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.Handler = postlite.QueryHandlerFunc(func(ctx context.Context, w postlite.ResultWriter, q *postlite.Query) error {
+			switch q.SQL {
+			case "SHOW fruit":
+				if err := w.WriteColumns("name", "count", "note"); err != nil {
+					return err
+				} else if err := w.WriteRow("apple", 3, nil); err != nil {
+					return err
+				} else if err := w.WriteRow("pear", 1.5, true); err != nil {
+					return err
+				}
+				w.SetCommandTag("SHOW 2")
+				return nil
+			case "SHOW invalid":
+				if err := w.WriteRow("before columns"); err == nil {
+					return errors.New("expected WriteRow to fail before WriteColumns")
+				}
+				if err := w.WriteColumns("a"); err != nil {
+					return err
+				} else if err := w.WriteRow(1, 2); err == nil {
+					return errors.New("expected WriteRow to fail with too many values")
+				} else if err := w.WriteRow(1); err != nil {
+					return err
+				} else if err := w.WriteColumns("b"); err == nil {
+					return errors.New("expected WriteColumns to fail after rows")
+				}
+				return nil
+			default:
+				return postlite.Errorf("0A000", "unsupported: %s", q.SQL)
+			}
+		})
+	})
+
+	c := MustConnect(t, s, "db", "alice", "")
+	if rows := c.MustQuery(`SHOW fruit`); !reflect.DeepEqual(rows, [][]string{{"apple", "3", "<nil>"}, {"pear", "1.5", "true"}}) {
+		t.Fatalf("rows=%v", rows)
+	} else if !reflect.DeepEqual(c.Columns, []string{"name", "count", "note"}) {
+		t.Fatalf("columns=%v", c.Columns)
+	} else if c.CommandTag != "SHOW 2" {
+		t.Fatalf("tag=%q, want SHOW 2", c.CommandTag)
+	}
+
+	// The command tag defaults to SELECT 1.
+	if rows := c.MustQuery(`SHOW invalid`); !reflect.DeepEqual(rows, [][]string{{"1"}}) {
+		t.Fatalf("rows=%v", rows)
+	} else if c.CommandTag != "SELECT 1" {
+		t.Fatalf("tag=%q, want SELECT 1", c.CommandTag)
+	}
+
+	// Queries never reach SQLite.
+	c.MustFailQuery(`CREATE TABLE t (x)`, "0A000")
+	c.MustFailQueryExtended(`SELECT 1`, "0A000")
+}
@@ -0,0 +1,286 @@
+package postlite
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+)
+
+// Access represents the level of access granted by an access rule.
+type Access int
+
+const (
+	AccessDeny Access = iota
+	AccessAllow
+	AccessReadOnly
+)
+
+// String returns the keyword used for the access level in a rules file.
+func (a Access) String() string {
+	switch a {
+	case AccessDeny:
+		return "deny"
+	case AccessAllow:
+		return "allow"
+	case AccessReadOnly:
+		return "readonly"
+	default:
+		return fmt.Sprintf("Access<%d>", a)
+	}
+}
+
+// ParseAccess returns the access level for a rules file keyword.
+func ParseAccess(s string) (Access, error) {
+	switch s {
+	case "deny":
+		return AccessDeny, nil
+	case "allow":
+		return AccessAllow, nil
+	case "readonly":
+		return AccessReadOnly, nil
+	default:
+		return 0, fmt.Errorf("invalid access %q", s)
+	}
+}
+
+// AuthMethod represents how clients matching an access rule authenticate.
+type AuthMethod int
+
+const (
+	// Clients authenticate with a password if any users are defined.
+	AuthPassword AuthMethod = iota
+
+	// Clients on Unix domain sockets authenticate as their operating system user.
+	AuthPeer
+)
+
+// String returns the keyword used for the auth method in a rules file.
+func (m AuthMethod) String() string {
+	switch m {
+	case AuthPassword:
+		return "password"
+	case AuthPeer:
+		return "peer"
+	default:
+		return fmt.Sprintf("AuthMethod<%d>", m)
+	}
+}
+
+// ParseAuthMethod returns the auth method for a rules file keyword.
+func ParseAuthMethod(s string) (AuthMethod, error) {
+	switch s {
+	case "password":
+		return AuthPassword, nil
+	case "peer":
+		return AuthPeer, nil
+	default:
+		return 0, fmt.Errorf("invalid auth method %q", s)
+	}
+}
+
+// AccessRule matches a connection by database, user & client address and
+// determines what level of access the connection is granted.
+type AccessRule struct {
+	Type     string     // connection type, "host" or "local"
+	Database string     // database glob, or "all"
+	User     string     // user name, or "all"
+	Network  *net.IPNet // client network, nil matches all addresses
+	Access   Access
+
+	// Authentication method & the name of the ident map used to map
+	// operating system users to database users for peer authentication.
+	Auth     AuthMethod
+	IdentMap string
+}
+
+// Match returns true if the rule applies to the given connection.
+func (r *AccessRule) Match(database, user string, addr net.Addr) bool {
+	if _, local := addr.(*net.UnixAddr); local != (r.Type == "local") {
+		return false
+	}
+	if r.Database != "all" {
+		if ok, _ := path.Match(r.Database, database); !ok {
+			return false
+		}
+	}
+	if r.User != "all" && r.User != user {
+		return false
+	}
+
+	if r.Network != nil {
+		ip := addrIP(addr)
+		if ip == nil || !r.Network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchAccessRule returns the first rule that matches the connection.
+// Returns nil if no rules match.
+func MatchAccessRule(rules []*AccessRule, database, user string, addr net.Addr) *AccessRule {
+	for _, r := range rules {
+		if r.Match(database, user, addr) {
+			return r
+		}
+	}
+	return nil
+}
+
+// ReadAccessRulesFile parses access rules from a file at filename.
+func ReadAccessRulesFile(filename string) ([]*AccessRule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules, err := ParseAccessRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return rules, nil
+}
+
+// ParseAccessRules parses access rules in a format similar to pg_hba.conf.
+// Each non-empty line contains whitespace-separated fields:
+//
+//	host   DATABASE  USER  ADDRESS  ACCESS  [AUTH]
+//	local  DATABASE  USER  ACCESS  [AUTH [map=NAME]]
+//
+// "host" lines match TCP connections & "local" lines match Unix domain socket
+// connections. DATABASE is a glob matched against the database path relative
+// to the data directory. USER & ADDRESS may be "all". ADDRESS is a CIDR block
+// or a single IP address. ACCESS is "allow", "deny" or "readonly".
+// AUTH is "password", the default, or "peer" for local connections. Peer
+// authentication may use an ident map to map operating system users to
+// database users. Everything after a "#" is treated as a comment.
+func ParseAccessRules(r io.Reader) ([]*AccessRule, error) {
+	var rules []*AccessRule
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// Local connections have no address field.
+		if fields[0] == "local" && len(fields) >= 4 {
+			fields = append([]string{fields[0], fields[1], fields[2], ""}, fields[3:]...)
+		}
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("line %d: expected at least 5 fields, found %d", lineNo, len(fields))
+		}
+
+		rule, err := NewAccessRule(fields[0], fields[1], fields[2], fields[3], fields[4])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if err := rule.parseOptions(fields[5:]); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// NewAccessRule returns an access rule from the fields of a rules file line.
+// The address must be blank for local rules.
+func NewAccessRule(typ, database, user, address, access string) (_ *AccessRule, err error) {
+	rule := &AccessRule{Type: typ, Database: database, User: user}
+	switch rule.Type {
+	case "host":
+		if rule.Network, err = parseNetwork(address); err != nil {
+			return nil, err
+		}
+	case "local":
+		if address != "" {
+			return nil, fmt.Errorf("address not allowed for local connections")
+		}
+	default:
+		return nil, fmt.Errorf("invalid connection type %q", rule.Type)
+	}
+
+	if _, err := path.Match(rule.Database, ""); err != nil {
+		return nil, fmt.Errorf("invalid database pattern %q", rule.Database)
+	}
+	if rule.Access, err = ParseAccess(access); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// parseOptions parses the optional auth method & "map=NAME" fields.
+func (r *AccessRule) parseOptions(fields []string) (err error) {
+	if len(fields) == 0 {
+		return nil
+	}
+	if r.Auth, err = ParseAuthMethod(fields[0]); err != nil {
+		return err
+	}
+
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "map":
+			r.IdentMap = value
+		default:
+			return fmt.Errorf("invalid option %q", field)
+		}
+	}
+	return r.Validate()
+}
+
+// Validate returns an error if the auth settings are invalid for the rule type.
+func (r *AccessRule) Validate() error {
+	if r.Auth == AuthPeer && r.Type != "local" {
+		return fmt.Errorf("peer authentication is only supported for local connections")
+	} else if r.IdentMap != "" && r.Auth != AuthPeer {
+		return fmt.Errorf("ident map requires peer authentication")
+	}
+	return nil
+}
+
+// parseNetwork parses a CIDR block or a single IP address. Returns nil for "all".
+func parseNetwork(s string) (*net.IPNet, error) {
+	if s == "all" {
+		return nil, nil
+	}
+
+	if strings.Contains(s, "/") {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", s)
+		}
+		return ipnet, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// addrIP returns the IP address of a network address, if available.
+func addrIP(addr net.Addr) net.IP {
+	if addr, ok := addr.(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package postlite_test
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure sessions admitted by access rules cannot reach other databases by
+// attaching them.
+func TestServer_AccessRules_Attach(t *testing.T) {
+	rules, err := postlite.ParseAccessRules(strings.NewReader("host  public  all  127.0.0.1  allow\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := MustOpenServer(t)
+	MustConnect(t, s, "prod", "admin", "").MustQuery(`CREATE TABLE secret (x)`)
+	MustConnect(t, s, "prod", "admin", "").MustQuery(`INSERT INTO secret VALUES ('s3cr3t')`)
+	s.SetAuth(&postlite.Auth{AccessRules: rules})
+
+	if _, err := Connect(t, s, "prod", "alice", ""); err == nil {
+		t.Fatal("expected access to prod to be denied")
+	}
+	c := MustConnect(t, s, "public", "alice", "")
+	c.MustFailQuery(fmt.Sprintf(`ATTACH '%s' AS prod`, filepath.Join(s.DataDir, "prod.db")), "42501")
+	c.MustFailQuery(`DETACH pg_catalog`, "42501")
+	c.MustQuery(`CREATE TABLE t (x)`)
+	c.MustQuery(`VACUUM`)
+}
+
+// Ensure local rules with peer authentication admit Unix domain socket
+// connections only for the operating system user or its mapped users.
+func TestServer_AccessRules_Peer(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on linux")
+	}
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := postlite.ParseAccessRules(strings.NewReader("local  app  all  allow  peer map=app\nlocal  all  all  allow  peer\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.SocketDirs = []string{t.TempDir()}
+		s.Auth = &postlite.Auth{
+			AccessRules:   rules,
+			IdentMappings: []*postlite.IdentMapping{{Map: "app", SystemUser: current.Username, User: "appuser"}},
+		}
+	})
+	socket := s.Listeners()[1]
+
+	MustConnectAddr(t, socket, map[string]string{"database": "db", "user": current.Username}, "").MustQuery(`SELECT 1`)
+	MustConnectAddr(t, socket, map[string]string{"database": "app", "user": "appuser"}, "").MustQuery(`SELECT 1`)
+	for _, params := range []map[string]string{
+		{"database": "db", "user": "mallory"},
+		{"database": "app", "user": current.Username},
+	} {
+		if _, err := ConnectAddr(t, socket, params, ""); err == nil {
+			t.Fatalf("%v: expected peer authentication to fail", params)
+		} else if e := (*Error)(nil); !errors.As(err, &e) || e.Code != "28000" {
+			t.Fatalf("%v: expected error 28000, got %v", params, err)
+		}
+	}
+
+	// TCP connections do not match local rules.
+	MustFailConnect(t, s, "db", current.Username, "", "28000")
+}
@@ -0,0 +1,93 @@
+package postlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Healthy returns an error if the server is not accepting connections.
+func (s *Server) Healthy() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown || s.ctx.Err() != nil {
+		return errors.New("server is shutting down")
+	} else if s.serveErr != nil {
+		return fmt.Errorf("listener stopped: %w", s.serveErr)
+	} else if len(s.lns) == 0 {
+		return errors.New("server is not listening")
+	}
+	return nil
+}
+
+// Ready returns an error if the server cannot serve queries. The data
+// directory must be readable & writable and a probe database created in it
+// must be able to run a query. Servers with a Backend are ready once healthy.
+func (s *Server) Ready(ctx context.Context) error {
+	if err := s.Healthy(); err != nil {
+		return err
+	} else if s.Backend != nil {
+		return nil
+	}
+
+	if _, err := os.ReadDir(s.DataDir); err != nil {
+		return fmt.Errorf("data directory not readable: %w", err)
+	}
+
+	f, err := os.CreateTemp(s.DataDir, ".postlite-probe-*")
+	if err != nil {
+		return fmt.Errorf("data directory not writable: %w", err)
+	}
+	path := f.Name()
+	defer func() {
+		for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+			os.Remove(path + suffix)
+		}
+	}()
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("data directory not writable: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("open probe database: %w", err)
+	}
+	defer db.Close()
+
+	var n int
+	if err := db.QueryRowContext(ctx, `SELECT 1`).Scan(&n); err != nil {
+		return fmt.Errorf("probe database: %w", err)
+	}
+	return nil
+}
+
+// HealthHandler returns an HTTP handler that serves liveness checks at
+// "/healthz" & readiness checks at "/readyz". Failed checks respond with a
+// 503 status & the reason.
+func HealthHandler(s *Server) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeCheck(w, s.Healthy())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		writeCheck(w, s.Ready(ctx))
+	})
+	return mux
+}
+
+func writeCheck(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
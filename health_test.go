@@ -0,0 +1,64 @@
+package postlite_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure liveness & readiness checks report the server & data directory.
+func TestHealthHandler(t *testing.T) {
+	s := MustOpenServer(t)
+	h := postlite.HealthHandler(s)
+
+	check := func(path string, code int, body string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != code {
+			t.Fatalf("%s: code=%d, want %d", path, w.Code, code)
+		} else if !strings.Contains(w.Body.String(), body) {
+			t.Fatalf("%s: body=%q, want %q", path, w.Body.String(), body)
+		}
+	}
+
+	check("/healthz", http.StatusOK, "ok")
+	check("/readyz", http.StatusOK, "ok")
+
+	// The probe database is removed.
+	if entries, err := os.ReadDir(s.DataDir); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 0 {
+		t.Fatalf("unexpected files in data directory: %v", entries)
+	}
+
+	// The server is alive but not ready without its data directory.
+	if err := os.RemoveAll(s.DataDir); err != nil {
+		t.Fatal(err)
+	}
+	check("/healthz", http.StatusOK, "ok")
+	check("/readyz", http.StatusServiceUnavailable, "data directory not readable")
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	check("/healthz", http.StatusServiceUnavailable, "server is shutting down")
+	check("/readyz", http.StatusServiceUnavailable, "server is shutting down")
+}
+
+// Ensure servers with a backend do not need a data directory to be ready.
+func TestServer_Ready_Backend(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.Backend = postlite.StaticBackend{}
+	})
+	if err := os.RemoveAll(s.DataDir); err != nil {
+		t.Fatal(err)
+	} else if err := s.Ready(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,71 @@
+package postlite
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// IdentMapping maps an operating system user to a database user within a
+// named map, similar to an entry in Postgres' pg_ident.conf.
+type IdentMapping struct {
+	Map        string // map name referenced by access rules
+	SystemUser string // operating system user name
+	User       string // database user name
+}
+
+// MatchIdentMap returns true if systemUser may connect as user under the named map.
+func MatchIdentMap(mappings []*IdentMapping, name, systemUser, user string) bool {
+	for _, m := range mappings {
+		if m.Map == name && m.SystemUser == systemUser && m.User == user {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadIdentMapFile parses ident mappings from a file at filename.
+func ReadIdentMapFile(filename string) ([]*IdentMapping, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mappings, err := ParseIdentMap(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return mappings, nil
+}
+
+// ParseIdentMap parses ident mappings. Each non-empty line contains
+// whitespace-separated fields:
+//
+//	MAPNAME  SYSTEM-USERNAME  DATABASE-USERNAME
+//
+// Everything after a "#" is treated as a comment.
+func ParseIdentMap(r io.Reader) ([]*IdentMapping, error) {
+	var mappings []*IdentMapping
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		} else if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected 3 fields, found %d", lineNo, len(fields))
+		}
+		mappings = append(mappings, &IdentMapping{Map: fields[0], SystemUser: fields[1], User: fields[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mappings, nil
+}
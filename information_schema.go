@@ -0,0 +1,831 @@
+package postlite
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// informationSchemaView is a view of the information_schema. Its rows are
+// derived from the SQLite schema each time it is queried.
+type informationSchemaView struct {
+	name    string
+	columns []string // column definitions
+	rows    func(is *informationSchema) ([][]interface{}, error)
+}
+
+// informationSchemaViews lists the views created in the information_schema
+// database. Columns follow Postgres 13.
+var informationSchemaViews = []informationSchemaView{
+	{
+		name: "schemata",
+		columns: []string{
+			"catalog_name TEXT", "schema_name TEXT", "schema_owner TEXT",
+			"default_character_set_catalog TEXT", "default_character_set_schema TEXT", "default_character_set_name TEXT",
+			"sql_path TEXT",
+		},
+		rows: (*informationSchema).schemata,
+	},
+	{
+		name: "tables",
+		columns: []string{
+			"table_catalog TEXT", "table_schema TEXT", "table_name TEXT", "table_type TEXT",
+			"self_referencing_column_name TEXT", "reference_generation TEXT",
+			"user_defined_type_catalog TEXT", "user_defined_type_schema TEXT", "user_defined_type_name TEXT",
+			"is_insertable_into TEXT", "is_typed TEXT", "commit_action TEXT",
+		},
+		rows: (*informationSchema).tables,
+	},
+	{
+		name: "columns",
+		columns: []string{
+			"table_catalog TEXT", "table_schema TEXT", "table_name TEXT", "column_name TEXT",
+			"ordinal_position INTEGER", "column_default TEXT", "is_nullable TEXT", "data_type TEXT",
+			"character_maximum_length INTEGER", "character_octet_length INTEGER",
+			"numeric_precision INTEGER", "numeric_precision_radix INTEGER", "numeric_scale INTEGER",
+			"datetime_precision INTEGER", "interval_type TEXT", "interval_precision INTEGER",
+			"character_set_catalog TEXT", "character_set_schema TEXT", "character_set_name TEXT",
+			"collation_catalog TEXT", "collation_schema TEXT", "collation_name TEXT",
+			"domain_catalog TEXT", "domain_schema TEXT", "domain_name TEXT",
+			"udt_catalog TEXT", "udt_schema TEXT", "udt_name TEXT",
+			"scope_catalog TEXT", "scope_schema TEXT", "scope_name TEXT",
+			"maximum_cardinality INTEGER", "dtd_identifier TEXT", "is_self_referencing TEXT",
+			"is_identity TEXT", "identity_generation TEXT", "identity_start TEXT", "identity_increment TEXT",
+			"identity_maximum TEXT", "identity_minimum TEXT", "identity_cycle TEXT",
+			"is_generated TEXT", "generation_expression TEXT", "is_updatable TEXT",
+		},
+		rows: (*informationSchema).columns,
+	},
+	{
+		name: "table_constraints",
+		columns: []string{
+			"constraint_catalog TEXT", "constraint_schema TEXT", "constraint_name TEXT",
+			"table_catalog TEXT", "table_schema TEXT", "table_name TEXT",
+			"constraint_type TEXT", "is_deferrable TEXT", "initially_deferred TEXT", "enforced TEXT",
+		},
+		rows: (*informationSchema).tableConstraints,
+	},
+	{
+		name: "key_column_usage",
+		columns: []string{
+			"constraint_catalog TEXT", "constraint_schema TEXT", "constraint_name TEXT",
+			"table_catalog TEXT", "table_schema TEXT", "table_name TEXT", "column_name TEXT",
+			"ordinal_position INTEGER", "position_in_unique_constraint INTEGER",
+		},
+		rows: (*informationSchema).keyColumnUsage,
+	},
+	{
+		name: "referential_constraints",
+		columns: []string{
+			"constraint_catalog TEXT", "constraint_schema TEXT", "constraint_name TEXT",
+			"unique_constraint_catalog TEXT", "unique_constraint_schema TEXT", "unique_constraint_name TEXT",
+			"match_option TEXT", "update_rule TEXT", "delete_rule TEXT",
+		},
+		rows: (*informationSchema).referentialConstraints,
+	},
+	{
+		name: "views",
+		columns: []string{
+			"table_catalog TEXT", "table_schema TEXT", "table_name TEXT", "view_definition TEXT",
+			"check_option TEXT", "is_updatable TEXT", "is_insertable_into TEXT",
+			"is_trigger_updatable TEXT", "is_trigger_deletable TEXT", "is_trigger_insertable_into TEXT",
+		},
+		rows: (*informationSchema).views,
+	},
+	{
+		name: "routines",
+		columns: []string{
+			"specific_catalog TEXT", "specific_schema TEXT", "specific_name TEXT",
+			"routine_catalog TEXT", "routine_schema TEXT", "routine_name TEXT", "routine_type TEXT",
+			"data_type TEXT", "type_udt_catalog TEXT", "type_udt_schema TEXT", "type_udt_name TEXT",
+			"routine_body TEXT", "routine_definition TEXT", "external_name TEXT", "external_language TEXT",
+			"parameter_style TEXT", "is_deterministic TEXT", "sql_data_access TEXT", "is_null_call TEXT",
+			"security_type TEXT",
+		},
+		rows: (*informationSchema).routines,
+	},
+	{
+		name: "character_sets",
+		columns: []string{
+			"character_set_catalog TEXT", "character_set_schema TEXT", "character_set_name TEXT",
+			"character_repertoire TEXT", "form_of_use TEXT",
+			"default_collate_catalog TEXT", "default_collate_schema TEXT", "default_collate_name TEXT",
+		},
+		rows: (*informationSchema).characterSets,
+	},
+}
+
+// registerInformationSchema registers a module for each information_schema
+// view on a SQLite connection.
+func (s *Server) registerInformationSchema(conn *sqlite3.SQLiteConn, db *DB) error {
+	is := &informationSchema{s: s, conn: conn, catalog: db.Name()}
+	for _, view := range informationSchemaViews {
+		view := view
+		mod := &informationSchemaModule{
+			columns: view.columns,
+			rows:    func() ([][]interface{}, error) { return view.rows(is) },
+		}
+		if err := conn.CreateModule("information_schema_"+view.name+"_module", mod); err != nil {
+			return fmt.Errorf("cannot register information_schema.%s module", view.name)
+		}
+	}
+	return nil
+}
+
+// createInformationSchema attaches an in-memory information_schema database to
+// the connection and creates its views as virtual tables.
+func createInformationSchema(conn *sqlite3.SQLiteConn) error {
+	if _, err := conn.Exec(`ATTACH ':memory:' AS information_schema`, nil); err != nil {
+		return fmt.Errorf("attach information_schema: %w", err)
+	}
+	for _, view := range informationSchemaViews {
+		if _, err := conn.Exec(fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS information_schema.%s USING information_schema_%s_module", view.name, view.name), nil); err != nil {
+			return fmt.Errorf("create information_schema.%s: %w", view.name, err)
+		}
+	}
+	return nil
+}
+
+// informationSchemaModule is a virtual table module serving the rows of an
+// information_schema view. Values may be strings, integers or nil.
+type informationSchemaModule struct {
+	columns []string
+	rows    func() ([][]interface{}, error)
+}
+
+func (m *informationSchemaModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	if err := c.DeclareVTab(fmt.Sprintf(`CREATE TABLE %s (%s)`, args[0], strings.Join(m.columns, ", "))); err != nil {
+		return nil, err
+	}
+	return &informationSchemaTable{rows: m.rows}, nil
+}
+
+func (m *informationSchemaModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Create(c, args)
+}
+
+func (m *informationSchemaModule) DestroyModule() {}
+
+type informationSchemaTable struct {
+	rows func() ([][]interface{}, error)
+}
+
+func (t *informationSchemaTable) Open() (sqlite3.VTabCursor, error) {
+	return &informationSchemaCursor{rowsFn: t.rows}, nil
+}
+
+func (t *informationSchemaTable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	return &sqlite3.IndexResult{Used: make([]bool, len(cst))}, nil
+}
+
+func (t *informationSchemaTable) Disconnect() error { return nil }
+func (t *informationSchemaTable) Destroy() error    { return nil }
+
+type informationSchemaCursor struct {
+	rowsFn func() ([][]interface{}, error)
+	rows   [][]interface{}
+	index  int
+}
+
+func (c *informationSchemaCursor) Column(sctx *sqlite3.SQLiteContext, col int) error {
+	switch v := c.rows[c.index][col].(type) {
+	case string:
+		sctx.ResultText(v)
+	case int:
+		sctx.ResultInt(v)
+	default:
+		sctx.ResultNull()
+	}
+	return nil
+}
+
+func (c *informationSchemaCursor) Filter(idxNum int, idxStr string, vals []interface{}) (err error) {
+	c.index = 0
+	c.rows, err = c.rowsFn()
+	return err
+}
+
+func (c *informationSchemaCursor) Next() error {
+	c.index++
+	return nil
+}
+
+func (c *informationSchemaCursor) EOF() bool {
+	return c.index >= len(c.rows)
+}
+
+func (c *informationSchemaCursor) Rowid() (int64, error) {
+	return int64(c.index), nil
+}
+
+func (c *informationSchemaCursor) Close() error {
+	return nil
+}
+
+// informationSchema derives the information_schema views of a connection.
+// The main database is reported as the public schema.
+type informationSchema struct {
+	s       *Server
+	conn    *sqlite3.SQLiteConn
+	catalog string // database name
+}
+
+// informationSchemas maps attached SQLite databases to the schema they are
+// reported as.
+var informationSchemas = []struct {
+	database string
+	schema   string
+}{
+	{"main", "public"},
+	{"pg_catalog", "pg_catalog"},
+	{"information_schema", "information_schema"},
+}
+
+func (is *informationSchema) schemata() ([][]interface{}, error) {
+	var rows [][]interface{}
+	for _, schema := range []string{"pg_catalog", "public", "information_schema"} {
+		rows = append(rows, []interface{}{is.catalog, schema, bootstrapRole, nil, nil, nil, nil})
+	}
+	return rows, nil
+}
+
+func (is *informationSchema) tables() ([][]interface{}, error) {
+	var rows [][]interface{}
+	for _, schema := range informationSchemas {
+		rels, err := is.relations(schema.database)
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range rels {
+			typ, insertable := "BASE TABLE", "NO"
+			if rel.typ == "view" || schema.database == "information_schema" {
+				typ = "VIEW"
+			} else if schema.database == "main" {
+				insertable = "YES"
+			}
+			rows = append(rows, []interface{}{
+				is.catalog, schema.schema, rel.name, typ,
+				nil, nil, nil, nil, nil,
+				insertable, "NO", nil,
+			})
+		}
+	}
+	return rows, nil
+}
+
+func (is *informationSchema) columns() ([][]interface{}, error) {
+	var rows [][]interface{}
+	for _, schema := range informationSchemas {
+		rels, err := is.relations(schema.database)
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range rels {
+			cols, err := is.tableColumns(schema.database, rel.name)
+			if err != nil {
+				return nil, err
+			}
+
+			updatable := "NO"
+			if schema.database == "main" && rel.typ == "table" {
+				updatable = "YES"
+			}
+			for i, col := range cols {
+				typ := pgColumnType(col.typ)
+
+				nullable := "YES"
+				if col.notNull || col.pk > 0 {
+					nullable = "NO"
+				}
+				isIdentity, identityGeneration := "NO", interface{}(nil)
+				if col.rowid {
+					isIdentity, identityGeneration = "YES", "BY DEFAULT"
+				}
+				isGenerated := "NEVER"
+				if col.generated {
+					isGenerated = "ALWAYS"
+				}
+
+				rows = append(rows, []interface{}{
+					is.catalog, schema.schema, rel.name, col.name,
+					i + 1, col.dflt, nullable, typ.dataType,
+					typ.maxLength, typ.octetLength,
+					typ.precision, typ.radix, typ.scale,
+					typ.datetimePrecision, nil, nil,
+					nil, nil, nil,
+					nil, nil, nil,
+					nil, nil, nil,
+					is.catalog, "pg_catalog", typ.udtName,
+					nil, nil, nil,
+					nil, strconv.Itoa(i + 1), "NO",
+					isIdentity, identityGeneration, nil, nil,
+					nil, nil, "NO",
+					isGenerated, nil, updatable,
+				})
+			}
+		}
+	}
+	return rows, nil
+}
+
+func (is *informationSchema) tableConstraints() ([][]interface{}, error) {
+	constraints, err := is.constraints()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]interface{}
+	for _, c := range constraints {
+		rows = append(rows, []interface{}{
+			is.catalog, "public", c.name,
+			is.catalog, "public", c.table,
+			c.typ, "NO", "NO", "YES",
+		})
+	}
+	return rows, nil
+}
+
+func (is *informationSchema) keyColumnUsage() ([][]interface{}, error) {
+	constraints, err := is.constraints()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]interface{}
+	for _, c := range constraints {
+		var unique *tableConstraint
+		if c.typ == "FOREIGN KEY" {
+			unique = referencedConstraint(constraints, c)
+		}
+
+		for i, col := range c.columns {
+			var position interface{}
+			if unique != nil {
+				position = indexOf(unique.columns, c.refColumns[i]) + 1
+			}
+			rows = append(rows, []interface{}{
+				is.catalog, "public", c.name,
+				is.catalog, "public", c.table, col,
+				i + 1, position,
+			})
+		}
+	}
+	return rows, nil
+}
+
+func (is *informationSchema) referentialConstraints() ([][]interface{}, error) {
+	constraints, err := is.constraints()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]interface{}
+	for _, c := range constraints {
+		if c.typ != "FOREIGN KEY" {
+			continue
+		}
+
+		var uniqueCatalog, uniqueSchema, uniqueName interface{}
+		if unique := referencedConstraint(constraints, c); unique != nil {
+			uniqueCatalog, uniqueSchema, uniqueName = is.catalog, "public", unique.name
+		}
+		rows = append(rows, []interface{}{
+			is.catalog, "public", c.name,
+			uniqueCatalog, uniqueSchema, uniqueName,
+			c.match, c.onUpdate, c.onDelete,
+		})
+	}
+	return rows, nil
+}
+
+func (is *informationSchema) views() ([][]interface{}, error) {
+	rels, err := is.relations("main")
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]interface{}
+	for _, rel := range rels {
+		if rel.typ != "view" {
+			continue
+		}
+
+		definition := rel.sql
+		if m := viewDefinitionRegex.FindStringSubmatch(rel.sql); m != nil {
+			definition = m[1]
+		}
+		rows = append(rows, []interface{}{
+			is.catalog, "public", rel.name, definition,
+			"NONE", "NO", "NO",
+			"NO", "NO", "NO",
+		})
+	}
+	return rows, nil
+}
+
+var viewDefinitionRegex = regexp.MustCompile(`(?is)^\s*CREATE\s+(?:TEMP\s+|TEMPORARY\s+)?VIEW\s+.*?\s+AS\s+(.*?);?\s*$`)
+
+func (is *informationSchema) routines() ([][]interface{}, error) {
+	procs, err := is.s.pgProcs(is.conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]interface{}
+	for _, proc := range procs {
+		schema, language := "pg_catalog", "INTERNAL"
+		if proc.pronamespace == 2200 {
+			schema, language = "public", "C"
+		}
+		var routineType interface{}
+		if proc.prokind == "f" {
+			routineType = "FUNCTION"
+		}
+		deterministic := "NO"
+		if proc.provolatile == "i" {
+			deterministic = "YES"
+		}
+
+		rows = append(rows, []interface{}{
+			is.catalog, schema, fmt.Sprintf("%s_%d", proc.proname, proc.oid),
+			is.catalog, schema, proc.proname, routineType,
+			"text", is.catalog, "pg_catalog", "text",
+			"EXTERNAL", nil, nil, language,
+			"GENERAL", deterministic, "MODIFIES", "NO",
+			"INVOKER",
+		})
+	}
+	return rows, nil
+}
+
+func (is *informationSchema) characterSets() ([][]interface{}, error) {
+	encoding, err := queryPragma(is.conn, "encoding")
+	if err != nil {
+		return nil, err
+	}
+	name := "UTF8"
+	if strings.HasPrefix(encoding, "UTF-16") {
+		name = "UTF16"
+	}
+
+	return [][]interface{}{
+		{nil, nil, name, "UCS", name, is.catalog, "pg_catalog", "C"},
+	}, nil
+}
+
+// relation is a table or view in the SQLite schema.
+type relation struct {
+	name string
+	typ  string // "table" or "view"
+	sql  string
+}
+
+// relations returns the tables & views of an attached database, excluding
+// SQLite's internal tables.
+func (is *informationSchema) relations(database string) ([]relation, error) {
+	rows, err := is.conn.Query(fmt.Sprintf(`SELECT name, type, sql FROM %q.sqlite_schema WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite\_%%' ESCAPE '\' ORDER BY name`, database), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s schema: %w", database, err)
+	}
+	defer rows.Close()
+
+	var rels []relation
+	dest := make([]driver.Value, 3)
+	for {
+		if err := rows.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s schema: %w", database, err)
+		}
+		rel := relation{name: dest[0].(string), typ: dest[1].(string)}
+		rel.sql, _ = dest[2].(string)
+		rels = append(rels, rel)
+	}
+	return rels, nil
+}
+
+// tableColumn is a column reported by PRAGMA table_xinfo.
+type tableColumn struct {
+	name      string
+	typ       string
+	notNull   bool
+	dflt      interface{}
+	pk        int  // position in the primary key, or zero
+	generated bool // generated column
+	rowid     bool // alias of the rowid, i.e. INTEGER PRIMARY KEY
+}
+
+// tableColumns returns the visible columns of a table or view.
+func (is *informationSchema) tableColumns(database, table string) ([]tableColumn, error) {
+	rows, err := is.conn.Query(`SELECT name, type, "notnull", dflt_value, pk, hidden FROM pragma_table_xinfo(?, ?) ORDER BY cid`, []driver.Value{table, database})
+	if err != nil {
+		return nil, fmt.Errorf("table info %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var cols []tableColumn
+	var pkN int
+	dest := make([]driver.Value, 6)
+	for {
+		if err := rows.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("table info %s: %w", table, err)
+		}
+		hidden := dest[5].(int64)
+		if hidden == 1 { // hidden virtual table column
+			continue
+		}
+
+		col := tableColumn{
+			name:      dest[0].(string),
+			notNull:   dest[2].(int64) != 0,
+			pk:        int(dest[4].(int64)),
+			generated: hidden == 2 || hidden == 3,
+		}
+		col.typ, _ = dest[1].(string)
+		if dflt, ok := dest[3].(string); ok {
+			col.dflt = dflt
+		}
+		if col.pk > 0 {
+			pkN++
+		}
+		cols = append(cols, col)
+	}
+
+	// A sole INTEGER PRIMARY KEY column is an alias of the rowid.
+	for i := range cols {
+		if pkN == 1 && cols[i].pk == 1 && strings.EqualFold(cols[i].typ, "INTEGER") {
+			cols[i].rowid = true
+		}
+	}
+	return cols, nil
+}
+
+// tableConstraint is a primary key, unique or foreign key constraint of a
+// table in the main database. SQLite does not report constraint names so
+// they are named as Postgres names them by default, e.g. "users_pkey".
+type tableConstraint struct {
+	name    string
+	table   string
+	typ     string // PRIMARY KEY, UNIQUE or FOREIGN KEY
+	columns []string
+
+	// Foreign keys only.
+	refTable   string
+	refColumns []string
+	match      string
+	onUpdate   string
+	onDelete   string
+}
+
+// constraints returns the constraints of the tables in the main database.
+func (is *informationSchema) constraints() ([]tableConstraint, error) {
+	rels, err := is.relations("main")
+	if err != nil {
+		return nil, err
+	}
+
+	var constraints []tableConstraint
+	for _, rel := range rels {
+		if rel.typ != "table" {
+			continue
+		}
+
+		// Primary key, ordered by position in the key.
+		cols, err := is.tableColumns("main", rel.name)
+		if err != nil {
+			return nil, err
+		}
+		var pk []string
+		for n := 1; ; n++ {
+			i := len(pk)
+			for _, col := range cols {
+				if col.pk == n {
+					pk = append(pk, col.name)
+				}
+			}
+			if len(pk) == i {
+				break
+			}
+		}
+		if len(pk) > 0 {
+			constraints = append(constraints, tableConstraint{name: rel.name + "_pkey", table: rel.name, typ: "PRIMARY KEY", columns: pk})
+		}
+
+		unique, err := is.uniqueConstraints(rel.name)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, unique...)
+
+		foreign, err := is.foreignKeys(rel.name)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, foreign...)
+	}
+
+	// Number duplicate names as Postgres does, e.g. "orders_user_id_fkey1".
+	names := make(map[string]bool)
+	for i := range constraints {
+		name := constraints[i].name
+		for n := 1; names[name]; n++ {
+			name = fmt.Sprintf("%s%d", constraints[i].name, n)
+		}
+		constraints[i].name, names[name] = name, true
+	}
+
+	// Foreign keys without referenced columns reference the primary key.
+	for i := range constraints {
+		c := &constraints[i]
+		if c.typ != "FOREIGN KEY" || c.refColumns != nil {
+			continue
+		}
+		for _, other := range constraints {
+			if other.table == c.refTable && other.typ == "PRIMARY KEY" {
+				c.refColumns = other.columns
+			}
+		}
+		if len(c.refColumns) != len(c.columns) {
+			c.refColumns = make([]string, len(c.columns))
+		}
+	}
+	return constraints, nil
+}
+
+// uniqueConstraints returns the UNIQUE constraints of a table. Unique indexes
+// created with CREATE INDEX are not constraints.
+func (is *informationSchema) uniqueConstraints(table string) ([]tableConstraint, error) {
+	rows, err := is.conn.Query(`SELECT il.name, ii.name FROM pragma_index_list(?) AS il, pragma_index_info(il.name) AS ii WHERE il.origin = 'u' ORDER BY il.seq, ii.seqno`, []driver.Value{table})
+	if err != nil {
+		return nil, fmt.Errorf("index list %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var constraints []tableConstraint
+	var index string
+	dest := make([]driver.Value, 2)
+	for {
+		if err := rows.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("index list %s: %w", table, err)
+		}
+		if dest[0].(string) != index || len(constraints) == 0 {
+			index = dest[0].(string)
+			constraints = append(constraints, tableConstraint{table: table, typ: "UNIQUE"})
+		}
+		c := &constraints[len(constraints)-1]
+		c.columns = append(c.columns, dest[1].(string))
+	}
+
+	for i := range constraints {
+		constraints[i].name = table + "_" + strings.Join(constraints[i].columns, "_") + "_key"
+	}
+	return constraints, nil
+}
+
+// foreignKeys returns the FOREIGN KEY constraints of a table.
+func (is *informationSchema) foreignKeys(table string) ([]tableConstraint, error) {
+	rows, err := is.conn.Query(`SELECT id, "table", "from", "to", on_update, on_delete, match FROM pragma_foreign_key_list(?) ORDER BY id, seq`, []driver.Value{table})
+	if err != nil {
+		return nil, fmt.Errorf("foreign key list %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var constraints []tableConstraint
+	id := int64(-1)
+	dest := make([]driver.Value, 7)
+	for {
+		if err := rows.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("foreign key list %s: %w", table, err)
+		}
+		if dest[0].(int64) != id {
+			id = dest[0].(int64)
+			constraints = append(constraints, tableConstraint{
+				table:    table,
+				typ:      "FOREIGN KEY",
+				refTable: dest[1].(string),
+				onUpdate: dest[4].(string),
+				onDelete: dest[5].(string),
+				match:    dest[6].(string),
+			})
+		}
+		c := &constraints[len(constraints)-1]
+		c.columns = append(c.columns, dest[2].(string))
+		if to, ok := dest[3].(string); ok {
+			c.refColumns = append(c.refColumns, to)
+		}
+	}
+
+	for i := range constraints {
+		constraints[i].name = table + "_" + strings.Join(constraints[i].columns, "_") + "_fkey"
+	}
+	return constraints, nil
+}
+
+// referencedConstraint returns the primary key or unique constraint that a
+// foreign key references. Returns nil if the referenced columns are not unique.
+func referencedConstraint(constraints []tableConstraint, fk tableConstraint) *tableConstraint {
+	for i, c := range constraints {
+		if c.table != fk.refTable || (c.typ != "PRIMARY KEY" && c.typ != "UNIQUE") || len(c.columns) != len(fk.refColumns) {
+			continue
+		}
+		match := true
+		for _, col := range fk.refColumns {
+			if indexOf(c.columns, col) == -1 {
+				match = false
+			}
+		}
+		if match {
+			return &constraints[i]
+		}
+	}
+	return nil
+}
+
+func indexOf(a []string, s string) int {
+	for i := range a {
+		if strings.EqualFold(a[i], s) {
+			return i
+		}
+	}
+	return -1
+}
+
+// pgColumnTypeInfo describes the Postgres type reported for a SQLite column.
+type pgColumnTypeInfo struct {
+	dataType          string
+	udtName           string
+	maxLength         interface{}
+	octetLength       interface{}
+	precision         interface{}
+	radix             interface{}
+	scale             interface{}
+	datetimePrecision interface{}
+}
+
+// pgColumnType maps the declared type of a SQLite column to a Postgres type,
+// following SQLite's rules for determining column affinity.
+func pgColumnType(decl string) pgColumnTypeInfo {
+	typ := strings.ToUpper(strings.TrimSpace(decl))
+	name, args := typ, []int(nil)
+	if i := strings.IndexByte(typ, '('); i != -1 {
+		name = strings.TrimSpace(typ[:i])
+		for _, s := range strings.Split(strings.TrimSuffix(typ[i+1:], ")"), ",") {
+			if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+				args = append(args, n)
+			}
+		}
+	}
+
+	switch name {
+	case "BOOL", "BOOLEAN":
+		return pgColumnTypeInfo{dataType: "boolean", udtName: "bool"}
+	case "DATE":
+		return pgColumnTypeInfo{dataType: "date", udtName: "date", datetimePrecision: 0}
+	case "DATETIME", "TIMESTAMP":
+		return pgColumnTypeInfo{dataType: "timestamp without time zone", udtName: "timestamp", datetimePrecision: 6}
+	case "TIMESTAMPTZ":
+		return pgColumnTypeInfo{dataType: "timestamp with time zone", udtName: "timestamptz", datetimePrecision: 6}
+	case "JSON":
+		return pgColumnTypeInfo{dataType: "json", udtName: "json"}
+	case "JSONB":
+		return pgColumnTypeInfo{dataType: "jsonb", udtName: "jsonb"}
+	case "UUID":
+		return pgColumnTypeInfo{dataType: "uuid", udtName: "uuid"}
+	}
+
+	switch {
+	case strings.Contains(name, "INT"):
+		return pgColumnTypeInfo{dataType: "bigint", udtName: "int8", precision: 64, radix: 2, scale: 0}
+	case strings.Contains(name, "CHAR"), strings.Contains(name, "CLOB"), strings.Contains(name, "TEXT"):
+		if len(args) == 0 {
+			return pgColumnTypeInfo{dataType: "text", udtName: "text", octetLength: 1073741824}
+		} else if strings.Contains(name, "VAR") || strings.Contains(name, "VARYING") {
+			return pgColumnTypeInfo{dataType: "character varying", udtName: "varchar", maxLength: args[0], octetLength: args[0] * 4}
+		}
+		return pgColumnTypeInfo{dataType: "character", udtName: "bpchar", maxLength: args[0], octetLength: args[0] * 4}
+	case name == "", strings.Contains(name, "BLOB"):
+		return pgColumnTypeInfo{dataType: "bytea", udtName: "bytea"}
+	case strings.Contains(name, "REAL"), strings.Contains(name, "FLOA"), strings.Contains(name, "DOUB"):
+		return pgColumnTypeInfo{dataType: "double precision", udtName: "float8", precision: 53, radix: 2}
+	}
+
+	info := pgColumnTypeInfo{dataType: "numeric", udtName: "numeric", radix: 10}
+	if len(args) > 0 {
+		info.precision, info.scale = args[0], 0
+	}
+	if len(args) > 1 {
+		info.scale = args[1]
+	}
+	return info
+}
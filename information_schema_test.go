@@ -0,0 +1,115 @@
+package postlite_test
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Ensure the information_schema views describe the database schema the way
+// ORMs & schema tools query them.
+func TestServer_InformationSchema(t *testing.T) {
+	s := MustOpenServer(t)
+	c := MustConnect(t, s, "app", "alice", "")
+	c.MustQuery(`CREATE TABLE users (id INTEGER PRIMARY KEY, email VARCHAR(255) NOT NULL UNIQUE, score REAL DEFAULT 0, created TIMESTAMP)`)
+	c.MustQuery(`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users (id) ON DELETE CASCADE, total NUMERIC(10,2))`)
+	c.MustQuery(`CREATE VIEW big_orders AS SELECT * FROM orders WHERE total > 100`)
+
+	for _, tt := range []struct {
+		name  string
+		query string
+		want  [][]string
+	}{
+		{
+			name:  "Schemata",
+			query: `SELECT catalog_name, schema_name FROM information_schema.schemata ORDER BY schema_name`,
+			want:  [][]string{{"app", "information_schema"}, {"app", "pg_catalog"}, {"app", "public"}},
+		},
+		{
+			name:  "Tables",
+			query: `SELECT table_catalog, table_name, table_type, is_insertable_into FROM information_schema.tables WHERE table_schema = 'public' ORDER BY table_name`,
+			want: [][]string{
+				{"app", "big_orders", "VIEW", "NO"},
+				{"app", "orders", "BASE TABLE", "YES"},
+				{"app", "users", "BASE TABLE", "YES"},
+			},
+		},
+		{
+			name:  "CatalogTables",
+			query: `SELECT table_schema, table_type FROM information_schema.tables WHERE table_name IN ('pg_class', 'columns') ORDER BY table_schema`,
+			want:  [][]string{{"information_schema", "VIEW"}, {"pg_catalog", "BASE TABLE"}},
+		},
+		{
+			name: "Columns",
+			query: `
+				SELECT column_name, ordinal_position, column_default, is_nullable, data_type,
+					character_maximum_length, numeric_precision, numeric_scale, udt_name, is_identity
+				FROM information_schema.columns
+				WHERE table_schema = 'public' AND table_name = 'users'
+				ORDER BY ordinal_position`,
+			want: [][]string{
+				{"id", "1", "<nil>", "NO", "bigint", "<nil>", "64", "0", "int8", "YES"},
+				{"email", "2", "<nil>", "NO", "character varying", "255", "<nil>", "<nil>", "varchar", "NO"},
+				{"score", "3", "0", "YES", "double precision", "<nil>", "53", "<nil>", "float8", "NO"},
+				{"created", "4", "<nil>", "YES", "timestamp without time zone", "<nil>", "<nil>", "<nil>", "timestamp", "NO"},
+			},
+		},
+		{
+			name:  "ViewColumns",
+			query: `SELECT column_name, is_updatable FROM information_schema.columns WHERE table_name = 'big_orders' ORDER BY ordinal_position`,
+			want:  [][]string{{"id", "NO"}, {"user_id", "NO"}, {"total", "NO"}},
+		},
+		{
+			name:  "TableConstraints",
+			query: `SELECT constraint_name, table_name, constraint_type FROM information_schema.table_constraints ORDER BY table_name, constraint_name`,
+			want: [][]string{
+				{"orders_pkey", "orders", "PRIMARY KEY"},
+				{"orders_user_id_fkey", "orders", "FOREIGN KEY"},
+				{"users_email_key", "users", "UNIQUE"},
+				{"users_pkey", "users", "PRIMARY KEY"},
+			},
+		},
+		{
+			name: "ForeignKeys",
+			query: `
+				SELECT kcu.table_name, kcu.column_name, ccu.table_name, ccu.column_name, rc.update_rule, rc.delete_rule
+				FROM information_schema.key_column_usage kcu
+				JOIN information_schema.referential_constraints rc ON rc.constraint_name = kcu.constraint_name
+				JOIN information_schema.key_column_usage ccu ON ccu.constraint_name = rc.unique_constraint_name
+					AND ccu.ordinal_position = kcu.position_in_unique_constraint`,
+			want: [][]string{{"orders", "user_id", "users", "id", "NO ACTION", "CASCADE"}},
+		},
+		{
+			name:  "Views",
+			query: `SELECT table_name, view_definition FROM information_schema.views`,
+			want:  [][]string{{"big_orders", "SELECT * FROM orders WHERE total > 100"}},
+		},
+		{
+			name:  "Routines",
+			query: `SELECT routine_schema, routine_name, routine_type, is_deterministic FROM information_schema.routines WHERE routine_name IN ('lower', 'random') ORDER BY routine_name`,
+			want:  [][]string{{"pg_catalog", "lower", "FUNCTION", "YES"}, {"pg_catalog", "random", "FUNCTION", "NO"}},
+		},
+		{
+			name:  "CharacterSets",
+			query: `SELECT character_set_name FROM information_schema.character_sets`,
+			want:  [][]string{{"UTF8"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if rows := c.MustQuery(tt.query); !reflect.DeepEqual(rows, tt.want) {
+				t.Fatalf("rows=%q\nwant=%q", rows, tt.want)
+			} else if rows := c.MustQueryExtended(tt.query); !reflect.DeepEqual(rows, tt.want) {
+				t.Fatalf("extended: rows=%q\nwant=%q", rows, tt.want)
+			}
+		})
+	}
+
+	// Views reflect schema changes made by the session.
+	c.MustQuery(`ALTER TABLE users ADD COLUMN name TEXT`)
+	if rows := c.MustQuery(`SELECT ordinal_position, data_type FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'name'`); !reflect.DeepEqual(rows, [][]string{{"5", "text"}}) {
+		t.Fatalf("rows=%q", rows)
+	}
+	c.MustQuery(`DROP VIEW big_orders`)
+	if rows := c.MustQuery(`SELECT count(*) FROM information_schema.views`); rows[0][0] != "0" {
+		t.Fatalf("count=%s, want 0", rows[0][0])
+	}
+}
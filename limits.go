@@ -0,0 +1,230 @@
+package postlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/mattn/go-sqlite3"
+)
+
+// DatabaseLimit limits the number of client connections to databases matching a glob.
+type DatabaseLimit struct {
+	Database       string // database glob, relative to the data directory
+	MaxConnections int
+}
+
+// Match returns true if name matches the database glob.
+func (l *DatabaseLimit) Match(name string) bool {
+	ok, _ := path.Match(l.Database, name)
+	return ok
+}
+
+// SetConnLimits replaces the server & database connection limits. Connections
+// that are already open are not closed.
+func (s *Server) SetConnLimits(maxConnections int, databaseLimits []*DatabaseLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.MaxConnections, s.DatabaseLimits = maxConnections, databaseLimits
+}
+
+// SetSessionTimeouts replaces the default session timeouts. Only new sessions
+// use the new defaults.
+func (s *Server) SetSessionTimeouts(statement, idleInTransaction, idleSession time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.StatementTimeout, s.IdleInTransactionSessionTimeout, s.IdleSessionTimeout = statement, idleInTransaction, idleSession
+}
+
+// databaseConnLimit returns the connection limit for a database. Returns -1
+// if the database has no limit, which matches Postgres' datconnlimit. Must be
+// called with mu held.
+func (s *Server) databaseConnLimit(name string) int {
+	for _, l := range s.DatabaseLimits {
+		if l.Match(name) {
+			return l.MaxConnections
+		}
+	}
+	return -1
+}
+
+// checkConnLimits returns an error if the server or the database already has
+// as many admitted connections as allowed. Otherwise, it admits c by
+// assigning the database to it. Connections still starting up are not counted.
+func (s *Server) checkConnLimits(c *Conn, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n, dbN int
+	for other := range s.conns {
+		if other == c || other.database == "" {
+			continue
+		}
+		n++
+		if other.database == name {
+			dbN++
+		}
+	}
+
+	if s.MaxConnections > 0 && n >= s.MaxConnections {
+		return writeFatal(c, "53300", "sorry, too many clients already") // too_many_connections
+	}
+	if limit := s.databaseConnLimit(name); limit >= 0 && dbN >= limit {
+		return writeFatal(c, "53300", fmt.Sprintf("too many connections for database %q", name))
+	}
+
+	c.database = name
+	return nil
+}
+
+// pgDatabases returns the pg_database rows visible to a connection to db.
+func (s *Server) pgDatabases(db *DB) []pgDatabase {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return []pgDatabase{{
+		oid:           databaseOID,
+		datname:       db.Name(),
+		datdba:        10,
+		encoding:      6, // UTF8
+		datcollate:    "C",
+		datctype:      "C",
+		datallowconn:  1,
+		datconnlimit:  s.databaseConnLimit(db.Name()),
+		datlastsysoid: 13756,
+		dattablespace: 1663,
+	}}
+}
+
+// databaseOID is the object ID reported for the connected database.
+const databaseOID = 16384
+
+// withStatementTimeout returns a context that is canceled once the
+// connection's statement timeout has elapsed, if one is set, or once the
+// query is canceled.
+func (c *Conn) withStatementTimeout(ctx context.Context) (_ context.Context, cancel context.CancelFunc) {
+	if c.statementTimeout <= 0 {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, c.statementTimeout)
+	}
+
+	// Allow the query to be canceled by pg_cancel_backend() & cancel requests.
+	c.mu.Lock()
+	c.cancelQuery = cancel
+	c.mu.Unlock()
+	return ctx, cancel
+}
+
+// queryErrorResponse converts a query error into an error response. Queries
+// interrupted by the statement timeout or a cancel request are reported as canceled.
+func (c *Conn) queryErrorResponse(ctx context.Context, err error) *pgproto3.ErrorResponse {
+	resp := toErrorResponse(err)
+	var serr sqlite3.Error
+	if errors.As(err, &serr) && serr.Code == sqlite3.ErrAuth {
+		resp.Message = c.deniedMessage() // SQLite only reports "not authorized"
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		resp = &pgproto3.ErrorResponse{
+			Severity: "ERROR",
+			Code:     "57014", // query_canceled
+			Message:  "canceling statement due to statement timeout",
+		}
+	} else if userCanceled(ctx) {
+		resp = &pgproto3.ErrorResponse{
+			Severity: "ERROR",
+			Code:     "57014", // query_canceled
+			Message:  "canceling statement due to user request",
+		}
+	}
+	c.metrics.observeError(resp, err)
+	return resp
+}
+
+// setTimeout handles SET statements for session timeouts. Returns false if
+// the query does not set a timeout.
+func (s *Server) setTimeout(c *Conn, w *resultBuffer, query string) (bool, error) {
+	m := setTimeoutRegex.FindStringSubmatch(query)
+	if m == nil {
+		return false, nil
+	}
+	name, value := strings.ToLower(m[1]), m[2]
+
+	var d time.Duration
+	if strings.EqualFold(value, "default") {
+		d = s.defaultTimeout(name)
+	} else {
+		var err error
+		if d, err = parseTimeout(value); err != nil {
+			return true, Errorf("22023", "invalid value for parameter %q: %q", name, value) // invalid_parameter_value
+		}
+	}
+
+	switch name {
+	case "statement_timeout":
+		c.statementTimeout = d
+	case "idle_in_transaction_session_timeout":
+		c.idleInTransactionSessionTimeout = d
+	case "idle_session_timeout":
+		c.idleSessionTimeout = d
+	}
+	w.SetCommandTag("SET")
+	return true, nil
+}
+
+// defaultTimeout returns the server default for the named timeout setting.
+func (s *Server) defaultTimeout(name string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch name {
+	case "statement_timeout":
+		return s.StatementTimeout
+	case "idle_in_transaction_session_timeout":
+		return s.IdleInTransactionSessionTimeout
+	case "idle_session_timeout":
+		return s.IdleSessionTimeout
+	default:
+		return 0
+	}
+}
+
+var setTimeoutRegex = regexp.MustCompile(`(?i)^\s*SET\s+(?:SESSION\s+)?(statement_timeout|idle_in_transaction_session_timeout|idle_session_timeout)\s*(?:=|\s+TO\s+)\s*'?([^';]*?)'?\s*;?\s*$`)
+
+// parseTimeout parses a Postgres time value such as "5000", "5s" or "1min".
+// Values without a unit are in milliseconds.
+func parseTimeout(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i == -1 {
+		i = len(s)
+	}
+
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid timeout: %q", s)
+	}
+
+	var unit time.Duration
+	switch strings.TrimSpace(s[i:]) {
+	case "", "ms":
+		unit = time.Millisecond
+	case "us":
+		unit = time.Microsecond
+	case "s":
+		unit = time.Second
+	case "min":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	case "d":
+		unit = 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("invalid timeout unit: %q", s)
+	}
+	return time.Duration(n * float64(unit)), nil
+}
@@ -0,0 +1,98 @@
+package postlite_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure session timeouts & custom settings can be set with the extended
+// query protocol.
+func TestServer_SetExtended(t *testing.T) {
+	s := MustOpenServer(t)
+	c := MustConnect(t, s, "db", "alice", "")
+
+	if _, err := c.QueryExtended(`SET statement_timeout = '50ms'`); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.QueryExtended(`WITH RECURSIVE r(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM r) SELECT count(*) FROM r`)
+	if e := (*Error)(nil); !errors.As(err, &e) || e.Code != "57014" {
+		t.Fatalf("expected statement timeout, got %v", err)
+	}
+
+	if _, err := c.QueryExtended(`SET app.tenant = 'a'`); err != nil {
+		t.Fatal(err)
+	} else if rows, err := c.QueryExtended(`SELECT current_setting('app.tenant')`); err != nil {
+		t.Fatal(err)
+	} else if rows[0][0] != "a" {
+		t.Fatalf("app.tenant=%q, want a", rows[0][0])
+	}
+	if _, err := c.QueryExtended(`SET statement_timeout = 'soon'`); err == nil {
+		t.Fatal("expected invalid value error")
+	}
+}
+
+// Ensure sessions waiting for a connection of a full pool give up after the
+// connection timeout.
+func TestServer_ConnTimeout(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.DBs.MaxOpenConns, s.DBs.ConnTimeout = 1, 50*time.Millisecond
+	})
+	a := MustConnect(t, s, "db", "alice", "")
+
+	_, err := Connect(t, s, "db", "bob", "")
+	if e := (*Error)(nil); !errors.As(err, &e) || e.Code != "53300" {
+		t.Fatalf("expected too many connections, got %v", err)
+	}
+
+	// The connection is available once the first session ends.
+	a.Close()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if _, err := Connect(t, s, "db", "bob", ""); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Ensure connection limits count only the other admitted sessions, so
+// connections that are still starting up or were rejected take no slot.
+func TestServer_ConnLimits(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.MaxConnections = 2
+		s.DatabaseLimits = []*postlite.DatabaseLimit{{Database: "logs", MaxConnections: 1}}
+	})
+
+	// Connections that have not sent a startup message are not counted.
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", s.Listeners()[0].String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	a := MustConnect(t, s, "logs", "alice", "")
+	MustFailConnect(t, s, "logs", "bob", "", "53300")
+	MustFailConnect(t, s, "logs", "bob", "", "53300")
+
+	// Rejected connections do not count against the server limit.
+	b := MustConnect(t, s, "db", "bob", "")
+	MustFailConnect(t, s, "db", "carol", "", "53300")
+
+	// Slots are freed once sessions end.
+	a.Close()
+	b.Close()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if c, err := Connect(t, s, "logs", "carol", ""); err == nil {
+			c.Close()
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+	}
+}
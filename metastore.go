@@ -0,0 +1,442 @@
+package postlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Errors returned by MetaStore.
+var (
+	ErrRoleExists   = errors.New("role already exists")
+	ErrRoleNotFound = errors.New("role does not exist")
+	ErrPolicyExists = errors.New("policy already exists")
+)
+
+// MetaStore is a sidecar SQLite database that holds server-wide metadata,
+// such as the roles created by clients. Like the audit database, it should not
+// be in the data directory, where clients could connect to it.
+type MetaStore struct {
+	db *sql.DB
+
+	// Path of the metadata database.
+	Path string
+}
+
+// NewMetaStore returns a new instance of MetaStore stored at path.
+func NewMetaStore(path string) *MetaStore {
+	return &MetaStore{Path: path}
+}
+
+// Open opens the metadata database & creates its tables if needed.
+func (s *MetaStore) Open() (err error) {
+	if s.db, err = sql.Open("sqlite3", "file:"+s.Path+"?_journal_mode=wal&_busy_timeout=5000"); err != nil {
+		return err
+	}
+	s.db.SetMaxOpenConns(1)
+
+	for _, stmt := range metaStoreSchema {
+		if _, err := s.db.Exec(stmt); err != nil {
+			s.db.Close()
+			return fmt.Errorf("create metastore schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the metadata database.
+func (s *MetaStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// metaStoreSchema creates the tables of the metadata database.
+var metaStoreSchema = []string{
+	`CREATE TABLE IF NOT EXISTS roles (
+		name        TEXT PRIMARY KEY,
+		password    TEXT,
+		superuser   INTEGER NOT NULL DEFAULT 0,
+		inherit     INTEGER NOT NULL DEFAULT 1,
+		createrole  INTEGER NOT NULL DEFAULT 0,
+		createdb    INTEGER NOT NULL DEFAULT 0,
+		login       INTEGER NOT NULL DEFAULT 0,
+		replication INTEGER NOT NULL DEFAULT 0,
+		bypassrls   INTEGER NOT NULL DEFAULT 0,
+		conn_limit  INTEGER NOT NULL DEFAULT -1,
+		valid_until TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS role_members (
+		role         TEXT NOT NULL,
+		member       TEXT NOT NULL,
+		grantor      TEXT NOT NULL,
+		admin_option INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (role, member)
+	)`,
+	`CREATE TABLE IF NOT EXISTS table_privileges (
+		database   TEXT NOT NULL,
+		table_name TEXT NOT NULL,
+		grantee    TEXT NOT NULL,
+		privilege  TEXT NOT NULL,
+		grantor    TEXT NOT NULL,
+		grantable  INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (database, table_name, grantee, privilege)
+	)`,
+	`CREATE TABLE IF NOT EXISTS row_security (
+		database   TEXT NOT NULL,
+		table_name TEXT NOT NULL,
+		enabled    INTEGER NOT NULL DEFAULT 0,
+		forced     INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (database, table_name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS policies (
+		database   TEXT NOT NULL,
+		table_name TEXT NOT NULL,
+		name       TEXT NOT NULL,
+		permissive INTEGER NOT NULL DEFAULT 1,
+		command    TEXT NOT NULL DEFAULT 'ALL',
+		roles      TEXT NOT NULL DEFAULT 'public',
+		using_expr TEXT,
+		check_expr TEXT,
+		PRIMARY KEY (database, table_name, name)
+	)`,
+}
+
+// Role is a Postgres-style role. Roles that can log in are users.
+type Role struct {
+	Name string
+
+	// Postgres-style MD5 hash of the password. Blank if the role has no password.
+	Password string
+
+	Superuser   bool
+	Inherit     bool // inherits the privileges of roles it is a member of
+	CreateRole  bool
+	CreateDB    bool
+	Login       bool
+	Replication bool
+	BypassRLS   bool
+
+	// Maximum number of concurrent connections, or -1 for no limit.
+	ConnLimit int
+
+	// Time after which the password is no longer valid. Zero if it never expires.
+	ValidUntil time.Time
+}
+
+// canLogin returns true if the role may log in at the given time.
+func (r *Role) canLogin(now time.Time) bool {
+	return r.Login && (r.ValidUntil.IsZero() || now.Before(r.ValidUntil))
+}
+
+// RoleMembership grants the privileges of a role to a member role.
+type RoleMembership struct {
+	Role        string
+	Member      string
+	Grantor     string
+	AdminOption bool // member may grant the role to others
+}
+
+// Roles returns all roles, sorted by name.
+func (s *MetaStore) Roles() ([]*Role, error) {
+	rows, err := s.db.Query(`SELECT name, password, superuser, inherit, createrole, createdb, login, replication, bypassrls, conn_limit, valid_until FROM roles ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*Role
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// Role returns the role with the given name. Returns nil if not found.
+func (s *MetaStore) Role(name string) (*Role, error) {
+	role, err := scanRole(s.db.QueryRow(`SELECT name, password, superuser, inherit, createrole, createdb, login, replication, bypassrls, conn_limit, valid_until FROM roles WHERE name = ?`, name))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return role, err
+}
+
+func scanRole(row interface{ Scan(...interface{}) error }) (*Role, error) {
+	var role Role
+	var password, validUntil sql.NullString
+	if err := row.Scan(&role.Name, &password, &role.Superuser, &role.Inherit, &role.CreateRole, &role.CreateDB, &role.Login, &role.Replication, &role.BypassRLS, &role.ConnLimit, &validUntil); err != nil {
+		return nil, err
+	}
+	role.Password = password.String
+
+	if validUntil.Valid {
+		t, err := time.Parse(time.RFC3339, validUntil.String)
+		if err != nil {
+			return nil, fmt.Errorf("role %q: invalid valid_until: %w", role.Name, err)
+		}
+		role.ValidUntil = t
+	}
+	return &role, nil
+}
+
+// CreateRole adds a new role. Returns ErrRoleExists if the name is taken.
+func (s *MetaStore) CreateRole(role *Role) error {
+	if existing, err := s.Role(role.Name); err != nil {
+		return err
+	} else if existing != nil {
+		return ErrRoleExists
+	}
+
+	_, err := s.db.Exec(`INSERT INTO roles (name, password, superuser, inherit, createrole, createdb, login, replication, bypassrls, conn_limit, valid_until) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		role.Name, nullString(role.Password), role.Superuser, role.Inherit, role.CreateRole, role.CreateDB, role.Login, role.Replication, role.BypassRLS, role.ConnLimit, nullTime(role.ValidUntil))
+	return err
+}
+
+// UpdateRole replaces the attributes of an existing role. Returns
+// ErrRoleNotFound if the role does not exist.
+func (s *MetaStore) UpdateRole(role *Role) error {
+	result, err := s.db.Exec(`UPDATE roles SET password = ?, superuser = ?, inherit = ?, createrole = ?, createdb = ?, login = ?, replication = ?, bypassrls = ?, conn_limit = ?, valid_until = ? WHERE name = ?`,
+		nullString(role.Password), role.Superuser, role.Inherit, role.CreateRole, role.CreateDB, role.Login, role.Replication, role.BypassRLS, role.ConnLimit, nullTime(role.ValidUntil), role.Name)
+	if err != nil {
+		return err
+	} else if n, _ := result.RowsAffected(); n == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+// DropRole removes a role, its memberships & its table privileges. Returns ErrRoleNotFound if the
+// role does not exist.
+func (s *MetaStore) DropRole(name string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM roles WHERE name = ?`, name)
+	if err != nil {
+		return err
+	} else if n, _ := result.RowsAffected(); n == 0 {
+		return ErrRoleNotFound
+	}
+	if _, err := tx.Exec(`DELETE FROM role_members WHERE role = ?1 OR member = ?1`, name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM table_privileges WHERE grantee = ?`, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RoleMemberships returns all role memberships.
+func (s *MetaStore) RoleMemberships() ([]*RoleMembership, error) {
+	rows, err := s.db.Query(`SELECT role, member, grantor, admin_option FROM role_members ORDER BY role, member`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var a []*RoleMembership
+	for rows.Next() {
+		var m RoleMembership
+		if err := rows.Scan(&m.Role, &m.Member, &m.Grantor, &m.AdminOption); err != nil {
+			return nil, err
+		}
+		a = append(a, &m)
+	}
+	return a, rows.Err()
+}
+
+// GrantRole makes member a member of role. Granting an existing membership
+// again only adds the admin option, as in Postgres.
+func (s *MetaStore) GrantRole(m *RoleMembership) error {
+	_, err := s.db.Exec(`
+		INSERT INTO role_members (role, member, grantor, admin_option) VALUES (?, ?, ?, ?)
+		ON CONFLICT (role, member) DO UPDATE SET admin_option = admin_option OR excluded.admin_option
+	`, m.Role, m.Member, m.Grantor, m.AdminOption)
+	return err
+}
+
+// RevokeRole removes member from role. If adminOnly is true, only the admin
+// option is revoked. Returns false if member was not a member of role.
+func (s *MetaStore) RevokeRole(role, member string, adminOnly bool) (bool, error) {
+	query := `DELETE FROM role_members WHERE role = ? AND member = ?`
+	if adminOnly {
+		query = `UPDATE role_members SET admin_option = 0 WHERE role = ? AND member = ?`
+	}
+	result, err := s.db.Exec(query, role, member)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// TablePrivilege grants a privilege on a table or view of a database to a
+// role, or to all roles if Grantee is "public".
+type TablePrivilege struct {
+	Database  string
+	Table     string
+	Grantee   string
+	Privilege string // SELECT, INSERT, UPDATE or DELETE
+	Grantor   string
+	Grantable bool // grantee may grant the privilege to others
+}
+
+// TablePrivileges returns the table privileges granted in a database.
+func (s *MetaStore) TablePrivileges(database string) ([]*TablePrivilege, error) {
+	rows, err := s.db.Query(`SELECT database, table_name, grantee, privilege, grantor, grantable FROM table_privileges WHERE database = ? ORDER BY table_name, grantee, privilege`, database)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var a []*TablePrivilege
+	for rows.Next() {
+		var p TablePrivilege
+		if err := rows.Scan(&p.Database, &p.Table, &p.Grantee, &p.Privilege, &p.Grantor, &p.Grantable); err != nil {
+			return nil, err
+		}
+		a = append(a, &p)
+	}
+	return a, rows.Err()
+}
+
+// GrantTablePrivilege grants a table privilege. Granting an existing
+// privilege again only adds the grant option.
+func (s *MetaStore) GrantTablePrivilege(p *TablePrivilege) error {
+	_, err := s.db.Exec(`
+		INSERT INTO table_privileges (database, table_name, grantee, privilege, grantor, grantable) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (database, table_name, grantee, privilege) DO UPDATE SET grantable = grantable OR excluded.grantable
+	`, p.Database, p.Table, p.Grantee, p.Privilege, p.Grantor, p.Grantable)
+	return err
+}
+
+// RevokeTablePrivilege revokes a table privilege. If p.Grantor is set, only a
+// privilege granted by it is revoked. If grantOptionOnly is true, only the
+// grant option is revoked. Returns false if the privilege was not granted.
+func (s *MetaStore) RevokeTablePrivilege(p *TablePrivilege, grantOptionOnly bool) (bool, error) {
+	query := `DELETE FROM table_privileges`
+	if grantOptionOnly {
+		query = `UPDATE table_privileges SET grantable = 0`
+	}
+	query += ` WHERE database = ? AND table_name = ? AND grantee = ? AND privilege = ? AND (? = '' OR grantor = ?)`
+
+	result, err := s.db.Exec(query, p.Database, p.Table, p.Grantee, p.Privilege, p.Grantor, p.Grantor)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// RowSecurity is the row-level security state of a table.
+type RowSecurity struct {
+	Database string
+	Table    string
+	Enabled  bool
+	Forced   bool // policies apply to the table owner too
+}
+
+// RowSecurity returns the row-level security state of the tables of a
+// database that have been altered.
+func (s *MetaStore) RowSecurity(database string) ([]*RowSecurity, error) {
+	rows, err := s.db.Query(`SELECT database, table_name, enabled, forced FROM row_security WHERE database = ? ORDER BY table_name`, database)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var a []*RowSecurity
+	for rows.Next() {
+		var rs RowSecurity
+		if err := rows.Scan(&rs.Database, &rs.Table, &rs.Enabled, &rs.Forced); err != nil {
+			return nil, err
+		}
+		a = append(a, &rs)
+	}
+	return a, rows.Err()
+}
+
+// SetRowSecurity sets the row-level security state of a table.
+func (s *MetaStore) SetRowSecurity(rs *RowSecurity) error {
+	_, err := s.db.Exec(`
+		INSERT INTO row_security (database, table_name, enabled, forced) VALUES (?, ?, ?, ?)
+		ON CONFLICT (database, table_name) DO UPDATE SET enabled = excluded.enabled, forced = excluded.forced
+	`, rs.Database, rs.Table, rs.Enabled, rs.Forced)
+	return err
+}
+
+// Policy is a row-level security policy of a table. Expressions are SQL
+// expressions over the columns of the table.
+type Policy struct {
+	Database   string
+	Table      string
+	Name       string
+	Permissive bool     // permissive policies are combined with OR, restrictive ones with AND
+	Command    string   // ALL, SELECT, INSERT, UPDATE or DELETE
+	Roles      []string // roles the policy applies to, or "public"
+	Using      string   // rows visible to the command
+	Check      string   // rows the command may write
+}
+
+// Policies returns the policies of the tables of a database.
+func (s *MetaStore) Policies(database string) ([]*Policy, error) {
+	rows, err := s.db.Query(`SELECT database, table_name, name, permissive, command, roles, using_expr, check_expr FROM policies WHERE database = ? ORDER BY table_name, name`, database)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var a []*Policy
+	for rows.Next() {
+		var p Policy
+		var roles string
+		var using, check sql.NullString
+		if err := rows.Scan(&p.Database, &p.Table, &p.Name, &p.Permissive, &p.Command, &roles, &using, &check); err != nil {
+			return nil, err
+		}
+		p.Roles, p.Using, p.Check = strings.Split(roles, ","), using.String, check.String
+		a = append(a, &p)
+	}
+	return a, rows.Err()
+}
+
+// CreatePolicy adds a policy. Returns ErrPolicyExists if the table already has
+// a policy with the same name.
+func (s *MetaStore) CreatePolicy(p *Policy) error {
+	_, err := s.db.Exec(`INSERT INTO policies (database, table_name, name, permissive, command, roles, using_expr, check_expr) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.Database, p.Table, p.Name, p.Permissive, p.Command, strings.Join(p.Roles, ","), nullString(p.Using), nullString(p.Check))
+	var serr sqlite3.Error
+	if errors.As(err, &serr) && serr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+		return ErrPolicyExists
+	}
+	return err
+}
+
+// DropPolicy removes a policy. Returns false if it does not exist.
+func (s *MetaStore) DropPolicy(database, table, name string) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM policies WHERE database = ? AND table_name = ? AND name = ?`, database, table, name)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+func nullTime(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.UTC().Format(time.RFC3339), Valid: true}
+}
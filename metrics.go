@@ -0,0 +1,227 @@
+package postlite
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultMaxDatabaseLabels is the default number of databases reported by
+// name in the database label.
+const DefaultMaxDatabaseLabels = 100
+
+// otherDatabaseLabel is the database label for databases beyond the limit.
+const otherDatabaseLabel = "other"
+
+// Metrics holds the Prometheus collectors that instrument a server. All
+// methods are safe to call on a nil Metrics, which disables collection.
+type Metrics struct {
+	// Number of distinct databases reported by name in the database label.
+	// Database names come from clients, so sessions on any further databases
+	// are reported as "other" to bound the number of label values.
+	MaxDatabaseLabels int
+
+	mu        sync.Mutex
+	databases map[string]struct{} // databases reported by name
+
+	registry *prometheus.Registry
+
+	connectionsActive *prometheus.GaugeVec
+	connectionsTotal  *prometheus.CounterVec
+	queriesTotal      *prometheus.CounterVec
+	queryDuration     *prometheus.HistogramVec
+	errorsTotal       *prometheus.CounterVec
+	bytesReceived     prometheus.Counter
+	bytesSent         prometheus.Counter
+	busyTotal         prometheus.Counter
+	authFailures      *prometheus.CounterVec
+}
+
+// NewMetrics returns a new set of metrics registered to their own registry.
+// dbs reports the number of open SQLite handles.
+func NewMetrics(dbs *DBManager) *Metrics {
+	m := &Metrics{
+		MaxDatabaseLabels: DefaultMaxDatabaseLabels,
+
+		databases: make(map[string]struct{}),
+		registry:  prometheus.NewRegistry(),
+
+		connectionsActive: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "postlite_connections_active",
+			Help: "Number of open client sessions.",
+		}, []string{"database"}),
+		connectionsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "postlite_connections_total",
+			Help: "Number of client sessions started.",
+		}, []string{"database"}),
+		queriesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "postlite_queries_total",
+			Help: "Number of queries executed, by command tag.",
+		}, []string{"database", "tag"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "postlite_query_duration_seconds",
+			Help:    "Time to execute queries & send their results, by command tag.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"tag"}),
+		errorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "postlite_errors_total",
+			Help: "Number of error responses sent to clients, by SQLSTATE.",
+		}, []string{"code"}),
+		bytesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "postlite_bytes_received_total",
+			Help: "Number of bytes received from clients.",
+		}),
+		bytesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "postlite_bytes_sent_total",
+			Help: "Number of bytes sent to clients.",
+		}),
+		busyTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "postlite_sqlite_busy_total",
+			Help: "Number of queries that failed with SQLITE_BUSY after retrying for the busy timeout.",
+		}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "postlite_auth_failures_total",
+			Help: "Number of rejected connections, by reason.",
+		}, []string{"reason"}),
+	}
+
+	m.registry.MustRegister(
+		m.connectionsActive,
+		m.connectionsTotal,
+		m.queriesTotal,
+		m.queryDuration,
+		m.errorsTotal,
+		m.bytesReceived,
+		m.bytesSent,
+		m.busyTotal,
+		m.authFailures,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "postlite_sqlite_handles_open",
+			Help: "Number of open SQLite database handles.",
+		}, func() float64 { return float64(len(dbs.DBs())) }),
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Registry returns the registry holding the metrics so callers can register
+// additional collectors.
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler returns an HTTP handler that serves the metrics in the Prometheus
+// exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// databaseLabel returns the database label for a database. The first
+// MaxDatabaseLabels databases are reported by name & the rest as "other".
+// A database keeps its label once assigned so session gauges stay balanced.
+func (m *Metrics) databaseLabel(database string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.databases[database]; ok {
+		return database
+	} else if len(m.databases) >= m.MaxDatabaseLabels {
+		return otherDatabaseLabel
+	}
+	m.databases[database] = struct{}{}
+	return database
+}
+
+func (m *Metrics) sessionStarted(database string) {
+	if m == nil {
+		return
+	}
+	label := m.databaseLabel(database)
+	m.connectionsTotal.WithLabelValues(label).Inc()
+	m.connectionsActive.WithLabelValues(label).Inc()
+}
+
+func (m *Metrics) sessionEnded(database string) {
+	if m == nil {
+		return
+	}
+	m.connectionsActive.WithLabelValues(m.databaseLabel(database)).Dec()
+}
+
+func (m *Metrics) observeQuery(database, query string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	tag := commandTag(query)
+	m.queriesTotal.WithLabelValues(m.databaseLabel(database), tag).Inc()
+	m.queryDuration.WithLabelValues(tag).Observe(d.Seconds())
+}
+
+// observeError counts an error response sent to a client. The error that
+// caused it, if any, is checked for SQLITE_BUSY.
+func (m *Metrics) observeError(resp *pgproto3.ErrorResponse, err error) {
+	if m == nil {
+		return
+	}
+	m.errorsTotal.WithLabelValues(resp.Code).Inc()
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrBusy {
+		m.busyTotal.Inc()
+	}
+}
+
+func (m *Metrics) authFailed(reason string) {
+	if m == nil {
+		return
+	}
+	m.authFailures.WithLabelValues(reason).Inc()
+}
+
+// commandTag returns the command of a query, such as "SELECT", for use as a
+// metric label. Unknown commands are reported as "OTHER" to bound the number
+// of label values.
+func commandTag(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "EMPTY"
+	}
+
+	tag := strings.ToUpper(strings.TrimSuffix(fields[0], ";"))
+	switch tag {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "WITH", "VALUES",
+		"BEGIN", "COMMIT", "END", "ROLLBACK", "SAVEPOINT", "RELEASE",
+		"CREATE", "ALTER", "DROP", "PRAGMA", "VACUUM", "ANALYZE",
+		"ATTACH", "DETACH", "EXPLAIN", "SET", "SHOW", "REPLACE", "REINDEX":
+		return tag
+	default:
+		return "OTHER"
+	}
+}
+
+// meteredConn counts the bytes read from & written to a client connection.
+type meteredConn struct {
+	net.Conn
+	metrics *Metrics
+}
+
+func (c *meteredConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.metrics.bytesReceived.Add(float64(n))
+	return n, err
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.metrics.bytesSent.Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,136 @@
+package postlite_test
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure label values are bounded: databases beyond the limit are reported
+// as "other" & unknown commands as "OTHER", whatever clients send.
+func TestMetrics_Labels(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.Metrics.MaxDatabaseLabels = 2
+		s.Auth = &postlite.Auth{Users: []*postlite.User{{Name: "alice", Password: "secret"}}}
+	})
+
+	var clients []*Client
+	for _, db := range []string{"a", "b", "c", "d"} {
+		c := MustConnect(t, s, db, "alice", "secret")
+		c.MustQuery(`SELECT 1`)
+		c.MustQuery(`NOTIFY "` + strings.Repeat("x", 100) + `"`)
+		c.MustFailQuery(`SELEC 1`, "42000")
+		clients = append(clients, c)
+	}
+	MustFailConnect(t, s, "e", "alice", "wrong", "28P01")
+
+	metrics := gatherMetrics(t, s.Metrics)
+	if got, want := labelValues(metrics["postlite_connections_total"], "database"), []string{"a", "b", "other"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("connections_total databases=%v, want %v", got, want)
+	} else if got, want := counterValue(metrics["postlite_connections_total"], "database", "other"), 2.0; got != want {
+		t.Fatalf("connections_total{database=other}=%v, want %v", got, want)
+	}
+	if got, want := labelValues(metrics["postlite_queries_total"], "database"), []string{"a", "b", "other"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("queries_total databases=%v, want %v", got, want)
+	} else if got, want := labelValues(metrics["postlite_queries_total"], "tag"), []string{"OTHER", "SELECT"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("queries_total tags=%v, want %v", got, want)
+	} else if got, want := labelValues(metrics["postlite_query_duration_seconds"], "tag"), []string{"OTHER", "SELECT"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("query_duration_seconds tags=%v, want %v", got, want)
+	}
+	if got, want := counterValue(metrics["postlite_errors_total"], "code", "42000"), 4.0; got != want {
+		t.Fatalf("errors_total{code=42000}=%v, want %v", got, want)
+	} else if got, want := counterValue(metrics["postlite_auth_failures_total"], "reason", "password"), 1.0; got != want {
+		t.Fatalf("auth_failures_total{reason=password}=%v, want %v", got, want)
+	}
+
+	// Databases keep their label so the active session gauge is balanced.
+	for _, c := range clients {
+		c.Close()
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		metrics = gatherMetrics(t, s.Metrics)
+		var n float64
+		for _, smp := range metrics["postlite_connections_active"] {
+			n += smp.value
+		}
+		if n == 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("connections_active=%v, want 0", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, want := labelValues(metrics["postlite_connections_active"], "database"), []string{"a", "b", "other"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("connections_active databases=%v, want %v", got, want)
+	}
+}
+
+// gatherMetrics returns the samples served by m's handler by metric name.
+func gatherMetrics(tb testing.TB, m *postlite.Metrics) map[string][]sample {
+	tb.Helper()
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	a := make(map[string][]sample)
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		match := sampleRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		value, err := strconv.ParseFloat(match[3], 64)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		smp := sample{labels: make(map[string]string), value: value}
+		for _, l := range labelRegex.FindAllStringSubmatch(match[2], -1) {
+			smp.labels[l[1]] = l[2]
+		}
+		name := strings.TrimSuffix(strings.TrimSuffix(match[1], "_bucket"), "_count")
+		a[name] = append(a[name], smp)
+	}
+	return a
+}
+
+var (
+	sampleRegex = regexp.MustCompile(`^(postlite_\w+)\{(.*)\} (\S+)$`)
+	labelRegex  = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// sample is a single metric value & its labels.
+type sample struct {
+	labels map[string]string
+	value  float64
+}
+
+// labelValues returns the sorted, distinct values of a label in samples.
+func labelValues(samples []sample, name string) []string {
+	set := make(map[string]struct{})
+	for _, smp := range samples {
+		if v, ok := smp.labels[name]; ok {
+			set[v] = struct{}{}
+		}
+	}
+	a := make([]string, 0, len(set))
+	for v := range set {
+		a = append(a, v)
+	}
+	sort.Strings(a)
+	return a
+}
+
+// counterValue returns the sum of the samples with the given label value.
+func counterValue(samples []sample, name, value string) (v float64) {
+	for _, smp := range samples {
+		if smp.labels[name] == value {
+			v += smp.value
+		}
+	}
+	return v
+}
@@ -0,0 +1,186 @@
+package postlite
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/mattn/go-sqlite3"
+)
+
+// maxNotifyPayload is the longest payload of a notification, as in Postgres.
+const maxNotifyPayload = 8000
+
+// Notifications are sent with NOTIFY or pg_notify() to the sessions of the
+// same database that LISTEN on their channel. They are queued by the session
+// until its transaction commits & are discarded if it rolls back. Listening
+// sessions receive them between queries, once they are outside of a
+// transaction.
+
+// handleNotifyStatement handles LISTEN, UNLISTEN & NOTIFY, which are
+// implemented by the server. Returns false if the query is not one of them.
+func (s *Server) handleNotifyStatement(c *Conn, w *resultBuffer, query string) (bool, error) {
+	var tag string
+	var err error
+	if m := listenRegex.FindStringSubmatch(query); m != nil {
+		tag = "LISTEN"
+		s.listen(c, identName(m[1]))
+	} else if m := unlistenRegex.FindStringSubmatch(query); m != nil {
+		tag = "UNLISTEN"
+		if m[1] == "*" {
+			s.unlistenAll(c)
+		} else {
+			s.unlisten(c, identName(m[1]))
+		}
+	} else if m := notifyRegex.FindStringSubmatch(query); m != nil {
+		tag = "NOTIFY"
+		var payload string
+		if m[2] != "" {
+			payload = strings.ReplaceAll(m[2][1:len(m[2])-1], "''", "'")
+		}
+		err = c.notify(identName(m[1]), payload)
+	} else {
+		return false, nil
+	}
+	w.SetCommandTag(tag)
+	return true, err
+}
+
+// listen registers the session to receive the notifications of a channel.
+func (s *Server) listen(c *Conn, channel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	database := c.db.Name()
+	if s.listeners[database] == nil {
+		s.listeners[database] = make(map[string]map[*Conn]struct{})
+	}
+	if s.listeners[database][channel] == nil {
+		s.listeners[database][channel] = make(map[*Conn]struct{})
+	}
+	s.listeners[database][channel][c] = struct{}{}
+	c.logger.Debug("listen", "channel", channel)
+}
+
+// unlisten stops the session receiving the notifications of a channel.
+func (s *Server) unlisten(c *Conn, channel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeListener(c, channel)
+}
+
+// unlistenAll stops the session receiving notifications on any channel.
+func (s *Server) unlistenAll(c *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.db == nil {
+		return
+	}
+	for channel := range s.listeners[c.db.Name()] {
+		s.removeListener(c, channel)
+	}
+}
+
+// removeListener removes the session from the listeners of a channel. Must be
+// called with mu held.
+func (s *Server) removeListener(c *Conn, channel string) {
+	database := c.db.Name()
+	delete(s.listeners[database][channel], c)
+	if len(s.listeners[database][channel]) == 0 {
+		delete(s.listeners[database], channel)
+	}
+	if len(s.listeners[database]) == 0 {
+		delete(s.listeners, database)
+	}
+}
+
+// notify queues a notification until the session's transaction commits.
+// Identical notifications of a transaction are only sent once.
+func (c *Conn) notify(channel, payload string) error {
+	if channel == "" {
+		return Errorf("22023", "channel name cannot be empty") // invalid_parameter_value
+	} else if len(payload) >= maxNotifyPayload {
+		return Errorf("22023", "payload string too long")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, n := range c.pendingNotifications {
+		if n.Channel == channel && n.Payload == payload {
+			return nil
+		}
+	}
+	c.pendingNotifications = append(c.pendingNotifications, &pgproto3.NotificationResponse{PID: uint32(c.pid), Channel: channel, Payload: payload})
+	return nil
+}
+
+// discardNotifications discards the notifications of a rolled back
+// transaction.
+func (c *Conn) discardNotifications() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pendingNotifications = nil
+}
+
+// publishNotifications sends the notifications of the session's committed
+// transaction to the sessions listening on their channels. Listeners waiting
+// for a message are interrupted so they receive them right away.
+func (s *Server) publishNotifications(c *Conn) {
+	c.mu.Lock()
+	pending := c.pendingNotifications
+	c.pendingNotifications = nil
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range pending {
+		for listener := range s.listeners[c.db.Name()][n.Channel] {
+			listener.mu.Lock()
+			listener.notifications = append(listener.notifications, n)
+			if listener.idle {
+				listener.SetReadDeadline(time.Now())
+			}
+			listener.mu.Unlock()
+		}
+	}
+}
+
+// flushNotifications sends the notifications received by the session to its
+// client.
+func (c *Conn) flushNotifications() error {
+	c.mu.Lock()
+	notifications := c.notifications
+	c.notifications = nil
+	c.mu.Unlock()
+
+	msgs := make([]pgproto3.Message, len(notifications))
+	for i, n := range notifications {
+		msgs[i] = n
+	}
+	return writeMessages(c, msgs...)
+}
+
+// pgNotify returns the pg_notify() SQL function for conn, which queues a
+// notification for the session that has pinned conn. It may be called by
+// triggers to notify listeners of data changes. Returns a blank string as SQL
+// functions cannot return void.
+func (s *Server) pgNotify(conn *sqlite3.SQLiteConn) func(channel, payload string) (string, error) {
+	return func(channel, payload string) (string, error) {
+		c := s.session(conn)
+		if c == nil {
+			return "", fmt.Errorf("pg_notify() requires a client session")
+		}
+		return "", c.notify(channel, payload)
+	}
+}
+
+var (
+	listenRegex   = regexp.MustCompile(`(?is)^\s*LISTEN\s+(` + roleIdent + `)\s*;?\s*$`)
+	unlistenRegex = regexp.MustCompile(`(?is)^\s*UNLISTEN\s+(\*|` + roleIdent + `)\s*;?\s*$`)
+	notifyRegex   = regexp.MustCompile(`(?is)^\s*NOTIFY\s+(` + roleIdent + `)(?:\s*,\s*('(?:[^']|'')*'))?\s*;?\s*$`)
+)
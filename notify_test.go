@@ -0,0 +1,50 @@
+package postlite_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Ensure notifications reach listening sessions when the notifying
+// transaction commits, in order, & are discarded when it rolls back.
+func TestServer_ListenNotify(t *testing.T) {
+	s := MustOpenServer(t)
+
+	a := MustConnect(t, s, "db", "alice", "")
+	b := MustConnect(t, s, "db", "bob", "")
+	b.MustQuery(`LISTEN jobs`)
+	b.MustQuery(`LISTEN done`)
+
+	a.MustQuery(`BEGIN`)
+	a.MustQuery(`NOTIFY jobs, 'rolled back'`)
+	a.MustQuery(`ROLLBACK`)
+
+	a.MustQuery(`BEGIN`)
+	a.MustQuery(`NOTIFY jobs, 'it''s first'`)
+	a.MustQuery(`SELECT pg_notify('jobs', 'second')`)
+	a.MustQuery(`COMMIT`)
+
+	for _, want := range []string{"it's first", "second"} {
+		if n := b.MustReceiveNotification(); n.Channel != "jobs" || n.Payload != want {
+			t.Fatalf("unexpected notification: %s %q, want %q", n.Channel, n.Payload, want)
+		}
+	}
+
+	// Sessions stop receiving a channel's notifications after UNLISTEN.
+	b.MustQuery(`UNLISTEN jobs`)
+	a.MustQuery(`NOTIFY jobs, 'ignored'`)
+	a.MustQuery(`NOTIFY done`)
+	if n := b.MustReceiveNotification(); n.Channel != "done" || n.Payload != "" {
+		t.Fatalf("unexpected notification: %s %q", n.Channel, n.Payload)
+	}
+
+	// Statements are handled by the server over the extended protocol too.
+	b.MustQueryExtended(`LISTEN ext`)
+	a.MustQueryExtended(`NOTIFY ext, 'x'`)
+	if n := b.MustReceiveNotification(); n.Channel != "ext" || n.Payload != "x" {
+		t.Fatalf("unexpected notification: %s %q", n.Channel, n.Payload)
+	}
+
+	a.MustFailQuery(fmt.Sprintf(`NOTIFY jobs, '%s'`, strings.Repeat("x", 8000)), "22023")
+}
@@ -0,0 +1,25 @@
+package postlite
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerUID returns the user ID of the process on the other end of a Unix
+// domain socket connection.
+func peerUID(conn *net.UnixConn) (uid int, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var cred *syscall.Ucred
+	if e := raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); e != nil {
+		return 0, e
+	} else if err != nil {
+		return 0, err
+	}
+	return int(cred.Uid), nil
+}
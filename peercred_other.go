@@ -0,0 +1,13 @@
+//go:build !linux
+
+package postlite
+
+import (
+	"errors"
+	"net"
+)
+
+// peerUID is not supported on this platform.
+func peerUID(conn *net.UnixConn) (uid int, err error) {
+	return 0, errors.New("peer authentication is not supported on this platform")
+}
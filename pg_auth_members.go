@@ -0,0 +1,96 @@
+package postlite
+
+import (
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type pgAuthMembersModule struct {
+	members func() ([]pgAuthMember, error)
+}
+
+func (m *pgAuthMembersModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	err := c.DeclareVTab(fmt.Sprintf(`
+		CREATE TABLE %s (
+			roleid       INTEGER,
+			member       INTEGER,
+			grantor      INTEGER,
+			admin_option INTEGER
+		)`, args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return &pgAuthMembersTable{members: m.members}, nil
+}
+
+func (m *pgAuthMembersModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Create(c, args)
+}
+
+func (m *pgAuthMembersModule) DestroyModule() {}
+
+type pgAuthMembersTable struct {
+	members func() ([]pgAuthMember, error)
+}
+
+func (t *pgAuthMembersTable) Open() (sqlite3.VTabCursor, error) {
+	return &pgAuthMembersCursor{members: t.members}, nil
+}
+
+func (t *pgAuthMembersTable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	return &sqlite3.IndexResult{Used: make([]bool, len(cst))}, nil
+}
+
+func (t *pgAuthMembersTable) Disconnect() error { return nil }
+func (t *pgAuthMembersTable) Destroy() error    { return nil }
+
+type pgAuthMembersCursor struct {
+	members func() ([]pgAuthMember, error)
+	rows    []pgAuthMember
+	index   int
+}
+
+func (c *pgAuthMembersCursor) Column(sctx *sqlite3.SQLiteContext, col int) error {
+	switch col {
+	case 0:
+		sctx.ResultInt(c.rows[c.index].roleid)
+	case 1:
+		sctx.ResultInt(c.rows[c.index].member)
+	case 2:
+		sctx.ResultInt(c.rows[c.index].grantor)
+	case 3:
+		sctx.ResultInt(c.rows[c.index].admin_option)
+	}
+	return nil
+}
+
+func (c *pgAuthMembersCursor) Filter(idxNum int, idxStr string, vals []interface{}) (err error) {
+	c.index = 0
+	c.rows, err = c.members()
+	return err
+}
+
+func (c *pgAuthMembersCursor) Next() error {
+	c.index++
+	return nil
+}
+
+func (c *pgAuthMembersCursor) EOF() bool {
+	return c.index >= len(c.rows)
+}
+
+func (c *pgAuthMembersCursor) Rowid() (int64, error) {
+	return int64(c.index), nil
+}
+
+func (c *pgAuthMembersCursor) Close() error {
+	return nil
+}
+
+type pgAuthMember struct {
+	roleid       int
+	member       int
+	grantor      int
+	admin_option int
+}
@@ -0,0 +1,113 @@
+package postlite
+
+import (
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type pgAuthIDModule struct {
+	roles func() ([]pgRole, error)
+}
+
+func (m *pgAuthIDModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	err := c.DeclareVTab(fmt.Sprintf(`
+		CREATE TABLE %s (
+			oid            INTEGER,
+			rolname        TEXT,
+			rolsuper       INTEGER,
+			rolinherit     INTEGER,
+			rolcreaterole  INTEGER,
+			rolcreatedb    INTEGER,
+			rolcanlogin    INTEGER,
+			rolreplication INTEGER,
+			rolbypassrls   INTEGER,
+			rolconnlimit   INTEGER,
+			rolpassword    TEXT,
+			rolvaliduntil  TEXT
+		)`, args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return &pgAuthIDTable{roles: m.roles}, nil
+}
+
+func (m *pgAuthIDModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Create(c, args)
+}
+
+func (m *pgAuthIDModule) DestroyModule() {}
+
+type pgAuthIDTable struct {
+	roles func() ([]pgRole, error)
+}
+
+func (t *pgAuthIDTable) Open() (sqlite3.VTabCursor, error) {
+	return &pgAuthIDCursor{roles: t.roles}, nil
+}
+
+func (t *pgAuthIDTable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	return &sqlite3.IndexResult{Used: make([]bool, len(cst))}, nil
+}
+
+func (t *pgAuthIDTable) Disconnect() error { return nil }
+func (t *pgAuthIDTable) Destroy() error    { return nil }
+
+type pgAuthIDCursor struct {
+	roles func() ([]pgRole, error)
+	rows  []pgRole
+	index int
+}
+
+func (c *pgAuthIDCursor) Column(sctx *sqlite3.SQLiteContext, col int) error {
+	switch col {
+	case 0:
+		sctx.ResultInt(c.rows[c.index].oid)
+	case 1:
+		sctx.ResultText(c.rows[c.index].rolname)
+	case 2:
+		sctx.ResultInt(c.rows[c.index].rolsuper)
+	case 3:
+		sctx.ResultInt(c.rows[c.index].rolinherit)
+	case 4:
+		sctx.ResultInt(c.rows[c.index].rolcreaterole)
+	case 5:
+		sctx.ResultInt(c.rows[c.index].rolcreatedb)
+	case 6:
+		sctx.ResultInt(c.rows[c.index].rolcanlogin)
+	case 7:
+		sctx.ResultInt(c.rows[c.index].rolreplication)
+	case 8:
+		sctx.ResultInt(c.rows[c.index].rolbypassrls)
+	case 9:
+		sctx.ResultInt(c.rows[c.index].rolconnlimit)
+	case 10:
+		resultNullText(sctx, c.rows[c.index].rolpassword)
+	case 11:
+		resultNullText(sctx, c.rows[c.index].rolvaliduntil)
+	}
+	return nil
+}
+
+func (c *pgAuthIDCursor) Filter(idxNum int, idxStr string, vals []interface{}) (err error) {
+	c.index = 0
+	c.rows, err = c.roles()
+	return err
+}
+
+func (c *pgAuthIDCursor) Next() error {
+	c.index++
+	return nil
+}
+
+func (c *pgAuthIDCursor) EOF() bool {
+	return c.index >= len(c.rows)
+}
+
+func (c *pgAuthIDCursor) Rowid() (int64, error) {
+	return int64(c.index), nil
+}
+
+func (c *pgAuthIDCursor) Close() error {
+	return nil
+}
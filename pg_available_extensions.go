@@ -0,0 +1,96 @@
+package postlite
+
+import (
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type pgAvailableExtensionsModule struct {
+	extensions func() ([]pgAvailableExtension, error)
+}
+
+func (m *pgAvailableExtensionsModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	err := c.DeclareVTab(fmt.Sprintf(`
+		CREATE TABLE %s (
+			name              TEXT,
+			default_version   TEXT,
+			installed_version TEXT,
+			comment           TEXT
+		)`, args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return &pgAvailableExtensionsTable{extensions: m.extensions}, nil
+}
+
+func (m *pgAvailableExtensionsModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Create(c, args)
+}
+
+func (m *pgAvailableExtensionsModule) DestroyModule() {}
+
+type pgAvailableExtensionsTable struct {
+	extensions func() ([]pgAvailableExtension, error)
+}
+
+func (t *pgAvailableExtensionsTable) Open() (sqlite3.VTabCursor, error) {
+	return &pgAvailableExtensionsCursor{extensions: t.extensions}, nil
+}
+
+func (t *pgAvailableExtensionsTable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	return &sqlite3.IndexResult{Used: make([]bool, len(cst))}, nil
+}
+
+func (t *pgAvailableExtensionsTable) Disconnect() error { return nil }
+func (t *pgAvailableExtensionsTable) Destroy() error    { return nil }
+
+type pgAvailableExtensionsCursor struct {
+	extensions func() ([]pgAvailableExtension, error)
+	rows       []pgAvailableExtension
+	index      int
+}
+
+func (c *pgAvailableExtensionsCursor) Column(sctx *sqlite3.SQLiteContext, col int) error {
+	switch col {
+	case 0:
+		sctx.ResultText(c.rows[c.index].name)
+	case 1:
+		sctx.ResultText(c.rows[c.index].defaultVersion)
+	case 2:
+		resultNullText(sctx, c.rows[c.index].installedVersion)
+	case 3:
+		resultNullText(sctx, c.rows[c.index].comment)
+	}
+	return nil
+}
+
+func (c *pgAvailableExtensionsCursor) Filter(idxNum int, idxStr string, vals []interface{}) (err error) {
+	c.index = 0
+	c.rows, err = c.extensions()
+	return err
+}
+
+func (c *pgAvailableExtensionsCursor) Next() error {
+	c.index++
+	return nil
+}
+
+func (c *pgAvailableExtensionsCursor) EOF() bool {
+	return c.index >= len(c.rows)
+}
+
+func (c *pgAvailableExtensionsCursor) Rowid() (int64, error) {
+	return int64(c.index), nil
+}
+
+func (c *pgAvailableExtensionsCursor) Close() error {
+	return nil
+}
+
+type pgAvailableExtension struct {
+	name             string
+	defaultVersion   string
+	installedVersion string // blank if not loaded
+	comment          string
+}
@@ -6,7 +6,9 @@ import (
 	"github.com/mattn/go-sqlite3"
 )
 
-type pgClassModule struct{}
+type pgClassModule struct {
+	classes func() ([]pgClass, error)
+}
 
 func (m *pgClassModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
 	err := c.DeclareVTab(fmt.Sprintf(`
@@ -48,7 +50,7 @@ func (m *pgClassModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VT
 	if err != nil {
 		return nil, err
 	}
-	return &pgClassTable{}, nil
+	return &pgClassTable{classes: m.classes}, nil
 }
 
 func (m *pgClassModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
@@ -57,10 +59,12 @@ func (m *pgClassModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.V
 
 func (m *pgClassModule) DestroyModule() {}
 
-type pgClassTable struct{}
+type pgClassTable struct {
+	classes func() ([]pgClass, error)
+}
 
 func (t *pgClassTable) Open() (sqlite3.VTabCursor, error) {
-	return &pgClassCursor{}, nil
+	return &pgClassCursor{classes: t.classes}, nil
 }
 
 func (t *pgClassTable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
@@ -71,84 +75,90 @@ func (t *pgClassTable) Disconnect() error { return nil }
 func (t *pgClassTable) Destroy() error    { return nil }
 
 type pgClassCursor struct {
-	index int
+	classes func() ([]pgClass, error)
+	rows    []pgClass
+	index   int
 }
 
 func (c *pgClassCursor) Column(sctx *sqlite3.SQLiteContext, col int) error {
 	switch col {
 	case 0:
-		sctx.ResultInt(pgClasses[c.index].oid)
+		sctx.ResultInt(c.rows[c.index].oid)
 	case 1:
-		sctx.ResultText(pgClasses[c.index].relname)
+		sctx.ResultText(c.rows[c.index].relname)
 	case 2:
-		sctx.ResultInt(pgClasses[c.index].relnamespace)
+		sctx.ResultInt(c.rows[c.index].relnamespace)
 	case 3:
-		sctx.ResultInt(pgClasses[c.index].reltype)
+		sctx.ResultInt(c.rows[c.index].reltype)
 	case 4:
-		sctx.ResultInt(pgClasses[c.index].reloftype)
+		sctx.ResultInt(c.rows[c.index].reloftype)
 	case 5:
-		sctx.ResultInt(pgClasses[c.index].relowner)
+		sctx.ResultInt(c.rows[c.index].relowner)
 	case 6:
-		sctx.ResultInt(pgClasses[c.index].relam)
+		sctx.ResultInt(c.rows[c.index].relam)
 	case 7:
-		sctx.ResultInt(pgClasses[c.index].relfilenode)
+		sctx.ResultInt(c.rows[c.index].relfilenode)
 	case 8:
-		sctx.ResultInt(pgClasses[c.index].reltablespace)
+		sctx.ResultInt(c.rows[c.index].reltablespace)
 	case 9:
-		sctx.ResultInt(pgClasses[c.index].relpages)
+		sctx.ResultInt(c.rows[c.index].relpages)
 	case 10:
-		sctx.ResultDouble(pgClasses[c.index].reltuples)
+		sctx.ResultDouble(c.rows[c.index].reltuples)
 	case 11:
-		sctx.ResultInt(pgClasses[c.index].relallvisible)
+		sctx.ResultInt(c.rows[c.index].relallvisible)
 	case 12:
-		sctx.ResultInt(pgClasses[c.index].reltoastrelid)
+		sctx.ResultInt(c.rows[c.index].reltoastrelid)
 	case 13:
-		sctx.ResultInt(pgClasses[c.index].relhasindex)
+		sctx.ResultInt(c.rows[c.index].relhasindex)
 	case 14:
-		sctx.ResultInt(pgClasses[c.index].relisshared)
+		sctx.ResultInt(c.rows[c.index].relisshared)
 	case 15:
-		sctx.ResultText(pgClasses[c.index].relpersistence)
+		sctx.ResultText(c.rows[c.index].relpersistence)
 	case 16:
-		sctx.ResultText(pgClasses[c.index].relkind)
+		sctx.ResultText(c.rows[c.index].relkind)
 	case 17:
-		sctx.ResultInt(pgClasses[c.index].relnatts)
+		sctx.ResultInt(c.rows[c.index].relnatts)
 	case 18:
-		sctx.ResultInt(pgClasses[c.index].relchecks)
+		sctx.ResultInt(c.rows[c.index].relchecks)
 	case 19:
-		sctx.ResultInt(pgClasses[c.index].relhasrules)
+		sctx.ResultInt(c.rows[c.index].relhasrules)
 	case 20:
-		sctx.ResultInt(pgClasses[c.index].relhastriggers)
+		sctx.ResultInt(c.rows[c.index].relhastriggers)
 	case 21:
-		sctx.ResultInt(pgClasses[c.index].relhassubclass)
+		sctx.ResultInt(c.rows[c.index].relhassubclass)
 	case 22:
-		sctx.ResultInt(pgClasses[c.index].relrowsecurity)
+		sctx.ResultInt(c.rows[c.index].relrowsecurity)
 	case 23:
-		sctx.ResultInt(pgClasses[c.index].relforcerowsecurity)
+		sctx.ResultInt(c.rows[c.index].relforcerowsecurity)
 	case 24:
-		sctx.ResultInt(pgClasses[c.index].relispopulated)
+		sctx.ResultInt(c.rows[c.index].relispopulated)
 	case 25:
-		sctx.ResultText(pgClasses[c.index].relreplident)
+		sctx.ResultText(c.rows[c.index].relreplident)
 	case 26:
-		sctx.ResultInt(pgClasses[c.index].relispartition)
+		sctx.ResultInt(c.rows[c.index].relispartition)
 	case 27:
-		sctx.ResultInt(pgClasses[c.index].relrewrite)
+		sctx.ResultInt(c.rows[c.index].relrewrite)
 	case 28:
-		sctx.ResultInt(pgClasses[c.index].relfrozenxid)
+		sctx.ResultInt(c.rows[c.index].relfrozenxid)
 	case 29:
-		sctx.ResultInt(pgClasses[c.index].relminmxid)
+		sctx.ResultInt(c.rows[c.index].relminmxid)
 	case 30:
-		sctx.ResultText(pgClasses[c.index].relacl)
+		resultNullText(sctx, c.rows[c.index].relacl)
 	case 31:
-		sctx.ResultText(pgClasses[c.index].reloptions)
+		resultNullText(sctx, c.rows[c.index].reloptions)
 	case 32:
-		sctx.ResultText(pgClasses[c.index].relpartbound)
+		resultNullText(sctx, c.rows[c.index].relpartbound)
 	}
 	return nil
 }
 
-func (c *pgClassCursor) Filter(idxNum int, idxStr string, vals []interface{}) error {
+func (c *pgClassCursor) Filter(idxNum int, idxStr string, vals []interface{}) (err error) {
 	c.index = 0
-	return nil
+	c.rows = pgClasses
+	if c.classes != nil {
+		c.rows, err = c.classes()
+	}
+	return err
 }
 
 func (c *pgClassCursor) Next() error {
@@ -157,7 +167,7 @@ func (c *pgClassCursor) Next() error {
 }
 
 func (c *pgClassCursor) EOF() bool {
-	return c.index >= len(pgClasses)
+	return c.index >= len(c.rows)
 }
 
 func (c *pgClassCursor) Rowid() (int64, error) {
@@ -6,7 +6,9 @@ import (
 	"github.com/mattn/go-sqlite3"
 )
 
-type pgDatabaseModule struct{}
+type pgDatabaseModule struct {
+	databases func() []pgDatabase
+}
 
 func (m *pgDatabaseModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
 	err := c.DeclareVTab(fmt.Sprintf(`
@@ -29,7 +31,7 @@ func (m *pgDatabaseModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3
 	if err != nil {
 		return nil, err
 	}
-	return &pgDatabaseTable{}, nil
+	return &pgDatabaseTable{databases: m.databases}, nil
 }
 
 func (m *pgDatabaseModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
@@ -38,10 +40,12 @@ func (m *pgDatabaseModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite
 
 func (m *pgDatabaseModule) DestroyModule() {}
 
-type pgDatabaseTable struct{}
+type pgDatabaseTable struct {
+	databases func() []pgDatabase
+}
 
 func (t *pgDatabaseTable) Open() (sqlite3.VTabCursor, error) {
-	return &pgDatabaseCursor{}, nil
+	return &pgDatabaseCursor{databases: t.databases}, nil
 }
 
 func (t *pgDatabaseTable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
@@ -52,45 +56,51 @@ func (t *pgDatabaseTable) Disconnect() error { return nil }
 func (t *pgDatabaseTable) Destroy() error    { return nil }
 
 type pgDatabaseCursor struct {
-	index int
+	databases func() []pgDatabase
+	rows      []pgDatabase
+	index     int
 }
 
 func (c *pgDatabaseCursor) Column(sctx *sqlite3.SQLiteContext, col int) error {
 	switch col {
 	case 0:
-		sctx.ResultInt(pgDatabases[c.index].oid)
+		sctx.ResultInt(c.rows[c.index].oid)
 	case 1:
-		sctx.ResultText(pgDatabases[c.index].datname)
+		sctx.ResultText(c.rows[c.index].datname)
 	case 2:
-		sctx.ResultInt(pgDatabases[c.index].datdba)
+		sctx.ResultInt(c.rows[c.index].datdba)
 	case 3:
-		sctx.ResultInt(pgDatabases[c.index].encoding)
+		sctx.ResultInt(c.rows[c.index].encoding)
 	case 4:
-		sctx.ResultText(pgDatabases[c.index].datcollate)
+		sctx.ResultText(c.rows[c.index].datcollate)
 	case 5:
-		sctx.ResultText(pgDatabases[c.index].datctype)
+		sctx.ResultText(c.rows[c.index].datctype)
 	case 6:
-		sctx.ResultInt(pgDatabases[c.index].datistemplate)
+		sctx.ResultInt(c.rows[c.index].datistemplate)
 	case 7:
-		sctx.ResultInt(pgDatabases[c.index].datallowconn)
+		sctx.ResultInt(c.rows[c.index].datallowconn)
 	case 8:
-		sctx.ResultInt(pgDatabases[c.index].datconnlimit)
+		sctx.ResultInt(c.rows[c.index].datconnlimit)
 	case 9:
-		sctx.ResultInt(pgDatabases[c.index].datlastsysoid)
+		sctx.ResultInt(c.rows[c.index].datlastsysoid)
 	case 10:
-		sctx.ResultInt(pgDatabases[c.index].datfrozenxid)
+		sctx.ResultInt(c.rows[c.index].datfrozenxid)
 	case 11:
-		sctx.ResultInt(pgDatabases[c.index].datminmxid)
+		sctx.ResultInt(c.rows[c.index].datminmxid)
 	case 12:
-		sctx.ResultInt(pgDatabases[c.index].dattablespace)
+		sctx.ResultInt(c.rows[c.index].dattablespace)
 	case 13:
-		sctx.ResultText(pgDatabases[c.index].datacl)
+		sctx.ResultText(c.rows[c.index].datacl)
 	}
 	return nil
 }
 
 func (c *pgDatabaseCursor) Filter(idxNum int, idxStr string, vals []interface{}) error {
 	c.index = 0
+	c.rows = pgDatabases
+	if c.databases != nil {
+		c.rows = c.databases()
+	}
 	return nil
 }
 
@@ -100,7 +110,7 @@ func (c *pgDatabaseCursor) Next() error {
 }
 
 func (c *pgDatabaseCursor) EOF() bool {
-	return c.index >= len(pgDatabases)
+	return c.index >= len(c.rows)
 }
 
 func (c *pgDatabaseCursor) Rowid() (int64, error) {
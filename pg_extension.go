@@ -0,0 +1,116 @@
+package postlite
+
+import (
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type pgExtensionModule struct {
+	extensions func() []pgExtension
+	destroy    func()
+}
+
+func (m *pgExtensionModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	err := c.DeclareVTab(fmt.Sprintf(`
+		CREATE TABLE %s (
+			oid            INTEGER,
+			extname        TEXT,
+			extowner       INTEGER,
+			extnamespace   INTEGER,
+			extrelocatable INTEGER,
+			extversion     TEXT,
+			extconfig      TEXT,
+			extcondition   TEXT
+		)`, args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return &pgExtensionTable{extensions: m.extensions}, nil
+}
+
+func (m *pgExtensionModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Create(c, args)
+}
+
+// DestroyModule is called once the connection is closed.
+func (m *pgExtensionModule) DestroyModule() {
+	if m.destroy != nil {
+		m.destroy()
+	}
+}
+
+type pgExtensionTable struct {
+	extensions func() []pgExtension
+}
+
+func (t *pgExtensionTable) Open() (sqlite3.VTabCursor, error) {
+	return &pgExtensionCursor{extensions: t.extensions}, nil
+}
+
+func (t *pgExtensionTable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	return &sqlite3.IndexResult{Used: make([]bool, len(cst))}, nil
+}
+
+func (t *pgExtensionTable) Disconnect() error { return nil }
+func (t *pgExtensionTable) Destroy() error    { return nil }
+
+type pgExtensionCursor struct {
+	extensions func() []pgExtension
+	rows       []pgExtension
+	index      int
+}
+
+func (c *pgExtensionCursor) Column(sctx *sqlite3.SQLiteContext, col int) error {
+	switch col {
+	case 0:
+		sctx.ResultInt(c.rows[c.index].oid)
+	case 1:
+		sctx.ResultText(c.rows[c.index].extname)
+	case 2:
+		sctx.ResultInt(c.rows[c.index].extowner)
+	case 3:
+		sctx.ResultInt(c.rows[c.index].extnamespace)
+	case 4:
+		sctx.ResultInt(c.rows[c.index].extrelocatable)
+	case 5:
+		sctx.ResultText(c.rows[c.index].extversion)
+	case 6:
+		sctx.ResultNull()
+	case 7:
+		sctx.ResultNull()
+	}
+	return nil
+}
+
+func (c *pgExtensionCursor) Filter(idxNum int, idxStr string, vals []interface{}) error {
+	c.index = 0
+	c.rows = c.extensions()
+	return nil
+}
+
+func (c *pgExtensionCursor) Next() error {
+	c.index++
+	return nil
+}
+
+func (c *pgExtensionCursor) EOF() bool {
+	return c.index >= len(c.rows)
+}
+
+func (c *pgExtensionCursor) Rowid() (int64, error) {
+	return int64(c.index), nil
+}
+
+func (c *pgExtensionCursor) Close() error {
+	return nil
+}
+
+type pgExtension struct {
+	oid            int
+	extname        string
+	extowner       int
+	extnamespace   int
+	extrelocatable int
+	extversion     string
+}
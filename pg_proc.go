@@ -0,0 +1,156 @@
+package postlite
+
+import (
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type pgProcModule struct {
+	procs func() ([]pgProc, error)
+}
+
+func (m *pgProcModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	err := c.DeclareVTab(fmt.Sprintf(`
+		CREATE TABLE %s (
+			oid             INTEGER,
+			proname         TEXT,
+			pronamespace    INTEGER,
+			proowner        INTEGER,
+			prolang         INTEGER,
+			procost         REAL,
+			prorows         REAL,
+			provariadic     INTEGER,
+			prosupport      TEXT,
+			prokind         TEXT,
+			prosecdef       INTEGER,
+			proleakproof    INTEGER,
+			proisstrict     INTEGER,
+			proretset       INTEGER,
+			provolatile     TEXT,
+			proparallel     TEXT,
+			pronargs        INTEGER,
+			pronargdefaults INTEGER,
+			prorettype      INTEGER,
+			proargtypes     TEXT,
+			proallargtypes  TEXT,
+			proargmodes     TEXT,
+			proargnames     TEXT,
+			proargdefaults  TEXT,
+			protrftypes     TEXT,
+			prosrc          TEXT,
+			probin          TEXT,
+			proconfig       TEXT,
+			proacl          TEXT
+		)`, args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return &pgProcTable{procs: m.procs}, nil
+}
+
+func (m *pgProcModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Create(c, args)
+}
+
+func (m *pgProcModule) DestroyModule() {}
+
+type pgProcTable struct {
+	procs func() ([]pgProc, error)
+}
+
+func (t *pgProcTable) Open() (sqlite3.VTabCursor, error) {
+	return &pgProcCursor{procs: t.procs}, nil
+}
+
+func (t *pgProcTable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	return &sqlite3.IndexResult{Used: make([]bool, len(cst))}, nil
+}
+
+func (t *pgProcTable) Disconnect() error { return nil }
+func (t *pgProcTable) Destroy() error    { return nil }
+
+type pgProcCursor struct {
+	procs func() ([]pgProc, error)
+	rows  []pgProc
+	index int
+}
+
+func (c *pgProcCursor) Column(sctx *sqlite3.SQLiteContext, col int) error {
+	switch col {
+	case 0:
+		sctx.ResultInt(c.rows[c.index].oid)
+	case 1:
+		sctx.ResultText(c.rows[c.index].proname)
+	case 2:
+		sctx.ResultInt(c.rows[c.index].pronamespace)
+	case 3:
+		sctx.ResultInt(10) // proowner
+	case 4:
+		sctx.ResultInt(12) // prolang: internal
+	case 5:
+		sctx.ResultDouble(1) // procost
+	case 6:
+		sctx.ResultDouble(0) // prorows
+	case 7:
+		sctx.ResultInt(c.rows[c.index].provariadic)
+	case 8:
+		sctx.ResultText("-") // prosupport
+	case 9:
+		sctx.ResultText(c.rows[c.index].prokind)
+	case 10, 11, 12, 13:
+		sctx.ResultInt(0) // prosecdef, proleakproof, proisstrict, proretset
+	case 14:
+		sctx.ResultText(c.rows[c.index].provolatile)
+	case 15:
+		sctx.ResultText("s") // proparallel: safe
+	case 16:
+		sctx.ResultInt(c.rows[c.index].pronargs)
+	case 17:
+		sctx.ResultInt(0) // pronargdefaults
+	case 18:
+		sctx.ResultInt(c.rows[c.index].prorettype)
+	case 19:
+		sctx.ResultText(c.rows[c.index].proargtypes)
+	case 25:
+		sctx.ResultText(c.rows[c.index].proname) // prosrc
+	default:
+		sctx.ResultNull()
+	}
+	return nil
+}
+
+func (c *pgProcCursor) Filter(idxNum int, idxStr string, vals []interface{}) (err error) {
+	c.index = 0
+	c.rows, err = c.procs()
+	return err
+}
+
+func (c *pgProcCursor) Next() error {
+	c.index++
+	return nil
+}
+
+func (c *pgProcCursor) EOF() bool {
+	return c.index >= len(c.rows)
+}
+
+func (c *pgProcCursor) Rowid() (int64, error) {
+	return int64(c.index), nil
+}
+
+func (c *pgProcCursor) Close() error {
+	return nil
+}
+
+type pgProc struct {
+	oid          int
+	proname      string
+	pronamespace int
+	provariadic  int
+	prokind      string
+	provolatile  string
+	pronargs     int
+	prorettype   int
+	proargtypes  string
+}
@@ -0,0 +1,57 @@
+package postlite_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure pg_proc lists SQLite's built-in functions, the compatibility
+// functions & custom functions with their kind, volatility & arguments.
+func TestServer_PgProc(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.Functions = []*postlite.Function{
+			{Name: "shout", Impl: func(s string) string { return strings.ToUpper(s) }, Pure: true},
+			{Name: "now_ms", Impl: func() int64 { return 0 }},
+		}
+		s.Aggregates = []*postlite.Aggregate{
+			{Name: "longest", Impl: func() *longest { return &longest{} }, Pure: true},
+		}
+	})
+	c := MustConnect(t, s, "db", "alice", "")
+
+	const query = `
+		SELECT n.nspname, p.proname, p.prokind, p.provolatile, p.pronargs, p.provariadic, coalesce(p.proargtypes, '')
+		FROM pg_catalog.pg_proc p
+		JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+		WHERE p.proname IN ('lower', 'count', 'row_number', 'printf', 'format_type', 'shout', 'now_ms', 'longest', 'random')
+		ORDER BY p.proname, p.pronargs`
+	want := [][]string{
+		{"pg_catalog", "count", "a", "v", "0", "0", ""},
+		{"pg_catalog", "count", "a", "v", "1", "0", "25"},
+		{"pg_catalog", "format_type", "f", "i", "2", "0", "25 25"},
+		{"public", "longest", "a", "i", "1", "0", "25"},
+		{"pg_catalog", "lower", "f", "i", "1", "0", "25"},
+		{"public", "now_ms", "f", "v", "0", "0", ""},
+		{"pg_catalog", "printf", "f", "i", "1", "25", "25"},
+		{"pg_catalog", "random", "f", "v", "0", "0", ""},
+		{"pg_catalog", "row_number", "w", "v", "0", "0", ""},
+		{"public", "shout", "f", "i", "1", "0", "25"},
+	}
+	if rows := c.MustQuery(query); !reflect.DeepEqual(rows, want) {
+		t.Fatalf("rows=%v\nwant=%v", rows, want)
+	} else if rows := c.MustQueryExtended(query); !reflect.DeepEqual(rows, want) {
+		t.Fatalf("extended: rows=%v\nwant=%v", rows, want)
+	}
+
+	// Object IDs are stable across sessions & unique.
+	if rows := c.MustQuery(`SELECT count(*), count(DISTINCT oid) FROM pg_proc`); rows[0][0] != rows[0][1] {
+		t.Fatalf("duplicate oids: %v", rows)
+	}
+	oid := c.MustQuery(`SELECT oid FROM pg_proc WHERE proname = 'shout'`)
+	if rows := MustConnect(t, s, "db", "bob", "").MustQuery(`SELECT oid FROM pg_proc WHERE proname = 'shout'`); !reflect.DeepEqual(rows, oid) {
+		t.Fatalf("oid=%v, want %v", rows, oid)
+	}
+}
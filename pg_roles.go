@@ -0,0 +1,135 @@
+package postlite
+
+import (
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type pgRolesModule struct {
+	roles func() ([]pgRole, error)
+}
+
+func (m *pgRolesModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	err := c.DeclareVTab(fmt.Sprintf(`
+		CREATE TABLE %s (
+			rolname        TEXT,
+			rolsuper       INTEGER,
+			rolinherit     INTEGER,
+			rolcreaterole  INTEGER,
+			rolcreatedb    INTEGER,
+			rolcanlogin    INTEGER,
+			rolreplication INTEGER,
+			rolconnlimit   INTEGER,
+			rolpassword    TEXT,
+			rolvaliduntil  TEXT,
+			rolbypassrls   INTEGER,
+			rolconfig      TEXT,
+			oid            INTEGER
+		)`, args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return &pgRolesTable{roles: m.roles}, nil
+}
+
+func (m *pgRolesModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Create(c, args)
+}
+
+func (m *pgRolesModule) DestroyModule() {}
+
+type pgRolesTable struct {
+	roles func() ([]pgRole, error)
+}
+
+func (t *pgRolesTable) Open() (sqlite3.VTabCursor, error) {
+	return &pgRolesCursor{roles: t.roles}, nil
+}
+
+func (t *pgRolesTable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	return &sqlite3.IndexResult{Used: make([]bool, len(cst))}, nil
+}
+
+func (t *pgRolesTable) Disconnect() error { return nil }
+func (t *pgRolesTable) Destroy() error    { return nil }
+
+type pgRolesCursor struct {
+	roles func() ([]pgRole, error)
+	rows  []pgRole
+	index int
+}
+
+func (c *pgRolesCursor) Column(sctx *sqlite3.SQLiteContext, col int) error {
+	switch col {
+	case 0:
+		sctx.ResultText(c.rows[c.index].rolname)
+	case 1:
+		sctx.ResultInt(c.rows[c.index].rolsuper)
+	case 2:
+		sctx.ResultInt(c.rows[c.index].rolinherit)
+	case 3:
+		sctx.ResultInt(c.rows[c.index].rolcreaterole)
+	case 4:
+		sctx.ResultInt(c.rows[c.index].rolcreatedb)
+	case 5:
+		sctx.ResultInt(c.rows[c.index].rolcanlogin)
+	case 6:
+		sctx.ResultInt(c.rows[c.index].rolreplication)
+	case 7:
+		sctx.ResultInt(c.rows[c.index].rolconnlimit)
+	case 8:
+		if c.rows[c.index].rolpassword != "" {
+			sctx.ResultText("********")
+		} else {
+			sctx.ResultNull()
+		}
+	case 9:
+		resultNullText(sctx, c.rows[c.index].rolvaliduntil)
+	case 10:
+		sctx.ResultInt(c.rows[c.index].rolbypassrls)
+	case 11:
+		sctx.ResultNull()
+	case 12:
+		sctx.ResultInt(c.rows[c.index].oid)
+	}
+	return nil
+}
+
+func (c *pgRolesCursor) Filter(idxNum int, idxStr string, vals []interface{}) (err error) {
+	c.index = 0
+	c.rows, err = c.roles()
+	return err
+}
+
+func (c *pgRolesCursor) Next() error {
+	c.index++
+	return nil
+}
+
+func (c *pgRolesCursor) EOF() bool {
+	return c.index >= len(c.rows)
+}
+
+func (c *pgRolesCursor) Rowid() (int64, error) {
+	return int64(c.index), nil
+}
+
+func (c *pgRolesCursor) Close() error {
+	return nil
+}
+
+type pgRole struct {
+	oid            int
+	rolname        string
+	rolsuper       int
+	rolinherit     int
+	rolcreaterole  int
+	rolcreatedb    int
+	rolcanlogin    int
+	rolreplication int
+	rolbypassrls   int
+	rolconnlimit   int
+	rolpassword    string // MD5 hash, blank if none
+	rolvaliduntil  string
+}
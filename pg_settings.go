@@ -1,7 +1,10 @@
 package postlite
 
 import (
+	"database/sql/driver"
 	"fmt"
+	"io"
+	"strings"
 
 	"github.com/mattn/go-sqlite3"
 )
@@ -32,7 +35,7 @@ func (m *pgSettingsModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3
 	if err != nil {
 		return nil, err
 	}
-	return &pgSettingsTable{}, nil
+	return &pgSettingsTable{conn: c}, nil
 }
 
 func (m *pgSettingsModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
@@ -41,10 +44,12 @@ func (m *pgSettingsModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite
 
 func (m *pgSettingsModule) DestroyModule() {}
 
-type pgSettingsTable struct{}
+type pgSettingsTable struct {
+	conn *sqlite3.SQLiteConn
+}
 
 func (t *pgSettingsTable) Open() (sqlite3.VTabCursor, error) {
-	return &pgSettingsCursor{}, nil
+	return &pgSettingsCursor{conn: t.conn}, nil
 }
 
 func (t *pgSettingsTable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
@@ -55,52 +60,55 @@ func (t *pgSettingsTable) Disconnect() error { return nil }
 func (t *pgSettingsTable) Destroy() error    { return nil }
 
 type pgSettingsCursor struct {
+	conn  *sqlite3.SQLiteConn
+	rows  []pgSetting
 	index int
 }
 
 func (c *pgSettingsCursor) Column(sctx *sqlite3.SQLiteContext, col int) error {
 	switch col {
 	case 0:
-		sctx.ResultText(pgSettings[c.index].name)
+		sctx.ResultText(c.rows[c.index].name)
 	case 1:
-		sctx.ResultText(pgSettings[c.index].setting)
+		sctx.ResultText(c.rows[c.index].setting)
 	case 2:
-		sctx.ResultText(pgSettings[c.index].unit)
+		sctx.ResultText(c.rows[c.index].unit)
 	case 3:
-		sctx.ResultText(pgSettings[c.index].category)
+		sctx.ResultText(c.rows[c.index].category)
 	case 4:
-		sctx.ResultText(pgSettings[c.index].short_desc)
+		sctx.ResultText(c.rows[c.index].short_desc)
 	case 5:
-		sctx.ResultText(pgSettings[c.index].extra_desc)
+		sctx.ResultText(c.rows[c.index].extra_desc)
 	case 6:
-		sctx.ResultText(pgSettings[c.index].context)
+		sctx.ResultText(c.rows[c.index].context)
 	case 7:
-		sctx.ResultText(pgSettings[c.index].vartype)
+		sctx.ResultText(c.rows[c.index].vartype)
 	case 8:
-		sctx.ResultText(pgSettings[c.index].source)
+		sctx.ResultText(c.rows[c.index].source)
 	case 9:
-		sctx.ResultText(pgSettings[c.index].min_val)
+		sctx.ResultText(c.rows[c.index].min_val)
 	case 10:
-		sctx.ResultText(pgSettings[c.index].max_val)
+		sctx.ResultText(c.rows[c.index].max_val)
 	case 11:
-		sctx.ResultText(pgSettings[c.index].enumvals)
+		sctx.ResultText(c.rows[c.index].enumvals)
 	case 12:
-		sctx.ResultText(pgSettings[c.index].boot_val)
+		sctx.ResultText(c.rows[c.index].boot_val)
 	case 13:
-		sctx.ResultText(pgSettings[c.index].reset_val)
+		sctx.ResultText(c.rows[c.index].reset_val)
 	case 14:
-		sctx.ResultText(pgSettings[c.index].sourcefile)
+		sctx.ResultText(c.rows[c.index].sourcefile)
 	case 15:
-		sctx.ResultInt(pgSettings[c.index].sourceline)
+		sctx.ResultInt(c.rows[c.index].sourceline)
 	case 16:
-		sctx.ResultInt(pgSettings[c.index].pending_restart)
+		sctx.ResultInt(c.rows[c.index].pending_restart)
 	}
 	return nil
 }
 
-func (c *pgSettingsCursor) Filter(idxNum int, idxStr string, vals []interface{}) error {
+func (c *pgSettingsCursor) Filter(idxNum int, idxStr string, vals []interface{}) (err error) {
 	c.index = 0
-	return nil
+	c.rows, err = connSettings(c.conn)
+	return err
 }
 
 func (c *pgSettingsCursor) Next() error {
@@ -109,7 +117,7 @@ func (c *pgSettingsCursor) Next() error {
 }
 
 func (c *pgSettingsCursor) EOF() bool {
-	return c.index >= len(pgSettings)
+	return c.index >= len(c.rows)
 }
 
 func (c *pgSettingsCursor) Rowid() (int64, error) {
@@ -141,3 +149,103 @@ type pgSetting struct {
 }
 
 var pgSettings = []pgSetting{}
+
+// connSettings returns the static settings plus settings derived from the
+// current state of the SQLite connection.
+func connSettings(conn *sqlite3.SQLiteConn) ([]pgSetting, error) {
+	queryOnly, err := queryPragma(conn, "query_only")
+	if err != nil {
+		return nil, err
+	}
+	readOnly := formatBool(queryOnly == "1")
+
+	settings := make([]pgSetting, len(pgSettings), len(pgSettings)+2+len(pragmaSettings))
+	copy(settings, pgSettings)
+	settings = append(settings,
+		pgSetting{
+			name:       "default_transaction_read_only",
+			setting:    readOnly,
+			category:   "Client Connection Defaults / Statement Behavior",
+			short_desc: "Sets the default read-only status of new transactions.",
+			context:    "user",
+			vartype:    "bool",
+			source:     "override",
+			boot_val:   "off",
+			reset_val:  readOnly,
+		},
+		pgSetting{
+			name:       "transaction_read_only",
+			setting:    readOnly,
+			category:   "Client Connection Defaults / Statement Behavior",
+			short_desc: "Sets the current transaction's read-only status.",
+			context:    "user",
+			vartype:    "bool",
+			source:     "override",
+			boot_val:   "off",
+			reset_val:  readOnly,
+		},
+	)
+
+	// Report SQLite PRAGMAs as settings.
+	for _, ps := range pragmaSettings {
+		value, err := queryPragma(conn, ps.name)
+		if err != nil {
+			return nil, err
+		}
+		if ps.format != nil {
+			value = ps.format(value)
+		}
+
+		settings = append(settings, pgSetting{
+			name:       ps.name,
+			setting:    value,
+			category:   "SQLite",
+			short_desc: ps.desc,
+			context:    "sighup",
+			vartype:    "string",
+			source:     "configuration file",
+			reset_val:  value,
+		})
+	}
+
+	return settings, nil
+}
+
+// showSetting returns the value of the named setting for the connection.
+// Returns a blank string if the setting does not exist.
+func showSetting(conn *sqlite3.SQLiteConn, name string) (string, error) {
+	settings, err := connSettings(conn)
+	if err != nil {
+		return "", err
+	}
+	for _, setting := range settings {
+		if strings.EqualFold(setting.name, name) {
+			return setting.setting, nil
+		}
+	}
+	return "", nil
+}
+
+// queryPragma returns the value of a PRAGMA on the connection as a string.
+func queryPragma(conn *sqlite3.SQLiteConn, name string) (string, error) {
+	rows, err := conn.Query("PRAGMA "+name, nil)
+	if err != nil {
+		return "", fmt.Errorf("pragma %s: %w", name, err)
+	}
+	defer rows.Close()
+
+	dest := make([]driver.Value, len(rows.Columns()))
+	if err := rows.Next(dest); err == io.EOF {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("pragma %s: %w", name, err)
+	}
+	return fmt.Sprint(dest[0]), nil
+}
+
+func formatBool(v bool) string {
+	if v {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,153 @@
+package postlite
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type pgStatActivityModule struct {
+	activity func() ([]Activity, error)
+}
+
+func (m *pgStatActivityModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	err := c.DeclareVTab(fmt.Sprintf(`
+		CREATE TABLE %s (
+			datid            INTEGER,
+			datname          TEXT,
+			pid              INTEGER,
+			usesysid         INTEGER,
+			usename          TEXT,
+			application_name TEXT,
+			client_addr      TEXT,
+			client_hostname  TEXT,
+			client_port      INTEGER,
+			backend_start    TEXT,
+			xact_start       TEXT,
+			query_start      TEXT,
+			state_change     TEXT,
+			wait_event_type  TEXT,
+			wait_event       TEXT,
+			state            TEXT,
+			backend_xid      INTEGER,
+			backend_xmin     INTEGER,
+			query            TEXT,
+			backend_type     TEXT
+		)`, args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return &pgStatActivityTable{activity: m.activity}, nil
+}
+
+func (m *pgStatActivityModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Create(c, args)
+}
+
+func (m *pgStatActivityModule) DestroyModule() {}
+
+type pgStatActivityTable struct {
+	activity func() ([]Activity, error)
+}
+
+func (t *pgStatActivityTable) Open() (sqlite3.VTabCursor, error) {
+	return &pgStatActivityCursor{activity: t.activity}, nil
+}
+
+func (t *pgStatActivityTable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	return &sqlite3.IndexResult{Used: make([]bool, len(cst))}, nil
+}
+
+func (t *pgStatActivityTable) Disconnect() error { return nil }
+func (t *pgStatActivityTable) Destroy() error    { return nil }
+
+type pgStatActivityCursor struct {
+	activity func() ([]Activity, error)
+	rows     []Activity
+	index    int
+}
+
+func (c *pgStatActivityCursor) Column(sctx *sqlite3.SQLiteContext, col int) error {
+	row := &c.rows[c.index]
+	switch col {
+	case 0:
+		sctx.ResultInt(databaseOID)
+	case 1:
+		sctx.ResultText(row.Database)
+	case 2:
+		sctx.ResultInt(int(row.PID))
+	case 3:
+		sctx.ResultNull()
+	case 4:
+		sctx.ResultText(row.User)
+	case 5:
+		sctx.ResultText(row.ApplicationName)
+	case 6:
+		resultNullText(sctx, row.ClientAddr)
+	case 7:
+		sctx.ResultNull()
+	case 8:
+		sctx.ResultInt(row.ClientPort)
+	case 9:
+		resultTimestamp(sctx, row.BackendStart)
+	case 10:
+		resultTimestamp(sctx, row.XactStart)
+	case 11:
+		resultTimestamp(sctx, row.QueryStart)
+	case 12:
+		resultTimestamp(sctx, row.StateChange)
+	case 13, 14:
+		sctx.ResultNull()
+	case 15:
+		sctx.ResultText(row.State)
+	case 16, 17:
+		sctx.ResultNull()
+	case 18:
+		sctx.ResultText(row.Query)
+	case 19:
+		sctx.ResultText("client backend")
+	}
+	return nil
+}
+
+func (c *pgStatActivityCursor) Filter(idxNum int, idxStr string, vals []interface{}) (err error) {
+	c.index = 0
+	c.rows, err = c.activity()
+	return err
+}
+
+func (c *pgStatActivityCursor) Next() error {
+	c.index++
+	return nil
+}
+
+func (c *pgStatActivityCursor) EOF() bool {
+	return c.index >= len(c.rows)
+}
+
+func (c *pgStatActivityCursor) Rowid() (int64, error) {
+	return int64(c.index), nil
+}
+
+func (c *pgStatActivityCursor) Close() error {
+	return nil
+}
+
+// resultTimestamp returns t formatted as a Postgres timestamptz, or NULL if t is zero.
+func resultTimestamp(sctx *sqlite3.SQLiteContext, t time.Time) {
+	if t.IsZero() {
+		sctx.ResultNull()
+		return
+	}
+	sctx.ResultText(t.Format("2006-01-02 15:04:05.999999-07"))
+}
+
+// resultNullText returns s, or NULL if s is blank.
+func resultNullText(sctx *sqlite3.SQLiteContext, s string) {
+	if s == "" {
+		sctx.ResultNull()
+		return
+	}
+	sctx.ResultText(s)
+}
@@ -0,0 +1,119 @@
+package postlite
+
+import (
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type pgUserModule struct {
+	roles func() ([]pgRole, error)
+}
+
+func (m *pgUserModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	err := c.DeclareVTab(fmt.Sprintf(`
+		CREATE TABLE %s (
+			usename      TEXT,
+			usesysid     INTEGER,
+			usecreatedb  INTEGER,
+			usesuper     INTEGER,
+			userepl      INTEGER,
+			usebypassrls INTEGER,
+			passwd       TEXT,
+			valuntil     TEXT,
+			useconfig    TEXT
+		)`, args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return &pgUserTable{roles: m.roles}, nil
+}
+
+func (m *pgUserModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Create(c, args)
+}
+
+func (m *pgUserModule) DestroyModule() {}
+
+type pgUserTable struct {
+	roles func() ([]pgRole, error)
+}
+
+func (t *pgUserTable) Open() (sqlite3.VTabCursor, error) {
+	return &pgUserCursor{roles: t.roles}, nil
+}
+
+func (t *pgUserTable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	return &sqlite3.IndexResult{Used: make([]bool, len(cst))}, nil
+}
+
+func (t *pgUserTable) Disconnect() error { return nil }
+func (t *pgUserTable) Destroy() error    { return nil }
+
+type pgUserCursor struct {
+	roles func() ([]pgRole, error)
+	rows  []pgRole
+	index int
+}
+
+func (c *pgUserCursor) Column(sctx *sqlite3.SQLiteContext, col int) error {
+	switch col {
+	case 0:
+		sctx.ResultText(c.rows[c.index].rolname)
+	case 1:
+		sctx.ResultInt(c.rows[c.index].oid)
+	case 2:
+		sctx.ResultInt(c.rows[c.index].rolcreatedb)
+	case 3:
+		sctx.ResultInt(c.rows[c.index].rolsuper)
+	case 4:
+		sctx.ResultInt(c.rows[c.index].rolreplication)
+	case 5:
+		sctx.ResultInt(c.rows[c.index].rolbypassrls)
+	case 6:
+		if c.rows[c.index].rolpassword != "" {
+			sctx.ResultText("********")
+		} else {
+			sctx.ResultNull()
+		}
+	case 7:
+		resultNullText(sctx, c.rows[c.index].rolvaliduntil)
+	case 8:
+		sctx.ResultNull()
+	}
+	return nil
+}
+
+func (c *pgUserCursor) Filter(idxNum int, idxStr string, vals []interface{}) error {
+	c.index = 0
+	roles, err := c.roles()
+	if err != nil {
+		return err
+	}
+
+	// Users are roles that can log in.
+	c.rows = c.rows[:0]
+	for _, role := range roles {
+		if role.rolcanlogin != 0 {
+			c.rows = append(c.rows, role)
+		}
+	}
+	return nil
+}
+
+func (c *pgUserCursor) Next() error {
+	c.index++
+	return nil
+}
+
+func (c *pgUserCursor) EOF() bool {
+	return c.index >= len(c.rows)
+}
+
+func (c *pgUserCursor) Rowid() (int64, error) {
+	return int64(c.index), nil
+}
+
+func (c *pgUserCursor) Close() error {
+	return nil
+}
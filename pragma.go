@@ -0,0 +1,166 @@
+package postlite
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Pragmas represents SQLite PRAGMA settings applied to each new connection.
+// Blank fields leave the SQLite default in place.
+type Pragmas struct {
+	JournalMode string // delete, truncate, persist, memory, wal, off
+	Synchronous string // off, normal, full, extra
+	ForeignKeys string // on, off
+	BusyTimeout string // milliseconds
+	CacheSize   string // pages, or KiB if negative
+	MmapSize    string // bytes
+	TempStore   string // default, file, memory
+}
+
+// DefaultPragmas returns the PRAGMA settings applied to every database unless overridden.
+func DefaultPragmas() Pragmas {
+	return Pragmas{
+		JournalMode: DefaultJournalMode,
+		BusyTimeout: strconv.FormatInt(DefaultBusyTimeout.Milliseconds(), 10),
+	}
+}
+
+// Merge returns a copy of p with the non-blank fields of other applied on top.
+func (p Pragmas) Merge(other Pragmas) Pragmas {
+	merge := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	merge(&p.JournalMode, other.JournalMode)
+	merge(&p.Synchronous, other.Synchronous)
+	merge(&p.ForeignKeys, other.ForeignKeys)
+	merge(&p.BusyTimeout, other.BusyTimeout)
+	merge(&p.CacheSize, other.CacheSize)
+	merge(&p.MmapSize, other.MmapSize)
+	merge(&p.TempStore, other.TempStore)
+	return p
+}
+
+// Set sets the value of a PRAGMA by name.
+func (p *Pragmas) Set(name, value string) error {
+	switch strings.ToLower(name) {
+	case "journal_mode":
+		p.JournalMode = value
+	case "synchronous":
+		p.Synchronous = value
+	case "foreign_keys":
+		p.ForeignKeys = value
+	case "busy_timeout":
+		p.BusyTimeout = value
+	case "cache_size":
+		p.CacheSize = value
+	case "mmap_size":
+		p.MmapSize = value
+	case "temp_store":
+		p.TempStore = value
+	default:
+		return fmt.Errorf("unsupported pragma: %q", name)
+	}
+	return nil
+}
+
+// Validate returns an error if any PRAGMA has an invalid value.
+func (p Pragmas) Validate() error {
+	for _, pragma := range p.list() {
+		if pragma.value == "" {
+			continue
+		} else if !pragma.valid(strings.ToLower(pragma.value)) {
+			return fmt.Errorf("invalid %s: %q", pragma.name, pragma.value)
+		}
+	}
+	return nil
+}
+
+// statements returns the PRAGMA statements to execute on a new connection.
+// The journal mode is skipped for read-only databases as it cannot be changed.
+func (p Pragmas) statements(readOnly bool) []string {
+	var a []string
+	for _, pragma := range p.list() {
+		if pragma.value == "" || (readOnly && pragma.name == "journal_mode") {
+			continue
+		}
+		a = append(a, fmt.Sprintf("PRAGMA %s = %s", pragma.name, pragma.value))
+	}
+	return a
+}
+
+func (p Pragmas) list() []pragmaValue {
+	return []pragmaValue{
+		{"busy_timeout", p.BusyTimeout, isInteger},
+		{"journal_mode", p.JournalMode, oneOf("delete", "truncate", "persist", "memory", "wal", "off")},
+		{"synchronous", p.Synchronous, oneOf("off", "normal", "full", "extra", "0", "1", "2", "3")},
+		{"foreign_keys", p.ForeignKeys, oneOf("on", "off", "true", "false", "yes", "no", "1", "0")},
+		{"cache_size", p.CacheSize, isInteger},
+		{"mmap_size", p.MmapSize, isInteger},
+		{"temp_store", p.TempStore, oneOf("default", "file", "memory", "0", "1", "2")},
+	}
+}
+
+type pragmaValue struct {
+	name  string
+	value string
+	valid func(string) bool
+}
+
+func isInteger(s string) bool {
+	_, err := strconv.ParseInt(s, 10, 64)
+	return err == nil
+}
+
+func oneOf(values ...string) func(string) bool {
+	return func(s string) bool {
+		for _, v := range values {
+			if s == v {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// DatabasePragmas applies PRAGMA settings to databases matching a glob.
+type DatabasePragmas struct {
+	Database string // database glob, relative to the data directory
+	Pragmas  Pragmas
+}
+
+// Match returns true if name matches the database glob.
+func (p *DatabasePragmas) Match(name string) bool {
+	ok, _ := path.Match(p.Database, name)
+	return ok
+}
+
+// pragmaSettings lists the PRAGMAs reported through SHOW & pg_settings along
+// with functions to convert their numeric values into keywords.
+var pragmaSettings = []struct {
+	name   string
+	desc   string
+	format func(string) string
+}{
+	{"journal_mode", "SQLite journal mode.", nil},
+	{"synchronous", "SQLite synchronous flag.", enumFormatter("off", "normal", "full", "extra")},
+	{"foreign_keys", "Enforces SQLite foreign key constraints.", enumFormatter("off", "on")},
+	{"busy_timeout", "Time to wait on a locked SQLite database, in milliseconds.", nil},
+	{"cache_size", "SQLite page cache size, in pages or KiB if negative.", nil},
+	{"mmap_size", "Maximum number of bytes of the SQLite database to memory map.", nil},
+	{"temp_store", "SQLite temporary table storage.", enumFormatter("default", "file", "memory")},
+}
+
+// enumFormatter returns a function that converts a numeric PRAGMA value into
+// its keyword. Values out of range are returned as-is.
+func enumFormatter(keywords ...string) func(string) string {
+	return func(s string) string {
+		if i, err := strconv.Atoi(s); err == nil && i >= 0 && i < len(keywords) {
+			return keywords[i]
+		}
+		return s
+	}
+}
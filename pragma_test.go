@@ -0,0 +1,137 @@
+package postlite_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+)
+
+func TestPragmas_Validate(t *testing.T) {
+	for _, tt := range []struct {
+		name, value string
+		err         string
+	}{
+		{"journal_mode", "WAL", ""},
+		{"journal_mode", "off", ""},
+		{"journal_mode", "wal2", `invalid journal_mode: "wal2"`},
+		{"synchronous", "NORMAL", ""},
+		{"synchronous", "2", ""},
+		{"synchronous", "4", `invalid synchronous: "4"`},
+		{"foreign_keys", "yes", ""},
+		{"foreign_keys", "maybe", `invalid foreign_keys: "maybe"`},
+		{"busy_timeout", "5000", ""},
+		{"busy_timeout", "5s", `invalid busy_timeout: "5s"`},
+		{"cache_size", "-2000", ""},
+		{"cache_size", "2MB", `invalid cache_size: "2MB"`},
+		{"mmap_size", "268435456", ""},
+		{"temp_store", "memory", ""},
+		{"temp_store", "disk", `invalid temp_store: "disk"`},
+	} {
+		t.Run(tt.name+"="+tt.value, func(t *testing.T) {
+			var p postlite.Pragmas
+			if err := p.Set(tt.name, tt.value); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Validate(); tt.err == "" && err != nil {
+				t.Fatal(err)
+			} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Fatalf("err=%v, want %s", err, tt.err)
+			}
+		})
+	}
+
+	t.Run("Unsupported", func(t *testing.T) {
+		var p postlite.Pragmas
+		if err := p.Set("query_only", "on"); err == nil || err.Error() != `unsupported pragma: "query_only"` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestPragmas_Merge(t *testing.T) {
+	p := postlite.Pragmas{JournalMode: "wal", Synchronous: "full", BusyTimeout: "5000"}
+	other := postlite.Pragmas{Synchronous: "normal", CacheSize: "-64000"}
+	if got, want := p.Merge(other), (postlite.Pragmas{JournalMode: "wal", Synchronous: "normal", BusyTimeout: "5000", CacheSize: "-64000"}); got != want {
+		t.Fatalf("Merge()=%+v, want %+v", got, want)
+	} else if p.Synchronous != "full" {
+		t.Fatal("expected receiver to be unchanged")
+	}
+}
+
+// Ensure invalid settings are rejected when the manager is opened.
+func TestDBManager_Open_InvalidPragmas(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		fn   func(m *postlite.DBManager)
+		err  string
+	}{
+		{"Global", func(m *postlite.DBManager) { m.Pragmas.Synchronous = "sometimes" }, `invalid synchronous: "sometimes"`},
+		{"Database", func(m *postlite.DBManager) {
+			m.DatabasePragmas = []*postlite.DatabasePragmas{{Database: "logs*", Pragmas: postlite.Pragmas{TempStore: "disk"}}}
+		}, `logs*: invalid temp_store: "disk"`},
+		{"Pattern", func(m *postlite.DBManager) {
+			m.DatabasePragmas = []*postlite.DatabasePragmas{{Database: "logs[", Pragmas: postlite.Pragmas{Synchronous: "off"}}}
+		}, `invalid database pattern "logs["`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := postlite.NewDBManager()
+			tt.fn(m)
+			if err := m.Open(); err == nil || err.Error() != tt.err {
+				t.Fatalf("err=%v, want %s", err, tt.err)
+			}
+			m.Close()
+		})
+	}
+}
+
+// Ensure databases get the global pragmas with the settings of every
+// matching database glob applied on top, in order.
+func TestServer_DatabasePragmas(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.DBs.Pragmas.Synchronous = "full"
+		s.DBs.Pragmas.CacheSize = "-1000"
+		s.DBs.DatabasePragmas = []*postlite.DatabasePragmas{
+			{Database: "logs*", Pragmas: postlite.Pragmas{JournalMode: "delete", Synchronous: "off"}},
+			{Database: "logs_archive", Pragmas: postlite.Pragmas{Synchronous: "normal"}},
+		}
+	})
+
+	for _, tt := range []struct {
+		db                                               string
+		journalMode, synchronous, cacheSize, busyTimeout string
+	}{
+		{"app", "wal", "2", "-1000", "5000"},
+		{"logs_today", "delete", "0", "-1000", "5000"},
+		{"logs_archive", "delete", "1", "-1000", "5000"},
+	} {
+		c := MustConnect(t, s, tt.db, "alice", "")
+		for pragma, want := range map[string]string{
+			"journal_mode": tt.journalMode,
+			"synchronous":  tt.synchronous,
+			"cache_size":   tt.cacheSize,
+			"busy_timeout": tt.busyTimeout,
+		} {
+			if rows := c.MustQuery(`PRAGMA ` + pragma); rows[0][0] != want {
+				t.Fatalf("%s: %s=%s, want %s", tt.db, pragma, rows[0][0], want)
+			}
+		}
+		c.Close()
+	}
+
+	// Settings are reported with their keywords.
+	c := MustConnect(t, s, "logs_archive", "alice", "")
+	if rows := c.MustQuery(`SHOW synchronous`); rows[0][0] != "normal" {
+		t.Fatalf("synchronous=%s, want normal", rows[0][0])
+	}
+	c.Close()
+
+	// New settings apply to databases opened afterwards.
+	s.DBs.SetPragmas(postlite.Pragmas{Synchronous: "extra"}, nil)
+	c = MustConnect(t, s, "other", "alice", "")
+	if rows := c.MustQuery(`PRAGMA synchronous`); rows[0][0] != "3" {
+		t.Fatalf("synchronous=%s, want 3", rows[0][0])
+	} else if rows := c.MustQuery(`PRAGMA journal_mode`); !strings.EqualFold(rows[0][0], "delete") {
+		t.Fatalf("journal_mode=%s, want delete", rows[0][0])
+	}
+}
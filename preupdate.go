@@ -0,0 +1,37 @@
+//go:build sqlite_preupdate_hook
+
+package postlite
+
+import "github.com/mattn/go-sqlite3"
+
+// preUpdateHookEnabled is true as SQLite is built with the preupdate hook,
+// which captures row changes for logical replication.
+const preUpdateHookEnabled = true
+
+// registerPreUpdateHook registers a preupdate hook on conn that captures the
+// row changes of the session that has pinned conn.
+func (s *Server) registerPreUpdateHook(conn *sqlite3.SQLiteConn) {
+	conn.RegisterPreUpdateHook(func(d sqlite3.SQLitePreUpdateData) {
+		c := s.capturingSession(conn, d.DatabaseName, d.TableName)
+		if c == nil {
+			return
+		}
+
+		change := &RowChange{}
+		switch d.Op {
+		case sqlite3.SQLITE_INSERT:
+			change.Op, change.New = "insert", make([]interface{}, d.Count())
+			d.New(change.New...)
+		case sqlite3.SQLITE_UPDATE:
+			change.Op, change.Old, change.New = "update", make([]interface{}, d.Count()), make([]interface{}, d.Count())
+			d.Old(change.Old...)
+			d.New(change.New...)
+		case sqlite3.SQLITE_DELETE:
+			change.Op, change.Old = "delete", make([]interface{}, d.Count())
+			d.Old(change.Old...)
+		default:
+			return
+		}
+		c.captureChange(d.TableName, change)
+	})
+}
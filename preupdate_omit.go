@@ -0,0 +1,12 @@
+//go:build !sqlite_preupdate_hook
+
+package postlite
+
+import "github.com/mattn/go-sqlite3"
+
+// preUpdateHookEnabled is false as SQLite is built without the preupdate hook,
+// so row changes cannot be captured for logical replication.
+const preUpdateHookEnabled = false
+
+// registerPreUpdateHook is not supported without the preupdate hook.
+func (s *Server) registerPreUpdateHook(conn *sqlite3.SQLiteConn) {}
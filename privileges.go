@@ -0,0 +1,696 @@
+package postlite
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// publicGrantee is the grantee of privileges granted to all roles.
+const publicGrantee = "public"
+
+// tablePrivilegeTypes lists the supported table privileges & their letters in
+// Postgres access control lists, in the order Postgres lists them.
+var tablePrivilegeTypes = []struct {
+	name   string
+	letter string
+}{
+	{"INSERT", "a"},
+	{"SELECT", "r"},
+	{"UPDATE", "w"},
+	{"DELETE", "d"},
+}
+
+// authorizerPrivileges maps SQLite authorizer actions to the table privilege
+// they require.
+var authorizerPrivileges = map[int]string{
+	sqlite3.SQLITE_READ:   "SELECT",
+	sqlite3.SQLITE_INSERT: "INSERT",
+	sqlite3.SQLITE_UPDATE: "UPDATE",
+	sqlite3.SQLITE_DELETE: "DELETE",
+}
+
+// sessionPrivileges are the privileges of a session's role, checked by the
+// SQLite authorizer.
+type sessionPrivileges struct {
+	superuser bool                       // may access every table & change the schema
+	readOnly  bool                       // session may not write, even as a superuser
+	confined  bool                       // session may not attach databases, even as a superuser
+	relations map[string]string          // types of the tables & views of the main database, by lower-case name
+	granted   map[string]map[string]bool // privileges by lower-case table name
+
+	// Temporary objects applying row-level security, which may not be
+	// dropped, & triggers reading tables with row-level security, by
+	// lower-case name of the table they are on, which may not be written.
+	rowSecurity map[string]bool
+	triggers    map[string]string
+}
+
+// check returns the error message for an authorizer action the session may
+// not perform. Returns a blank string if the action is allowed. Schema
+// changes, attached databases & pragmas changing the connection are reserved
+// to superusers. Other roles may still create temporary objects, except
+// triggers on the tables of other schemas, which would run as whichever
+// session writes them.
+func (p *sessionPrivileges) check(op int, arg1, arg2, database string) string {
+	// Sessions admitted by access rules may only reach the database the
+	// rules were matched against. VACUUM attaches a temporary database,
+	// which has no file name.
+	if p.confined && (op == sqlite3.SQLITE_ATTACH && arg1 != "" || op == sqlite3.SQLITE_DETACH) {
+		return "access rules do not permit attaching databases"
+	}
+
+	// Read-only sessions are restricted by query_only, so they may not turn
+	// it off nor attach databases it would not apply to, such as the file
+	// written by VACUUM INTO.
+	if p.readOnly {
+		switch {
+		case op == sqlite3.SQLITE_ATTACH && arg1 != "", op == sqlite3.SQLITE_DETACH:
+			return "cannot attach databases in a read-only session"
+		case op == sqlite3.SQLITE_PRAGMA && strings.EqualFold(arg1, "query_only") && arg2 != "":
+			return "cannot change query_only in a read-only session"
+		}
+	}
+
+	if p.superuser {
+		return ""
+	}
+
+	switch op {
+	case sqlite3.SQLITE_ATTACH, sqlite3.SQLITE_DETACH:
+		return "must be superuser to attach databases"
+	case sqlite3.SQLITE_DROP_TEMP_VIEW, sqlite3.SQLITE_DROP_TEMP_TRIGGER:
+		if p.rowSecurity[strings.ToLower(arg1)] {
+			return fmt.Sprintf("permission denied to drop %s, which applies row-level security", arg1)
+		}
+		return ""
+	case sqlite3.SQLITE_INSERT, sqlite3.SQLITE_UPDATE, sqlite3.SQLITE_DELETE:
+		if trigger := p.triggers[strings.ToLower(arg1)]; trigger != "" && database == "main" {
+			return fmt.Sprintf("permission denied for table %s, as trigger %s reads a table with row-level security", arg1, trigger)
+		}
+	case sqlite3.SQLITE_PRAGMA:
+		return checkPragma(arg1, arg2)
+	case sqlite3.SQLITE_ALTER_TABLE: // database name is passed first
+		if arg1 != "temp" {
+			return schemaDenied(arg1)
+		}
+		return ""
+	case sqlite3.SQLITE_CREATE_INDEX, sqlite3.SQLITE_CREATE_TABLE, sqlite3.SQLITE_CREATE_TRIGGER, sqlite3.SQLITE_CREATE_VIEW, sqlite3.SQLITE_CREATE_VTABLE,
+		sqlite3.SQLITE_DROP_INDEX, sqlite3.SQLITE_DROP_TABLE, sqlite3.SQLITE_DROP_TRIGGER, sqlite3.SQLITE_DROP_VIEW, sqlite3.SQLITE_DROP_VTABLE:
+		if database != "temp" {
+			return schemaDenied(database)
+		}
+		return ""
+	}
+
+	// Rows are added to & removed from schema tables by schema changes,
+	// including temporary triggers on the tables of the schema. Updates are
+	// also authorized when virtual tables declare their columns, & SQLite
+	// rejects other updates unless writable_schema is set.
+	privilege, table := authorizerPrivileges[op], strings.ToLower(arg1)
+	if (op == sqlite3.SQLITE_INSERT || op == sqlite3.SQLITE_DELETE) && schemaTables[table] && database != "temp" {
+		return schemaDenied(database)
+	}
+
+	// Tables of the server are only read & written by the server.
+	if privilege != "" && serverTables[table] && (database == "main" || database == "") {
+		return fmt.Sprintf("permission denied for table %s", arg1)
+	}
+
+	// Virtual tables such as pragma functions are not in the schema & are
+	// not checked, nor are temporary tables. Tables read without reading any
+	// column, e.g. by count(*), are passed with the database name as written
+	// in the query, which is blank if unqualified.
+	typ := p.relations[table]
+	if privilege == "" || (database != "main" && database != "") || typ == "" || p.granted[table][privilege] {
+		return ""
+	}
+	return fmt.Sprintf("permission denied for %s %s", typ, arg1)
+}
+
+// schemaDenied returns the message for a change to the schema of an attached
+// database. The main database is the public schema.
+func schemaDenied(database string) string {
+	if database == "main" || database == "" {
+		database = "public"
+	}
+	return "permission denied for schema " + database
+}
+
+// checkPragma returns the error message for a pragma a role other than a
+// superuser may not run. Settings may be read but not changed & pragmas
+// describing the schema may be run with their arguments.
+func checkPragma(name, arg string) string {
+	name = strings.ToLower(name)
+	if schemaPragmas[name] || (arg == "" && !actionPragmas[name]) {
+		return ""
+	}
+	return fmt.Sprintf("must be superuser to run pragma %s", name)
+}
+
+var (
+	// serverTables are the names of the tables the server keeps in the
+	// main database.
+	serverTables = map[string]bool{changeTable: true, extensionTable: true}
+
+	// schemaTables are the names of SQLite's schema tables.
+	schemaTables = map[string]bool{
+		"sqlite_master": true, "sqlite_schema": true,
+		"sqlite_temp_master": true, "sqlite_temp_schema": true,
+	}
+
+	// schemaPragmas describe the schema & take the object to describe as
+	// their argument, rather than a value to set.
+	schemaPragmas = map[string]bool{
+		"collation_list": true, "compile_options": true, "database_list": true,
+		"foreign_key_check": true, "foreign_key_list": true, "function_list": true,
+		"index_info": true, "index_list": true, "index_xinfo": true,
+		"integrity_check": true, "module_list": true, "pragma_list": true,
+		"quick_check": true, "table_info": true, "table_list": true, "table_xinfo": true,
+	}
+
+	// actionPragmas change the database or connection without a value.
+	actionPragmas = map[string]bool{
+		"incremental_vacuum": true, "optimize": true, "shrink_memory": true, "wal_checkpoint": true,
+	}
+)
+
+// authorizer returns a SQLite authorizer that checks the statements of the
+// session that has pinned conn against the session's privileges. As SQLite
+// authorizes the tables read by views & written by triggers, a role needs
+// privileges on the tables behind a view as well as the view.
+//
+// Privileges are loaded before each statement of the session. Until then, &
+// while the server changes objects on the session's behalf, they are nil &
+// statements, which are the server's own, are not checked.
+func (s *Server) authorizer(conn *sqlite3.SQLiteConn) func(op int, arg1, arg2, database string) int {
+	return func(op int, arg1, arg2, database string) int {
+		c := s.session(conn)
+		if c == nil {
+			return sqlite3.SQLITE_OK
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.privileges == nil {
+			return sqlite3.SQLITE_OK
+		} else if msg := c.privileges.check(op, arg1, arg2, database); msg != "" {
+			c.denied = msg
+			return sqlite3.SQLITE_DENY
+		}
+		return sqlite3.SQLITE_OK
+	}
+}
+
+// loadPrivileges loads the privileges of the session's role before it runs a
+// statement. Without a metastore there are no roles, so every session acts as
+// the bootstrap superuser.
+func (s *Server) loadPrivileges(ctx context.Context, c *Conn) error {
+	p := &sessionPrivileges{superuser: true}
+	if s.MetaStore != nil {
+		role, err := s.sessionRole(c)
+		if err != nil {
+			return err
+		} else if !role.Superuser {
+			if p, err = s.rolePrivileges(ctx, c, role); err != nil {
+				return err
+			}
+		}
+	}
+
+	p.readOnly, p.confined = c.readOnly, c.confined
+	p.rowSecurity, p.triggers = c.rowSecurityObjects()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.privileges, c.denied = p, ""
+	return nil
+}
+
+func (s *Server) rolePrivileges(ctx context.Context, c *Conn, role *Role) (*sessionPrivileges, error) {
+	granted, err := s.grantedPrivileges(c.db.Name(), role, false)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := c.conn.QueryContext(ctx, `SELECT name, type FROM main.sqlite_schema WHERE type IN ('table', 'view')`)
+	if err != nil {
+		return nil, fmt.Errorf("load relations: %w", err)
+	}
+	defer rows.Close()
+
+	p := &sessionPrivileges{relations: make(map[string]string), granted: granted}
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, fmt.Errorf("load relations: %w", err)
+		}
+		p.relations[strings.ToLower(name)] = typ
+	}
+	return p, rows.Err()
+}
+
+// deniedMessage returns the message of the last action denied by the
+// authorizer.
+func (c *Conn) deniedMessage() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.denied == "" {
+		return "permission denied"
+	}
+	return c.denied
+}
+
+// grantedPrivileges returns the privileges granted on the tables of a database
+// to a role, the roles it inherits from & public, by lower-case table name. If
+// grantOption is true, only privileges with the grant option are returned.
+func (s *Server) grantedPrivileges(database string, role *Role, grantOption bool) (map[string]map[string]bool, error) {
+	granted := make(map[string]map[string]bool)
+	if s.MetaStore == nil {
+		return granted, nil
+	}
+
+	grantees := map[string]bool{role.Name: true, publicGrantee: true}
+	if role.Inherit {
+		memberships, err := s.roleMemberships()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range memberOf(memberships, role.Name) {
+			grantees[name] = true
+		}
+	}
+
+	privileges, err := s.MetaStore.TablePrivileges(database)
+	if err != nil {
+		return nil, fmt.Errorf("table privileges: %w", err)
+	}
+	for _, p := range privileges {
+		if !grantees[p.Grantee] || (grantOption && !p.Grantable) {
+			continue
+		}
+		table := strings.ToLower(p.Table)
+		if granted[table] == nil {
+			granted[table] = make(map[string]bool)
+		}
+		granted[table][p.Privilege] = true
+	}
+	return granted, nil
+}
+
+// hasTablePrivilege returns the has_table_privilege() SQL function, which
+// takes an optional role name, a table name & a comma-separated list of
+// privileges. Returns true if the role has any of the privileges.
+func (s *Server) hasTablePrivilege(conn *sqlite3.SQLiteConn, db *DB) func(args ...string) (bool, error) {
+	return func(args ...string) (bool, error) {
+		var role *Role
+		var err error
+		switch len(args) {
+		case 2:
+			if c := s.session(conn); c != nil {
+				role, err = s.sessionRole(c)
+			} else {
+				role, err = s.role(s.auth(), bootstrapRole)
+			}
+		case 3:
+			if role, err = s.role(s.auth(), args[0]); err == nil && role == nil {
+				err = Errorf("42704", "role %q does not exist", args[0])
+			}
+			args = args[1:]
+		default:
+			return false, errors.New("has_table_privilege() takes 2 or 3 arguments")
+		}
+		if err != nil {
+			return false, err
+		}
+
+		table, err := lookupRelation(conn, args[0])
+		if err != nil {
+			return false, err
+		}
+
+		var privileges []string
+		var grantOption bool
+		for _, priv := range strings.Split(args[1], ",") {
+			priv = strings.ToUpper(strings.Join(strings.Fields(priv), " "))
+			if strings.HasSuffix(priv, " WITH GRANT OPTION") {
+				priv, grantOption = strings.TrimSuffix(priv, " WITH GRANT OPTION"), true
+			}
+			switch priv {
+			case "SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER":
+				privileges = append(privileges, priv)
+			default:
+				return false, Errorf("22023", "unrecognized privilege type: %q", priv) // invalid_parameter_value
+			}
+		}
+		if role.Superuser {
+			return true, nil
+		}
+
+		granted, err := s.grantedPrivileges(db.Name(), role, grantOption)
+		if err != nil {
+			return false, err
+		}
+		for _, priv := range privileges {
+			if granted[strings.ToLower(table)][priv] {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// tableACLs returns the Postgres access control lists of the tables of a
+// database with granted privileges, by lower-case table name, e.g.
+// "{sqlite3=arwd/sqlite3,alice=r/sqlite3}". The owner, the bootstrap
+// superuser, is listed first.
+func (s *Server) tableACLs(database string) (map[string]string, error) {
+	acls := make(map[string]string)
+	if s.MetaStore == nil {
+		return acls, nil
+	}
+	privileges, err := s.MetaStore.TablePrivileges(database)
+	if err != nil {
+		return nil, fmt.Errorf("table privileges: %w", err)
+	}
+
+	// Group privileges by grantee & grantor.
+	type aclKey struct{ grantee, grantor string }
+	items := make(map[string]map[aclKey]map[string]string)
+	for _, p := range privileges {
+		table := strings.ToLower(p.Table)
+		if items[table] == nil {
+			items[table] = make(map[aclKey]map[string]string)
+		}
+		key := aclKey{p.Grantee, p.Grantor}
+		if items[table][key] == nil {
+			items[table][key] = make(map[string]string)
+		}
+		items[table][key][p.Privilege] = ""
+		if p.Grantable {
+			items[table][key][p.Privilege] = "*" // grant option
+		}
+	}
+
+	for table, grants := range items {
+		keys := make([]aclKey, 0, len(grants))
+		for key := range grants {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].grantee != keys[j].grantee {
+				return keys[i].grantee < keys[j].grantee
+			}
+			return keys[i].grantor < keys[j].grantor
+		})
+
+		acl := []string{bootstrapRole + "=arwd/" + bootstrapRole}
+		for _, key := range keys {
+			var privs string
+			for _, typ := range tablePrivilegeTypes {
+				if option, ok := grants[key][typ.name]; ok {
+					privs += typ.letter + option
+				}
+			}
+			grantee := key.grantee
+			if grantee == publicGrantee {
+				grantee = ""
+			}
+			acl = append(acl, grantee+"="+privs+"/"+key.grantor)
+		}
+		acls[table] = "{" + strings.Join(acl, ",") + "}"
+	}
+	return acls, nil
+}
+
+// pgClasses returns the pg_class rows for the tables, views & indexes of the
+// main database, which are owned by the bootstrap superuser.
+func (s *Server) pgClasses(conn *sqlite3.SQLiteConn, db *DB) ([]pgClass, error) {
+	acls, err := s.tableACLs(db.Name())
+	if err != nil {
+		return nil, err
+	}
+	security := make(map[string]*RowSecurity)
+	if s.MetaStore != nil {
+		states, err := s.MetaStore.RowSecurity(db.Name())
+		if err != nil {
+			return nil, fmt.Errorf("row security: %w", err)
+		}
+		for _, state := range states {
+			security[strings.ToLower(state.Table)] = state
+		}
+	}
+
+	rows, err := conn.Query(`
+		SELECT name, type,
+			CASE type WHEN 'index' THEN (SELECT count(*) FROM pragma_index_info(s.name)) ELSE (SELECT count(*) FROM pragma_table_xinfo(s.name) WHERE hidden <> 1) END,
+			EXISTS (SELECT 1 FROM main.sqlite_schema i WHERE i.type = 'index' AND i.tbl_name = s.name),
+			EXISTS (SELECT 1 FROM main.sqlite_schema t WHERE t.type = 'trigger' AND t.tbl_name = s.name)
+		FROM main.sqlite_schema s
+		WHERE type IN ('table', 'view', 'index') AND name NOT LIKE 'sqlite\_%' ESCAPE '\'
+		ORDER BY name
+	`, nil)
+	if err != nil {
+		return nil, fmt.Errorf("pg_class: %w", err)
+	}
+	defer rows.Close()
+
+	var classes []pgClass
+	dest := make([]driver.Value, 5)
+	for {
+		if err := rows.Next(dest); err == io.EOF {
+			return classes, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("pg_class: %w", err)
+		}
+		name, typ := dest[0].(string), dest[1].(string)
+
+		class := pgClass{
+			oid:            stableOID("class:" + name),
+			relname:        name,
+			relnamespace:   2200, // public
+			relowner:       10,
+			relpersistence: "p",
+			relkind:        "r",
+			relam:          2, // heap
+			relnatts:       int(dest[2].(int64)),
+			relhasindex:    boolInt(dest[3].(int64) != 0),
+			relhastriggers: boolInt(dest[4].(int64) != 0),
+			relispopulated: 1,
+			relreplident:   "d",
+			relacl:         acls[strings.ToLower(name)],
+		}
+		if state := security[strings.ToLower(name)]; state != nil && typ == "table" {
+			class.relrowsecurity, class.relforcerowsecurity = boolInt(state.Enabled), boolInt(state.Forced)
+		}
+		switch typ {
+		case "view":
+			class.relkind, class.relam, class.relreplident = "v", 0, "n"
+		case "index":
+			class.relkind, class.relam, class.relreplident = "i", 403, "n" // btree
+		}
+		classes = append(classes, class)
+	}
+}
+
+// lookupRelation returns the name of a table or view of the main database from
+// an identifier, which may be qualified by the public schema. Returns an error
+// if the relation does not exist.
+func lookupRelation(conn *sqlite3.SQLiteConn, ident string) (string, error) {
+	m := relationIdentRegex.FindStringSubmatch(strings.TrimSpace(ident))
+	if m == nil {
+		return "", Errorf("42602", "invalid name syntax") // invalid_name
+	} else if m[1] != "" && identName(m[1]) != "public" {
+		return "", Errorf("3F000", "schema %q does not exist", identName(m[1])) // invalid_schema_name
+	}
+
+	name := identName(m[2])
+	rows, err := conn.Query(`SELECT name FROM main.sqlite_schema WHERE type IN ('table', 'view') AND name = ? COLLATE NOCASE`, []driver.Value{name})
+	if err != nil {
+		return "", fmt.Errorf("lookup relation: %w", err)
+	}
+	defer rows.Close()
+
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err == io.EOF {
+		return "", Errorf("42P01", "relation %q does not exist", name) // undefined_table
+	} else if err != nil {
+		return "", fmt.Errorf("lookup relation: %w", err)
+	}
+	return dest[0].(string), nil
+}
+
+// relationNames returns the names of the tables & views of the main database.
+func relationNames(conn *sqlite3.SQLiteConn) ([]string, error) {
+	rows, err := conn.Query(`SELECT name FROM main.sqlite_schema WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite\_%' ESCAPE '\' ORDER BY name`, nil)
+	if err != nil {
+		return nil, fmt.Errorf("relations: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	dest := make([]driver.Value, 1)
+	for {
+		if err := rows.Next(dest); err == io.EOF {
+			return names, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("relations: %w", err)
+		}
+		names = append(names, dest[0].(string))
+	}
+}
+
+// handlePrivilegeStatement handles GRANT & REVOKE of table privileges, which
+// are kept in the metastore. Returns false if the query is not a GRANT or
+// REVOKE of table privileges.
+func (s *Server) handlePrivilegeStatement(c *Conn, w *resultBuffer, query string) (bool, error) {
+	var tag string
+	var err error
+	if m := grantTableRegex.FindStringSubmatch(query); m != nil {
+		tag = "GRANT"
+		err = s.changeTablePrivileges(c, tag, m[1], m[2], m[3], roleNames(m[4]), m[5] != "")
+	} else if m := revokeTableRegex.FindStringSubmatch(query); m != nil {
+		tag = "REVOKE"
+		err = s.changeTablePrivileges(c, tag, m[2], m[3], m[4], roleNames(m[5]), m[1] != "")
+	} else {
+		return false, nil
+	}
+	w.SetCommandTag(tag)
+	return true, err
+}
+
+// changeTablePrivileges grants or revokes privileges on tables. The tables
+// are either listed or all tables if allTablesSchema is set. For GRANT,
+// grantOption grants the grant option too. For REVOKE, it revokes only the
+// grant option.
+func (s *Server) changeTablePrivileges(c *Conn, stmt, privList, allTablesSchema, tableList string, grantees []string, grantOption bool) error {
+	if err := s.checkMetaStoreChange(c, stmt); err != nil {
+		return err
+	}
+
+	privileges, err := parseTablePrivileges(privList)
+	if err != nil {
+		return err
+	}
+
+	var tables []string
+	if allTablesSchema != "" {
+		if schema := identName(allTablesSchema); schema != "public" {
+			return Errorf("3F000", "schema %q does not exist", schema)
+		} else if tables, err = relationNames(c.sqliteConn); err != nil {
+			return err
+		}
+	} else {
+		for _, ident := range relationIdentListRegex.FindAllString(tableList, -1) {
+			table, err := lookupRelation(c.sqliteConn, ident)
+			if err != nil {
+				return err
+			}
+			tables = append(tables, table)
+		}
+	}
+
+	for _, grantee := range grantees {
+		if grantee == publicGrantee {
+			continue
+		} else if role, err := s.role(s.auth(), grantee); err != nil {
+			return err
+		} else if role == nil {
+			return Errorf("42704", "role %q does not exist", grantee)
+		}
+	}
+
+	// Superusers grant privileges as the owner of the tables. Other roles
+	// need the grant option & may only revoke privileges they granted.
+	caller, err := s.sessionRole(c)
+	if err != nil {
+		return err
+	}
+	grantor := bootstrapRole
+	if !caller.Superuser {
+		grantor = caller.Name
+		grantable, err := s.grantedPrivileges(c.db.Name(), caller, true)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			for _, priv := range privileges {
+				if !grantable[strings.ToLower(table)][priv] {
+					return Errorf("42501", "permission denied for table %s", table) // insufficient_privilege
+				}
+			}
+		}
+	}
+
+	for _, table := range tables {
+		for _, grantee := range grantees {
+			for _, priv := range privileges {
+				p := &TablePrivilege{Database: c.db.Name(), Table: table, Grantee: grantee, Privilege: priv, Grantor: grantor}
+				if stmt == "GRANT" {
+					p.Grantable = grantOption
+					if err := s.MetaStore.GrantTablePrivilege(p); err != nil {
+						return fmt.Errorf("grant: %w", err)
+					}
+					continue
+				}
+
+				if caller.Superuser {
+					p.Grantor = ""
+				}
+				if _, err := s.MetaStore.RevokeTablePrivilege(p, grantOption); err != nil {
+					return fmt.Errorf("revoke: %w", err)
+				}
+			}
+			c.logger.Info("table privileges changed", "stmt", stmt, "table", table, "grantee", grantee, "privileges", strings.Join(privileges, ","))
+		}
+	}
+	return nil
+}
+
+// parseTablePrivileges returns the privileges of a GRANT or REVOKE statement.
+// ALL is expanded to all supported privileges.
+func parseTablePrivileges(list string) ([]string, error) {
+	if allPrivilegesRegex.MatchString(list) {
+		privileges := make([]string, 0, len(tablePrivilegeTypes))
+		for _, typ := range tablePrivilegeTypes {
+			privileges = append(privileges, typ.name)
+		}
+		return privileges, nil
+	}
+
+	var privileges []string
+	for _, priv := range strings.Split(list, ",") {
+		switch priv = strings.ToUpper(strings.TrimSpace(priv)); priv {
+		case "SELECT", "INSERT", "UPDATE", "DELETE":
+			privileges = append(privileges, priv)
+		case "TRUNCATE", "REFERENCES", "TRIGGER":
+			return nil, Errorf("0A000", "%s privilege is not supported", priv) // feature_not_supported
+		default:
+			return nil, Errorf("42601", "syntax error at or near %q", priv) // syntax_error
+		}
+	}
+	return privileges, nil
+}
+
+const (
+	relationIdent     = `(?:(` + roleIdent + `)\.)?(` + roleIdent + `)`
+	relationIdentList = `(?:` + roleIdent + `\.)?` + roleIdent + `(?:\s*,\s*(?:` + roleIdent + `\.)?` + roleIdent + `)*`
+	privilegeList     = `\w+(?:\s+PRIVILEGES)?(?:\s*,\s*\w+)*`
+	privilegeTarget   = `ON\s+(?:ALL\s+TABLES\s+IN\s+SCHEMA\s+(` + roleIdent + `)|(?:TABLE\s+)?(` + relationIdentList + `))`
+)
+
+var (
+	relationIdentRegex     = regexp.MustCompile(`^` + relationIdent + `$`)
+	relationIdentListRegex = regexp.MustCompile(`(?:` + roleIdent + `\.)?` + roleIdent)
+	allPrivilegesRegex     = regexp.MustCompile(`(?is)^\s*ALL(?:\s+PRIVILEGES)?\s*$`)
+
+	grantTableRegex  = regexp.MustCompile(`(?is)^\s*GRANT\s+(` + privilegeList + `)\s+` + privilegeTarget + `\s+TO\s+(` + roleIdentList + `)(\s+WITH\s+GRANT\s+OPTION)?(?:\s+GRANTED\s+BY\s+` + roleIdent + `)?\s*;?\s*$`)
+	revokeTableRegex = regexp.MustCompile(`(?is)^\s*REVOKE\s+(GRANT\s+OPTION\s+FOR\s+)?(` + privilegeList + `)\s+` + privilegeTarget + `\s+FROM\s+(` + roleIdentList + `)(?:\s+GRANTED\s+BY\s+` + roleIdent + `)?(?:\s+(?:CASCADE|RESTRICT))?\s*;?\s*$`)
+)
@@ -0,0 +1,78 @@
+package postlite_test
+
+import (
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure roles other than superusers are limited to their table privileges &
+// cannot escape them with temporary triggers, pragmas or schema writes.
+func TestServer_TablePrivileges(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) { s.MetaStore = MustOpenMetaStore(t) })
+
+	admin := MustConnect(t, s, "db", "sqlite3", "")
+	admin.MustQuery(`CREATE TABLE secret (x TEXT)`)
+	admin.MustQuery(`INSERT INTO secret VALUES ('s3cr3t')`)
+	admin.MustQuery(`CREATE TABLE pub (x TEXT)`)
+	admin.MustQuery(`CREATE TABLE t (x TEXT)`)
+	admin.MustQuery(`CREATE ROLE low LOGIN`)
+	admin.MustQuery(`GRANT SELECT, INSERT ON pub TO low`)
+
+	low := MustConnect(t, s, "db", "low", "")
+	low.MustQuery(`SELECT * FROM pub`)
+	low.MustQuery(`INSERT INTO pub VALUES ('a')`)
+	low.MustFailQuery(`SELECT * FROM secret`, "42501")
+	low.MustFailQuery(`DELETE FROM pub`, "42501")
+	low.MustFailQuery(`CREATE TABLE other (x)`, "42501")
+	low.MustFailQuery(`ATTACH ':memory:' AS other`, "42501")
+
+	// Temporary triggers on tables of the main database would run with the
+	// privileges of whoever writes the table.
+	low.MustFailQuery(`CREATE TEMP TRIGGER steal AFTER INSERT ON main.t BEGIN INSERT INTO pub SELECT x FROM main.secret; END`, "42501")
+	low.MustFailQuery(`CREATE TEMP TRIGGER steal AFTER INSERT ON t BEGIN SELECT 1; END`, "42501")
+	low.MustQuery(`CREATE TEMP TABLE scratch (x)`)
+	low.MustQuery(`CREATE TEMP TRIGGER scratch_insert AFTER INSERT ON scratch BEGIN SELECT 1; END`)
+	low.MustQuery(`INSERT INTO scratch VALUES (1)`)
+
+	// Settings may be read but not changed.
+	low.MustQuery(`PRAGMA foreign_keys`)
+	low.MustQuery(`PRAGMA table_info(pub)`)
+	low.MustQuery(`SELECT name FROM pragma_table_xinfo('pub')`)
+	low.MustFailQuery(`PRAGMA writable_schema = 1`, "42501")
+	low.MustFailQuery(`PRAGMA query_only = 0`, "42501")
+	low.MustFailQuery(`PRAGMA foreign_keys = 0`, "42501")
+	low.MustFailQuery(`PRAGMA optimize`, "42501")
+
+	// Schema tables & catalogs may not be written.
+	for _, query := range []string{`DELETE FROM sqlite_master WHERE 0`, `UPDATE sqlite_master SET sql = '' WHERE 0`} {
+		if _, err := low.Query(query); err == nil {
+			t.Fatalf("%s: expected error", query)
+		}
+	}
+	low.MustFailQuery(`CREATE TABLE pg_catalog.x (a)`, "42501")
+	low.MustFailQuery(`DROP TABLE pg_catalog.pg_class`, "42501")
+
+	// Privileges are changed over the extended protocol too.
+	admin.MustQueryExtended(`REVOKE INSERT ON pub FROM low`)
+	low.MustFailQuery(`INSERT INTO pub VALUES ('b')`, "42501")
+	admin.MustQueryExtended(`GRANT SELECT ON secret TO low`)
+	low.MustQuery(`SELECT * FROM secret`)
+	admin.MustQueryExtended(`REVOKE SELECT ON secret FROM low`)
+
+	// Nothing reached the secret table.
+	admin.MustQuery(`INSERT INTO t VALUES ('x')`)
+	if rows := admin.MustQuery(`SELECT count(*) FROM pub WHERE x = 's3cr3t'`); rows[0][0] != "0" {
+		t.Fatal("secret leaked into pub")
+	}
+}
+
+// Ensure every session acts as a superuser without a metastore.
+func TestServer_TablePrivileges_NoMetaStore(t *testing.T) {
+	s := MustOpenServer(t)
+	c := MustConnect(t, s, "db", "anyone", "")
+	c.MustQuery(`CREATE TABLE t (x)`)
+	c.MustQuery(`PRAGMA foreign_keys = 1`)
+	c.MustQuery(`CREATE TEMP TRIGGER tr AFTER INSERT ON main.t BEGIN SELECT 1; END`)
+	c.MustQuery(`ATTACH ':memory:' AS other`)
+}
@@ -0,0 +1,874 @@
+package postlite
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgtype"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Row changes are streamed to logical replication consumers with the
+// pgoutput protocol. Sessions capture the changes of their transaction with
+// SQLite's preupdate hook, which requires building with the
+// sqlite_preupdate_hook tag, & stage them in the change table of their
+// database before the transaction commits, so a transaction cannot commit
+// without its changes. Staged transactions are copied to the server's change
+// log once committed. Replication connections, opened with
+// replication=database, create slots on the change log, copy the transactions
+// staged but not yet copied, e.g. before a crash, & stream the change log
+// from the position their consumer last confirmed.
+
+// changeTable is the table of the main database in which sessions stage the
+// row changes of their transactions. Only the server may read or write it.
+const changeTable = "postlite_changes"
+
+// replicationBatchSize is the number of transactions read from the change log
+// at a time while streaming.
+const replicationBatchSize = 100
+
+// replicationKeepaliveInterval is the time between keepalive messages sent to
+// idle replication consumers.
+const replicationKeepaliveInterval = 10 * time.Second
+
+// capturedChange is a row change of a session's transaction.
+type capturedChange struct {
+	table  string
+	change *RowChange
+}
+
+// changeSavepoint is a savepoint of a session's transaction & the number of
+// row changes captured before it.
+type changeSavepoint struct {
+	name string
+	n    int
+}
+
+// capturingSession returns the session that has pinned conn if the changes
+// of a table should be captured. Changes are only captured for databases with
+// a replication slot.
+func (s *Server) capturingSession(conn *sqlite3.SQLiteConn, database, table string) *Conn {
+	if s.ChangeLog == nil || database != "main" || strings.HasPrefix(table, "sqlite_") || serverTables[table] {
+		return nil
+	}
+	c := s.session(conn)
+	if c == nil || !s.ChangeLog.HasSlots(c.db.Name()) {
+		return nil
+	}
+	return c
+}
+
+// captureChange records a row change of the session's transaction.
+func (c *Conn) captureChange(table string, change *RowChange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changes = append(c.changes, capturedChange{table: table, change: change})
+}
+
+// changeMark returns the number of row changes captured by the session's
+// transaction, so the changes of a failed statement can be discarded.
+func (c *Conn) changeMark() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.changes)
+}
+
+// rollbackChanges discards the row changes captured after mark.
+func (c *Conn) rollbackChanges(mark int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if mark < len(c.changes) {
+		c.changes = c.changes[:mark]
+	}
+}
+
+// discardChanges discards the row changes of a rolled back transaction.
+func (c *Conn) discardChanges() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changes, c.changeSavepoints = nil, nil
+}
+
+// trackSavepoint keeps the captured row changes in step with the savepoints
+// of a statement that succeeded: changes are discarded when the transaction
+// rolls back to a savepoint.
+func (c *Conn) trackSavepoint(query string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// find returns the index of the most recent savepoint with a name.
+	find := func(ident string) int {
+		name := identName(ident)
+		for i := len(c.changeSavepoints) - 1; i >= 0; i-- {
+			if strings.EqualFold(c.changeSavepoints[i].name, name) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	if m := savepointRegex.FindStringSubmatch(query); m != nil {
+		c.changeSavepoints = append(c.changeSavepoints, changeSavepoint{name: identName(m[1]), n: len(c.changes)})
+	} else if m := releaseSavepointRegex.FindStringSubmatch(query); m != nil {
+		if i := find(m[1]); i != -1 {
+			c.changeSavepoints = c.changeSavepoints[:i]
+		}
+	} else if m := rollbackToSavepointRegex.FindStringSubmatch(query); m != nil {
+		if i := find(m[1]); i != -1 {
+			c.changes = c.changes[:c.changeSavepoints[i].n]
+			c.changeSavepoints = c.changeSavepoints[:i+1]
+		}
+	}
+}
+
+// serveQuery executes a query with the server's query handler. While the
+// session's database has replication slots, statements that may write are
+// run in a transaction & statements that may commit the session's
+// transaction are run once its row changes are staged. The changes of a
+// transaction that commits are then published.
+func (s *Server) serveQuery(ctx context.Context, w ResultWriter, c *Conn, q *Query) error {
+	var err error
+	switch capturing := s.ChangeLog != nil && s.ChangeLog.HasSlots(c.db.Name()); {
+	case capturing && !c.inTransaction() && changeStatementRegex.MatchString(q.SQL):
+		err = s.runInTransaction(ctx, w, c, q)
+	case capturing && c.inTransaction() && endTransactionRegex.MatchString(q.SQL):
+		err = s.runCommit(ctx, w, c, q)
+	default:
+		err = s.runQuery(ctx, w, c, q)
+	}
+	if err != nil || c.inTransaction() {
+		return err
+	}
+	return s.publishChanges(ctx, c)
+}
+
+// runQuery executes a query with the server's query handler. The row changes
+// captured by a statement that fails are discarded, as SQLite rolls the
+// statement back.
+func (s *Server) runQuery(ctx context.Context, w ResultWriter, c *Conn, q *Query) error {
+	mark := c.changeMark()
+	if err := s.queryHandler().ServeQuery(ctx, w, q); err != nil {
+		c.rollbackChanges(mark)
+		return err
+	}
+	c.trackSavepoint(q.SQL)
+	return nil
+}
+
+// runInTransaction executes a statement outside of the session's transaction
+// in a transaction of its own, which commits once its row changes are staged.
+func (s *Server) runInTransaction(ctx context.Context, w ResultWriter, c *Conn, q *Query) error {
+	if _, err := c.conn.ExecContext(ctx, `BEGIN`); err != nil {
+		return err
+	}
+	err := s.runQuery(ctx, w, c, q)
+	if err == nil {
+		_, err = s.stageChanges(ctx, c)
+	}
+	if err == nil {
+		_, err = c.conn.ExecContext(ctx, `COMMIT`)
+	}
+	if err != nil {
+		if e := c.conn.Raw(rollback); e != nil {
+			c.logger.Warn("cannot roll back", "err", e)
+		}
+		return err
+	}
+	c.setChangesStaged()
+	return nil
+}
+
+// runCommit executes a statement that may commit the session's transaction,
+// such as COMMIT or RELEASE, once its row changes are staged. The staged
+// changes are removed if the transaction stays open, e.g. as the statement
+// fails or releases a savepoint within the transaction.
+func (s *Server) runCommit(ctx context.Context, w ResultWriter, c *Conn, q *Query) error {
+	seq, err := s.stageChanges(ctx, c)
+	if err != nil {
+		return err
+	}
+	err = s.runQuery(ctx, w, c, q)
+	if !c.inTransaction() {
+		if err == nil {
+			c.setChangesStaged()
+		}
+		return err
+	} else if seq != 0 {
+		if e := s.unstageChanges(context.Background(), c, seq); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// stageChanges adds the row changes captured by the session's open
+// transaction to the change table of its database, which is created if
+// needed, & returns their position. Transactions already appended to the
+// change log are removed. Returns zero if no changes were captured.
+func (s *Server) stageChanges(ctx context.Context, c *Conn) (uint64, error) {
+	tx, err := s.changeTx(ctx, c)
+	if err != nil || tx == nil {
+		return 0, err
+	}
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return 0, err
+	}
+	last, err := s.ChangeLog.LastSeq(tx.Database)
+	if err != nil {
+		return 0, fmt.Errorf("change log position: %w", err)
+	}
+
+	defer c.withoutPrivileges()()
+	if _, err := c.conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS main.`+changeTable+` (seq INTEGER PRIMARY KEY, committed_at TEXT NOT NULL, changes TEXT NOT NULL)`); err != nil {
+		return 0, fmt.Errorf("create change table: %w", err)
+	} else if _, err := c.conn.ExecContext(ctx, `DELETE FROM main.`+changeTable+` WHERE seq <= ?`, last); err != nil {
+		return 0, fmt.Errorf("remove appended changes: %w", err)
+	}
+
+	// Positions continue after the last one appended, even if the database
+	// was replaced since.
+	var seq uint64
+	if err := c.conn.QueryRowContext(ctx, `
+		INSERT INTO main.`+changeTable+` (seq, committed_at, changes)
+		VALUES ((SELECT max(?1, coalesce(max(seq), 0)) + 1 FROM main.`+changeTable+`), ?2, ?3)
+		RETURNING seq
+	`, last, tx.CommittedAt.UTC().Format(time.RFC3339Nano), data).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("stage changes: %w", err)
+	}
+	return seq, nil
+}
+
+// unstageChanges removes changes staged by the session's open transaction.
+func (s *Server) unstageChanges(ctx context.Context, c *Conn, seq uint64) error {
+	defer c.withoutPrivileges()()
+	if _, err := c.conn.ExecContext(ctx, `DELETE FROM main.`+changeTable+` WHERE seq = ?`, seq); err != nil {
+		return fmt.Errorf("unstage changes: %w", err)
+	}
+	return nil
+}
+
+// setChangesStaged marks the row changes captured by the session's committed
+// transaction as staged, to be copied to the change log.
+func (c *Conn) setChangesStaged() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changes, c.changeSavepoints = nil, nil
+	c.changesStaged = true
+}
+
+// withoutPrivileges lifts the session's privileges so the server can access
+// the change table, & returns a function restoring them.
+func (c *Conn) withoutPrivileges() func() {
+	c.mu.Lock()
+	p := c.privileges
+	c.privileges = nil
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		c.privileges = p
+		c.mu.Unlock()
+	}
+}
+
+// changeTx returns the row changes captured by the session's transaction &
+// the columns of their tables. Returns nil if no changes were captured.
+func (s *Server) changeTx(ctx context.Context, c *Conn) (*ChangeTx, error) {
+	c.mu.Lock()
+	changes := append([]capturedChange(nil), c.changes...)
+	c.mu.Unlock()
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	tx := &ChangeTx{Database: c.db.Name(), CommittedAt: time.Now()}
+	relations := make(map[string]int)
+	for _, captured := range changes {
+		i, ok := relations[captured.table]
+		if !ok {
+			rel, err := relationDef(ctx, c, captured.table)
+			if err != nil {
+				return nil, err
+			}
+			i = len(tx.Relations)
+			relations[captured.table] = i
+			tx.Relations = append(tx.Relations, rel)
+		}
+		change := *captured.change
+		change.Relation = i
+		tx.Changes = append(tx.Changes, &change)
+	}
+	return tx, nil
+}
+
+// publishChanges copies the transactions staged by the session to the change
+// log. A failure is logged, as staged transactions are copied again by the
+// next session that stages or streams changes of the database. Changes that
+// were not staged, as the statement making them was not expected to write,
+// were committed without them & are appended directly; a failure is returned.
+func (s *Server) publishChanges(ctx context.Context, c *Conn) error {
+	if s.ChangeLog == nil {
+		return nil
+	}
+	c.mu.Lock()
+	staged := c.changesStaged
+	c.changesStaged = false
+	c.mu.Unlock()
+
+	if staged {
+		if err := s.copyStagedChanges(ctx, c); err != nil {
+			c.logger.Error("cannot copy staged changes to change log", "err", err)
+		}
+	}
+
+	tx, err := s.changeTx(ctx, c)
+	c.discardChanges()
+	if err != nil {
+		return fmt.Errorf("transaction committed but its changes were not logged: %w", err)
+	} else if tx == nil {
+		return nil
+	}
+	if err := s.ChangeLog.Append(tx); err != nil {
+		return fmt.Errorf("transaction committed but its changes were not logged: %w", err)
+	}
+	c.logger.Debug("changes published", "lsn", formatLSN(tx.LSN), "changes", len(tx.Changes))
+	return nil
+}
+
+// copyStagedChanges appends the transactions staged in the session's
+// database after the last one appended to the change log, in order.
+func (s *Server) copyStagedChanges(ctx context.Context, c *Conn) error {
+	database := c.db.Name()
+	last, err := s.ChangeLog.LastSeq(database)
+	if err != nil {
+		return fmt.Errorf("change log position: %w", err)
+	}
+
+	defer c.withoutPrivileges()()
+	var exists bool
+	if err := c.conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM main.sqlite_schema WHERE type = 'table' AND name = ?)`, changeTable).Scan(&exists); err != nil {
+		return err
+	} else if !exists {
+		return nil
+	}
+
+	rows, err := c.conn.QueryContext(ctx, `SELECT seq, committed_at, changes FROM main.`+changeTable+` WHERE seq > ? ORDER BY seq`, last)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var txs []*ChangeTx
+	for rows.Next() {
+		var tx ChangeTx
+		var committedAt string
+		var data []byte
+		if err := rows.Scan(&tx.Seq, &committedAt, &data); err != nil {
+			return err
+		} else if err := json.Unmarshal(data, &tx); err != nil {
+			return fmt.Errorf("decode staged transaction %d: %w", tx.Seq, err)
+		}
+		tx.Database = database
+		tx.CommittedAt, _ = time.Parse(time.RFC3339Nano, committedAt)
+		txs = append(txs, &tx)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, tx := range txs {
+		if err := s.ChangeLog.Append(tx); err != nil {
+			return fmt.Errorf("append to change log: %w", err)
+		}
+		c.logger.Debug("changes published", "lsn", formatLSN(tx.LSN), "changes", len(tx.Changes))
+	}
+	return nil
+}
+
+// relationDef returns the columns of a table of the main database.
+func relationDef(ctx context.Context, c *Conn, table string) (*RelationDef, error) {
+	rows, err := c.conn.QueryContext(ctx, `SELECT name, type, pk FROM pragma_table_xinfo(?, 'main') ORDER BY cid`, table)
+	if err != nil {
+		return nil, fmt.Errorf("table info %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	rel := &RelationDef{Name: table}
+	for rows.Next() {
+		var name, typ string
+		var pk int
+		if err := rows.Scan(&name, &typ, &pk); err != nil {
+			return nil, fmt.Errorf("table info %s: %w", table, err)
+		}
+		rel.Columns, rel.Types, rel.Keys = append(rel.Columns, name), append(rel.Types, typ), append(rel.Keys, pk > 0)
+	}
+	return rel, rows.Err()
+}
+
+// checkReplicationRole returns an error if the role of a session opened with
+// replication=database may not replicate.
+func (s *Server) checkReplicationRole(c *Conn) error {
+	role, err := s.sessionRole(c)
+	if err != nil {
+		return err
+	} else if !role.Superuser && !role.Replication {
+		return writeFatal(c, "42501", "must be superuser or replication role to start walsender") // insufficient_privilege
+	}
+	return nil
+}
+
+// handleReplicationCommand handles the commands of the replication protocol
+// on a replication connection. Returns false if the query is not one of them.
+func (s *Server) handleReplicationCommand(ctx context.Context, c *Conn, query string) (bool, error) {
+	t := time.Now()
+	var res resultBuffer
+	var err error
+	if identifySystemRegex.MatchString(query) {
+		err = s.identifySystem(c, &res)
+	} else if m := createReplicationSlotRegex.FindStringSubmatch(query); m != nil {
+		err = s.createReplicationSlot(c, &res, identName(m[1]), m[2] != "", m[3], identName(m[4]))
+	} else if m := dropReplicationSlotRegex.FindStringSubmatch(query); m != nil {
+		err = s.dropReplicationSlot(identName(m[1]))
+		res.SetCommandTag("DROP_REPLICATION_SLOT")
+	} else if m := startReplicationRegex.FindStringSubmatch(query); m != nil {
+		slot, lsn, err := s.replicationStart(c, identName(m[1]), m[2], m[3])
+		if err != nil {
+			return true, s.replicationCommandError(c, query, t, err)
+		} else if err := s.acquireSlot(c, slot.Name); err != nil {
+			return true, s.replicationCommandError(c, query, t, err)
+		}
+		defer s.releaseSlot(slot.Name)
+		s.auditStatement(c, query, t, 0, nil)
+		return true, s.startReplication(ctx, c, slot, lsn)
+	} else {
+		return false, nil
+	}
+
+	if err != nil {
+		return true, s.replicationCommandError(c, query, t, err)
+	}
+	s.auditStatement(c, query, t, int64(len(res.rows)), nil)
+
+	var buf []byte
+	if len(res.cols) > 0 {
+		buf = res.rowDescription().Encode(buf)
+	}
+	buf = res.encodeRows(buf)
+	buf = (&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(buf)
+	_, err = c.Write(buf)
+	return true, err
+}
+
+// replicationCommandError audits a failed replication command & sends its
+// error to the client.
+func (s *Server) replicationCommandError(c *Conn, query string, t time.Time, err error) error {
+	resp := toErrorResponse(err)
+	c.metrics.observeError(resp, nil)
+	s.auditStatement(c, query, t, 0, resp)
+	return writeMessages(c, resp, &pgproto3.ReadyForQuery{TxStatus: 'I'})
+}
+
+// checkLogicalReplication returns an error if the server cannot capture the
+// row changes for logical replication.
+func (s *Server) checkLogicalReplication() error {
+	if s.ChangeLog == nil {
+		return Errorf("55000", "logical decoding requires a change log") // object_not_in_prerequisite_state
+	} else if !preUpdateHookEnabled {
+		return Errorf("55000", "logical decoding requires postlite built with the sqlite_preupdate_hook tag")
+	}
+	return nil
+}
+
+func (s *Server) identifySystem(c *Conn, w ResultWriter) error {
+	var lsn uint64
+	if s.ChangeLog != nil {
+		var err error
+		if lsn, err = s.ChangeLog.CurrentLSN(); err != nil {
+			return fmt.Errorf("current lsn: %w", err)
+		}
+	}
+	w.WriteColumns("systemid", "timeline", "xlogpos", "dbname")
+	w.SetCommandTag("IDENTIFY_SYSTEM")
+	return w.WriteRow(strconv.Itoa(stableOID("system:"+s.DataDir)), 1, formatLSN(lsn), c.database)
+}
+
+func (s *Server) createReplicationSlot(c *Conn, w ResultWriter, name string, temporary bool, kind, plugin string) error {
+	if err := s.checkLogicalReplication(); err != nil {
+		return err
+	} else if !replicationSlotNameRegex.MatchString(name) {
+		return Errorf("42602", "replication slot name %q contains invalid character", name) // invalid_name
+	} else if !strings.EqualFold(kind, "LOGICAL") {
+		return Errorf("0A000", "physical replication is not supported") // feature_not_supported
+	} else if plugin != "pgoutput" {
+		return Errorf("0A000", "output plugin %q is not supported", plugin)
+	}
+
+	slot := &ReplicationSlot{Name: name, Database: c.db.Name(), Plugin: plugin, Temporary: temporary}
+	if err := s.ChangeLog.CreateSlot(slot); err == ErrSlotExists {
+		return Errorf("42710", "replication slot %q already exists", name) // duplicate_object
+	} else if err != nil {
+		return fmt.Errorf("create replication slot: %w", err)
+	}
+	if temporary {
+		c.mu.Lock()
+		c.temporarySlots = append(c.temporarySlots, name)
+		c.mu.Unlock()
+	}
+	c.logger.Info("replication slot created", "slot", name, "temporary", temporary)
+
+	w.WriteColumns("slot_name", "consistent_point", "snapshot_name", "output_plugin")
+	w.SetCommandTag("CREATE_REPLICATION_SLOT")
+	return w.WriteRow(name, formatLSN(slot.ConfirmedLSN), "", plugin)
+}
+
+func (s *Server) dropReplicationSlot(name string) error {
+	if s.ChangeLog == nil {
+		return Errorf("42704", "replication slot %q does not exist", name) // undefined_object
+	} else if pid := s.slotActivePID(name); pid != 0 {
+		return Errorf("55006", "replication slot %q is active for PID %d", name, pid) // object_in_use
+	}
+
+	if err := s.ChangeLog.DropSlot(name); err == ErrSlotNotFound {
+		return Errorf("42704", "replication slot %q does not exist", name)
+	} else if err != nil {
+		return fmt.Errorf("drop replication slot: %w", err)
+	}
+	s.Logger.Info("replication slot dropped", "slot", name)
+	return nil
+}
+
+// dropTemporarySlots drops the temporary replication slots created by a
+// session once it ends.
+func (s *Server) dropTemporarySlots(c *Conn) {
+	c.mu.Lock()
+	names := c.temporarySlots
+	c.temporarySlots = nil
+	c.mu.Unlock()
+
+	for _, name := range names {
+		if err := s.ChangeLog.DropSlot(name); err != nil && err != ErrSlotNotFound {
+			c.logger.Warn("cannot drop temporary replication slot", "slot", name, "err", err)
+		}
+	}
+}
+
+// slotActivePID returns the pid of the session streaming from a slot. Returns
+// zero if the slot is not in use.
+func (s *Server) slotActivePID(name string) int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.activeSlots[name]
+}
+
+// acquireSlot marks a slot in use by a session. Returns an error if another
+// session uses it.
+func (s *Server) acquireSlot(c *Conn, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pid := s.activeSlots[name]; pid != 0 {
+		return Errorf("55006", "replication slot %q is active for PID %d", name, pid) // object_in_use
+	}
+	s.activeSlots[name] = c.pid
+	return nil
+}
+
+// releaseSlot marks a slot no longer in use.
+func (s *Server) releaseSlot(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.activeSlots, name)
+}
+
+// replicationStart returns the slot to stream from & the position to start
+// at: the position the client requests, or the last position confirmed for
+// the slot if that is later.
+func (s *Server) replicationStart(c *Conn, name, kind, start string) (*ReplicationSlot, uint64, error) {
+	lsn, err := parseLSN(start)
+	if err != nil {
+		return nil, 0, Errorf("22P02", "invalid input syntax for type pg_lsn: %q", start) // invalid_text_representation
+	} else if !strings.EqualFold(kind, "LOGICAL") {
+		return nil, 0, Errorf("0A000", "physical replication is not supported") // feature_not_supported
+	} else if err := s.checkLogicalReplication(); err != nil {
+		return nil, 0, err
+	}
+
+	slot, err := s.ChangeLog.Slot(name)
+	if err == ErrSlotNotFound {
+		return nil, 0, Errorf("42704", "replication slot %q does not exist", name) // undefined_object
+	} else if err != nil {
+		return nil, 0, fmt.Errorf("replication slot: %w", err)
+	} else if slot.Database != c.db.Name() {
+		return nil, 0, Errorf("55000", "replication slot %q was not created in this database", name) // object_not_in_prerequisite_state
+	}
+
+	if slot.ConfirmedLSN > lsn {
+		lsn = slot.ConfirmedLSN
+	}
+	return slot, lsn, nil
+}
+
+// startReplication streams the transactions of the change log to the client
+// from lsn until the client ends the stream. Options of the pgoutput plugin,
+// such as publication_names, are accepted but all tables are streamed.
+func (s *Server) startReplication(ctx context.Context, c *Conn, slot *ReplicationSlot, lsn uint64) error {
+	name := slot.Name
+	if err := s.copyStagedChanges(ctx, c); err != nil {
+		return fmt.Errorf("copy staged changes: %w", err)
+	}
+	c.logger.Info("replication started", "slot", name, "lsn", formatLSN(lsn))
+	// pgproto3 omits the overall format of CopyBothResponse so it is encoded
+	// here: textual, with no columns.
+	if _, err := c.Write([]byte{'W', 0, 0, 0, 7, 0, 0, 0}); err != nil {
+		return err
+	}
+
+	// Receive standby status updates until the client ends the stream.
+	msgs, errs, done := make(chan pgproto3.FrontendMessage), make(chan error, 1), make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			msg, err := c.backend.Receive()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-done:
+				return
+			}
+			if _, ok := msg.(*pgproto3.CopyDone); ok {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(replicationKeepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		// Wait for changes once all logged transactions are sent.
+		changed := s.ChangeLog.Changed()
+		txs, err := s.ChangeLog.Transactions(slot.Database, lsn, replicationBatchSize)
+		if err != nil {
+			return fmt.Errorf("read change log: %w", err)
+		}
+		for _, tx := range txs {
+			if err := writeMessages(c, pgoutputMessages(tx)...); err != nil {
+				return err
+			}
+			lsn = tx.LSN
+		}
+		if len(txs) == replicationBatchSize {
+			continue
+		}
+
+		select {
+		case <-changed:
+		case <-ticker.C:
+			if err := writeMessages(c, replicationKeepalive(lsn)); err != nil {
+				return err
+			}
+		case msg := <-msgs:
+			switch msg := msg.(type) {
+			case *pgproto3.CopyData:
+				if len(msg.Data) >= 17 && msg.Data[0] == 'r' { // standby status update
+					if flushed := binary.BigEndian.Uint64(msg.Data[9:17]); flushed > 0 {
+						if err := s.ChangeLog.ConfirmSlot(name, flushed); err != nil {
+							return fmt.Errorf("confirm slot: %w", err)
+						}
+					}
+				}
+			case *pgproto3.CopyDone:
+				c.logger.Info("replication stopped", "slot", name, "lsn", formatLSN(lsn))
+				return writeMessages(c,
+					&pgproto3.CopyDone{},
+					&pgproto3.CommandComplete{CommandTag: []byte("START_REPLICATION")},
+					&pgproto3.ReadyForQuery{TxStatus: 'I'},
+				)
+			default:
+				return fmt.Errorf("unexpected message type during replication: %#v", msg)
+			}
+		case err := <-errs:
+			c.mu.Lock()
+			terminated := c.terminated
+			c.mu.Unlock()
+			if terminated {
+				return writeAdminShutdown(c, "")
+			}
+			return fmt.Errorf("receive message during replication: %w", err)
+		case <-ctx.Done():
+			return writeAdminShutdown(c, "the server is shutting down")
+		}
+	}
+}
+
+// pgoutputMessages returns the messages of the pgoutput protocol, version 1,
+// streaming a transaction: its relations are described before its changes.
+func pgoutputMessages(tx *ChangeTx) []pgproto3.Message {
+	ts := pgTimestamp(tx.CommittedAt)
+	relids := make([]uint32, len(tx.Relations))
+	for i, rel := range tx.Relations {
+		relids[i] = uint32(stableOID("class:" + rel.Name))
+	}
+
+	var msgs [][]byte
+	msgs = append(msgs, appendUint32(appendUint64(appendUint64([]byte{'B'}, tx.LSN), ts), uint32(tx.LSN)))
+	for i, rel := range tx.Relations {
+		buf := appendUint32([]byte{'R'}, relids[i])
+		buf = append(append(buf, "public"...), 0)
+		buf = append(append(buf, rel.Name...), 0)
+		buf = append(buf, 'f') // replica identity full: old rows are sent whole
+		buf = appendUint16(buf, uint16(len(rel.Columns)))
+		for j, col := range rel.Columns {
+			var flags byte
+			if rel.Keys[j] {
+				flags = 1
+			}
+			buf = append(buf, flags)
+			buf = append(append(buf, col...), 0)
+			buf = appendUint32(buf, pgTypeOID(rel.Types[j]))
+			buf = appendUint32(buf, 0xFFFFFFFF) // atttypmod -1
+		}
+		msgs = append(msgs, buf)
+	}
+	for _, change := range tx.Changes {
+		rel := tx.Relations[change.Relation]
+		buf := appendUint32([]byte{strings.ToUpper(change.Op[:1])[0]}, relids[change.Relation])
+		if change.Old != nil {
+			buf = appendTupleData(append(buf, 'O'), rel, change.Old)
+		}
+		if change.New != nil {
+			buf = appendTupleData(append(buf, 'N'), rel, change.New)
+		}
+		msgs = append(msgs, buf)
+	}
+	msgs = append(msgs, appendUint64(appendUint64(appendUint64([]byte{'C', 0}, tx.LSN), tx.LSN), ts))
+
+	// Each message is sent as XLogData at the position of the transaction.
+	a := make([]pgproto3.Message, len(msgs))
+	for i, msg := range msgs {
+		buf := appendUint64(appendUint64(appendUint64([]byte{'w'}, tx.LSN), tx.LSN), pgTimestamp(time.Now()))
+		a[i] = &pgproto3.CopyData{Data: append(buf, msg...)}
+	}
+	return a
+}
+
+// appendTupleData appends the values of a row as pgoutput TupleData in text
+// format.
+func appendTupleData(buf []byte, rel *RelationDef, values []interface{}) []byte {
+	buf = appendUint16(buf, uint16(len(rel.Columns)))
+	for i := range rel.Columns {
+		var v interface{}
+		if i < len(values) {
+			v = values[i]
+		}
+		if v == nil {
+			buf = append(buf, 'n')
+			continue
+		}
+		text := pgTextValue(rel.Types[i], v)
+		buf = append(appendUint32(append(buf, 't'), uint32(len(text))), text...)
+	}
+	return buf
+}
+
+// pgTextValue formats a SQLite value as text for a column's Postgres type.
+func pgTextValue(decl string, v interface{}) string {
+	typ := pgColumnType(decl).udtName
+	switch v := v.(type) {
+	case int64:
+		if typ == "bool" {
+			return strconv.FormatBool(v != 0)[:1]
+		}
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		if typ == "bytea" {
+			return `\x` + hex.EncodeToString(v)
+		}
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// pgTypeOID returns the object ID of the Postgres type of a SQLite column.
+func pgTypeOID(decl string) uint32 {
+	if dt, ok := pgConnInfo.DataTypeForName(pgColumnType(decl).udtName); ok {
+		return dt.OID
+	}
+	return pgtype.TextOID
+}
+
+var pgConnInfo = pgtype.NewConnInfo()
+
+// replicationKeepalive returns a primary keepalive message, which reports the
+// position of the stream & requests a status update.
+func replicationKeepalive(lsn uint64) *pgproto3.CopyData {
+	buf := appendUint64(appendUint64([]byte{'k'}, lsn), pgTimestamp(time.Now()))
+	return &pgproto3.CopyData{Data: append(buf, 1)}
+}
+
+// pgEpoch is the epoch of Postgres timestamps.
+var pgEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// pgTimestamp returns t in microseconds since the Postgres epoch.
+func pgTimestamp(t time.Time) uint64 {
+	return uint64(t.Sub(pgEpoch).Microseconds())
+}
+
+// formatLSN formats a log sequence number as a Postgres pg_lsn, e.g. "0/16B3748".
+func formatLSN(lsn uint64) string {
+	return fmt.Sprintf("%X/%X", lsn>>32, uint32(lsn))
+}
+
+// parseLSN parses a log sequence number formatted as a Postgres pg_lsn.
+func parseLSN(s string) (uint64, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, errors.New("invalid lsn")
+	}
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	return h<<32 | l, nil
+}
+
+func appendUint16(buf []byte, v uint16) []byte { return binary.BigEndian.AppendUint16(buf, v) }
+func appendUint32(buf []byte, v uint32) []byte { return binary.BigEndian.AppendUint32(buf, v) }
+func appendUint64(buf []byte, v uint64) []byte { return binary.BigEndian.AppendUint64(buf, v) }
+
+var (
+	identifySystemRegex        = regexp.MustCompile(`(?is)^\s*IDENTIFY_SYSTEM\s*;?\s*$`)
+	createReplicationSlotRegex = regexp.MustCompile(`(?is)^\s*CREATE_REPLICATION_SLOT\s+(` + roleIdent + `)(\s+TEMPORARY)?\s+(PHYSICAL|LOGICAL)(?:\s+(` + roleIdent + `))?(?:\s+.*?)?\s*;?\s*$`)
+	dropReplicationSlotRegex   = regexp.MustCompile(`(?is)^\s*DROP_REPLICATION_SLOT\s+(` + roleIdent + `)(?:\s+WAIT)?\s*;?\s*$`)
+	startReplicationRegex      = regexp.MustCompile(`(?is)^\s*START_REPLICATION\s+SLOT\s+(` + roleIdent + `)\s+(PHYSICAL|LOGICAL)\s+([0-9A-Fa-f]+/[0-9A-Fa-f]+)(?:\s*\(.*\))?\s*;?\s*$`)
+
+	replicationSlotNameRegex = regexp.MustCompile(`^[a-z0-9_]{1,63}$`)
+
+	// Statements that may write outside of a transaction & statements that
+	// may commit a transaction. Queries of several statements are neither.
+	changeStatementRegex = regexp.MustCompile(`(?is)^\s*(?:INSERT|UPDATE|DELETE|REPLACE|WITH|CREATE)\b[^;]*;?\s*$`)
+	endTransactionRegex  = regexp.MustCompile(`(?is)^\s*(?:COMMIT|END|RELEASE)\b[^;]*;?\s*$`)
+
+	savepointRegex           = regexp.MustCompile(`(?is)^\s*SAVEPOINT\s+(` + roleIdent + `)\s*;?\s*$`)
+	releaseSavepointRegex    = regexp.MustCompile(`(?is)^\s*RELEASE\s+(?:SAVEPOINT\s+)?(` + roleIdent + `)\s*;?\s*$`)
+	rollbackToSavepointRegex = regexp.MustCompile(`(?is)^\s*ROLLBACK\s+(?:TRANSACTION\s+|WORK\s+)?TO\s+(?:SAVEPOINT\s+)?(` + roleIdent + `)\s*;?\s*$`)
+)
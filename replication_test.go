@@ -0,0 +1,113 @@
+//go:build sqlite_preupdate_hook
+
+package postlite_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+	"github.com/jackc/pgproto3/v2"
+)
+
+// Ensure row changes are logged once their transaction commits, are kept if
+// the change log cannot be written when it commits & are streamed to
+// consumers with pgoutput.
+func TestServer_LogicalReplication(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "changes.db")
+	changeLog := postlite.NewChangeLog(path)
+	if err := changeLog.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { changeLog.Close() })
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.ChangeLog, s.MetaStore = changeLog, MustOpenMetaStore(t)
+	})
+
+	repl := MustConnectParams(t, s, map[string]string{"database": "db", "user": "sqlite3", "replication": "database"}, "")
+	repl.MustQuery(`CREATE_REPLICATION_SLOT sub LOGICAL pgoutput`)
+
+	c := MustConnect(t, s, "db", "sqlite3", "")
+	c.MustQuery(`CREATE TABLE t (id INTEGER PRIMARY KEY, x TEXT)`)
+	c.MustQuery(`INSERT INTO t VALUES (1, 'a')`)
+	c.MustQuery(`BEGIN`)
+	c.MustQuery(`UPDATE t SET x = 'b'`)
+	c.MustQuery(`INSERT INTO t VALUES (2, 'c')`)
+	c.MustQuery(`COMMIT`)
+	c.MustQuery(`BEGIN`)
+	c.MustQuery(`DELETE FROM t`)
+	c.MustQuery(`ROLLBACK`)
+	if ops := changeOps(t, changeLog, 0); !reflect.DeepEqual(ops, [][]string{{"insert"}, {"update", "insert"}}) {
+		t.Fatalf("ops=%v", ops)
+	}
+
+	// Transactions committed while the change log rejects them are staged
+	// in the database & logged by the next one.
+	other, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := other.Exec(`CREATE TRIGGER fail BEFORE INSERT ON transactions BEGIN SELECT RAISE(FAIL, 'unavailable'); END`); err != nil {
+		t.Fatal(err)
+	}
+	c.MustQuery(`INSERT INTO t VALUES (3, 'd')`)
+	if _, err := c.Query(`INSERT INTO t VALUES (4, 'e'); INSERT INTO t VALUES (5, 'f')`); err == nil {
+		t.Fatal("expected error for changes that cannot be logged")
+	}
+	if _, err := other.Exec(`DROP TRIGGER fail`); err != nil {
+		t.Fatal(err)
+	}
+	c.MustQuery(`DELETE FROM t WHERE id = 1`)
+	if ops := changeOps(t, changeLog, 0); !reflect.DeepEqual(ops, [][]string{{"insert"}, {"update", "insert"}, {"insert"}, {"delete"}}) {
+		t.Fatalf("ops=%v", ops)
+	}
+
+	// Staged changes are only visible to the server, even if granted.
+	c.MustQuery(`CREATE ROLE low LOGIN`)
+	c.MustQuery(`GRANT ALL ON ALL TABLES IN SCHEMA public TO low`)
+	low := MustConnect(t, s, "db", "low", "")
+	low.MustQuery(`INSERT INTO t VALUES (6, 'g')`)
+	low.MustFailQuery(`SELECT * FROM postlite_changes`, "42501")
+	low.MustFailQuery(`DELETE FROM postlite_changes`, "42501")
+	if ops := changeOps(t, changeLog, 0); len(ops) != 5 {
+		t.Fatalf("ops=%v", ops)
+	}
+
+	// Consumers receive each transaction between begin & commit messages.
+	if _, err := repl.Write((&pgproto3.Query{String: `START_REPLICATION SLOT sub LOGICAL 0/0 (proto_version '1', publication_names 'pub')`}).Encode(nil)); err != nil {
+		t.Fatal(err)
+	}
+	var types []byte
+	for len(types) < 3 || types[len(types)-1] != 'C' {
+		msg, err := repl.frontend.Receive()
+		if err != nil {
+			t.Fatal(err)
+		} else if msg, ok := msg.(*pgproto3.CopyData); ok && msg.Data[0] == 'w' {
+			types = append(types, msg.Data[25])
+		}
+	}
+	if string(types) != "BRIC" {
+		t.Fatalf("messages=%q, want BRIC", types)
+	}
+}
+
+// changeOps returns the operations of each transaction of db logged after lsn.
+func changeOps(tb testing.TB, l *postlite.ChangeLog, lsn uint64) [][]string {
+	tb.Helper()
+	txs, err := l.Transactions("db", lsn, 100)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var a [][]string
+	for _, tx := range txs {
+		var ops []string
+		for _, change := range tx.Changes {
+			ops = append(ops, change.Op)
+		}
+		a = append(a, ops)
+	}
+	return a
+}
@@ -0,0 +1,635 @@
+package postlite
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// rowSecurity holds the temporary objects that apply row-level security
+// policies on a session's connection.
+//
+// Each table with row-level security is shadowed by a temporary view of the
+// same name that only returns the rows visible to the session's role, so
+// statements reading the table read the view. Statements writing the table
+// are rewritten to write the table itself, where temporary triggers skip the
+// rows the role may not change & reject the rows it may not write.
+//
+// Views of the main database reading the table would read the table itself,
+// so they are shadowed by temporary copies reading the temporary views.
+// Triggers of the main database cannot be shadowed, so the tables they are on
+// may not be written if they read a table with row-level security. The
+// authorizer prevents the session from dropping the objects.
+type rowSecurity struct {
+	signature string            // statements that created the objects
+	tables    map[string]bool   // shadowed tables, by lower-case name
+	triggers  map[string]string // triggers reading shadowed tables, by lower-case name of their table
+	objects   []rowSecurityItem // objects created, in order
+}
+
+// rowSecurityItem is a temporary view or trigger applying row-level security.
+type rowSecurityItem struct {
+	typ    string // "view" or "trigger"
+	name   string
+	create string
+}
+
+// applyRowSecurity applies the row-level security policies of the session's
+// role before it runs a statement. Returns the statement to run.
+func (s *Server) applyRowSecurity(ctx context.Context, c *Conn, query string) (string, error) {
+	if s.MetaStore == nil {
+		return query, nil
+	}
+
+	// Objects are only changed between transactions so a rollback cannot
+	// remove them.
+	if !c.inTransaction() {
+		if err := s.updateRowSecurity(ctx, c); err != nil {
+			return "", err
+		}
+	}
+	if c.rowSecurity == nil || len(c.rowSecurity.tables) == 0 {
+		return query, nil
+	}
+
+	// Tables qualified with their schema would be read without the views.
+	if mainSchemaRegex.MatchString(query) {
+		return "", Errorf("42501", "schema %q cannot be referenced when row-level security applies", "main") // insufficient_privilege
+	}
+	if m := writeTargetRegex.FindStringSubmatchIndex(query); m != nil && c.rowSecurity.tables[strings.ToLower(identName(query[m[2]:m[3]]))] {
+		query = query[:m[2]] + "main." + query[m[2]:]
+	}
+	return query, nil
+}
+
+// updateRowSecurity recreates the objects applying row-level security on the
+// session's connection if the policies that apply to it have changed or any
+// of the objects no longer exist.
+func (s *Server) updateRowSecurity(ctx context.Context, c *Conn) error {
+	items, tables, triggers, err := s.rowSecurityItems(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	var stmts []string
+	for _, item := range items {
+		stmts = append(stmts, item.create)
+	}
+	signature := strings.Join(stmts, ";\n")
+	if c.rowSecurity == nil && len(items) == 0 {
+		return nil
+	} else if c.rowSecurity != nil && c.rowSecurity.signature == signature {
+		if ok, err := c.rowSecurityExists(ctx); err != nil || ok {
+			return err
+		}
+	}
+
+	// Objects are changed without the session's privileges, which would
+	// prevent dropping them, & regardless of whether it is read-only.
+	c.mu.Lock()
+	c.privileges = nil
+	c.mu.Unlock()
+	if c.queryOnly {
+		if _, err := c.conn.ExecContext(ctx, `PRAGMA query_only = OFF`); err != nil {
+			return err
+		}
+		defer c.conn.ExecContext(ctx, `PRAGMA query_only = ON`)
+	}
+
+	if err := c.dropRowSecurity(ctx); err != nil {
+		return err
+	}
+	rs := &rowSecurity{tables: tables, triggers: triggers}
+	c.rowSecurity = rs
+	for _, item := range items {
+		if _, err := c.conn.ExecContext(ctx, item.create); err != nil {
+			return fmt.Errorf("apply row-level security: %w", err)
+		}
+		rs.objects = append(rs.objects, item)
+	}
+	rs.signature = signature
+	return nil
+}
+
+// rowSecurityExists returns true if all objects applying row-level security
+// on the session's connection exist.
+func (c *Conn) rowSecurityExists(ctx context.Context) (bool, error) {
+	rows, err := c.conn.QueryContext(ctx, `SELECT type, name FROM temp.sqlite_schema`)
+	if err != nil {
+		return false, fmt.Errorf("row-level security objects: %w", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[rowSecurityItem]bool)
+	for rows.Next() {
+		var item rowSecurityItem
+		if err := rows.Scan(&item.typ, &item.name); err != nil {
+			return false, fmt.Errorf("row-level security objects: %w", err)
+		}
+		existing[item] = true
+	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("row-level security objects: %w", err)
+	}
+
+	for _, item := range c.rowSecurity.objects {
+		if !existing[rowSecurityItem{typ: item.typ, name: item.name}] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// rowSecurityObjects returns the names of the objects applying row-level
+// security on the session's connection & the triggers of the main database
+// reading the tables they shadow, by lower-case name of their table.
+func (c *Conn) rowSecurityObjects() (objects map[string]bool, triggers map[string]string) {
+	if c.rowSecurity == nil {
+		return nil, nil
+	}
+	objects = make(map[string]bool)
+	for _, item := range c.rowSecurity.objects {
+		objects[strings.ToLower(item.name)] = true
+	}
+	return objects, c.rowSecurity.triggers
+}
+
+// dropRowSecurity drops the objects applying row-level security on the
+// session's connection so they do not apply to the next session using it.
+func (c *Conn) dropRowSecurity(ctx context.Context) error {
+	if c.rowSecurity == nil {
+		return nil
+	}
+	for i := len(c.rowSecurity.objects) - 1; i >= 0; i-- {
+		item := c.rowSecurity.objects[i]
+		if _, err := c.conn.ExecContext(ctx, fmt.Sprintf(`DROP %s IF EXISTS temp.%q`, strings.ToUpper(item.typ), item.name)); err != nil {
+			return fmt.Errorf("drop row-level security: %w", err)
+		}
+	}
+	c.rowSecurity = nil
+	return nil
+}
+
+// rowSecurityItems returns the objects applying the row-level security
+// policies of the session's database to its role, the tables they apply to &
+// the triggers reading them. Superusers & roles with BYPASSRLS bypass
+// row-level security.
+func (s *Server) rowSecurityItems(ctx context.Context, c *Conn) ([]rowSecurityItem, map[string]bool, map[string]string, error) {
+	role, err := s.sessionRole(c)
+	if err != nil {
+		return nil, nil, nil, err
+	} else if role.Superuser || role.BypassRLS {
+		return nil, nil, nil, nil
+	}
+
+	states, err := s.MetaStore.RowSecurity(c.db.Name())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("row security: %w", err)
+	}
+	policies, err := s.MetaStore.Policies(c.db.Name())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("policies: %w", err)
+	}
+	memberships, err := s.roleMemberships()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	roles := map[string]bool{publicGrantee: true}
+	for _, name := range memberOf(memberships, role.Name) {
+		roles[name] = true
+	}
+
+	var items []rowSecurityItem
+	tables := make(map[string]bool)
+	for _, state := range states {
+		if !state.Enabled {
+			continue
+		}
+		cols, err := mainTableColumns(ctx, c, state.Table)
+		if err != nil {
+			return nil, nil, nil, err
+		} else if len(cols) == 0 { // dropped
+			continue
+		}
+
+		var applied []*Policy
+		for _, p := range policies {
+			if !strings.EqualFold(p.Table, state.Table) {
+				continue
+			}
+			for _, name := range p.Roles {
+				if roles[name] {
+					applied = append(applied, p)
+					break
+				}
+			}
+		}
+		items = append(items, tableRowSecurityItems(state.Table, cols, applied)...)
+		tables[strings.ToLower(state.Table)] = true
+	}
+	if len(tables) == 0 {
+		return items, tables, nil, nil
+	}
+
+	views, triggers, err := dependentRowSecurityItems(ctx, c, tables)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return append(items, views...), tables, triggers, nil
+}
+
+// dependentRowSecurityItems returns temporary copies of the views of the main
+// database that read tables with row-level security, directly or through
+// other views, so they read the temporary views instead. Also returns the
+// triggers of the main database reading those tables or views, by lower-case
+// name of the table they are on.
+//
+// References are found from the identifiers in the objects' SQL, so views
+// with a column of the same name as a table are copied too, which is harmless.
+func dependentRowSecurityItems(ctx context.Context, c *Conn, tables map[string]bool) ([]rowSecurityItem, map[string]string, error) {
+	type object struct {
+		typ, name, table, sql string
+		idents                []string
+	}
+	rows, err := c.conn.QueryContext(ctx, `SELECT type, name, tbl_name, sql FROM main.sqlite_schema WHERE type IN ('view', 'trigger') AND sql IS NOT NULL ORDER BY rowid`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dependent objects: %w", err)
+	}
+	defer rows.Close()
+
+	var objects []*object
+	for rows.Next() {
+		var obj object
+		if err := rows.Scan(&obj.typ, &obj.name, &obj.table, &obj.sql); err != nil {
+			return nil, nil, fmt.Errorf("dependent objects: %w", err)
+		}
+		obj.idents = sqlIdents(obj.sql)
+		if obj.typ == "trigger" {
+			obj.idents = triggerBodyIdents(obj.idents)
+		}
+		objects = append(objects, &obj)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("dependent objects: %w", err)
+	}
+
+	reads := func(obj *object, names map[string]bool) bool {
+		for _, ident := range obj.idents {
+			if names[ident] {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Add views reading the shadowed tables & views until none are left.
+	shadowed := make(map[string]bool)
+	for name := range tables {
+		shadowed[name] = true
+	}
+	copied := make(map[*object]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, obj := range objects {
+			if obj.typ == "view" && !copied[obj] && reads(obj, shadowed) {
+				copied[obj], changed = true, true
+				shadowed[strings.ToLower(obj.name)] = true
+			}
+		}
+	}
+
+	var items []rowSecurityItem
+	triggers := make(map[string]string)
+	for _, obj := range objects {
+		if copied[obj] {
+			// Schema qualifiers are removed so the copy reads temporary views.
+			def := mainSchemaRegex.ReplaceAllString(obj.sql[len(viewHeaderRegex.FindString(obj.sql)):], "")
+			items = append(items, rowSecurityItem{typ: "view", name: obj.name, create: fmt.Sprintf(`CREATE TEMP VIEW %q%s`, obj.name, def)})
+		} else if obj.typ == "trigger" && reads(obj, shadowed) {
+			triggers[strings.ToLower(obj.table)] = obj.name
+		}
+	}
+	return items, triggers, nil
+}
+
+// sqlIdents returns the identifiers & keywords of a SQL statement, unquoted &
+// in lower case as SQLite identifiers are case-insensitive, along with the
+// dots qualifying them. String literals & comments are skipped.
+func sqlIdents(sql string) []string {
+	var idents []string
+	for _, token := range sqlTokenRegex.FindAllString(sql, -1) {
+		switch token[0] {
+		case '\'', '-', '/':
+			continue
+		case '"', '`':
+			q := token[:1]
+			token = strings.ReplaceAll(token[1:len(token)-1], q+q, q)
+		case '[':
+			token = token[1 : len(token)-1]
+		}
+		idents = append(idents, strings.ToLower(token))
+	}
+	return idents
+}
+
+// triggerBodyIdents returns the identifiers of a trigger following the table
+// it is on, which is read by its WHEN clause & statements.
+func triggerBodyIdents(idents []string) []string {
+	for i, ident := range idents {
+		if ident != "on" {
+			continue
+		} else if i+3 < len(idents) && idents[i+2] == "." {
+			return idents[i+4:]
+		} else if i+1 < len(idents) {
+			return idents[i+2:]
+		}
+	}
+	return idents
+}
+
+// tableRowSecurityItems returns the view & triggers applying policies to a
+// table. Rows are checked against the policies' expressions by selecting the
+// row's values under the column names.
+func tableRowSecurityItems(table string, cols []string, policies []*Policy) []rowSecurityItem {
+	row := func(ref string) string {
+		values := make([]string, len(cols))
+		for i, col := range cols {
+			values[i] = fmt.Sprintf("%s.%q AS %q", ref, col, col)
+		}
+		return fmt.Sprintf("(SELECT %s) AS %q", strings.Join(values, ", "), table)
+	}
+	violation := strings.ReplaceAll(fmt.Sprintf("new row violates row-level security policy for table %q", table), "'", "''")
+
+	using := func(p *Policy) string { return p.Using }
+	check := func(p *Policy) string {
+		if p.Check != "" {
+			return p.Check
+		}
+		return p.Using
+	}
+
+	name := func(op string) string { return "postlite_rls_" + table + "_" + op }
+	return []rowSecurityItem{
+		{
+			typ:  "view",
+			name: table,
+			create: fmt.Sprintf(`CREATE TEMP VIEW %q AS SELECT * FROM main.%q AS %q WHERE %s`,
+				table, table, table, policyFilter(policies, "SELECT", using)),
+		},
+		{
+			typ:  "trigger",
+			name: name("insert"),
+			create: fmt.Sprintf(`CREATE TEMP TRIGGER %q BEFORE INSERT ON main.%q BEGIN SELECT RAISE(ABORT, '%s') WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s); END`,
+				name("insert"), table, violation, row("NEW"), policyFilter(policies, "INSERT", check)),
+		},
+		{
+			typ:  "trigger",
+			name: name("update"),
+			create: fmt.Sprintf(`CREATE TEMP TRIGGER %q BEFORE UPDATE ON main.%q BEGIN SELECT RAISE(IGNORE) WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s); SELECT RAISE(ABORT, '%s') WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s); END`,
+				name("update"), table, row("OLD"), policyFilter(policies, "UPDATE", using), violation, row("NEW"), policyFilter(policies, "UPDATE", check)),
+		},
+		{
+			typ:  "trigger",
+			name: name("delete"),
+			create: fmt.Sprintf(`CREATE TEMP TRIGGER %q BEFORE DELETE ON main.%q BEGIN SELECT RAISE(IGNORE) WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s); END`,
+				name("delete"), table, row("OLD"), policyFilter(policies, "DELETE", using)),
+		},
+	}
+}
+
+// policyFilter combines the expressions of the policies for a command:
+// permissive policies with OR & restrictive policies with AND. If no
+// permissive policy applies, no rows are allowed, as in Postgres.
+func policyFilter(policies []*Policy, command string, expr func(*Policy) string) string {
+	var permissive, restrictive []string
+	for _, p := range policies {
+		e := expr(p)
+		if e == "" || (p.Command != "ALL" && p.Command != command) {
+			continue
+		}
+		e = "(" + policyExpr(e) + ")"
+		if p.Permissive {
+			permissive = append(permissive, e)
+		} else {
+			restrictive = append(restrictive, e)
+		}
+	}
+	if len(permissive) == 0 {
+		return "0"
+	}
+	return strings.Join(append([]string{"(" + strings.Join(permissive, " OR ") + ")"}, restrictive...), " AND ")
+}
+
+// policyExpr translates a Postgres policy expression for SQLite: system
+// information variables are rewritten as functions & casts are removed, as
+// SQLite compares values with the affinity of the column.
+func policyExpr(expr string) string {
+	expr = systemFunctionRegex.ReplaceAllString(expr, "$1()$2")
+	return policyCastRegex.ReplaceAllString(expr, "")
+}
+
+// mainTableColumns returns the names of the columns of a table of the main
+// database. Returns nil if the table does not exist.
+func mainTableColumns(ctx context.Context, c *Conn, table string) ([]string, error) {
+	rows, err := c.conn.QueryContext(ctx, `SELECT name FROM pragma_table_xinfo(?, 'main') WHERE hidden <> 1 ORDER BY cid`, table)
+	if err != nil {
+		return nil, fmt.Errorf("table info %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, fmt.Errorf("table info %s: %w", table, err)
+		}
+		cols = append(cols, col)
+	}
+	return cols, rows.Err()
+}
+
+// handleRowSecurityStatement handles CREATE POLICY, DROP POLICY & ALTER TABLE
+// statements changing row-level security, which are kept in the metastore.
+// Returns false if the query is not a row-level security statement.
+func (s *Server) handleRowSecurityStatement(c *Conn, w *resultBuffer, query string) (bool, error) {
+	var tag string
+	var notices []*pgproto3.NoticeResponse
+	var err error
+	if m := createPolicyRegex.FindStringSubmatch(query); m != nil {
+		tag = "CREATE POLICY"
+		err = s.createPolicy(c, &Policy{
+			Name:       identName(m[1]),
+			Permissive: !strings.EqualFold(m[3], "RESTRICTIVE"),
+			Command:    strings.ToUpper(m[4]),
+			Roles:      roleNames(m[5]),
+			Using:      strings.TrimSpace(m[6]),
+			Check:      strings.TrimSpace(m[7]),
+		}, m[2])
+	} else if m := dropPolicyRegex.FindStringSubmatch(query); m != nil {
+		tag = "DROP POLICY"
+		notices, err = s.dropPolicy(c, identName(m[2]), m[3], m[1] != "")
+	} else if m := alterRowSecurityRegex.FindStringSubmatch(query); m != nil {
+		tag, err = "ALTER TABLE", s.alterRowSecurity(c, m[1], strings.ToUpper(strings.Join(strings.Fields(m[2]), " ")))
+	} else {
+		return false, nil
+	}
+	w.notices = append(w.notices, notices...)
+	w.SetCommandTag(tag)
+	return true, err
+}
+
+// checkTableOwner returns an error if the session does not own a table. Tables
+// are owned by the bootstrap superuser, so only superusers own them.
+func (s *Server) checkTableOwner(c *Conn, table string) error {
+	role, err := s.sessionRole(c)
+	if err != nil {
+		return err
+	} else if !role.Superuser {
+		return Errorf("42501", "must be owner of table %s", table) // insufficient_privilege
+	}
+	return nil
+}
+
+func (s *Server) createPolicy(c *Conn, p *Policy, ident string) error {
+	if err := s.checkMetaStoreChange(c, "CREATE POLICY"); err != nil {
+		return err
+	}
+	table, err := lookupRelation(c.sqliteConn, ident)
+	if err != nil {
+		return err
+	} else if err := s.checkTableOwner(c, table); err != nil {
+		return err
+	}
+	p.Database, p.Table = c.db.Name(), table
+
+	if p.Command == "" {
+		p.Command = "ALL"
+	}
+	if len(p.Roles) == 0 {
+		p.Roles = []string{publicGrantee}
+	}
+	switch {
+	case p.Command == "INSERT" && p.Using != "":
+		return Errorf("42601", "only WITH CHECK expression allowed for INSERT") // syntax_error
+	case (p.Command == "SELECT" || p.Command == "DELETE") && p.Check != "":
+		return Errorf("42601", "WITH CHECK cannot be applied to SELECT or DELETE")
+	}
+	for _, name := range p.Roles {
+		if name == publicGrantee {
+			continue
+		} else if role, err := s.role(s.auth(), name); err != nil {
+			return err
+		} else if role == nil {
+			return Errorf("42704", "role %q does not exist", name) // undefined_object
+		}
+	}
+
+	// Check the expressions are valid for the table.
+	for _, expr := range []string{p.Using, p.Check} {
+		if expr == "" {
+			continue
+		}
+		stmt, err := c.sqliteConn.Prepare(fmt.Sprintf(`SELECT 1 FROM main.%q AS %q WHERE (%s)`, table, table, policyExpr(expr)))
+		if err != nil {
+			return Errorf("42601", "invalid policy expression: %s", err)
+		}
+		stmt.Close()
+	}
+
+	if err := s.MetaStore.CreatePolicy(p); err == ErrPolicyExists {
+		return Errorf("42710", "policy %q for table %q already exists", p.Name, table) // duplicate_object
+	} else if err != nil {
+		return fmt.Errorf("create policy: %w", err)
+	}
+	c.logger.Info("policy created", "policy", p.Name, "table", table)
+	return nil
+}
+
+func (s *Server) dropPolicy(c *Conn, name, ident string, ifExists bool) ([]*pgproto3.NoticeResponse, error) {
+	if err := s.checkMetaStoreChange(c, "DROP POLICY"); err != nil {
+		return nil, err
+	}
+	table, err := lookupRelation(c.sqliteConn, ident)
+	if err != nil {
+		return nil, err
+	} else if err := s.checkTableOwner(c, table); err != nil {
+		return nil, err
+	}
+
+	if ok, err := s.MetaStore.DropPolicy(c.db.Name(), table, name); err != nil {
+		return nil, fmt.Errorf("drop policy: %w", err)
+	} else if !ok && ifExists {
+		return []*pgproto3.NoticeResponse{{Severity: "NOTICE", Code: "00000", Message: fmt.Sprintf("policy %q for relation %q does not exist, skipping", name, table)}}, nil
+	} else if !ok {
+		return nil, Errorf("42704", "policy %q for table %q does not exist", name, table)
+	}
+	c.logger.Info("policy dropped", "policy", name, "table", table)
+	return nil, nil
+}
+
+func (s *Server) alterRowSecurity(c *Conn, ident, action string) error {
+	if err := s.checkMetaStoreChange(c, "ALTER TABLE"); err != nil {
+		return err
+	}
+	table, err := lookupRelation(c.sqliteConn, ident)
+	if err != nil {
+		return err
+	} else if err := s.checkTableOwner(c, table); err != nil {
+		return err
+	}
+
+	states, err := s.MetaStore.RowSecurity(c.db.Name())
+	if err != nil {
+		return fmt.Errorf("row security: %w", err)
+	}
+	state := &RowSecurity{Database: c.db.Name(), Table: table}
+	for _, existing := range states {
+		if strings.EqualFold(existing.Table, table) {
+			state = existing
+		}
+	}
+
+	switch action {
+	case "ENABLE":
+		state.Enabled = true
+	case "DISABLE":
+		state.Enabled = false
+	case "FORCE":
+		state.Forced = true
+	case "NO FORCE":
+		state.Forced = false
+	}
+	if err := s.MetaStore.SetRowSecurity(state); err != nil {
+		return fmt.Errorf("alter table: %w", err)
+	}
+	c.logger.Info("row security altered", "table", table, "action", action)
+	return nil
+}
+
+// sqlIdent matches an identifier, quoted in any of the ways SQLite accepts.
+const sqlIdent = "(?:\"(?:[^\"]|\"\")*\"|`(?:[^`]|``)*`|\\[[^\\]]*\\]|[\\w$]+)"
+
+var (
+	createPolicyRegex     = regexp.MustCompile(`(?is)^\s*CREATE\s+POLICY\s+(` + roleIdent + `)\s+ON\s+(` + relationIdentList + `)(?:\s+AS\s+(PERMISSIVE|RESTRICTIVE))?(?:\s+FOR\s+(ALL|SELECT|INSERT|UPDATE|DELETE))?(?:\s+TO\s+(` + roleIdentList + `))?(?:\s+USING\s*\((.*?)\))?(?:\s+WITH\s+CHECK\s*\((.*)\))?\s*;?\s*$`)
+	dropPolicyRegex       = regexp.MustCompile(`(?is)^\s*DROP\s+POLICY\s+(IF\s+EXISTS\s+)?(` + roleIdent + `)\s+ON\s+((?:` + roleIdent + `\.)?` + roleIdent + `)(?:\s+(?:CASCADE|RESTRICT))?\s*;?\s*$`)
+	alterRowSecurityRegex = regexp.MustCompile(`(?is)^\s*ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?((?:` + roleIdent + `\.)?` + roleIdent + `)\s+(ENABLE|DISABLE|FORCE|NO\s+FORCE)\s+ROW\s+LEVEL\s+SECURITY\s*;?\s*$`)
+
+	// Schema qualifiers of the main database, including quoted ones &
+	// comments before the dot.
+	mainSchemaRegex = regexp.MustCompile("(?is)(?:\\bmain\\b|\"main\"|\\[main\\]|`main`)\\s*(?:/\\*.*?\\*/\\s*|--[^\\n]*\\n\\s*)*\\.")
+
+	// Header of a CREATE VIEW statement, up to & including its name.
+	viewHeaderRegex = regexp.MustCompile("(?is)^\\s*CREATE\\s+VIEW\\s+(?:IF\\s+NOT\\s+EXISTS\\s+)?(?:" + sqlIdent + "\\s*\\.\\s*)?" + sqlIdent)
+
+	// Tokens of a SQL statement: string literals, quoted identifiers,
+	// comments, words & dots.
+	sqlTokenRegex = regexp.MustCompile("(?s)'(?:[^']|'')*'|\"(?:[^\"]|\"\")*\"|`(?:[^`]|``)*`|\\[[^\\]]*\\]|--[^\\n]*|/\\*.*?(?:\\*/|$)|[\\w$]+|\\.")
+
+	// Table written by an INSERT, UPDATE or DELETE statement.
+	writeTargetRegex = regexp.MustCompile(`(?is)^\s*(?:INSERT(?:\s+OR\s+\w+)?\s+INTO|REPLACE\s+INTO|UPDATE(?:\s+OR\s+\w+)?|DELETE\s+FROM)\s+("[^"]+"|[\w$]+)`)
+
+	policyCastRegex = regexp.MustCompile(`::\s*\w+(?:\s+\w+)?(?:\s*\(\s*\d+(?:\s*,\s*\d+)?\s*\))?(?:\[\])?`)
+)
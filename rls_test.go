@@ -0,0 +1,65 @@
+package postlite_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure row-level security policies cannot be bypassed through views,
+// triggers or by dropping the objects that apply them.
+func TestServer_RowSecurity(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) { s.MetaStore = MustOpenMetaStore(t) })
+
+	admin := MustConnect(t, s, "db", "sqlite3", "")
+	admin.MustQuery(`CREATE TABLE docs (tenant TEXT, body TEXT)`)
+	admin.MustQuery(`INSERT INTO docs VALUES ('a', 'a1'), ('b', 'b1')`)
+	admin.MustQuery(`CREATE VIEW docs_view AS SELECT * FROM docs`)
+	admin.MustQuery(`CREATE VIEW docs_view2 AS SELECT body FROM "docs_view"`)
+	admin.MustQuery(`CREATE TABLE log (body TEXT)`)
+	admin.MustQuery(`CREATE TRIGGER log_copy AFTER INSERT ON log BEGIN INSERT INTO log SELECT body FROM docs WHERE NEW.body = 'copy'; END`)
+	admin.MustQuery(`CREATE ROLE low LOGIN`)
+	admin.MustQuery(`GRANT SELECT, INSERT, UPDATE, DELETE ON docs, docs_view, docs_view2, log TO low`)
+	admin.MustQueryExtended(`CREATE POLICY tenant_isolation ON docs USING (tenant = current_setting('app.tenant'))`)
+	admin.MustQueryExtended(`ALTER TABLE docs ENABLE ROW LEVEL SECURITY`)
+
+	low := MustConnect(t, s, "db", "low", "")
+	low.MustQuery(`SET app.tenant = 'a'`)
+	want := [][]string{{"a1"}}
+	for _, query := range []string{
+		`SELECT body FROM docs`,
+		`SELECT body FROM docs_view`,
+		`SELECT body FROM docs_view2`,
+		`SELECT (SELECT group_concat(body) FROM docs_view)`,
+	} {
+		if rows := low.MustQuery(query); !reflect.DeepEqual(rows, want) {
+			t.Fatalf("%s: rows=%v, want %v", query, rows, want)
+		}
+	}
+	if rows := low.MustQuery(`SELECT count(*) FROM docs_view`); rows[0][0] != "1" {
+		t.Fatalf("count=%s, want 1", rows[0][0])
+	}
+	low.MustFailQuery(`SELECT body FROM main.docs`, "42501")
+
+	// The objects applying policies may not be dropped.
+	low.MustFailQuery(`DROP VIEW temp.docs`, "42501")
+	low.MustFailQuery(`DROP VIEW docs_view`, "42501")
+	low.MustFailQuery(`DROP TRIGGER postlite_rls_docs_insert`, "42501")
+	if rows := low.MustQuery(`SELECT body FROM docs`); !reflect.DeepEqual(rows, want) {
+		t.Fatalf("rows=%v, want %v", rows, want)
+	}
+
+	// Triggers of the main database read tables without policies.
+	low.MustFailQuery(`INSERT INTO log VALUES ('copy')`, "42501")
+
+	// Writes are limited to visible rows.
+	low.MustQuery(`UPDATE docs SET body = 'x'`)
+	if rows := admin.MustQuery(`SELECT body FROM docs ORDER BY tenant`); !reflect.DeepEqual(rows, [][]string{{"x"}, {"b1"}}) {
+		t.Fatalf("rows=%v", rows)
+	}
+	if _, err := low.Query(`INSERT INTO docs VALUES ('b', 'b2')`); err == nil || !strings.Contains(err.Error(), "violates row-level security policy") {
+		t.Fatalf("expected policy violation, got %v", err)
+	}
+}
@@ -0,0 +1,634 @@
+package postlite
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/mattn/go-sqlite3"
+)
+
+// bootstrapRole is the superuser that owns the catalog, reported with object
+// ID 10 as in Postgres. Sessions run as it if no user name is known.
+const bootstrapRole = "sqlite3"
+
+// roles returns all roles sorted by name: the bootstrap superuser, the users of
+// auth, which are superusers, & the roles in the server's metastore.
+func (s *Server) roles(auth *Auth) ([]*Role, error) {
+	roles := []*Role{{
+		Name: bootstrapRole, Superuser: true, Inherit: true, CreateRole: true, CreateDB: true,
+		Login: true, Replication: true, BypassRLS: true, ConnLimit: -1,
+	}}
+	names := map[string]bool{bootstrapRole: true}
+	for _, u := range auth.Users {
+		if u.Name == bootstrapRole {
+			roles[0].Password = u.md5Hash()
+			continue
+		}
+		roles = append(roles, &Role{
+			Name: u.Name, Password: u.md5Hash(), Superuser: true, Inherit: true, CreateRole: true, CreateDB: true,
+			Login: true, Replication: true, BypassRLS: true, ConnLimit: -1,
+		})
+		names[u.Name] = true
+	}
+
+	if s.MetaStore != nil {
+		stored, err := s.MetaStore.Roles()
+		if err != nil {
+			return nil, fmt.Errorf("roles: %w", err)
+		}
+		for _, role := range stored {
+			if !names[role.Name] {
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+// role returns the role with the given name. Returns nil if not found.
+func (s *Server) role(auth *Auth, name string) (*Role, error) {
+	roles, err := s.roles(auth)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		if role.Name == name {
+			return role, nil
+		}
+	}
+	return nil, nil
+}
+
+// isConfigRole returns true if the role is the bootstrap superuser or a user
+// of auth. These roles cannot be changed with SQL statements.
+func isConfigRole(auth *Auth, name string) bool {
+	return name == bootstrapRole || auth.User(name) != nil
+}
+
+// roleOID returns the object ID of a role.
+func roleOID(name string) int {
+	if name == bootstrapRole {
+		return 10
+	}
+	return stableOID("role:" + name)
+}
+
+// authenticateRole performs password authentication if any role has a
+// password. Roles without a password cannot log in once passwords are used.
+func (s *Server) authenticateRole(c *Conn, auth *Auth, name string) error {
+	roles, err := s.roles(auth)
+	if err != nil {
+		return err
+	}
+
+	var u *User
+	var passwords bool
+	for _, role := range roles {
+		if role.Password == "" {
+			continue
+		}
+		passwords = true
+		if role.Name == name && role.canLogin(time.Now()) {
+			u = &User{Name: role.Name, Password: role.Password}
+		}
+	}
+	if !passwords {
+		return nil
+	}
+	return s.authenticatePassword(c, u, name)
+}
+
+// checkRoleLogin returns an error if the role does not exist or may not log
+// in. Clients may connect as any user until roles are defined.
+func (s *Server) checkRoleLogin(c *Conn, auth *Auth, name string) error {
+	roles, err := s.roles(auth)
+	if err != nil {
+		return err
+	}
+
+	var role *Role
+	for _, r := range roles {
+		if r.Name == name {
+			role = r
+		}
+	}
+	switch {
+	case role == nil && len(roles) == 1:
+		return nil
+	case role == nil:
+		c.metrics.authFailed("role")
+		return writeFatal(c, "28000", fmt.Sprintf("role %q does not exist", name)) // invalid_authorization_specification
+	case !role.canLogin(time.Now()):
+		c.metrics.authFailed("role")
+		return writeFatal(c, "28000", fmt.Sprintf("role %q is not permitted to log in", name))
+	}
+
+	if role.ConnLimit >= 0 {
+		s.mu.Lock()
+		var n int
+		for other := range s.conns {
+			if other != c && other.sessionUser() == name {
+				n++
+			}
+		}
+		s.mu.Unlock()
+
+		if n >= role.ConnLimit {
+			return writeFatal(c, "53300", fmt.Sprintf("too many connections for role %q", name)) // too_many_connections
+		}
+	}
+	return nil
+}
+
+// sessionRole returns the role of the session's user. Users that are not
+// roles connected before any roles were defined and act as the bootstrap
+// superuser so they can create the first roles.
+func (s *Server) sessionRole(c *Conn) (*Role, error) {
+	name := c.sessionUser()
+	if role, err := s.role(s.auth(), name); err != nil || role != nil {
+		return role, err
+	}
+	return &Role{Name: name, Superuser: true, Inherit: true, CreateRole: true, CreateDB: true, ConnLimit: -1}, nil
+}
+
+// memberOf returns the names of the roles that a role is a member of, directly
+// or through other roles, including the role itself.
+func memberOf(memberships []*RoleMembership, name string) []string {
+	roles := []string{name}
+	seen := map[string]bool{name: true}
+	for i := 0; i < len(roles); i++ {
+		for _, m := range memberships {
+			if m.Member == roles[i] && !seen[m.Role] {
+				seen[m.Role] = true
+				roles = append(roles, m.Role)
+			}
+		}
+	}
+	return roles
+}
+
+// roleMemberships returns the memberships in the metastore, if any.
+func (s *Server) roleMemberships() ([]*RoleMembership, error) {
+	if s.MetaStore == nil {
+		return nil, nil
+	}
+	return s.MetaStore.RoleMemberships()
+}
+
+// currentUser returns a SQL function that reports the user of the session
+// that has pinned conn.
+func (s *Server) currentUser(conn *sqlite3.SQLiteConn) func() string {
+	return func() string {
+		if c := s.session(conn); c != nil {
+			if name := c.sessionUser(); name != "" {
+				return name
+			}
+		}
+		return bootstrapRole
+	}
+}
+
+// pgRoles returns the pg_roles rows for all roles.
+func (s *Server) pgRoles() ([]pgRole, error) {
+	roles, err := s.roles(s.auth())
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]pgRole, 0, len(roles))
+	for _, role := range roles {
+		row := pgRole{
+			oid:            roleOID(role.Name),
+			rolname:        role.Name,
+			rolsuper:       boolInt(role.Superuser),
+			rolinherit:     boolInt(role.Inherit),
+			rolcreaterole:  boolInt(role.CreateRole),
+			rolcreatedb:    boolInt(role.CreateDB),
+			rolcanlogin:    boolInt(role.Login),
+			rolreplication: boolInt(role.Replication),
+			rolbypassrls:   boolInt(role.BypassRLS),
+			rolconnlimit:   role.ConnLimit,
+			rolpassword:    role.Password,
+		}
+		if !role.ValidUntil.IsZero() {
+			row.rolvaliduntil = role.ValidUntil.UTC().Format("2006-01-02 15:04:05-07")
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// pgAuthID returns the pg_authid rows, which include password hashes. Only
+// superusers may read them.
+func (s *Server) pgAuthID(conn *sqlite3.SQLiteConn) ([]pgRole, error) {
+	if c := s.session(conn); c != nil {
+		if role, err := s.sessionRole(c); err != nil {
+			return nil, err
+		} else if !role.Superuser {
+			return nil, errors.New("permission denied for table pg_authid")
+		}
+	}
+	return s.pgRoles()
+}
+
+// pgAuthMembers returns the pg_auth_members rows for all role memberships.
+func (s *Server) pgAuthMembers() ([]pgAuthMember, error) {
+	memberships, err := s.roleMemberships()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]pgAuthMember, 0, len(memberships))
+	for _, m := range memberships {
+		rows = append(rows, pgAuthMember{
+			roleid:       roleOID(m.Role),
+			member:       roleOID(m.Member),
+			grantor:      roleOID(m.Grantor),
+			admin_option: boolInt(m.AdminOption),
+		})
+	}
+	return rows, nil
+}
+
+func boolInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// handleRoleStatement handles CREATE ROLE, ALTER ROLE, DROP ROLE and GRANT &
+// REVOKE of role memberships, which change the roles in the metastore.
+// Returns false if the query is not a role statement.
+func (s *Server) handleRoleStatement(c *Conn, w *resultBuffer, query string) (bool, error) {
+	var tag string
+	var notices []*pgproto3.NoticeResponse
+	var err error
+	if m := createRoleRegex.FindStringSubmatch(query); m != nil {
+		tag, err = "CREATE ROLE", s.createRole(c, strings.ToUpper(m[1]), identName(m[2]), m[3])
+	} else if m := alterRoleRegex.FindStringSubmatch(query); m != nil {
+		tag, err = "ALTER ROLE", s.alterRole(c, identName(m[1]), m[2])
+	} else if m := dropRoleRegex.FindStringSubmatch(query); m != nil {
+		tag = "DROP ROLE"
+		notices, err = s.dropRoles(c, roleNames(m[2]), m[1] != "")
+	} else if m := grantRoleRegex.FindStringSubmatch(query); m != nil {
+		tag, err = "GRANT ROLE", s.grantRoles(c, roleNames(m[1]), roleNames(m[2]), m[3] != "")
+	} else if m := revokeRoleRegex.FindStringSubmatch(query); m != nil {
+		tag = "REVOKE ROLE"
+		notices, err = s.revokeRoles(c, roleNames(m[2]), roleNames(m[3]), m[1] != "")
+	} else {
+		return false, nil
+	}
+	w.notices = append(w.notices, notices...)
+	w.SetCommandTag(tag)
+	return true, err
+}
+
+// checkMetaStoreChange returns an error if the session cannot change the
+// roles or privileges in the metastore.
+func (s *Server) checkMetaStoreChange(c *Conn, stmt string) error {
+	if s.MetaStore == nil {
+		return Errorf("0A000", "%s requires a metastore", stmt) // feature_not_supported
+	} else if c.readOnly {
+		return Errorf("25006", "cannot execute %s in a read-only transaction", stmt) // read_only_sql_transaction
+	}
+	return nil
+}
+
+func (s *Server) createRole(c *Conn, kind, name, options string) error {
+	stmt := "CREATE " + kind
+	if err := s.checkMetaStoreChange(c, stmt); err != nil {
+		return err
+	}
+
+	if name == publicGrantee {
+		return Errorf("42939", "role name %q is reserved", name) // reserved_name
+	}
+
+	role := &Role{Name: name, Inherit: true, Login: kind == "USER", ConnLimit: -1}
+	if _, err := applyRoleOptions(role, options); err != nil {
+		return err
+	}
+
+	caller, err := s.sessionRole(c)
+	if err != nil {
+		return err
+	} else if !caller.Superuser && !caller.CreateRole {
+		return Errorf("42501", "permission denied to create role") // insufficient_privilege
+	} else if !caller.Superuser && (role.Superuser || role.Replication || role.BypassRLS) {
+		return Errorf("42501", "must be superuser to create superusers, replication users or roles that bypass row-level security")
+	}
+
+	if isConfigRole(s.auth(), name) {
+		return Errorf("42710", "role %q already exists", name) // duplicate_object
+	} else if err := s.MetaStore.CreateRole(role); err == ErrRoleExists {
+		return Errorf("42710", "role %q already exists", name)
+	} else if err != nil {
+		return fmt.Errorf("create role: %w", err)
+	}
+	c.logger.Info("role created", "role", name)
+	return nil
+}
+
+func (s *Server) alterRole(c *Conn, name, options string) error {
+	if err := s.checkMetaStoreChange(c, "ALTER ROLE"); err != nil {
+		return err
+	} else if isConfigRole(s.auth(), name) {
+		return Errorf("55000", "role %q is defined by the server configuration", name) // object_not_in_prerequisite_state
+	}
+
+	role, err := s.MetaStore.Role(name)
+	if err != nil {
+		return fmt.Errorf("alter role: %w", err)
+	} else if role == nil {
+		return Errorf("42704", "role %q does not exist", name) // undefined_object
+	}
+	wasSuperuser := role.Superuser
+
+	set, err := applyRoleOptions(role, options)
+	if err != nil {
+		return err
+	}
+
+	// Superusers may change any role, CREATEROLE roles may change other roles
+	// & other roles may only change their own password.
+	caller, err := s.sessionRole(c)
+	if err != nil {
+		return err
+	}
+	switch {
+	case caller.Superuser:
+	case wasSuperuser || set["SUPERUSER"] || set["REPLICATION"] || set["BYPASSRLS"]:
+		return Errorf("42501", "must be superuser to alter superusers, replication users or roles that bypass row-level security")
+	case caller.CreateRole:
+	case caller.Name == name && len(set) == 1 && set["PASSWORD"]:
+	default:
+		return Errorf("42501", "permission denied")
+	}
+
+	if err := s.MetaStore.UpdateRole(role); err != nil {
+		return fmt.Errorf("alter role: %w", err)
+	}
+	c.logger.Info("role altered", "role", name)
+	return nil
+}
+
+func (s *Server) dropRoles(c *Conn, names []string, ifExists bool) ([]*pgproto3.NoticeResponse, error) {
+	if err := s.checkMetaStoreChange(c, "DROP ROLE"); err != nil {
+		return nil, err
+	}
+	caller, err := s.sessionRole(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check every role before dropping any.
+	var notices []*pgproto3.NoticeResponse
+	var roles []*Role
+	for _, name := range names {
+		role, err := s.MetaStore.Role(name)
+		if err != nil {
+			return nil, fmt.Errorf("drop role: %w", err)
+		}
+
+		switch {
+		case name == c.sessionUser():
+			return nil, Errorf("55006", "current user cannot be dropped") // object_in_use
+		case isConfigRole(s.auth(), name):
+			return nil, Errorf("55000", "role %q is defined by the server configuration", name)
+		case role == nil && ifExists:
+			notices = append(notices, &pgproto3.NoticeResponse{Severity: "NOTICE", Code: "00000", Message: fmt.Sprintf("role %q does not exist, skipping", name)})
+			continue
+		case role == nil:
+			return nil, Errorf("42704", "role %q does not exist", name)
+		case !caller.Superuser && (role.Superuser || !caller.CreateRole):
+			return nil, Errorf("42501", "permission denied to drop role %q", name)
+		}
+		roles = append(roles, role)
+	}
+
+	for _, role := range roles {
+		if err := s.MetaStore.DropRole(role.Name); err != nil {
+			return nil, fmt.Errorf("drop role: %w", err)
+		}
+		c.logger.Info("role dropped", "role", role.Name)
+	}
+	return notices, nil
+}
+
+func (s *Server) grantRoles(c *Conn, roles, members []string, adminOption bool) error {
+	if err := s.checkMetaStoreChange(c, "GRANT"); err != nil {
+		return err
+	}
+	memberships, err := s.checkGrantRoles(c, append(append([]string(nil), roles...), members...), roles)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		for _, member := range members {
+			for _, name := range memberOf(memberships, role) {
+				if name == member {
+					return Errorf("0LP01", "role %q is a member of role %q", role, member) // invalid_grant_operation
+				}
+			}
+
+			m := &RoleMembership{Role: role, Member: member, Grantor: c.sessionUser(), AdminOption: adminOption}
+			if err := s.MetaStore.GrantRole(m); err != nil {
+				return fmt.Errorf("grant role: %w", err)
+			}
+			memberships = append(memberships, m)
+			c.logger.Info("role granted", "role", role, "member", member)
+		}
+	}
+	return nil
+}
+
+func (s *Server) revokeRoles(c *Conn, roles, members []string, adminOnly bool) ([]*pgproto3.NoticeResponse, error) {
+	if err := s.checkMetaStoreChange(c, "REVOKE"); err != nil {
+		return nil, err
+	} else if _, err := s.checkGrantRoles(c, append(append([]string(nil), roles...), members...), roles); err != nil {
+		return nil, err
+	}
+
+	var notices []*pgproto3.NoticeResponse
+	for _, role := range roles {
+		for _, member := range members {
+			if ok, err := s.MetaStore.RevokeRole(role, member, adminOnly); err != nil {
+				return nil, fmt.Errorf("revoke role: %w", err)
+			} else if !ok {
+				notices = append(notices, &pgproto3.NoticeResponse{Severity: "WARNING", Code: "01000", Message: fmt.Sprintf("role %q is not a member of role %q", member, role)})
+				continue
+			}
+			c.logger.Info("role revoked", "role", role, "member", member)
+		}
+	}
+	return notices, nil
+}
+
+// checkGrantRoles returns an error if any of the named roles do not exist or
+// the session may not grant membership in the granted roles. Superusers may
+// grant any role & CREATEROLE roles any role but superusers. Other roles need
+// the admin option. Returns the current memberships.
+func (s *Server) checkGrantRoles(c *Conn, names, granted []string) ([]*RoleMembership, error) {
+	for _, name := range names {
+		if role, err := s.role(s.auth(), name); err != nil {
+			return nil, err
+		} else if role == nil {
+			return nil, Errorf("42704", "role %q does not exist", name)
+		}
+	}
+
+	caller, err := s.sessionRole(c)
+	if err != nil {
+		return nil, err
+	}
+	memberships, err := s.roleMemberships()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range granted {
+		role, err := s.role(s.auth(), name)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case caller.Superuser:
+		case role.Superuser:
+			return nil, Errorf("42501", "must be superuser to alter superusers")
+		case caller.CreateRole:
+		case hasAdminOption(memberships, name, caller.Name):
+		default:
+			return nil, Errorf("42501", "must have admin option on role %q", name)
+		}
+	}
+	return memberships, nil
+}
+
+func hasAdminOption(memberships []*RoleMembership, role, member string) bool {
+	for _, m := range memberships {
+		if m.Role == role && m.Member == member && m.AdminOption {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRoleOptions sets the options of a CREATE ROLE or ALTER ROLE statement
+// on role. Returns the names of the options that were set.
+func applyRoleOptions(role *Role, options string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	for rest := strings.TrimSpace(options); rest != ""; {
+		m := roleOptionRegex.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, Errorf("42601", "syntax error at or near %q", strings.Fields(rest)[0]) // syntax_error
+		}
+		rest = strings.TrimSpace(rest[len(m[0]):])
+
+		switch {
+		case m[2] != "":
+			option, v := strings.ToUpper(m[2]), m[1] == ""
+			switch option {
+			case "SUPERUSER":
+				role.Superuser = v
+			case "INHERIT":
+				role.Inherit = v
+			case "CREATEROLE":
+				role.CreateRole = v
+			case "CREATEDB":
+				role.CreateDB = v
+			case "LOGIN":
+				role.Login = v
+			case "REPLICATION":
+				role.Replication = v
+			case "BYPASSRLS":
+				role.BypassRLS = v
+			}
+			set[option] = true
+
+		case m[3] != "":
+			role.Password = ""
+			if !strings.EqualFold(m[3], "NULL") {
+				password := strings.ReplaceAll(strings.Trim(m[3], "'"), "''", "'")
+				role.Password = (&User{Name: role.Name, Password: password}).md5Hash()
+			}
+			set["PASSWORD"] = true
+
+		case m[4] != "":
+			n, err := strconv.Atoi(m[4])
+			if err != nil || n < -1 {
+				return nil, Errorf("22023", "invalid connection limit: %s", m[4]) // invalid_parameter_value
+			}
+			role.ConnLimit = n
+			set["CONNECTION LIMIT"] = true
+
+		default:
+			t, err := parseValidUntil(m[5])
+			if err != nil {
+				return nil, Errorf("22007", "invalid input syntax for type timestamp with time zone: %q", m[5]) // invalid_datetime_format
+			}
+			role.ValidUntil = t
+			set["VALID UNTIL"] = true
+		}
+	}
+	return set, nil
+}
+
+// parseValidUntil parses the timestamp of a VALID UNTIL option. Returns the
+// zero time for "infinity".
+func parseValidUntil(s string) (time.Time, error) {
+	if strings.EqualFold(s, "infinity") {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05Z07", "2006-01-02 15:04:05Z07:00", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp: %q", s)
+}
+
+// identName returns the name of an identifier. Unquoted identifiers are
+// folded to lower case, as in Postgres.
+func identName(ident string) string {
+	if strings.HasPrefix(ident, `"`) {
+		return strings.Trim(ident, `"`)
+	}
+	return strings.ToLower(ident)
+}
+
+// roleNames returns the names in a comma-separated list of role identifiers.
+func roleNames(list string) []string {
+	var names []string
+	for _, ident := range roleIdentRegex.FindAllString(list, -1) {
+		names = append(names, identName(ident))
+	}
+	return names
+}
+
+const (
+	roleIdent     = `(?:"[^"]+"|[\w$]+)`
+	roleIdentList = roleIdent + `(?:\s*,\s*` + roleIdent + `)*`
+)
+
+var (
+	roleIdentRegex = regexp.MustCompile(roleIdent)
+
+	createRoleRegex = regexp.MustCompile(`(?is)^\s*CREATE\s+(ROLE|USER|GROUP)\s+(` + roleIdent + `)(?:\s+WITH)?(\s.*?)?\s*;?\s*$`)
+	alterRoleRegex  = regexp.MustCompile(`(?is)^\s*ALTER\s+(?:ROLE|USER|GROUP)\s+(` + roleIdent + `)(?:\s+WITH)?(\s.*?)?\s*;?\s*$`)
+	dropRoleRegex   = regexp.MustCompile(`(?is)^\s*DROP\s+(?:ROLE|USER|GROUP)\s+(IF\s+EXISTS\s+)?(` + roleIdentList + `)\s*;?\s*$`)
+	grantRoleRegex  = regexp.MustCompile(`(?is)^\s*GRANT\s+(` + roleIdentList + `)\s+TO\s+(` + roleIdentList + `)(\s+WITH\s+ADMIN\s+OPTION)?(?:\s+GRANTED\s+BY\s+` + roleIdent + `)?\s*;?\s*$`)
+	revokeRoleRegex = regexp.MustCompile(`(?is)^\s*REVOKE\s+(ADMIN\s+OPTION\s+FOR\s+)?(` + roleIdentList + `)\s+FROM\s+(` + roleIdentList + `)(?:\s+GRANTED\s+BY\s+` + roleIdent + `)?(?:\s+(?:CASCADE|RESTRICT))?\s*;?\s*$`)
+
+	roleOptionRegex = regexp.MustCompile(`(?is)^(?:(NO)?(SUPERUSER|INHERIT|CREATEROLE|CREATEDB|LOGIN|REPLICATION|BYPASSRLS)\b|(?:ENCRYPTED\s+)?PASSWORD\s+(NULL\b|'(?:[^']|'')*')|CONNECTION\s+LIMIT\s+(-?\d+)|VALID\s+UNTIL\s+'([^']*)')`)
+)
@@ -0,0 +1,45 @@
+package postlite_test
+
+import (
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure roles created with SQL control who may log in & with which password.
+func TestServer_Roles(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) { s.MetaStore = MustOpenMetaStore(t) })
+
+	admin := MustConnect(t, s, "db", "sqlite3", "")
+	admin.MustQuery(`CREATE ROLE carol LOGIN`)
+	admin.MustQuery(`CREATE ROLE staff`)
+
+	// Only existing roles that may log in can connect until passwords are set.
+	carol := MustConnect(t, s, "db", "carol", "")
+	carol.MustFailQuery(`CREATE ROLE dave`, "42501")
+	carol.MustFailQueryExtended(`CREATE ROLE dave`, "42501")
+	MustFailConnect(t, s, "db", "dave", "", "28000")
+	MustFailConnect(t, s, "db", "staff", "", "28000")
+
+	admin.MustQuery(`CREATE ROLE alice LOGIN PASSWORD 'secret' CONNECTION LIMIT 1`)
+	admin.MustQuery(`CREATE USER expired PASSWORD 'secret' VALID UNTIL '2000-01-01'`)
+	if rows := admin.MustQuery(`SELECT rolcanlogin, rolconnlimit FROM pg_roles WHERE rolname = 'alice'`); len(rows) != 1 || rows[0][0] != "1" || rows[0][1] != "1" {
+		t.Fatalf("unexpected pg_roles row: %v", rows)
+	}
+
+	alice := MustConnect(t, s, "db", "alice", "secret")
+	MustFailConnect(t, s, "db", "alice", "secret", "53300")
+	alice.Close()
+
+	MustFailConnect(t, s, "db", "alice", "wrong", "28P01")
+	MustFailConnect(t, s, "db", "expired", "secret", "28P01")
+	MustFailConnect(t, s, "db", "carol", "", "28P01")
+
+	admin.MustQuery(`ALTER ROLE alice NOLOGIN`)
+	MustFailConnect(t, s, "db", "alice", "secret", "28P01")
+
+	// Role statements are handled over the extended protocol too.
+	admin.MustQueryExtended(`ALTER ROLE alice LOGIN PASSWORD 'changed'`)
+	MustFailConnect(t, s, "db", "alice", "secret", "28P01")
+	MustConnect(t, s, "db", "alice", "changed").MustQuery(`SELECT 1`)
+}
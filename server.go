@@ -2,19 +2,23 @@ package postlite
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"os"
-	"path/filepath"
+	osuser "os/user"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jackc/pgproto3/v2"
-	"github.com/jackc/pgtype"
 	"github.com/mattn/go-sqlite3"
 	"golang.org/x/sync/errgroup"
 )
@@ -24,124 +28,399 @@ const (
 	ServerVersion = "13.0.0"
 )
 
-func init() {
-	sql.Register("postlite-sqlite3", &sqlite3.SQLiteDriver{
-		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
-			if err := conn.RegisterFunc("current_catalog", currentCatalog, true); err != nil {
-				return fmt.Errorf("cannot register current_catalog() function")
-			}
-			if err := conn.RegisterFunc("current_schema", currentSchema, true); err != nil {
-				return fmt.Errorf("cannot register current_schema() function")
-			}
-			if err := conn.RegisterFunc("current_user", currentUser, true); err != nil {
-				return fmt.Errorf("cannot register current_schema() function")
-			}
-			if err := conn.RegisterFunc("session_user", sessionUser, true); err != nil {
-				return fmt.Errorf("cannot register session_user() function")
-			}
-			if err := conn.RegisterFunc("user", user, true); err != nil {
-				return fmt.Errorf("cannot register user() function")
-			}
-			if err := conn.RegisterFunc("show", show, true); err != nil {
-				return fmt.Errorf("cannot register show() function")
-			}
-			if err := conn.RegisterFunc("format_type", formatType, true); err != nil {
-				return fmt.Errorf("cannot register format_type() function")
-			}
-			if err := conn.RegisterFunc("version", version, true); err != nil {
-				return fmt.Errorf("cannot register version() function")
-			}
+// connectHook prepares a new SQLite connection to serve Postgres clients.
+func (s *Server) connectHook(conn *sqlite3.SQLiteConn, db *DB) error {
+	if err := s.registerFuncs(conn, db); err != nil {
+		return err
+	} else if err := s.registerCustom(conn); err != nil {
+		return err
+	} else if err := createCatalog(conn); err != nil {
+		return err
+	}
+	return s.loadExtensions(conn, db)
+}
 
-			if err := conn.CreateModule("pg_namespace_module", &pgNamespaceModule{}); err != nil {
-				return fmt.Errorf("cannot register pg_namespace module")
-			}
-			if err := conn.CreateModule("pg_description_module", &pgDescriptionModule{}); err != nil {
-				return fmt.Errorf("cannot register pg_description module")
-			}
-			if err := conn.CreateModule("pg_database_module", &pgDatabaseModule{}); err != nil {
-				return fmt.Errorf("cannot register pg_database module")
-			}
-			if err := conn.CreateModule("pg_settings_module", &pgSettingsModule{}); err != nil {
-				return fmt.Errorf("cannot register pg_settings module")
-			}
-			if err := conn.CreateModule("pg_type_module", &pgTypeModule{}); err != nil {
-				return fmt.Errorf("cannot register pg_type module")
-			}
-			if err := conn.CreateModule("pg_class_module", &pgClassModule{}); err != nil {
-				return fmt.Errorf("cannot register pg_class module")
-			}
-			if err := conn.CreateModule("pg_range_module", &pgRangeModule{}); err != nil {
-				return fmt.Errorf("cannot register pg_range module")
-			}
-			return nil
-		},
+// registerFuncs registers the Postgres compatibility functions & catalog
+// modules on a SQLite connection.
+func (s *Server) registerFuncs(conn *sqlite3.SQLiteConn, db *DB) error {
+	if err := conn.RegisterFunc("current_catalog", currentCatalog, true); err != nil {
+		return fmt.Errorf("cannot register current_catalog() function")
+	}
+	if err := conn.RegisterFunc("current_schema", currentSchema, true); err != nil {
+		return fmt.Errorf("cannot register current_schema() function")
+	}
+	if err := conn.RegisterFunc("current_user", s.currentUser(conn), false); err != nil {
+		return fmt.Errorf("cannot register current_user() function")
+	}
+	if err := conn.RegisterFunc("session_user", s.currentUser(conn), false); err != nil {
+		return fmt.Errorf("cannot register session_user() function")
+	}
+	if err := conn.RegisterFunc("user", s.currentUser(conn), false); err != nil {
+		return fmt.Errorf("cannot register user() function")
+	}
+	if err := conn.RegisterFunc("show", func(name string) (string, error) { return showSetting(conn, name) }, false); err != nil {
+		return fmt.Errorf("cannot register show() function")
+	}
+	if err := conn.RegisterFunc("pg_cancel_backend", s.signalBackend(conn, s.CancelBackend), false); err != nil {
+		return fmt.Errorf("cannot register pg_cancel_backend() function")
+	}
+	if err := conn.RegisterFunc("pg_terminate_backend", s.signalBackend(conn, s.TerminateBackend), false); err != nil {
+		return fmt.Errorf("cannot register pg_terminate_backend() function")
+	}
+	if err := conn.RegisterFunc("format_type", formatType, true); err != nil {
+		return fmt.Errorf("cannot register format_type() function")
+	}
+	if err := conn.RegisterFunc("version", version, true); err != nil {
+		return fmt.Errorf("cannot register version() function")
+	}
+	if err := conn.RegisterFunc("has_table_privilege", s.hasTablePrivilege(conn, db), false); err != nil {
+		return fmt.Errorf("cannot register has_table_privilege() function")
+	}
+	if err := conn.RegisterFunc("current_setting", s.currentSetting(conn), false); err != nil {
+		return fmt.Errorf("cannot register current_setting() function")
+	}
+	if err := conn.RegisterFunc("set_config", s.setConfig(conn), false); err != nil {
+		return fmt.Errorf("cannot register set_config() function")
+	}
+	if err := conn.RegisterFunc("pg_notify", s.pgNotify(conn), false); err != nil {
+		return fmt.Errorf("cannot register pg_notify() function")
+	}
+	conn.RegisterRollbackHook(func() {
+		if c := s.session(conn); c != nil {
+			c.discardNotifications()
+			c.discardChanges()
+		}
 	})
+	s.registerPreUpdateHook(conn)
+	conn.RegisterAuthorizer(s.authorizer(conn))
+
+	if err := conn.CreateModule("pg_namespace_module", &pgNamespaceModule{}); err != nil {
+		return fmt.Errorf("cannot register pg_namespace module")
+	}
+	if err := conn.CreateModule("pg_description_module", &pgDescriptionModule{}); err != nil {
+		return fmt.Errorf("cannot register pg_description module")
+	}
+	if err := conn.CreateModule("pg_database_module", &pgDatabaseModule{databases: func() []pgDatabase { return s.pgDatabases(db) }}); err != nil {
+		return fmt.Errorf("cannot register pg_database module")
+	}
+	if err := conn.CreateModule("pg_stat_activity_module", &pgStatActivityModule{activity: func() ([]Activity, error) { return s.pgStatActivity(conn) }}); err != nil {
+		return fmt.Errorf("cannot register pg_stat_activity module")
+	}
+	if err := conn.CreateModule("pg_settings_module", &pgSettingsModule{}); err != nil {
+		return fmt.Errorf("cannot register pg_settings module")
+	}
+	if err := conn.CreateModule("pg_type_module", &pgTypeModule{}); err != nil {
+		return fmt.Errorf("cannot register pg_type module")
+	}
+	if err := conn.CreateModule("pg_class_module", &pgClassModule{classes: func() ([]pgClass, error) { return s.pgClasses(conn, db) }}); err != nil {
+		return fmt.Errorf("cannot register pg_class module")
+	}
+	if err := conn.CreateModule("pg_range_module", &pgRangeModule{}); err != nil {
+		return fmt.Errorf("cannot register pg_range module")
+	}
+
+	loaded, untrack := s.trackExtensions(conn)
+	if err := conn.CreateModule("pg_extension_module", &pgExtensionModule{extensions: func() []pgExtension { return s.pgExtensions(loaded) }, destroy: untrack}); err != nil {
+		untrack()
+		return fmt.Errorf("cannot register pg_extension module")
+	}
+	if err := conn.CreateModule("pg_available_extensions_module", &pgAvailableExtensionsModule{extensions: func() ([]pgAvailableExtension, error) { return s.pgAvailableExtensions(conn, loaded) }}); err != nil {
+		return fmt.Errorf("cannot register pg_available_extensions module")
+	}
+	if err := conn.CreateModule("pg_proc_module", &pgProcModule{procs: func() ([]pgProc, error) { return s.pgProcs(conn) }}); err != nil {
+		return fmt.Errorf("cannot register pg_proc module")
+	}
+	if err := conn.CreateModule("pg_roles_module", &pgRolesModule{roles: s.pgRoles}); err != nil {
+		return fmt.Errorf("cannot register pg_roles module")
+	}
+	if err := conn.CreateModule("pg_authid_module", &pgAuthIDModule{roles: func() ([]pgRole, error) { return s.pgAuthID(conn) }}); err != nil {
+		return fmt.Errorf("cannot register pg_authid module")
+	}
+	if err := conn.CreateModule("pg_user_module", &pgUserModule{roles: s.pgRoles}); err != nil {
+		return fmt.Errorf("cannot register pg_user module")
+	}
+	if err := conn.CreateModule("pg_auth_members_module", &pgAuthMembersModule{members: s.pgAuthMembers}); err != nil {
+		return fmt.Errorf("cannot register pg_auth_members module")
+	}
+	return s.registerInformationSchema(conn, db)
+}
+
+// createCatalog attaches an in-memory pg_catalog database to the connection
+// and creates virtual tables to imitate the Postgres system catalog.
+func createCatalog(conn *sqlite3.SQLiteConn) error {
+	// Attach an in-memory database for pg_catalog.
+	if _, err := conn.Exec(`ATTACH ':memory:' AS pg_catalog`, nil); err != nil {
+		return fmt.Errorf("attach pg_catalog: %w", err)
+	}
+
+	// Register virtual tables to imitate postgres.
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_namespace USING pg_namespace_module (oid, nspname, nspowner, nspacl)", nil); err != nil {
+		return fmt.Errorf("create pg_namespace: %w", err)
+	}
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_description USING pg_description_module (objoid, classoid, objsubid, description)", nil); err != nil {
+		return fmt.Errorf("create pg_description: %w", err)
+	}
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_database USING pg_database_module (oid, datname, datdba, encoding, datcollate, datctype, datistemplate, datallowconn, datconnlimit, datlastsysoid, datfrozenxid, datminmxid, dattablespace, datacl)", nil); err != nil {
+		return fmt.Errorf("create pg_database: %w", err)
+	}
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_stat_activity USING pg_stat_activity_module (datid, datname, pid, usesysid, usename, application_name, client_addr, client_hostname, client_port, backend_start, xact_start, query_start, state_change, wait_event_type, wait_event, state, backend_xid, backend_xmin, query, backend_type)", nil); err != nil {
+		return fmt.Errorf("create pg_stat_activity: %w", err)
+	}
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_settings USING pg_settings_module (name, setting, unit, category, short_desc, extra_desc, context, vartype, source, min_val, max_val, enumvals, boot_val, reset_val, sourcefile, sourceline, pending_restart)", nil); err != nil {
+		return fmt.Errorf("create pg_settings: %w", err)
+	}
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_type USING pg_type_module (oid, typname, typnamespace, typowner, typlen, typbyval, typtype, typcategory, typispreferred, typisdefined, typdelim, typrelid, typelem, typarray, typinput, typoutput, typreceive, typsend, typmodin, typmodout, typanalyze, typalign, typstorage, typnotnull, typbasetype, typtypmod, typndims, typcollation, typdefaultbin, typdefault, typacl)", nil); err != nil {
+		return fmt.Errorf("create pg_type: %w", err)
+	}
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_class USING pg_class_module (oid, relname, relnamespace, reltype, reloftype, relowner, relam, relfilenode, reltablespace, relpages, reltuples, relallvisible, reltoastrelid, relhasindex, relisshared, relpersistence, relkind, relnatts, relchecks, relhasrules, relhastriggers, relhassubclass, relrowsecurity, relforcerowsecurity, relispopulated, relreplident, relispartition, relrewrite, relfrozenxid, relminmxid, relacl, reloptions, relpartbound)", nil); err != nil {
+		return fmt.Errorf("create pg_class: %w", err)
+	}
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_range USING pg_range_module (rngtypid, rngsubtype, rngmultitypid, rngcollation, rngsubopc, rngcanonical, rngsubdiff)", nil); err != nil {
+		return fmt.Errorf("create pg_range: %w", err)
+	}
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_extension USING pg_extension_module (oid, extname, extowner, extnamespace, extrelocatable, extversion, extconfig, extcondition)", nil); err != nil {
+		return fmt.Errorf("create pg_extension: %w", err)
+	}
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_available_extensions USING pg_available_extensions_module (name, default_version, installed_version, comment)", nil); err != nil {
+		return fmt.Errorf("create pg_available_extensions: %w", err)
+	}
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_proc USING pg_proc_module (oid, proname, pronamespace, proowner, prolang, procost, prorows, provariadic, prosupport, prokind, prosecdef, proleakproof, proisstrict, proretset, provolatile, proparallel, pronargs, pronargdefaults, prorettype, proargtypes, proallargtypes, proargmodes, proargnames, proargdefaults, protrftypes, prosrc, probin, proconfig, proacl)", nil); err != nil {
+		return fmt.Errorf("create pg_proc: %w", err)
+	}
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_roles USING pg_roles_module (rolname, rolsuper, rolinherit, rolcreaterole, rolcreatedb, rolcanlogin, rolreplication, rolconnlimit, rolpassword, rolvaliduntil, rolbypassrls, rolconfig, oid)", nil); err != nil {
+		return fmt.Errorf("create pg_roles: %w", err)
+	}
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_authid USING pg_authid_module (oid, rolname, rolsuper, rolinherit, rolcreaterole, rolcreatedb, rolcanlogin, rolreplication, rolbypassrls, rolconnlimit, rolpassword, rolvaliduntil)", nil); err != nil {
+		return fmt.Errorf("create pg_authid: %w", err)
+	}
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_user USING pg_user_module (usename, usesysid, usecreatedb, usesuper, userepl, usebypassrls, passwd, valuntil, useconfig)", nil); err != nil {
+		return fmt.Errorf("create pg_user: %w", err)
+	}
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_auth_members USING pg_auth_members_module (roleid, member, grantor, admin_option)", nil); err != nil {
+		return fmt.Errorf("create pg_auth_members: %w", err)
+	}
+	return createInformationSchema(conn)
 }
 
 func currentCatalog() string { return "public" }
 func currentSchema() string  { return "public" }
 
-func currentUser() string { return "sqlite3" }
-func sessionUser() string { return "sqlite3" }
-func user() string        { return "sqlite3" }
-
 func version() string { return "postlite v0.0.0" }
 
 func formatType(type_oid, typemod string) string { return "" }
 
-func show(name string) string { return "" }
-
 type Server struct {
 	mu    sync.Mutex
-	ln    net.Listener
+	lns   []net.Listener
 	conns map[*Conn]struct{}
+	pid   int32 // last assigned backend pid
+
+	shuttingDown bool  // set by Shutdown()
+	serveErr     error // error that stopped a listener, if any
+
+	// Sessions by the SQLite connection they have pinned.
+	sessions map[*sqlite3.SQLiteConn]*Conn
+
+	// Extensions loaded into each SQLite connection.
+	extensions map[*sqlite3.SQLiteConn]*connExtensions
+
+	// Sessions listening for notifications, by database & channel.
+	listeners map[string]map[string]map[*Conn]struct{}
+
+	// Pids of the sessions streaming from replication slots, by slot name.
+	activeSlots map[string]int32
 
 	g      errgroup.Group
 	ctx    context.Context
 	cancel func()
 
-	// Bind address to listen to Postgres wire protocol.
+	// Bind address to listen to Postgres wire protocol. If blank, no TCP
+	// listener is opened.
 	Addr string
 
+	// Directories to create Unix domain sockets in. Sockets are named after
+	// the port in Addr, e.g. "/tmp/.s.PGSQL.5432", like Postgres.
+	SocketDirs []string
+
+	// File permissions of Unix domain sockets.
+	SocketPerm os.FileMode
+
 	// Directory that holds SQLite databases.
 	DataDir string
+
+	// Authentication & access control settings used for new connections.
+	// Use SetAuth() to replace them while the server is running.
+	Auth *Auth
+
+	// TLS configuration used when clients request SSL. If nil, SSL is refused.
+	TLSConfig *tls.Config
+
+	// If true, all databases are opened read-only regardless of access rules.
+	ReadOnly bool
+
+	// Stores roles created with CREATE ROLE. If nil, only the users of Auth
+	// are roles and role statements are rejected.
+	MetaStore *MetaStore
+
+	// Logs the row changes of databases with replication slots for logical
+	// replication. If nil, replication slots cannot be created.
+	ChangeLog *ChangeLog
+
+	// Manages SQLite handles shared between client connections.
+	DBs *DBManager
+
+	// Maximum number of client connections. If zero, connections are unlimited.
+	MaxConnections int
+
+	// Connection limits for databases matching a glob. The first match is used.
+	// Use SetConnLimits() to replace the limits while the server is running.
+	DatabaseLimits []*DatabaseLimit
+
+	// Prometheus metrics for the server. If nil, metrics are not collected.
+	Metrics *Metrics
+
+	// Structured logger. Protocol traces are logged at debug level, connections
+	// at info level & connection errors at warn level.
+	Logger *slog.Logger
+
+	// If true, bound parameters are not written to the log.
+	RedactParameters bool
+
+	// Records session starts & ends and the statements executed by sessions.
+	// If nil, auditing is disabled.
+	AuditSink AuditSink
+
+	// Statement classes to audit. If empty, all statements are audited.
+	AuditClasses []StatementClass
+
+	// Opens the databases clients connect to. If nil, SQLite files in
+	// DataDir are opened with DBs.
+	Backend Backend
+
+	// Executes queries for sessions. If nil, queries are executed against
+	// the session's SQLite connection.
+	Handler QueryHandler
+
+	// Wraps the query handler. The first middleware is the outermost.
+	Middleware []Middleware
+
+	// Custom SQL functions, aggregates, collations & virtual table modules
+	// registered on every SQLite connection. They replace built-ins with the
+	// same name, such as format_type() or pg_type_module.
+	Functions  []*Function
+	Aggregates []*Aggregate
+	Collations []*Collation
+	Modules    []*Module
+
+	// Default session timeouts. Clients may change them with SET. If zero,
+	// the timeout is disabled. Use SetSessionTimeouts() to replace them
+	// while the server is running.
+	StatementTimeout                time.Duration
+	IdleInTransactionSessionTimeout time.Duration
+	IdleSessionTimeout              time.Duration
 }
 
 func NewServer() *Server {
 	s := &Server{
-		conns: make(map[*Conn]struct{}),
+		conns:       make(map[*Conn]struct{}),
+		sessions:    make(map[*sqlite3.SQLiteConn]*Conn),
+		extensions:  make(map[*sqlite3.SQLiteConn]*connExtensions),
+		listeners:   make(map[string]map[string]map[*Conn]struct{}),
+		activeSlots: make(map[string]int32),
+		SocketPerm:  DefaultSocketPerm,
+		DBs:         NewDBManager(),
+		Logger:      slog.Default(),
 	}
+	s.DBs.ConnectHook = s.connectHook
+	s.Metrics = NewMetrics(s.DBs)
 	s.ctx, s.cancel = context.WithCancel(context.Background())
 	return s
 }
 
+// auth returns the current authentication settings.
+func (s *Server) auth() *Auth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Auth == nil {
+		return &Auth{}
+	}
+	return s.Auth
+}
+
+// SetAuth replaces the authentication & access control settings used for new
+// connections. Existing connections keep the settings they started with.
+func (s *Server) SetAuth(auth *Auth) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Auth = auth
+}
+
 func (s *Server) Open() (err error) {
-	// Ensure data directory exists.
-	if _, err := os.Stat(s.DataDir); err != nil {
-		return err
+	// Ensure data directory exists, unless databases come from a backend.
+	if s.Backend == nil {
+		if _, err := os.Stat(s.DataDir); err != nil {
+			return err
+		}
 	}
 
-	s.ln, err = net.Listen("tcp", s.Addr)
-	if err != nil {
+	if err := s.DBs.Open(); err != nil {
 		return err
 	}
 
-	s.g.Go(func() error {
-		if err := s.serve(); s.ctx.Err() != nil {
-			return err // return error unless context canceled
+	if s.Addr == "" && len(s.SocketDirs) == 0 {
+		return fmt.Errorf("address or socket directory required")
+	}
+
+	if s.Addr != "" {
+		ln, err := net.Listen("tcp", s.Addr)
+		if err != nil {
+			return err
 		}
-		return nil
-	})
+		s.lns = append(s.lns, ln)
+	}
+
+	for _, dir := range s.SocketDirs {
+		ln, err := listenUnix(SocketPath(dir, s.Addr), s.SocketPerm)
+		if err != nil {
+			s.closeListeners()
+			return err
+		}
+		s.lns = append(s.lns, ln)
+	}
+
+	for _, ln := range s.lns {
+		ln := ln
+		s.g.Go(func() error {
+			// Return error unless the server is closing or shutting down.
+			if err := s.serve(ln); s.ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				s.mu.Lock()
+				s.serveErr = err
+				s.mu.Unlock()
+				return err
+			}
+			return nil
+		})
+	}
 	return nil
 }
 
-func (s *Server) Close() (err error) {
-	if s.ln != nil {
-		if e := s.ln.Close(); err == nil {
-			err = e
-		}
+// Listeners returns the addresses of all listeners.
+func (s *Server) Listeners() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := make([]net.Addr, len(s.lns))
+	for i, ln := range s.lns {
+		a[i] = ln.Addr()
 	}
+	return a
+}
+
+func (s *Server) Close() (err error) {
 	s.cancel()
+	if e := s.closeListeners(); err == nil {
+		err = e
+	}
 
 	// Track and close all open connections.
 	if e := s.CloseClientConnections(); err == nil {
@@ -151,58 +430,164 @@ func (s *Server) Close() (err error) {
 	if err := s.g.Wait(); err != nil {
 		return err
 	}
+
+	if e := s.DBs.Close(); err == nil {
+		err = e
+	}
 	return err
 }
 
-// CloseClientConnections disconnects all Postgres connections.
-func (s *Server) CloseClientConnections() (err error) {
+// Shutdown gracefully shuts down the server. It stops accepting connections
+// and closes sessions as they become idle, notifying clients with an
+// admin_shutdown error. Sessions in a transaction may finish it first. If ctx
+// is done before all sessions have closed, the remaining ones are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.shuttingDown = true
+	s.mu.Unlock()
+
+	if err := s.closeListeners(); err != nil {
+		return err
+	}
 
+	s.mu.Lock()
 	for conn := range s.conns {
-		if e := conn.Close(); err == nil {
+		if err := conn.drain(); err != nil {
+			s.Logger.Warn("cannot drain connection", "pid", conn.pid, "err", err)
+		}
+	}
+	s.mu.Unlock()
+
+	// Wait for sessions to close before forcing the rest to close.
+	done := make(chan struct{})
+	go func() { s.g.Wait(); close(done) }()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Logger.Warn("shutdown deadline exceeded, closing connections", "n", s.connN())
+	}
+	return s.Close()
+}
+
+// nextPID returns a new backend process ID to identify a connection.
+func (s *Server) nextPID() int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pid++
+	return s.pid
+}
+
+// logParams returns bound parameters as they should be logged.
+func (s *Server) logParams(params []interface{}) []interface{} {
+	if !s.RedactParameters {
+		return params
+	}
+	redacted := make([]interface{}, len(params))
+	for i := range redacted {
+		redacted[i] = "[redacted]"
+	}
+	return redacted
+}
+
+// messageType returns the name of a protocol message type for logging.
+func messageType(msg pgproto3.FrontendMessage) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", msg), "*pgproto3.")
+}
+
+// connN returns the number of client connections.
+func (s *Server) connN() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func (s *Server) closeListeners() (err error) {
+	s.mu.Lock()
+	lns := s.lns
+	s.lns = nil
+	s.mu.Unlock()
+
+	for _, ln := range lns {
+		if e := ln.Close(); err == nil {
 			err = e
 		}
 	}
+	return err
+}
 
+// CloseClientConnections disconnects all Postgres connections.
+func (s *Server) CloseClientConnections() (err error) {
+	// Connections are closed without the lock as closing them runs SQLite
+	// statements, which the authorizer checks against the sessions.
+	s.mu.Lock()
+	conns := s.conns
 	s.conns = make(map[*Conn]struct{})
+	s.sessions = make(map[*sqlite3.SQLiteConn]*Conn)
+	s.listeners = make(map[string]map[string]map[*Conn]struct{})
+	s.mu.Unlock()
 
+	for conn := range conns {
+		if e := conn.Close(); err == nil {
+			err = e
+		}
+	}
 	return err
 }
 
 // CloseClientConnection disconnects a Postgres connections.
 func (s *Server) CloseClientConnection(conn *Conn) (err error) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	delete(s.conns, conn)
+	if conn.sqliteConn != nil {
+		delete(s.sessions, conn.sqliteConn)
+	}
+	s.mu.Unlock()
+
+	s.unlistenAll(conn)
+	if s.ChangeLog != nil {
+		s.dropTemporarySlots(conn)
+	}
 	return conn.Close()
 }
 
-func (s *Server) serve() error {
+func (s *Server) serve(ln net.Listener) error {
 	for {
-		c, err := s.ln.Accept()
+		c, err := ln.Accept()
 		if err != nil {
 			return err
 		}
-		conn := newConn(c)
+		if s.Metrics != nil {
+			c = &meteredConn{Conn: c, metrics: s.Metrics}
+		}
+		conn := newConn(c, s.backend())
+		conn.metrics = s.Metrics
+		conn.pid = s.nextPID()
+		conn.logger = s.Logger.With("remote_addr", c.RemoteAddr().String(), "pid", conn.pid)
 
 		// Track live connections.
 		s.mu.Lock()
 		s.conns[conn] = struct{}{}
 		s.mu.Unlock()
 
-		log.Println("connection accepted: ", conn.RemoteAddr())
+		conn.logger.Info("connection accepted")
 
 		s.g.Go(func() error {
 			defer s.CloseClientConnection(conn)
+			defer s.auditSession(conn, AuditSessionEnd)
 
-			if err := s.serveConn(s.ctx, conn); err != nil && s.ctx.Err() == nil {
-				log.Printf("connection error, closing: %s", err)
+			if err := s.serveConn(s.ctx, conn); errors.Is(err, errCancelRequest) {
+				conn.logger.Debug("cancel request handled")
+				return nil
+			} else if errors.Is(err, errAdminShutdown) {
+				conn.logger.Info("connection closed by administrator")
+				return nil
+			} else if err != nil && s.ctx.Err() == nil {
+				conn.logger.Warn("connection error, closing", "err", err)
 				return nil
 			}
 
-			log.Printf("connection closed: %s", conn.RemoteAddr())
+			conn.logger.Info("connection closed")
 			return nil
 		})
 	}
@@ -214,12 +599,18 @@ func (s *Server) serveConn(ctx context.Context, c *Conn) error {
 	}
 
 	for {
-		msg, err := c.backend.Receive()
+		// Notifications are published once the transaction queueing them
+		// commits.
+		if !c.inTransaction() {
+			s.publishNotifications(c)
+		}
+
+		msg, err := c.receive()
 		if err != nil {
 			return fmt.Errorf("receive message: %w", err)
 		}
 
-		log.Printf("[recv] %#v", msg)
+		c.logger.Debug("receive", "type", messageType(msg))
 
 		switch msg := msg.(type) {
 		case *pgproto3.Query:
@@ -235,6 +626,9 @@ func (s *Server) serveConn(ctx context.Context, c *Conn) error {
 		case *pgproto3.Sync: // ignore
 			continue
 
+		case *pgproto3.CopyData, *pgproto3.CopyDone, *pgproto3.CopyFail:
+			continue // ignore, such as status updates sent after a stream ends
+
 		case *pgproto3.Terminate:
 			return nil // exit
 
@@ -261,174 +655,320 @@ func (s *Server) serveConnStartup(ctx context.Context, c *Conn) error {
 			return fmt.Errorf("ssl request message: %w", err)
 		}
 		return nil
+	case *pgproto3.CancelRequest:
+		return s.handleCancelRequest(msg)
 	default:
 		return fmt.Errorf("unexpected startup message: %#v", msg)
 	}
 }
 
 func (s *Server) handleStartupMessage(ctx context.Context, c *Conn, msg *pgproto3.StartupMessage) (err error) {
-	log.Printf("received startup message: %#v", msg)
+	c.logger.Debug("startup message", "parameters", msg.Parameters)
 
 	// Validate
 	name := getParameter(msg.Parameters, "database")
 	if name == "" {
-		return writeMessages(c, &pgproto3.ErrorResponse{Message: "database required"})
+		return writeFatal(c, "3D000", "database required") // invalid_catalog_name
 	} else if strings.Contains(name, "..") {
-		return writeMessages(c, &pgproto3.ErrorResponse{Message: "invalid database name"})
+		return writeFatal(c, "3D000", "invalid database name")
 	}
+	user := getParameter(msg.Parameters, "user")
 
-	// Open SQL database & attach to the connection.
-	if c.db, err = sql.Open("postlite-sqlite3", filepath.Join(s.DataDir, name)); err != nil {
+	if err := s.checkConnLimits(c, name); err != nil {
 		return err
 	}
 
-	// Attach an in-memory database for pg_catalog.
-	if _, err := c.db.ExecContext(ctx, `ATTACH ':memory:' AS pg_catalog`); err != nil {
-		return fmt.Errorf("attach pg_catalog: %w", err)
+	// Settings are fixed for the lifetime of the connection.
+	auth := s.auth()
+
+	// Determine access level from the first matching rule, if any are set.
+	var rule *AccessRule
+	access := AccessAllow
+	if len(auth.AccessRules) > 0 {
+		if rule = MatchAccessRule(auth.AccessRules, name, user, c.RemoteAddr()); rule != nil {
+			access = rule.Access
+		} else {
+			access = AccessDeny
+		}
+	}
+	if access == AccessDeny {
+		c.metrics.authFailed("access_denied")
+		return writeFatal(c, "28000", // invalid_authorization_specification
+			fmt.Sprintf("access denied for host %q, user %q, database %q", addrIP(c.RemoteAddr()), user, name))
 	}
+	c.readOnly = s.ReadOnly || access == AccessReadOnly
+	c.confined = rule != nil
 
-	// Register virtual tables to imitate postgres.
-	if _, err := c.db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_namespace USING pg_namespace_module (oid, nspname, nspowner, nspacl)"); err != nil {
-		return fmt.Errorf("create pg_namespace: %w", err)
+	// Authenticate with peer credentials if the rule requires it. Otherwise,
+	// require a password if any users or roles have one.
+	if rule != nil && rule.Auth == AuthPeer {
+		if err := s.authenticatePeer(c, auth, rule, user); err != nil {
+			return err
+		}
+	} else if err := s.authenticateRole(c, auth, user); err != nil {
+		return err
 	}
-	if _, err := c.db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_description USING pg_description_module (objoid, classoid, objsubid, description)"); err != nil {
-		return fmt.Errorf("create pg_description: %w", err)
+	if err := s.checkRoleLogin(c, auth, user); err != nil {
+		return err
 	}
-	if _, err := c.db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_database USING pg_database_module (oid, datname, datdba, encoding, datcollate, datctype, datistemplate, datallowconn, datconnlimit, datlastsysoid, datfrozenxid, datminmxid, dattablespace, datacl)"); err != nil {
-		return fmt.Errorf("create pg_database: %w", err)
+
+	// Acquire shared database handle & pin a connection for this session.
+	if c.db, err = c.dbs.Acquire(ctx, name, c.readOnly); errors.Is(err, ErrDatabaseNotFound) {
+		return writeFatal(c, "3D000", fmt.Sprintf("database %q does not exist", name)) // invalid_catalog_name
+	} else if err != nil {
+		return err
 	}
-	if _, err := c.db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_settings USING pg_settings_module (name, setting, unit, category, short_desc, extra_desc, context, vartype, source, min_val, max_val, enumvals, boot_val, reset_val, sourcefile, sourceline, pending_restart)"); err != nil {
-		return fmt.Errorf("create pg_settings: %w", err)
+	c.metrics.sessionStarted(name)
+	if c.conn, err = s.pinConn(ctx, c.db); errors.Is(err, context.DeadlineExceeded) {
+		return writeFatal(c, "53300", fmt.Sprintf("too many connections for database %q", name)) // too_many_connections
+	} else if err != nil {
+		return fmt.Errorf("connect: %w", err)
 	}
-	if _, err := c.db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_type USING pg_type_module (oid, typname, typnamespace, typowner, typlen, typbyval, typtype, typcategory, typispreferred, typisdefined, typdelim, typrelid, typelem, typarray, typinput, typoutput, typreceive, typsend, typmodin, typmodout, typanalyze, typalign, typstorage, typnotnull, typbasetype, typtypmod, typndims, typcollation, typdefaultbin, typdefault, typacl)"); err != nil {
-		return fmt.Errorf("create pg_type: %w", err)
+
+	// Handles from a backend may be writable even if the session is not.
+	if c.readOnly && !c.db.ReadOnly() {
+		if _, err := c.conn.ExecContext(ctx, `PRAGMA query_only = ON`); err != nil {
+			return fmt.Errorf("query only: %w", err)
+		}
+		c.queryOnly = true
 	}
-	if _, err := c.db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_class USING pg_class_module (oid, relname, relnamespace, reltype, reloftype, relowner, relam, relfilenode, reltablespace, relpages, reltuples, relallvisible, reltoastrelid, relhasindex, relisshared, relpersistence, relkind, relnatts, relchecks, relhasrules, relhastriggers, relhassubclass, relrowsecurity, relforcerowsecurity, relispopulated, relreplident, relispartition, relrewrite, relfrozenxid, relminmxid, relacl, reloptions, relpartbound)"); err != nil {
-		return fmt.Errorf("create pg_class: %w", err)
+
+	if err := s.bindSession(c); err != nil {
+		return fmt.Errorf("bind session: %w", err)
 	}
-	if _, err := c.db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS pg_catalog.pg_range USING pg_range_module (rngtypid, rngsubtype, rngmultitypid, rngcollation, rngsubopc, rngcanonical, rngsubdiff)"); err != nil {
-		return fmt.Errorf("create pg_range: %w", err)
+	if err := s.loadExtensions(c.sqliteConn, c.db); err != nil {
+		return fmt.Errorf("load extensions: %w", err)
+	}
+	if c.secretKey, err = newSecretKey(); err != nil {
+		return fmt.Errorf("secret key: %w", err)
 	}
 
+	c.mu.Lock()
+	c.user = user
+	c.applicationName = getParameter(msg.Parameters, "application_name")
+	c.backendStart = time.Now()
+	c.mu.Unlock()
+	c.setState(StateIdle, "")
+
+	// Replication connections must be opened by a role allowed to replicate.
+	// Only logical replication of a database is supported.
+	switch replication := strings.ToLower(getParameter(msg.Parameters, "replication")); replication {
+	case "", "false", "off", "no", "0":
+	case "database":
+		if err := s.checkReplicationRole(c); err != nil {
+			return err
+		}
+		c.walSender = true
+	default:
+		return writeFatal(c, "0A000", "physical replication is not supported") // feature_not_supported
+	}
+
+	c.logger = c.logger.With("user", user, "database", name)
+	c.logger.Info("session started", "read_only", c.readOnly, "replication", c.walSender)
+	s.auditSession(c, AuditSessionStart)
+
+	c.statementTimeout = s.defaultTimeout("statement_timeout")
+	c.idleInTransactionSessionTimeout = s.defaultTimeout("idle_in_transaction_session_timeout")
+	c.idleSessionTimeout = s.defaultTimeout("idle_session_timeout")
+
 	return writeMessages(c,
 		&pgproto3.AuthenticationOk{},
+		&pgproto3.BackendKeyData{ProcessID: uint32(c.pid), SecretKey: c.secretKey},
 		&pgproto3.ParameterStatus{Name: "server_version", Value: ServerVersion},
 		&pgproto3.ReadyForQuery{TxStatus: 'I'},
 	)
 }
 
+// authenticatePassword performs MD5 password authentication for user.
+// The challenge is still sent for unknown users so clients cannot
+// determine which users exist.
+func (s *Server) authenticatePassword(c *Conn, u *User, name string) error {
+	salt, err := newSalt()
+	if err != nil {
+		return fmt.Errorf("salt: %w", err)
+	}
+	if err := writeMessages(c, &pgproto3.AuthenticationMD5Password{Salt: salt}); err != nil {
+		return err
+	}
+
+	msg, err := c.backend.Receive()
+	if err != nil {
+		return fmt.Errorf("receive password message: %w", err)
+	}
+	pmsg, ok := msg.(*pgproto3.PasswordMessage)
+	if !ok {
+		return fmt.Errorf("unexpected message during authentication: %#v", msg)
+	}
+
+	if u == nil || !u.CheckMD5Password(pmsg.Password, salt) {
+		c.metrics.authFailed("password")
+		return writeFatal(c, "28P01", fmt.Sprintf("password authentication failed for user %q", name)) // invalid_password
+	}
+	return nil
+}
+
+// authenticatePeer verifies that the operating system user of the process on
+// the other end of a Unix domain socket may connect as user. Without an ident
+// map, the names must match.
+func (s *Server) authenticatePeer(c *Conn, auth *Auth, rule *AccessRule, user string) error {
+	systemUser, err := c.peerUser()
+	if err != nil {
+		c.metrics.authFailed("peer")
+		return writeFatal(c, "28000", fmt.Sprintf("could not get peer credentials: %s", err))
+	}
+
+	if rule.IdentMap == "" && systemUser == user {
+		return nil
+	} else if rule.IdentMap != "" && MatchIdentMap(auth.IdentMappings, rule.IdentMap, systemUser, user) {
+		return nil
+	}
+	c.metrics.authFailed("peer")
+	return writeFatal(c, "28000", fmt.Sprintf("peer authentication failed for user %q", user)) // invalid_authorization_specification
+}
+
 func (s *Server) handleSSLRequestMessage(ctx context.Context, c *Conn, msg *pgproto3.SSLRequest) error {
-	log.Printf("received ssl request message: %#v", msg)
-	if _, err := c.Write([]byte("N")); err != nil {
+	c.logger.Debug("ssl request message")
+	if s.TLSConfig == nil {
+		if _, err := c.Write([]byte("N")); err != nil {
+			return err
+		}
+		return s.serveConnStartup(ctx, c)
+	}
+
+	// Upgrade the connection to TLS & read the startup message over it.
+	if _, err := c.Write([]byte("S")); err != nil {
 		return err
 	}
+	tlsConn := tls.Server(c.Conn, s.TLSConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("tls handshake: %w", err)
+	}
+	c.Conn = tlsConn
+	c.backend = pgproto3.NewBackend(pgproto3.NewChunkReader(tlsConn), tlsConn)
+
 	return s.serveConnStartup(ctx, c)
 }
 
 func (s *Server) handleQueryMessage(ctx context.Context, c *Conn, msg *pgproto3.Query) error {
-	log.Printf("received query: %q", msg.String)
+	c.logger.Debug("query", "sql", msg.String)
+	c.setState(StateActive, msg.String)
 
-	// Execute query against database.
-	rows, err := c.db.QueryContext(ctx, msg.String)
-	if err != nil {
-		return writeMessages(c,
-			&pgproto3.ErrorResponse{Message: err.Error()},
-			&pgproto3.ReadyForQuery{TxStatus: 'I'},
-		)
+	// Replication commands stream the change log to replication clients.
+	if c.walSender {
+		if ok, err := s.handleReplicationCommand(ctx, c, msg.String); ok {
+			return err
+		}
 	}
-	defer rows.Close()
 
-	// Encode column header.
-	cols, err := rows.ColumnTypes()
-	if err != nil {
-		return fmt.Errorf("column types: %w", err)
+	// Execute query with the handler.
+	ctx, cancel := c.withStatementTimeout(ctx)
+	defer cancel()
+
+	t := time.Now()
+	var res resultBuffer
+	var errResp *pgproto3.ErrorResponse
+	defer func() {
+		c.metrics.observeQuery(c.database, msg.String, time.Since(t))
+		s.auditStatement(c, msg.String, t, int64(len(res.rows)), errResp)
+	}()
+
+	if ok, err := s.handleServerStatement(ctx, c, &res, msg.String); ok && err != nil {
+		errResp = c.queryErrorResponse(ctx, err)
+		return writeMessages(c, errResp, &pgproto3.ReadyForQuery{TxStatus: 'I'})
+	} else if ok {
+		_, err := c.Write((&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(res.encodeRows(nil)))
+		return err
 	}
-	buf := toRowDescription(cols).Encode(nil)
 
-	// Iterate over each row and encode it to the wire protocol.
-	for rows.Next() {
-		row, err := scanRow(rows, cols)
-		if err != nil {
-			return fmt.Errorf("scan: %w", err)
-		}
-		buf = row.Encode(buf)
-	}
-	if err := rows.Err(); err != nil {
-		return fmt.Errorf("rows: %w", err)
+	// Row-level security is applied first as it resets the privileges.
+	query, err := s.applyRowSecurity(ctx, c, msg.String)
+	if err != nil {
+		errResp = c.queryErrorResponse(ctx, err)
+		return writeMessages(c, errResp, &pgproto3.ReadyForQuery{TxStatus: 'I'})
+	} else if err := s.loadPrivileges(ctx, c); err != nil {
+		errResp = c.queryErrorResponse(ctx, err)
+		return writeMessages(c, errResp, &pgproto3.ReadyForQuery{TxStatus: 'I'})
+	} else if err := s.serveQuery(ctx, &res, c, c.newQuery(query, nil)); err != nil {
+		errResp = c.queryErrorResponse(ctx, err)
+		return writeMessages(c, errResp, &pgproto3.ReadyForQuery{TxStatus: 'I'})
 	}
 
-	// Mark command complete and ready for next query.
-	buf = (&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}).Encode(buf)
+	// Encode column header, rows & mark command complete and ready for next query.
+	buf := res.rowDescription().Encode(nil)
+	buf = res.encodeRows(buf)
 	buf = (&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(buf)
 
 	_, err = c.Write(buf)
 	return err
 }
 
-func toRowDescription(cols []*sql.ColumnType) *pgproto3.RowDescription {
-	var desc pgproto3.RowDescription
-	for _, col := range cols {
-		desc.Fields = append(desc.Fields, pgproto3.FieldDescription{
-			Name:                 []byte(col.Name()),
-			TableOID:             0,
-			TableAttributeNumber: 0,
-			DataTypeOID:          pgtype.TextOID,
-			DataTypeSize:         -1,
-			TypeModifier:         -1,
-			Format:               0,
-		})
+// handleServerStatement handles the statements that the server implements
+// rather than SQLite, for both the simple & the extended query protocols.
+// Returns false if the query is not one of them.
+func (s *Server) handleServerStatement(ctx context.Context, c *Conn, w *resultBuffer, query string) (bool, error) {
+	// Session timeouts & custom settings are kept by the server.
+	if ok, err := s.handleSetStatement(c, w, query); ok {
+		return true, err
 	}
-	return &desc
-}
 
-func scanRow(rows *sql.Rows, cols []*sql.ColumnType) (*pgproto3.DataRow, error) {
-	refs := make([]interface{}, len(cols))
-	values := make([]interface{}, len(cols))
-	for i := range refs {
-		refs[i] = &values[i]
+	// Extensions are loaded by the server as SQLite has no CREATE EXTENSION.
+	if ok, err := s.handleCreateExtension(ctx, c, w, query); ok {
+		return true, err
 	}
 
-	// Scan from SQLite database.
-	if err := rows.Scan(refs...); err != nil {
-		return nil, fmt.Errorf("scan: %w", err)
+	// Notifications are delivered by the server between sessions.
+	if ok, err := s.handleNotifyStatement(c, w, query); ok {
+		return true, err
 	}
 
-	// Convert to TEXT values to return over Postgres wire protocol.
-	row := pgproto3.DataRow{Values: make([][]byte, len(values))}
-	for i := range values {
-		row.Values[i] = []byte(fmt.Sprint(values[i]))
+	// Roles, table privileges & row security policies are kept in the
+	// metastore rather than the database.
+	if ok, err := s.handleRoleStatement(c, w, query); ok {
+		return true, err
+	} else if ok, err := s.handlePrivilegeStatement(c, w, query); ok {
+		return true, err
+	} else if ok, err := s.handleRowSecurityStatement(c, w, query); ok {
+		return true, err
 	}
-	return &row, nil
+	return false, nil
 }
 
 func (s *Server) handleParseMessage(ctx context.Context, c *Conn, pmsg *pgproto3.Parse) error {
-	// Rewrite system-information queries so they're tolerable by SQLite.
-	query := rewriteQuery(pmsg.Query)
+	c.logger.Debug("parse", "sql", pmsg.Query)
+	c.setState(StateActive, pmsg.Query)
 
-	if pmsg.Query != query {
-		log.Printf("query rewrite: %s", query)
-	}
-
-	// Prepare the query.
-	stmt, err := c.db.PrepareContext(ctx, query)
-	if err != nil {
-		return fmt.Errorf("prepare: %w", err)
-	}
-
-	var rows *sql.Rows
-	var cols []*sql.ColumnType
+	// The query is executed by the handler on the first Describe or Execute
+	// once its parameters are bound.
+	var res *resultBuffer
+	var errResp *pgproto3.ErrorResponse
 	var binds []interface{}
-	exec := func() (err error) {
-		if rows != nil {
-			return nil
+	var start time.Time
+	queryCtx, cancel := ctx, context.CancelFunc(func() {})
+	defer func() { cancel() }()
+	defer func() {
+		if res != nil {
+			c.metrics.observeQuery(c.database, pmsg.Query, time.Since(start))
+			s.auditStatement(c, pmsg.Query, start, int64(len(res.rows)), errResp)
 		}
-		if rows, err = stmt.QueryContext(ctx, binds...); err != nil {
-			return fmt.Errorf("query: %w", err)
+	}()
+	exec := func() {
+		if res != nil {
+			return
 		}
-		if cols, err = rows.ColumnTypes(); err != nil {
-			return fmt.Errorf("column types: %w", err)
+		start = time.Now()
+		queryCtx, cancel = c.withStatementTimeout(ctx)
+		res = &resultBuffer{}
+		if ok, err := s.handleServerStatement(queryCtx, c, res, pmsg.Query); ok {
+			if err != nil {
+				errResp = c.queryErrorResponse(queryCtx, err)
+			}
+		} else if query, err := s.applyRowSecurity(queryCtx, c, pmsg.Query); err != nil {
+			errResp = c.queryErrorResponse(queryCtx, err)
+		} else if err := s.loadPrivileges(queryCtx, c); err != nil {
+			errResp = c.queryErrorResponse(queryCtx, err)
+		} else if err := s.serveQuery(queryCtx, res, c, c.newQuery(query, binds)); err != nil {
+			errResp = c.queryErrorResponse(queryCtx, err)
 		}
-		return nil
 	}
 
 	// LOOP:
@@ -438,7 +978,7 @@ func (s *Server) handleParseMessage(ctx context.Context, c *Conn, pmsg *pgproto3
 			return fmt.Errorf("receive message during parse: %w", err)
 		}
 
-		log.Printf("[recv(p)] %#v", msg)
+		c.logger.Debug("receive", "type", messageType(msg))
 
 		switch msg := msg.(type) {
 		case *pgproto3.Bind:
@@ -446,35 +986,24 @@ func (s *Server) handleParseMessage(ctx context.Context, c *Conn, pmsg *pgproto3
 			for i := range msg.Parameters {
 				binds[i] = string(msg.Parameters[i])
 			}
+			c.logger.Debug("bind", "params", s.logParams(binds))
 
 		case *pgproto3.Describe:
-			if err := exec(); err != nil {
-				return fmt.Errorf("exec: %w", err)
+			if exec(); errResp != nil {
+				return writeErrorUntilSync(c, errResp)
 			}
-			if _, err := c.Write(toRowDescription(cols).Encode(nil)); err != nil {
+			if _, err := c.Write(res.rowDescription().Encode(nil)); err != nil {
 				return err
 			}
 
 		case *pgproto3.Execute:
 			// TODO: Send pgproto3.ParseComplete?
-			if err := exec(); err != nil {
-				return fmt.Errorf("exec: %w", err)
-			}
-
-			var buf []byte
-			for rows.Next() {
-				row, err := scanRow(rows, cols)
-				if err != nil {
-					return fmt.Errorf("scan: %w", err)
-				}
-				buf = row.Encode(buf)
-			}
-			if err := rows.Err(); err != nil {
-				return fmt.Errorf("rows: %w", err)
+			if exec(); errResp != nil {
+				return writeErrorUntilSync(c, errResp)
 			}
 
 			// Mark command complete and ready for next query.
-			buf = (&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}).Encode(buf)
+			buf := res.encodeRows(nil)
 			buf = (&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(buf)
 			_, err := c.Write(buf)
 			return err
@@ -485,6 +1014,36 @@ func (s *Server) handleParseMessage(ctx context.Context, c *Conn, pmsg *pgproto3
 	}
 }
 
+// writeErrorUntilSync sends an error for an extended protocol query & discards
+// messages until the client's Sync, as Postgres does, before sending
+// ReadyForQuery.
+func writeErrorUntilSync(c *Conn, resp *pgproto3.ErrorResponse) error {
+	if _, err := c.Write(resp.Encode(nil)); err != nil {
+		return err
+	}
+	for {
+		msg, err := c.backend.Receive()
+		if err != nil {
+			return fmt.Errorf("receive message until sync: %w", err)
+		} else if _, ok := msg.(*pgproto3.Sync); ok {
+			return writeMessages(c, &pgproto3.ReadyForQuery{TxStatus: 'I'})
+		}
+	}
+}
+
+// newQuery returns a query to pass to the query handler.
+func (c *Conn) newQuery(sql string, args []interface{}) *Query {
+	return &Query{
+		SQL:      sql,
+		Args:     args,
+		PID:      c.pid,
+		User:     c.sessionUser(),
+		Database: c.database,
+		ReadOnly: c.readOnly,
+		Conn:     c.conn,
+	}
+}
+
 func (s *Server) execSetQuery(ctx context.Context, c *Conn, query string) error {
 	buf := (&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}).Encode(nil)
 	buf = (&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(buf)
@@ -494,22 +1053,257 @@ func (s *Server) execSetQuery(ctx context.Context, c *Conn, query string) error
 
 type Conn struct {
 	net.Conn
-	backend *pgproto3.Backend
-	db      *sql.DB // sqlite database
+	backend   *pgproto3.Backend
+	logger    *slog.Logger
+	metrics   *Metrics
+	pid       int32 // backend process ID reported to clients
+	dbs       Backend
+	db        *DB       // shared sqlite database
+	conn      *sql.Conn // connection pinned for the session
+	readOnly  bool      // if true, database is opened read-only
+	confined  bool      // if true, access rules admitted the session to its database only
+	walSender bool      // if true, replication commands are accepted
+	queryOnly bool      // if true, query_only is set on conn for the session
+	database  string    // database name from the startup message
+
+	mu       sync.Mutex
+	idle     bool // waiting for a message outside of a transaction
+	draining bool // close once idle, set by Server.Shutdown()
+
+	// Session activity reported in pg_stat_activity, guarded by mu.
+	user            string
+	applicationName string
+	backendStart    time.Time
+	xactStart       time.Time
+	queryStart      time.Time
+	stateChange     time.Time
+	state           string
+	query           string
+	cancelQuery     context.CancelFunc // cancels the running query
+	terminated      bool               // close as soon as possible
+
+	// Table privileges checked by the authorizer, guarded by mu.
+	privileges *sessionPrivileges
+	denied     string // message of the last action denied
+
+	// Notifications queued by the session's transaction & notifications
+	// received from other sessions, guarded by mu.
+	pendingNotifications []*pgproto3.NotificationResponse
+	notifications        []*pgproto3.NotificationResponse
+
+	// Row changes captured for logical replication & the savepoints of the
+	// session's transaction, guarded by mu.
+	changes          []capturedChange
+	changeSavepoints []changeSavepoint
+	changesStaged    bool // committed changes staged but not copied to the change log
+
+	// Temporary replication slots created by the session, guarded by mu.
+	temporarySlots []string
+
+	// Custom settings of the session & of its transaction, guarded by mu.
+	settings      map[string]string
+	localSettings map[string]string
+
+	// Temporary objects applying row-level security on conn.
+	rowSecurity *rowSecurity
+
+	remoteAddr net.Addr
+	secretKey  uint32              // key clients must send to cancel queries
+	sqliteConn *sqlite3.SQLiteConn // pinned SQLite connection
+
+	// Session timeouts, initialized from the server defaults.
+	statementTimeout                time.Duration
+	idleInTransactionSessionTimeout time.Duration
+	idleSessionTimeout              time.Duration
 }
 
-func newConn(conn net.Conn) *Conn {
+func newConn(conn net.Conn, dbs Backend) *Conn {
 	return &Conn{
-		Conn:    conn,
-		backend: pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn),
+		Conn:       conn,
+		remoteAddr: conn.RemoteAddr(),
+		backend:    pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn),
+		logger:     slog.Default(),
+		dbs:        dbs,
 	}
 }
 
+// peerUser returns the operating system user name of the client process.
+// Only available for Unix domain socket connections.
+func (c *Conn) peerUser() (string, error) {
+	netConn := c.Conn
+	if tlsConn, ok := netConn.(*tls.Conn); ok {
+		netConn = tlsConn.NetConn()
+	}
+	if mc, ok := netConn.(*meteredConn); ok {
+		netConn = mc.Conn
+	}
+	unixConn, ok := netConn.(*net.UnixConn)
+	if !ok {
+		return "", fmt.Errorf("not a unix domain socket connection")
+	}
+
+	uid, err := peerUID(unixConn)
+	if err != nil {
+		return "", err
+	}
+	u, err := osuser.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
+
+// receive waits for the next message from the client. If the session is idle
+// for longer than its idle timeout, the client is sent a fatal error. Once the
+// server is shutting down, sessions are closed as soon as they are idle
+// outside of a transaction.
+func (c *Conn) receive() (pgproto3.FrontendMessage, error) {
+	for {
+		inTx := c.inTransaction()
+		timeout := c.idleSessionTimeout
+		if inTx {
+			timeout = c.idleInTransactionSessionTimeout
+		}
+
+		var deadline time.Time
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		if err := c.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+
+		if inTx {
+			c.setState(StateIdleInTransaction, "")
+		} else {
+			c.setState(StateIdle, "")
+			c.resetLocalSettings()
+		}
+
+		// Mark the session idle so Shutdown() & notifications can interrupt
+		// the read. The deadline must be set first so it is not overwritten.
+		c.mu.Lock()
+		c.idle = !inTx
+		draining, terminated := c.draining, c.terminated
+		notified := c.idle && len(c.notifications) > 0
+		c.mu.Unlock()
+		if terminated {
+			return nil, writeAdminShutdown(c, "")
+		} else if draining && !inTx {
+			return nil, writeAdminShutdown(c, "the server is shutting down")
+		} else if notified {
+			if err := c.flushNotifications(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		msg, err := c.backend.Receive()
+
+		c.mu.Lock()
+		c.idle = false
+		c.mu.Unlock()
+
+		if err == nil {
+			return msg, c.SetReadDeadline(time.Time{})
+		}
+
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			return nil, err
+		} else if deadline.IsZero() || time.Now().Before(deadline) {
+			continue // interrupted by Shutdown()
+		}
+
+		c.SetWriteDeadline(time.Now().Add(time.Second))
+		if inTx {
+			return nil, writeFatal(c, "25P03", "terminating connection due to idle-in-transaction timeout") // idle_in_transaction_session_timeout
+		}
+		return nil, writeFatal(c, "57P05", "terminating connection due to idle-session timeout") // idle_session_timeout
+	}
+}
+
+// pinConn returns a connection to db for a new session. Waits up to the
+// connection timeout for a connection once the pool is exhausted.
+func (s *Server) pinConn(ctx context.Context, db *DB) (*sql.Conn, error) {
+	if s.DBs.ConnTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.DBs.ConnTimeout)
+		defer cancel()
+	}
+	return db.Conn(ctx)
+}
+
+// inTransaction returns true if the session has an open transaction.
+func (c *Conn) inTransaction() (inTx bool) {
+	if c.conn == nil {
+		return false
+	}
+	c.conn.Raw(func(driverConn interface{}) error {
+		inTx = !driverConn.(*sqlite3.SQLiteConn).AutoCommit()
+		return nil
+	})
+	return inTx
+}
+
+// drain marks the session to be closed once it is idle. Sessions that are
+// already waiting for a message are interrupted.
+func (c *Conn) drain() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.draining = true
+	if c.idle {
+		return c.SetReadDeadline(time.Now())
+	}
+	return nil
+}
+
+// writeAdminShutdown notifies the client that its session is being closed,
+// preceded by a notice if one is given, & returns an error so the connection
+// is closed.
+func writeAdminShutdown(c *Conn, notice string) error {
+	resp := &pgproto3.ErrorResponse{Severity: "FATAL", Code: "57P01", Message: "terminating connection due to administrator command"} // admin_shutdown
+	c.metrics.observeError(resp, nil)
+
+	var msgs []pgproto3.Message
+	if notice != "" {
+		msgs = append(msgs, &pgproto3.NoticeResponse{Severity: "NOTICE", Code: "57P01", Message: notice})
+	}
+	if err := writeMessages(c, append(msgs, resp)...); err != nil {
+		return err
+	}
+	return errAdminShutdown
+}
+
+// errAdminShutdown is returned when a session is closed by Shutdown() or
+// terminated by an administrator.
+var errAdminShutdown = errors.New("terminating connection due to administrator command")
+
 func (c *Conn) Close() (err error) {
+	// Roll back any open transaction & reset the connection before returning
+	// it to the pool so nothing the session changed leaks into another
+	// session. The row-level security objects are dropped with the session's
+	// temporary objects. Connections that cannot be reset are discarded.
+	if c.conn != nil {
+		if e := c.conn.Raw(rollback); err == nil {
+			err = e
+		}
+		c.rowSecurity = nil
+		if e := c.db.resetConn(context.Background(), c.conn); e != nil {
+			c.logger.Warn("cannot reset connection, discarding", "err", e)
+			c.conn.Raw(func(driverConn interface{}) error { return driver.ErrBadConn })
+		} else if e := c.conn.Close(); err == nil {
+			err = e
+		}
+		c.conn = nil
+	}
+
 	if c.db != nil {
-		if e := c.db.Close(); err == nil {
+		c.metrics.sessionEnded(c.database)
+		if e := c.dbs.Release(c.db); err == nil {
 			err = e
 		}
+		c.db = nil
 	}
 
 	if e := c.Conn.Close(); err == nil {
@@ -518,6 +1312,73 @@ func (c *Conn) Close() (err error) {
 	return err
 }
 
+// writeFatal sends a fatal error to the client & returns it as an error so
+// the connection is closed.
+func writeFatal(c *Conn, code, message string) error {
+	resp := &pgproto3.ErrorResponse{Severity: "FATAL", Code: code, Message: message}
+	c.metrics.observeError(resp, nil)
+	if err := writeMessages(c, resp); err != nil {
+		return err
+	}
+	return errors.New(message)
+}
+
+// toErrorResponse converts an error into a Postgres error response. SQLite
+// error codes are mapped to their closest SQLSTATE, where one exists.
+func toErrorResponse(err error) *pgproto3.ErrorResponse {
+	resp := &pgproto3.ErrorResponse{Severity: "ERROR", Code: "XX000", Message: err.Error()} // internal_error
+
+	var perr *Error
+	if errors.As(err, &perr) {
+		resp.Code, resp.Message = perr.Code, perr.Message
+	}
+
+	var serr sqlite3.Error
+	if errors.As(err, &serr) {
+		switch serr.Code {
+		case sqlite3.ErrError:
+			resp.Code = "42000" // syntax_error_or_access_rule_violation
+		case sqlite3.ErrReadonly:
+			resp.Code = "25006" // read_only_sql_transaction
+		case sqlite3.ErrAuth:
+			resp.Code = "42501" // insufficient_privilege
+		case sqlite3.ErrBusy, sqlite3.ErrLocked:
+			resp.Code = "55P03" // lock_not_available
+		case sqlite3.ErrInterrupt:
+			resp.Code = "57014" // query_canceled
+		case sqlite3.ErrConstraint:
+			resp.Code = constraintErrorCode(serr.ExtendedCode)
+		}
+	}
+	return resp
+}
+
+// constraintErrorCode returns the SQLSTATE for a SQLite constraint violation.
+func constraintErrorCode(code sqlite3.ErrNoExtended) string {
+	switch code {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return "23505" // unique_violation
+	case sqlite3.ErrConstraintNotNull:
+		return "23502" // not_null_violation
+	case sqlite3.ErrConstraintForeignKey:
+		return "23503" // foreign_key_violation
+	case sqlite3.ErrConstraintCheck:
+		return "23514" // check_violation
+	default:
+		return "23000" // integrity_constraint_violation
+	}
+}
+
+// rollback rolls back the current transaction on a SQLite connection, if any.
+func rollback(driverConn interface{}) error {
+	conn := driverConn.(*sqlite3.SQLiteConn)
+	if conn.AutoCommit() {
+		return nil
+	}
+	_, err := conn.Exec("ROLLBACK", nil)
+	return err
+}
+
 func getParameter(m map[string]string, k string) string {
 	if m == nil {
 		return ""
@@ -558,7 +1419,7 @@ func rewriteQuery(q string) string {
 	// q = pgCatalogRegex.ReplaceAllString(q, "")
 
 	// Rewrite "SHOW" commands into function calls.
-	q = showRegex.ReplaceAllString(q, "SELECT show('$1')")
+	q = showRegex.ReplaceAllString(q, "SELECT show('$1') AS $1")
 
 	return q
 }
@@ -570,5 +1431,5 @@ var (
 
 	pgCatalogRegex = regexp.MustCompile(`\bpg_catalog\.`)
 
-	showRegex = regexp.MustCompile(`^SHOW (\w+)`)
+	showRegex = regexp.MustCompile(`(?i)^SHOW (\w+)`)
 )
@@ -0,0 +1,376 @@
+package postlite_test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/postlite"
+	"github.com/jackc/pgproto3/v2"
+)
+
+// Ensure temporary objects, attached databases & settings do not leak from a
+// session into the next session that reuses its pooled connection.
+func TestServer_ConnReset(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.DBs.Pragmas.ForeignKeys = "on"
+		s.DBs.MaxOpenConns, s.DBs.MaxIdleConns = 1, 1
+	})
+
+	a := MustConnect(t, s, "db", "alice", "")
+	a.MustQuery(`CREATE TEMP TABLE secret (x TEXT)`)
+	a.MustQuery(`INSERT INTO temp.secret VALUES ('alice-secret')`)
+	a.MustQuery(`CREATE TEMP VIEW v AS SELECT 1`)
+	a.MustQuery(`ATTACH ':memory:' AS other`)
+	a.MustQuery(`PRAGMA foreign_keys = 0`)
+	a.Close()
+
+	b := MustConnect(t, s, "db", "bob", "")
+	if _, err := b.Query(`SELECT x FROM temp.secret`); err == nil || !strings.Contains(err.Error(), "no such table") {
+		t.Fatalf("expected temporary table to be dropped, got %v", err)
+	}
+	if rows := b.MustQuery(`SELECT count(*) FROM temp.sqlite_schema`); rows[0][0] != "0" {
+		t.Fatalf("unexpected temporary objects: %v", rows)
+	}
+	if rows := b.MustQuery(`SELECT count(*) FROM pragma_database_list WHERE name = 'other'`); rows[0][0] != "0" {
+		t.Fatal("expected database to be detached")
+	}
+	if rows := b.MustQuery(`PRAGMA foreign_keys`); rows[0][0] != "1" {
+		t.Fatalf("foreign_keys=%s, want 1", rows[0][0])
+	}
+	if rows := b.MustQuery(`SELECT count(*) FROM pragma_database_list WHERE name = 'pg_catalog'`); rows[0][0] != "1" {
+		t.Fatal("expected pg_catalog to stay attached")
+	}
+}
+
+// Ensure read-only sessions cannot turn off query_only nor attach databases
+// that it would not apply to.
+func TestServer_ReadOnly(t *testing.T) {
+	s := MustOpenServer(t, func(s *postlite.Server) { s.ReadOnly = true })
+
+	prod := filepath.Join(s.DataDir, "prod.db")
+	rw := MustOpenServer(t, func(rw *postlite.Server) { rw.DataDir = s.DataDir })
+	MustConnect(t, rw, "prod", "admin", "").MustQuery(`CREATE TABLE t (x)`)
+	MustConnect(t, rw, "db", "admin", "").MustQuery(`CREATE TABLE t (x)`)
+
+	c := MustConnect(t, s, "db", "alice", "")
+	c.MustFailQuery(fmt.Sprintf(`ATTACH '%s' AS w`, prod), "42501")
+	c.MustFailQuery(`PRAGMA query_only = 0`, "42501")
+	c.MustFailQuery(`PRAGMA query_only = false`, "42501")
+	c.MustFailQuery(fmt.Sprintf(`VACUUM INTO '%s'`, filepath.Join(s.DataDir, "copy.db")), "42501")
+	c.MustQuery(`PRAGMA query_only`)
+	if _, err := c.Query(`INSERT INTO w.t VALUES (1)`); err == nil {
+		t.Fatal("expected write to fail")
+	}
+
+	if rows := MustConnect(t, rw, "prod", "admin", "").MustQuery(`SELECT count(*) FROM t`); rows[0][0] != "0" {
+		t.Fatalf("count=%s, want 0", rows[0][0])
+	}
+}
+
+// MustOpenServer returns an open server with a temporary data directory that
+// listens on a random local port. Functions are applied before opening.
+func MustOpenServer(tb testing.TB, fns ...func(*postlite.Server)) *postlite.Server {
+	tb.Helper()
+	s := postlite.NewServer()
+	s.Addr = "127.0.0.1:0"
+	s.DataDir = tb.TempDir()
+	s.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, fn := range fns {
+		fn(s)
+	}
+	if err := s.Open(); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			tb.Log(err)
+		}
+	})
+	return s
+}
+
+// MustOpenMetaStore returns an open metastore in a temporary directory.
+func MustOpenMetaStore(tb testing.TB) *postlite.MetaStore {
+	tb.Helper()
+	store := postlite.NewMetaStore(filepath.Join(tb.TempDir(), "meta.db"))
+	if err := store.Open(); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { store.Close() })
+	return store
+}
+
+// Client is a minimal Postgres client speaking the simple query protocol.
+type Client struct {
+	tb testing.TB
+	net.Conn
+	frontend *pgproto3.Frontend
+
+	// Notifications received while waiting for query results.
+	Notifications []*pgproto3.NotificationResponse
+
+	// Column names & command tag of the last query.
+	Columns    []string
+	CommandTag string
+}
+
+// Connect opens a session on database as user. A password is sent if the
+// server asks for one.
+func Connect(tb testing.TB, s *postlite.Server, database, user, password string) (*Client, error) {
+	return ConnectParams(tb, s, map[string]string{"database": database, "user": user}, password)
+}
+
+// ConnectParams opens a session with the given startup parameters.
+func ConnectParams(tb testing.TB, s *postlite.Server, params map[string]string, password string) (*Client, error) {
+	tb.Helper()
+	return ConnectAddr(tb, s.Listeners()[0], params, password)
+}
+
+// ConnectAddr opens a session on the listener at addr, which may be a TCP
+// address or a Unix domain socket.
+func ConnectAddr(tb testing.TB, addr net.Addr, params map[string]string, password string) (*Client, error) {
+	tb.Helper()
+	conn, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	c := &Client{tb: tb, Conn: conn, frontend: pgproto3.NewFrontend(pgproto3.NewChunkReader(conn), conn)}
+	tb.Cleanup(func() { c.Close() })
+
+	if _, err := conn.Write((&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: params}).Encode(nil)); err != nil {
+		tb.Fatal(err)
+	}
+	for {
+		msg, err := c.frontend.Receive()
+		if err != nil {
+			return nil, err
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.AuthenticationMD5Password:
+			hash := md5Hex(md5Hex(password+params["user"]) + string(msg.Salt[:]))
+			if _, err := conn.Write((&pgproto3.PasswordMessage{Password: "md5" + hash}).Encode(nil)); err != nil {
+				tb.Fatal(err)
+			}
+		case *pgproto3.ErrorResponse:
+			return nil, errorResponse(msg)
+		case *pgproto3.ReadyForQuery:
+			return c, nil
+		}
+	}
+}
+
+// MustConnect opens a session or fails the test.
+func MustConnect(tb testing.TB, s *postlite.Server, database, user, password string) *Client {
+	tb.Helper()
+	c, err := Connect(tb, s, database, user, password)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return c
+}
+
+// MustFailConnect opens a session & fails the test unless the server rejects
+// it with the given SQLSTATE code.
+func MustFailConnect(tb testing.TB, s *postlite.Server, database, user, password, code string) {
+	tb.Helper()
+	if _, err := Connect(tb, s, database, user, password); err == nil {
+		tb.Fatalf("%s@%s: expected error %s", user, database, code)
+	} else if e := (*Error)(nil); !errors.As(err, &e) || e.Code != code {
+		tb.Fatalf("%s@%s: expected error %s, got %v", user, database, code, err)
+	}
+}
+
+// MustConnectAddr opens a session on the listener at addr or fails the test.
+func MustConnectAddr(tb testing.TB, addr net.Addr, params map[string]string, password string) *Client {
+	tb.Helper()
+	c, err := ConnectAddr(tb, addr, params, password)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return c
+}
+
+// MustConnectParams opens a session with startup parameters or fails the test.
+func MustConnectParams(tb testing.TB, s *postlite.Server, params map[string]string, password string) *Client {
+	tb.Helper()
+	c, err := ConnectParams(tb, s, params, password)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return c
+}
+
+// Query runs a query with the simple query protocol & returns its rows as
+// text. Returns the error sent by the server, if any.
+func (c *Client) Query(query string) ([][]string, error) {
+	c.tb.Helper()
+	if _, err := c.Write((&pgproto3.Query{String: query}).Encode(nil)); err != nil {
+		return nil, err
+	}
+	return c.results()
+}
+
+// QueryExtended runs a query with the extended query protocol & returns its
+// rows as text.
+func (c *Client) QueryExtended(query string, args ...string) ([][]string, error) {
+	c.tb.Helper()
+	params := make([][]byte, len(args))
+	for i, arg := range args {
+		params[i] = []byte(arg)
+	}
+	var buf []byte
+	buf = (&pgproto3.Parse{Query: query}).Encode(buf)
+	buf = (&pgproto3.Bind{Parameters: params}).Encode(buf)
+	buf = (&pgproto3.Describe{ObjectType: 'P'}).Encode(buf)
+	buf = (&pgproto3.Execute{}).Encode(buf)
+	buf = (&pgproto3.Sync{}).Encode(buf)
+	if _, err := c.Write(buf); err != nil {
+		return nil, err
+	}
+	return c.results()
+}
+
+// MustQuery runs a query or fails the test.
+func (c *Client) MustQuery(query string) [][]string {
+	c.tb.Helper()
+	rows, err := c.Query(query)
+	if err != nil {
+		c.tb.Fatalf("%s: %s", query, err)
+	}
+	return rows
+}
+
+// MustQueryExtended runs a query with the extended query protocol or fails
+// the test.
+func (c *Client) MustQueryExtended(query string, args ...string) [][]string {
+	c.tb.Helper()
+	rows, err := c.QueryExtended(query, args...)
+	if err != nil {
+		c.tb.Fatalf("%s: %s", query, err)
+	}
+	return rows
+}
+
+// MustFailQuery runs a query & fails the test unless the server returns an
+// error with the given SQLSTATE code.
+func (c *Client) MustFailQuery(query, code string) {
+	c.tb.Helper()
+	if _, err := c.Query(query); err == nil {
+		c.tb.Fatalf("%s: expected error %s", query, code)
+	} else if e := (*Error)(nil); !errors.As(err, &e) || e.Code != code {
+		c.tb.Fatalf("%s: expected error %s, got %v", query, code, err)
+	}
+}
+
+// MustFailQueryExtended runs a query with the extended query protocol & fails
+// the test unless the server returns an error with the given SQLSTATE code.
+func (c *Client) MustFailQueryExtended(query, code string) {
+	c.tb.Helper()
+	if _, err := c.QueryExtended(query); err == nil {
+		c.tb.Fatalf("%s: expected error %s", query, code)
+	} else if e := (*Error)(nil); !errors.As(err, &e) || e.Code != code {
+		c.tb.Fatalf("%s: expected error %s, got %v", query, code, err)
+	}
+}
+
+// MustReceiveNotification returns the next notification received by the
+// session, waiting for one to be sent while it is idle.
+func (c *Client) MustReceiveNotification() *pgproto3.NotificationResponse {
+	c.tb.Helper()
+	if len(c.Notifications) > 0 {
+		n := c.Notifications[0]
+		c.Notifications = c.Notifications[1:]
+		return n
+	}
+	for {
+		msg, err := c.frontend.Receive()
+		if err != nil {
+			c.tb.Fatal(err)
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.NotificationResponse:
+			return msg
+		case *pgproto3.ErrorResponse:
+			c.tb.Fatal(errorResponse(msg))
+		}
+	}
+}
+
+// MustReceiveFatal waits for the server to close the idle session with a
+// fatal error & fails unless it has the given SQLSTATE code.
+func (c *Client) MustReceiveFatal(code string) {
+	c.tb.Helper()
+	for {
+		msg, err := c.frontend.Receive()
+		if err != nil {
+			c.tb.Fatal(err)
+		}
+		if msg, ok := msg.(*pgproto3.ErrorResponse); ok {
+			if msg.Severity != "FATAL" || msg.Code != code {
+				c.tb.Fatalf("unexpected error: %v, want FATAL %s", errorResponse(msg), code)
+			}
+			return
+		}
+	}
+}
+
+// results reads the results of a query until the server is ready for the
+// next one.
+func (c *Client) results() (rows [][]string, err error) {
+	for {
+		msg, e := c.frontend.Receive()
+		if e != nil {
+			return nil, e
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.RowDescription:
+			c.Columns = c.Columns[:0]
+			for _, f := range msg.Fields {
+				c.Columns = append(c.Columns, string(f.Name))
+			}
+		case *pgproto3.CommandComplete:
+			c.CommandTag = string(msg.CommandTag)
+		case *pgproto3.DataRow:
+			row := make([]string, len(msg.Values))
+			for i, v := range msg.Values {
+				row[i] = string(v)
+			}
+			rows = append(rows, row)
+		case *pgproto3.NotificationResponse:
+			c.Notifications = append(c.Notifications, msg)
+		case *pgproto3.ErrorResponse:
+			err = errorResponse(msg)
+		case *pgproto3.ReadyForQuery:
+			return rows, err
+		}
+	}
+}
+
+// Error is an error sent by the server.
+type Error struct {
+	Severity string
+	Code     string
+	Message  string
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("%s %s: %s", e.Severity, e.Code, e.Message) }
+
+// md5Hex returns the hex-encoded MD5 hash of s.
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func errorResponse(msg *pgproto3.ErrorResponse) error {
+	return &Error{Severity: msg.Severity, Code: msg.Code, Message: msg.Message}
+}
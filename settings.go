@@ -0,0 +1,155 @@
+package postlite
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Custom settings are session settings with a dotted name, such as
+// "app.tenant", which applications use to pass values to SQL, e.g. to
+// row-level security policies. They are set with SET or set_config() & read
+// with current_setting().
+
+// setting returns the value of a custom setting. Values set for the current
+// transaction take precedence over session values.
+func (c *Conn) setting(name string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name = strings.ToLower(name)
+	if v, ok := c.localSettings[name]; ok {
+		return v, true
+	}
+	v, ok := c.settings[name]
+	return v, ok
+}
+
+// setSetting sets a custom setting for the session, or for the current
+// transaction if local is true.
+func (c *Conn) setSetting(name, value string, local bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name = strings.ToLower(name)
+	if local {
+		if c.localSettings == nil {
+			c.localSettings = make(map[string]string)
+		}
+		c.localSettings[name] = value
+		return
+	}
+	if c.settings == nil {
+		c.settings = make(map[string]string)
+	}
+	c.settings[name] = value
+	delete(c.localSettings, name)
+}
+
+// resetSetting removes a custom setting.
+func (c *Conn) resetSetting(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name = strings.ToLower(name)
+	delete(c.settings, name)
+	delete(c.localSettings, name)
+}
+
+// resetLocalSettings removes the settings of a finished transaction.
+func (c *Conn) resetLocalSettings() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.localSettings = nil
+}
+
+// handleSetStatement handles SET statements that the server applies rather
+// than SQLite: session timeouts & custom settings. Returns false if the query is not one of them.
+func (s *Server) handleSetStatement(c *Conn, w *resultBuffer, query string) (bool, error) {
+	if ok, err := s.setTimeout(c, w, query); ok {
+		return true, err
+	}
+	return s.setParameter(c, w, query)
+}
+
+// setParameter handles SET & RESET of custom settings. Returns false if the
+// query does not set a custom setting.
+func (s *Server) setParameter(c *Conn, w *resultBuffer, query string) (bool, error) {
+	if m := resetParameterRegex.FindStringSubmatch(query); m != nil {
+		c.resetSetting(m[1])
+		w.SetCommandTag("RESET")
+		return true, nil
+	}
+
+	m := setParameterRegex.FindStringSubmatch(query)
+	if m == nil {
+		return false, nil
+	}
+	local, name, value := strings.EqualFold(strings.TrimSpace(m[1]), "LOCAL"), m[2], m[3]
+
+	switch {
+	case local && !c.inTransaction():
+		w.notices = append(w.notices, &pgproto3.NoticeResponse{Severity: "WARNING", Code: "25P01", Message: "SET LOCAL can only be used in transaction blocks"}) // no_active_sql_transaction
+	case strings.EqualFold(value, "DEFAULT"):
+		c.resetSetting(name)
+	default:
+		if strings.HasPrefix(value, "'") {
+			value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		}
+		c.setSetting(name, value, local)
+	}
+	w.SetCommandTag("SET")
+	return true, nil
+}
+
+var (
+	setParameterRegex   = regexp.MustCompile(`(?is)^\s*SET\s+(SESSION\s+|LOCAL\s+)?([a-z_][\w$]*\.[\w$.]+)\s*(?:=|\s+TO\s+)\s*(DEFAULT|'(?:[^']|'')*'|[^\s;']+)\s*;?\s*$`)
+	resetParameterRegex = regexp.MustCompile(`(?is)^\s*RESET\s+([a-z_][\w$]*\.[\w$.]+)\s*;?\s*$`)
+)
+
+// currentSetting returns the current_setting() SQL function for conn, which
+// reports custom settings of the session that has pinned conn & the settings
+// of pg_settings. If missingOK is true, missing settings are blank rather
+// than an error, as SQL functions cannot return a NULL string.
+func (s *Server) currentSetting(conn *sqlite3.SQLiteConn) func(name string, missingOK ...bool) (string, error) {
+	return func(name string, missingOK ...bool) (string, error) {
+		if strings.Contains(name, ".") {
+			if c := s.session(conn); c != nil {
+				if v, ok := c.setting(name); ok {
+					return v, nil
+				}
+			}
+		} else {
+			settings, err := connSettings(conn)
+			if err != nil {
+				return "", err
+			}
+			for _, setting := range settings {
+				if strings.EqualFold(setting.name, name) {
+					return setting.setting, nil
+				}
+			}
+		}
+
+		if len(missingOK) > 0 && missingOK[0] {
+			return "", nil
+		}
+		return "", Errorf("42704", "unrecognized configuration parameter %q", name) // undefined_object
+	}
+}
+
+// setConfig returns the set_config() SQL function for conn, which sets a
+// custom setting of the session that has pinned conn & returns its value.
+func (s *Server) setConfig(conn *sqlite3.SQLiteConn) func(name, value string, local bool) (string, error) {
+	return func(name, value string, local bool) (string, error) {
+		if !strings.Contains(name, ".") {
+			return "", Errorf("0A000", "parameter %q cannot be changed with set_config()", name) // feature_not_supported
+		}
+		c := s.session(conn)
+		if c == nil {
+			return "", fmt.Errorf("set_config() requires a client session")
+		}
+		c.setSetting(name, value, local)
+		return value, nil
+	}
+}
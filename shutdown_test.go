@@ -0,0 +1,88 @@
+package postlite_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure shutdown closes idle sessions, waits for open transactions to finish
+// & refuses new connections.
+func TestServer_Shutdown(t *testing.T) {
+	s := MustOpenServer(t)
+	addr := s.Listeners()[0].String()
+
+	setup := MustConnect(t, s, "db", "admin", "")
+	setup.MustQuery(`CREATE TABLE t (x)`)
+	setup.Close()
+
+	idle := MustConnect(t, s, "db", "alice", "")
+	tx := MustConnect(t, s, "db", "bob", "")
+	tx.MustQuery(`BEGIN`)
+	tx.MustQuery(`INSERT INTO t VALUES (1)`)
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+
+	idle.MustReceiveFatal("57P01")
+
+	// The transaction may still be finished.
+	time.Sleep(50 * time.Millisecond)
+	tx.MustQuery(`INSERT INTO t VALUES (2)`)
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown returned before the transaction finished: %v", err)
+	default:
+	}
+	tx.MustQuery(`COMMIT`)
+	tx.MustReceiveFatal("57P01")
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for shutdown")
+	}
+
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Fatal("expected new connections to be refused")
+	}
+
+	// The committed rows are kept.
+	s = MustOpenServer(t, func(next *postlite.Server) { next.DataDir = s.DataDir })
+	if rows := MustConnect(t, s, "db", "alice", "").MustQuery(`SELECT x FROM t ORDER BY x`); !reflect.DeepEqual(rows, [][]string{{"1"}, {"2"}}) {
+		t.Fatalf("rows=%v", rows)
+	}
+}
+
+// Ensure shutdown closes sessions that are still in a transaction once its
+// context is done & rolls their transactions back.
+func TestServer_Shutdown_Timeout(t *testing.T) {
+	s := MustOpenServer(t)
+
+	tx := MustConnect(t, s, "db", "bob", "")
+	tx.MustQuery(`CREATE TABLE t (x)`)
+	tx.MustQuery(`BEGIN`)
+	tx.MustQuery(`INSERT INTO t VALUES (1)`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Query(`COMMIT`); err == nil {
+		t.Fatal("expected session to be closed")
+	}
+
+	s = MustOpenServer(t, func(next *postlite.Server) { next.DataDir = s.DataDir })
+	if rows := MustConnect(t, s, "db", "alice", "").MustQuery(`SELECT count(*) FROM t`); rows[0][0] != "0" {
+		t.Fatalf("count=%s, want 0", rows[0][0])
+	}
+}
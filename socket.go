@@ -0,0 +1,62 @@
+package postlite
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// DefaultSocketPerm is the default file mode of Unix domain sockets.
+const DefaultSocketPerm os.FileMode = 0777
+
+// DefaultPort is the port used to name Unix domain sockets if none is
+// specified in the server address.
+const DefaultPort = "5432"
+
+// SocketPath returns the path of the Unix domain socket in dir. The socket is
+// named after the port in addr using the Postgres convention, ".s.PGSQL.PORT".
+func SocketPath(dir, addr string) string {
+	port := DefaultPort
+	if _, p, err := net.SplitHostPort(addr); err == nil && p != "" {
+		port = p
+	}
+	return filepath.Join(dir, ".s.PGSQL."+port)
+}
+
+// listenUnix listens on a Unix domain socket at path with the given file
+// permissions. A stale socket file left by a previous process is removed.
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("chmod socket: %w", err)
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket file at path if no process is
+// accepting connections on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("cannot listen on %s: file exists", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("cannot listen on %s: socket in use", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,44 @@
+package postlite_test
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/benbjohnson/postlite"
+)
+
+// Ensure the server replaces a stale Unix domain socket, applies the socket
+// permissions & refuses to take over a socket that is in use.
+func TestServer_UnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	path := postlite.SocketPath(dir, "")
+
+	// Leave a socket file behind, as a crashed server would.
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	s := MustOpenServer(t, func(s *postlite.Server) {
+		s.Addr, s.SocketDirs, s.SocketPerm = "", []string{dir}, 0700
+	})
+	if addr := s.Listeners()[0]; addr.String() != path {
+		t.Fatalf("addr=%s, want %s", addr, path)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if perm := fi.Mode().Perm(); perm != 0700 {
+		t.Fatalf("perm=%o, want 700", perm)
+	}
+	MustConnectAddr(t, s.Listeners()[0], map[string]string{"database": "db", "user": "alice"}, "").MustQuery(`SELECT 1`)
+
+	other := postlite.NewServer()
+	other.DataDir, other.SocketDirs = t.TempDir(), []string{dir}
+	if err := other.Open(); err == nil {
+		other.Close()
+		t.Fatal("expected socket in use error")
+	}
+}